@@ -0,0 +1,468 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Command ldbtool inspects the files of a LevelDB database.
+//
+// Usage:
+//
+//	ldbtool manifest [-json] [-num N] <db-path>
+//		Prints the edits of a manifest and the replayed level layout of
+//		both keyspaces. The current manifest is used unless -num is given.
+//
+//	ldbtool table [-json] [-entries] [-hex] <table-file>
+//		Prints the block layout of a single table file of either keyspace
+//		and verifies all block checksums. With -entries every entry is
+//		printed, values as size unless -hex is given.
+//
+//	ldbtool tablestats [-json] <db-path>
+//		Prints table statistics per level of the leveled tree and the
+//		state tree, for comparing the two.
+//
+//	ldbtool wal [-stream main|state|both] [-json] [-entries] [-hex] [-interleave] [-out file] <db-path|journal-file>
+//		Decodes the batches of the journal (.log) and state journal (.logs)
+//		files and reports torn or corrupted chunks with their offsets.
+//		With -interleave the records of both streams are merged by
+//		sequence number. With -out the valid records of a single stream
+//		are written to a new journal file.
+//
+//	ldbtool verify [-json] <db-path>
+//		Opens the database as a secondary and checks every live table of both
+//		trees, see DB.Verify. Exits with status 1 if a problem is found.
+//
+// The database is read without taking its lock, so the tool can be used
+// on a database that is open by another process.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"awesomeProject1/goleveldb/leveldb"
+	"awesomeProject1/goleveldb/leveldb/journal"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"manifest", "[-json] [-num N] <db-path>", runManifest},
+	{"table", "[-json] [-entries] [-hex] <table-file>", runTable},
+	{"tablestats", "[-json] <db-path>", runTableStats},
+	{"wal", "[-stream main|state|both] [-json] [-entries] [-hex] [-interleave] [-out file] <db-path|journal-file>", runWAL},
+	{"verify", "[-json] <db-path>", runVerify},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ldbtool <command> [arguments]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "\t%s %s\n", c.name, c.usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "ldbtool %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+}
+
+// openStorage opens the database directory read-only, see openPrimary.
+func openStorage(fs *flag.FlagSet) (storage.Storage, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return openPrimary(fs.Arg(0))
+}
+
+// openPrimary opens the database directory at path without locking it, so
+// the tool can be used on a database that is open by another process. The
+// lock and the LOG go to a scratch directory removed on Close.
+func openPrimary(path string) (storage.Storage, error) {
+	scratch, err := os.MkdirTemp("", "ldbtool-")
+	if err != nil {
+		return nil, err
+	}
+	stor, err := storage.OpenFileSecondary(path, scratch)
+	if err != nil {
+		os.RemoveAll(scratch)
+		return nil, err
+	}
+	return &scratchStorage{Storage: stor, dir: scratch}, nil
+}
+
+// scratchStorage is a storage opened by openPrimary.
+type scratchStorage struct {
+	storage.Storage
+	dir string
+}
+
+// Open releases the pin on a table file once its reader is closed; the
+// tool reads every table once, and a pin holds the file open.
+func (s *scratchStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
+	r, err := s.Storage.Open(fd)
+	if err != nil || fd.Type != storage.TypeTable {
+		return r, err
+	}
+	return &unpinReader{Reader: r, s: s.Storage, fd: fd}, nil
+}
+
+func (s *scratchStorage) Close() error {
+	err := s.Storage.Close()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+type unpinReader struct {
+	storage.Reader
+	s  storage.Storage
+	fd storage.FileDesc
+}
+
+func (r *unpinReader) Close() error {
+	err := r.Reader.Close()
+	r.s.Remove(r.fd)
+	return err
+}
+
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runManifest(args []string) error {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	num := fs.Int64("num", -1, "manifest file number, defaults to the current manifest")
+	fs.Parse(args)
+
+	stor, err := openStorage(fs)
+	if err != nil {
+		return err
+	}
+	defer stor.Close()
+
+	var fd storage.FileDesc
+	if *num >= 0 {
+		fd = storage.FileDesc{Type: storage.TypeManifest, Num: *num}
+	}
+	report, err := leveldb.InspectManifest(stor, fd)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(report)
+	}
+	return report.WriteText(os.Stdout)
+}
+
+// tableFileDesc derives the file descriptor from a table file name, it is
+// only used in error messages.
+func tableFileDesc(path string) storage.FileDesc {
+	fd := storage.FileDesc{Type: storage.TypeTable}
+	name := filepath.Base(path)
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		fd.Num, _ = strconv.ParseInt(name[:i], 10, 64)
+	}
+	return fd
+}
+
+func runTable(args []string) error {
+	fs := flag.NewFlagSet("table", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	entries := fs.Bool("entries", false, "print every entry")
+	hex := fs.Bool("hex", false, "print values as hex instead of their size")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var fn func(leveldb.TableEntry)
+	if *entries && !*asJSON {
+		fn = func(e leveldb.TableEntry) {
+			if e.Err != nil {
+				fmt.Printf("%q corrupted: %v\n", e.Key, e.Err)
+				return
+			}
+			if *hex {
+				fmt.Printf("%q,%s%d %x\n", e.Key, e.Type, e.Seq, e.Value)
+			} else {
+				fmt.Printf("%q,%s%d V·%d\n", e.Key, e.Type, e.Seq, len(e.Value))
+			}
+		}
+	}
+	report, err := leveldb.InspectTable(f, fi.Size(), tableFileDesc(fs.Arg(0)), nil, fn)
+	if report == nil {
+		return err
+	}
+	if *asJSON {
+		if jerr := writeJSON(report); jerr != nil {
+			return jerr
+		}
+	} else if werr := report.WriteText(os.Stdout); werr != nil {
+		return werr
+	}
+	if err == nil && report.Corrupted > 0 {
+		err = fmt.Errorf("%d corrupted blocks", report.Corrupted)
+	}
+	return err
+}
+
+func runTableStats(args []string) error {
+	fs := flag.NewFlagSet("tablestats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	fs.Parse(args)
+
+	stor, err := openStorage(fs)
+	if err != nil {
+		return err
+	}
+	defer stor.Close()
+
+	report, err := leveldb.InspectTables(stor, nil)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(report)
+	}
+	return report.WriteText(os.Stdout)
+}
+
+// journalFileDesc derives the file descriptor from a journal file name, the
+// extension tells the stream.
+func journalFileDesc(path string) (storage.FileDesc, error) {
+	name := filepath.Base(path)
+	i := strings.IndexByte(name, '.')
+	if i <= 0 {
+		return storage.FileDesc{}, fmt.Errorf("%s: not a journal file", path)
+	}
+	fd := storage.FileDesc{}
+	switch name[i:] {
+	case ".log":
+		fd.Type = storage.TypeJournal
+	case ".logs":
+		fd.Type = storage.TypeJournals
+	default:
+		return fd, fmt.Errorf("%s: not a journal file", path)
+	}
+	fd.Num, _ = strconv.ParseInt(name[:i], 10, 64)
+	return fd, nil
+}
+
+func runWAL(args []string) error {
+	fs := flag.NewFlagSet("wal", flag.ExitOnError)
+	stream := fs.String("stream", "both", "journal stream to read: main, state or both")
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	entries := fs.Bool("entries", false, "print every record of each batch")
+	hex := fs.Bool("hex", false, "print keys and values as hex instead of quoted")
+	interleave := fs.Bool("interleave", false, "merge the records of both streams by sequence number")
+	out := fs.String("out", "", "write the valid records to a new journal file")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var ft storage.FileType
+	switch *stream {
+	case "main":
+		ft = storage.TypeJournal
+	case "state":
+		ft = storage.TypeJournals
+	case "both":
+		ft = storage.TypeJournal | storage.TypeJournals
+	default:
+		return fmt.Errorf("invalid stream %q", *stream)
+	}
+
+	var (
+		records []*leveldb.JournalRecord
+		jw      *journal.Writer
+		written int
+	)
+	collect := *asJSON || *interleave
+	fn := func(rec *leveldb.JournalRecord) error {
+		if jw != nil && rec.Error == "" {
+			w, err := jw.Next()
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(rec.Data); err != nil {
+				return err
+			}
+			written++
+		}
+		if collect {
+			records = append(records, rec)
+			return nil
+		}
+		return leveldb.WriteJournalRecord(os.Stdout, rec, *entries, *hex)
+	}
+
+	path := fs.Arg(0)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	var fd storage.FileDesc
+	if !fi.IsDir() {
+		if fd, err = journalFileDesc(path); err != nil {
+			return err
+		}
+	} else if ft == storage.TypeJournal|storage.TypeJournals && *out != "" {
+		// 两个流的记录不能写进同一个 journal。
+		return fmt.Errorf("-out needs -stream main or state")
+	}
+
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		jw = journal.NewWriter(f)
+	}
+
+	var reports []*leveldb.JournalReport
+	if fd.Zero() {
+		stor, err := openStorage(fs)
+		if err != nil {
+			return err
+		}
+		defer stor.Close()
+		reports, err = leveldb.InspectJournals(stor, ft, fn)
+		if err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		report, err := leveldb.InspectJournal(f, fd, fn)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		reports = append(reports, report)
+	}
+	if jw != nil {
+		if err := jw.Close(); err != nil {
+			return err
+		}
+	}
+
+	if *interleave {
+		leveldb.SortJournalRecords(records)
+	}
+	if *asJSON {
+		if err := writeJSON(struct {
+			Records  []*leveldb.JournalRecord `json:"records"`
+			Journals []*leveldb.JournalReport `json:"journals"`
+		}{records, reports}); err != nil {
+			return err
+		}
+	} else {
+		var next uint64
+		for i, rec := range records {
+			if rec.Error == "" && i > 0 && rec.Seq != next {
+				fmt.Printf("-- sequence jumps from %d to %d\n", next, rec.Seq)
+			}
+			if err := leveldb.WriteJournalRecord(os.Stdout, rec, *entries, *hex); err != nil {
+				return err
+			}
+			if rec.Error == "" {
+				next = rec.Seq + uint64(rec.Len)
+			}
+		}
+		for _, report := range reports {
+			if err := report.WriteText(os.Stdout); err != nil {
+				return err
+			}
+		}
+		if jw != nil {
+			fmt.Printf("wrote %d records to %s\n", written, *out)
+		}
+	}
+
+	var dropped, bad int
+	for _, report := range reports {
+		dropped += len(report.Corruptions)
+		bad += report.BadRecords
+	}
+	if dropped > 0 || bad > 0 {
+		return fmt.Errorf("%d corrupted chunks, %d undecodable records", dropped, bad)
+	}
+	return nil
+}
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// 和 openPrimary 一样不锁主库。
+	scratch, err := os.MkdirTemp("", "ldbtool-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+	db, err := leveldb.OpenSecondary(fs.Arg(0), scratch, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.Verify()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		err = writeJSON(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err == nil && !report.OK() {
+		err = fmt.Errorf("%d problems", len(report.Problems))
+	}
+	return err
+}
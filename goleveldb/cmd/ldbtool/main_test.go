@@ -0,0 +1,73 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+func TestOpenPrimaryInUse(t *testing.T) {
+	path := t.TempDir()
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		if err := db.Put(key, key, nil); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+		if err := db.Put_s(key, key, nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+
+	// 数据库还开着，工具照样能读。
+	stor, err := openPrimary(path)
+	if err != nil {
+		t.Fatal("openPrimary: got error: ", err)
+	}
+	scratch := stor.(*scratchStorage).dir
+	if _, err := leveldb.InspectManifest(stor, storage.FileDesc{}); err != nil {
+		t.Error("InspectManifest: got error: ", err)
+	}
+	stats, err := leveldb.InspectTables(stor, nil)
+	if err != nil {
+		t.Error("InspectTables: got error: ", err)
+	} else if len(stats.Levels) == 0 {
+		t.Errorf("InspectTables: got %+v", stats)
+	}
+	if _, err := leveldb.InspectJournals(stor, storage.TypeJournal|storage.TypeJournals, func(*leveldb.JournalRecord) error { return nil }); err != nil {
+		t.Error("InspectJournals: got error: ", err)
+	}
+	if pinned := stor.(*scratchStorage).Storage.(storage.Pinner).Pinned(); len(pinned) != 0 {
+		t.Errorf("tables still pinned after reading: %v", pinned)
+	}
+	if err := stor.Close(); err != nil {
+		t.Error("Close: got error: ", err)
+	}
+	if _, err := os.Stat(scratch); !os.IsNotExist(err) {
+		t.Errorf("scratch directory %s left behind: %v", scratch, err)
+	}
+
+	if err := runVerify([]string{path}); err != nil {
+		t.Error("verify: got error: ", err)
+	}
+	if err := db.Put([]byte("after"), []byte("v"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+}
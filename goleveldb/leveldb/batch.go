@@ -42,6 +42,13 @@ type BatchReplay interface {
 	Delete(key []byte)
 }
 
+// BatchMergeReplay is implemented by a BatchReplay that also wants to receive
+// 'merge operation'. Replay silently skips merge records otherwise.
+type BatchMergeReplay interface {
+	BatchReplay
+	Merge(key, operand []byte)
+}
+
 type batchIndex struct {
 	keyType            keyType //插入还是删除
 	keyPos, keyLen     int     //K长度和内容
@@ -93,7 +100,7 @@ func (b *Batch) grow(n int) {
 
 func (b *Batch) appendRec(kt keyType, key, value []byte) {
 	n := 1 + binary.MaxVarintLen32 + len(key)
-	if kt == keyTypeVal {
+	if kt != keyTypeDel {
 		n += binary.MaxVarintLen32 + len(value)
 	}
 	b.grow(n)
@@ -106,7 +113,7 @@ func (b *Batch) appendRec(kt keyType, key, value []byte) {
 	index.keyPos = o
 	index.keyLen = len(key)
 	o += copy(data[o:], key)
-	if kt == keyTypeVal {
+	if kt != keyTypeDel {
 		o += binary.PutUvarint(data[o:], uint64(len(value)))
 		index.valuePos = o
 		index.valueLen = len(value)
@@ -131,6 +138,15 @@ func (b *Batch) Delete(key []byte) {
 	b.appendRec(keyTypeDel, key, nil)
 }
 
+// Merge appends 'merge operation' of the given key/operand pair to the batch.
+// The operand is combined with the existing value by Options.MergeOperator
+// when the key is read or compacted.
+// It is safe to modify the contents of the argument after Merge returns but
+// not before.
+func (b *Batch) Merge(key, operand []byte) {
+	b.appendRec(keyTypeMerge, key, operand)
+}
+
 // Dump dumps batch contents. The returned slice can be loaded into the
 // batch using Load method.
 // The returned slice is not its own copy, so the contents should not be
@@ -149,12 +165,17 @@ func (b *Batch) Load(data []byte) error {
 
 // Replay replays batch contents.
 func (b *Batch) Replay(r BatchReplay) error {
+	mr, _ := r.(BatchMergeReplay)
 	for _, index := range b.index {
 		switch index.keyType {
 		case keyTypeVal:
 			r.Put(index.k(b.data), index.v(b.data))
 		case keyTypeDel:
 			r.Delete(index.k(b.data))
+		case keyTypeMerge:
+			if mr != nil {
+				mr.Merge(index.k(b.data), index.v(b.data))
+			}
 		}
 	}
 	return nil
@@ -173,6 +194,15 @@ func (b *Batch) Reset() {
 	b.internalLen = 0
 }
 
+func (b *Batch) hasMerge() bool {
+	for _, index := range b.index {
+		if index.keyType == keyTypeMerge {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *Batch) replayInternal(fn func(i int, kt keyType, k, v []byte) error) error {
 	for i, index := range b.index {
 		if err := fn(i, index.keyType, index.k(b.data), index.v(b.data)); err != nil {
@@ -234,7 +264,7 @@ func (b *Batch) putMem(seq uint64, mdb *memdb.DB) error {
 }
 
 // 由batch写入mem & revertmem重写的方法
-func (b *Batch) putMem_s(seq uint64, mdb *memdb.DBs) error {
+func (b *Batch) putMem_s(seq uint64, mdb memdb.Table_s) error {
 	var ik []byte
 	//fmt.Println("开始遍历batch，给每个数据加上8bytes的后缀")
 	for i, index := range b.index {
@@ -248,7 +278,7 @@ func (b *Batch) putMem_s(seq uint64, mdb *memdb.DBs) error {
 	//fmt.Println("所有的数据都被Put进入mem,准备返回")
 	return nil
 }
-func (b *Batch) revertMem_s(seq uint64, mdb *memdb.DBs) error {
+func (b *Batch) revertMem_s(seq uint64, mdb memdb.Table_s) error {
 	var ik []byte
 	for i, index := range b.index {
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
@@ -283,7 +313,7 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 	for i, o := 0, 0; o < len(data); i++ {
 		// Key type.
 		index.keyType = keyType(data[o])
-		if index.keyType > keyTypeVal {
+		if index.keyType > keyTypeMerge {
 			return newErrBatchCorrupted(fmt.Sprintf("bad record: invalid type %#x", uint(index.keyType)))
 		}
 		o++
@@ -299,7 +329,7 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 		o += index.keyLen
 
 		// Value.
-		if index.keyType == keyTypeVal {
+		if index.keyType != keyTypeDel {
 			x, n = binary.Uvarint(data[o:])
 			o += n
 			if n <= 0 || o+int(x) > len(data) {
@@ -349,7 +379,7 @@ func decodeBatchToMem(data []byte, expectSeq uint64, mdb *memdb.DB) (seq uint64,
 	}
 	return
 }
-func decodeBatchToMem_s(data []byte, expectSeq uint64, mdb *memdb.DBs) (seq uint64, batchLen int, err error) {
+func decodeBatchToMem_s(data []byte, expectSeq uint64, mdb memdb.Table_s) (seq uint64, batchLen int, err error) {
 	seq, batchLen, err = decodeBatchHeader(data)
 	if err != nil {
 		return 0, 0, err
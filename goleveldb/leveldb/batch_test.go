@@ -56,13 +56,19 @@ func TestBatch(t *testing.T) {
 		return nil
 	}
 	f := func(ktr uint8, k, v []byte) bool {
-		kt := keyType(ktr % 2)
-		if kt == keyTypeVal {
+		kt := keyType(ktr % 3)
+		switch kt {
+		case keyTypeVal:
 			batch.Put(k, v)
 			rbatch.Put(k, v)
 			kvs = append(kvs, batchKV{kt: kt, k: k, v: v})
 			internalLen += len(k) + len(v) + 8
-		} else {
+		case keyTypeMerge:
+			batch.Merge(k, v)
+			rbatch.Merge(k, v)
+			kvs = append(kvs, batchKV{kt: kt, k: k, v: v})
+			internalLen += len(k) + len(v) + 8
+		default:
 			batch.Delete(k)
 			rbatch.Delete(k)
 			kvs = append(kvs, batchKV{kt: kt, k: k})
@@ -497,3 +497,56 @@ func TestCorruptDB_RecoverTable(t *testing.T) {
 	}
 	h.check(985, 985)
 }
+
+func TestCorruptDB_RecoverStateTree(t *testing.T) {
+	h := newDbCorruptHarness(t)
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	for i := 0; i < 100; i++ {
+		if err := h.db.Put_s([]byte(fmt.Sprintf("s%03d", i)), []byte(fmt.Sprintf("v%03d", i)), nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem_s(0, true)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+	// Only in the state journal.
+	if err := h.db.Put_s([]byte("s-journal"), []byte("vj"), nil); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	h.closeDB()
+
+	h.forceRemoveAll(storage.TypeManifest)
+	h.recover()
+	h.check(100, 100)
+
+	props, err := h.db.GetTableProperties(opt.KeyspaceState)
+	if err != nil {
+		t.Fatal("GetTableProperties: got error: ", err)
+	}
+	if len(props) == 0 {
+		t.Fatal("state tree is empty after recovery")
+	}
+	for _, p := range props {
+		if p.Properties == nil || p.Properties.Keyspace != "state" {
+			t.Fatalf("state table @%d: got properties %+v", p.Num, p.Properties)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		v, err := h.db.Get_s([]byte(fmt.Sprintf("s%03d", i)), nil)
+		if err != nil || string(v) != fmt.Sprintf("v%03d", i) {
+			t.Fatalf("Get_s s%03d: got %q, %v", i, v, err)
+		}
+	}
+	if v, err := h.db.Get_s([]byte("s-journal"), nil); err != nil || string(v) != "vj" {
+		t.Fatalf("Get_s s-journal: got %q, %v", v, err)
+	}
+	if _, err := h.db.Get([]byte("s000"), nil); err != ErrNotFound {
+		t.Fatalf("Get s000: got %v, want ErrNotFound", err)
+	}
+}
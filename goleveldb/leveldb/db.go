@@ -48,20 +48,59 @@ type DB struct {
 	nonLevel0Comp  uint32 // The cumulative number of non-level0 compaction
 	nonLevel0Comps uint32 // The cumulative number of non-level0 compaction
 	seekComp       uint32 // The cumulative number of seek compaction
+	deletionComp   uint32 // The cumulative number of deletion-ratio compaction
 
 	// Session.表示一个持久的数据库会话
 	s *session
 
+	// Set if the DB follows a primary, see OpenSecondary. secVersions are
+	// the versions the secondary made current that may still be in use,
+	// guarded by s.vmu; see unpinTables.
+	secondary   bool
+	catchUpMu   sync.Mutex
+	secVersions []*version
+
+	// Change subscriptions, see Subscribe. journalHold counts subscriptions
+	// replaying journals; no journal is removed while it isn't zero.
+	subsMu      sync.Mutex
+	subs        map[*Subscription]struct{}
+	subsN       int32
+	journalHold int
+	// journalLast is the last sequence number in the current journal of
+	// each keyspace, zero if it has none; guarded by the write lock.
+	journalLast [2]uint64
+
 	// MemDB.
 	memMu                            sync.RWMutex //读写锁
 	memPool                          chan *memdb.DB
-	memPools                         chan *memdb.DBs
-	mem, mems, frozenMems, frozenMem *memDB //指针 memDB中由db和*memdb.DB
+	memPools                         chan memdb.Table_s
+	mem, mems, frozenMems, frozenMem *memDB       //指针 memDB中由db和*memdb.DB
+	budget                           *writeBudget // nil 表示 WriteBuffer 和 WriteBuffer2 固定不变
 	journal                          *journal.Writer
 	journal2                         *journal.Writer2
 	journalWriter                    storage.Writer
 	journalWriter2                   storage.Writer
 
+	// Journal sync, see opt.JournalSync. jsyncMu is held while a journal
+	// writer is synced in the background or replaced.
+	jsync      [2]journalSyncStats
+	jsyncMu    sync.Mutex
+	jsyncKickC chan struct{} // nil 表示没有后台 sync
+
+	// WAL-less writes, see opt.WriteOptions.DisableWAL. walLess is set
+	// while the effective memdb of the keyspace holds such writes.
+	walLessMu sync.Mutex
+	walLess   [2]uint32
+
+	// Cold tier mover, see opt.Options.ColdLevel. tiers is only used by
+	// the mover and records whether a table is known to be cold.
+	tierKickC chan struct{} // nil 表示没有冷热分层
+	tiers     map[int64]bool
+
+	// Journal files kept for reuse, see opt.Options.JournalRecycle.
+	jfreeMu sync.Mutex
+	jfree   []storage.FileDesc
+
 	journalFd       storage.FileDesc //newmem生成日志的fd
 	frozenJournalFd storage.FileDesc //对应frozen的那一条
 	frozenSeq       uint64           //seq N //db.seq
@@ -79,10 +118,11 @@ type DB struct {
 	batchPools sync.Pool //另外的一个池
 	//writeMergeC、writeMergedC、writeAckC和writeLockC共同控制多线程的数据插入和合并操作
 	writeMergeC  chan writeMerge //写入合并，channel可以携带插入的数据
-	writeMergedC chan bool
-	writeLockC   chan struct{} //可以缓存一个对象？还是不可以
+	writeMergedC chan chan error // 合并成功时传来写入组的 ack channel，交出写锁时为 nil
+	writeLockC   chan struct{}   //可以缓存一个对象？还是不可以
 	writeAckC    chan error
 	writeDelay   time.Duration
+	memw         *memWriters // 已分配 seq 但还没发布的写入组
 
 	writeMergeCs  chan writeMerge //写入合并，channel可以携带插入的数据
 	writeMergedCs chan bool
@@ -110,6 +150,7 @@ type DB struct {
 	compPerErrCs chan error
 	compErrSetCs chan error
 
+	compSched            *compScheduler
 	compWriteLocking     bool
 	compStats, comStatss cStats
 	memdbMaxLevel        int // For testing.
@@ -159,17 +200,19 @@ func openDB(s *session) (*DB, error) {
 		// Initial sequence
 		seq: s.stSeqNum, //什么作用？
 		// MemDB
-		memPool:  make(chan *memdb.DB, 1),  //make一个通道的对象
-		memPools: make(chan *memdb.DBs, 1), //make另外的一个通道
+		memPool:  make(chan *memdb.DB, 1),     //make一个通道的对象
+		memPools: make(chan memdb.Table_s, 1), //make另外的一个通道
+		budget:   newWriteBudget(s.o.Options),
 		// Snapshot
 		snapsList: list.New(), //快照
 		// Write
 		batchPool:     sync.Pool{New: newBatch},
 		batchPools:    sync.Pool{New: newBatch}, //两个batch池
 		writeMergeC:   make(chan writeMerge),
-		writeMergedC:  make(chan bool),
+		writeMergedC:  make(chan chan error),
 		writeLockC:    make(chan struct{}, 1),
 		writeAckC:     make(chan error),
+		memw:          newMemWriters(),
 		writeMergeCs:  make(chan writeMerge),
 		writeMergedCs: make(chan bool),
 		writeLockCs:   make(chan struct{}, 1),
@@ -191,6 +234,12 @@ func openDB(s *session) (*DB, error) {
 		// Close
 		closeC: make(chan struct{}),
 	} //给DB赋值
+	db.compSched = newCompScheduler(s.o.GetMaxBackgroundCompactions(), db.compPriority)
+
+	// 上次 bulk load 没关库就崩了，不写日志的数据已经丢了。
+	if err := db.checkWALLess(); err != nil {
+		return nil, err
+	}
 
 	// Read-only mode.
 	readOnly := s.o.GetReadOnly() //只读模式
@@ -200,6 +249,9 @@ func openDB(s *session) (*DB, error) {
 		if err := db.recoverJournalRO(); err != nil {
 			return nil, err
 		}
+		if err := db.recoverJournalRO_s(); err != nil {
+			return nil, err
+		}
 	} else { //必走这一条，从两个log中恢复，这里会有问题
 		// Recover journals.
 		if db.WhichLogFirst() {
@@ -236,6 +288,7 @@ func openDB(s *session) (*DB, error) {
 			}
 			return nil, err
 		}
+		db.placeTables()
 	}
 
 	// Doesn't need to be included in the wait group.
@@ -251,6 +304,16 @@ func openDB(s *session) (*DB, error) {
 		go db.mCompaction()   //minor
 		go db.tCompaction_s() //major
 		go db.mCompaction_s() //minor
+		if !db.s.o.GetNoSync() && (db.s.o.GetJournalSync() == opt.SyncBackground || db.s.o.GetJournalSync2() == opt.SyncBackground) {
+			db.jsyncKickC = make(chan struct{}, 1)
+			db.closeW.Add(1)
+			go db.jSyncLoop()
+		}
+		if db.s.o.GetColdDir() != "" && (db.s.o.GetColdLevel() > 0 || db.s.o.GetColdLevel2() > 0) && db.s.stor.canTier() {
+			db.tierKickC = make(chan struct{}, 1)
+			db.closeW.Add(1)
+			go db.tierLoop()
+		}
 		// go db.jWriter()
 	}
 
@@ -307,7 +370,9 @@ func Open(stor storage.Storage, o *opt.Options) (db *DB, err error) {
 // os.ErrExist error.
 //
 // OpenFile uses standard file-system backed storage implementation as
-// described in the leveldb/storage package.
+// described in the leveldb/storage package. The tables and journals are
+// placed in the directories set by opt.Options.TableDir, StateTableDir and
+// JournalDir.
 //
 // OpenFile will return an error with type of ErrCorrupted if corruption
 // detected in the DB. Use errors.IsCorrupted to test whether an error is
@@ -316,7 +381,7 @@ func Open(stor storage.Storage, o *opt.Options) (db *DB, err error) {
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func OpenFile(path string, o *opt.Options) (db *DB, err error) {
-	stor, err := storage.OpenFile(path, o.GetReadOnly())
+	stor, err := openFileStorage(path, o, o.GetReadOnly())
 	if err != nil {
 		return
 	}
@@ -334,6 +399,12 @@ func OpenFile(path string, o *opt.Options) (db *DB, err error) {
 // The DB must already exist or it will returns an error.
 // Also, Recover will ignore ErrorIfMissing and ErrorIfExist options.
 //
+// Tables are put back into the main or the state tree according to the
+// keyspace recorded in their properties block; tables written before
+// properties existed are classified using what can still be read from the
+// old manifest, otherwise they go to the main tree. Both journal families
+// are then replayed as on a normal open.
+//
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func Recover(stor storage.Storage, o *opt.Options) (db *DB, err error) {
@@ -366,7 +437,7 @@ func Recover(stor storage.Storage, o *opt.Options) (db *DB, err error) {
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func RecoverFile(path string, o *opt.Options) (db *DB, err error) {
-	stor, err := storage.OpenFile(path, false)
+	stor, err := openFileStorage(path, o, false)
 	if err != nil {
 		return
 	}
@@ -394,6 +465,7 @@ func recoverTable(s *session, o *opt.Options) error {
 	var (
 		maxSeq                                                            uint64
 		recoveredKey, goodKey, corruptedKey, corruptedBlock, droppedTable int
+		recoveredState                                                    int
 
 		// We will drop corrupted table.
 		strict = o.GetStrict(opt.StrictRecovery)
@@ -402,9 +474,36 @@ func recoverTable(s *session, o *opt.Options) error {
 		rec   = &sessionRecord{}
 		bpool = util.NewBufferPool(o.GetBlockSize() + 5)
 	)
-	buildTable := func(iter iterator.Iterator) (tmpFd storage.FileDesc, size int64, err error) {
+	// Tables without a properties block are classified by whatever can
+	// still be read from the old manifest, and fall back to the main tree.
+	stateHint := make(map[int64]bool)
+	if report, _ := InspectManifest(s.stor, storage.FileDesc{}); report != nil {
+		for _, l := range report.LevelsState {
+			for _, t := range l.Tables {
+				stateHint[t.Num] = true
+			}
+		}
+	}
+	tableKeyspace := func(fd storage.FileDesc, tr *table.Reader) opt.Keyspace {
+		if p, err := tr.Properties(); err == nil && p != nil && p.Keyspace != "" {
+			if p.Keyspace == opt.KeyspaceState.String() {
+				return opt.KeyspaceState
+			}
+			return opt.KeyspaceMain
+		}
+		if stateHint[fd.Num] {
+			return opt.KeyspaceState
+		}
+		return opt.KeyspaceMain
+	}
+
+	buildTable := func(iter iterator.Iterator, ks opt.Keyspace) (tmpFd storage.FileDesc, size int64, err error) {
 		tmpFd = s.newTemp()
-		writer, err := s.stor.Create(tmpFd)
+		create := s.stor.Create
+		if ks == opt.KeyspaceState {
+			create = s.stor.Create_s
+		}
+		writer, err := create(tmpFd)
 		if err != nil {
 			return
 		}
@@ -418,19 +517,33 @@ func recoverTable(s *session, o *opt.Options) error {
 
 		// Copy entries.
 		tw := table.NewWriter(writer, o)
+		props := &table.Properties{Keyspace: ks.String(), Producer: "repair", CreationTime: time.Now().Unix()}
 		for iter.Next() {
 			key := iter.Key()
-			if validInternalKey(key) {
+			if _, seq, kt, kerr := parseInternalKey(key); kerr == nil {
 				err = tw.Append(key, iter.Value())
 				if err != nil {
 					return
 				}
+				switch kt {
+				case keyTypeDel:
+					props.NumDeletions++
+				case keyTypeMerge:
+					props.NumMerges++
+				}
+				if tw.EntriesLen() == 1 || seq < props.SmallestSeq {
+					props.SmallestSeq = seq
+				}
+				if seq > props.LargestSeq {
+					props.LargestSeq = seq
+				}
 			}
 		}
 		err = iter.Error()
 		if err != nil && !errors.IsCorrupted(err) {
 			return
 		}
+		tw.SetProperties(props)
 		err = tw.Close()
 		if err != nil {
 			return
@@ -472,6 +585,7 @@ func recoverTable(s *session, o *opt.Options) error {
 		if err != nil {
 			return err
 		}
+		ks := tableKeyspace(fd, tr)
 		iter := tr.NewIterator(nil, nil)
 		if itererr, ok := iter.(iterator.ErrorCallbackSetter); ok {
 			itererr.SetErrorCallback(func(err error) {
@@ -511,7 +625,7 @@ func recoverTable(s *session, o *opt.Options) error {
 
 		if strict && (tcorruptedKey > 0 || tcorruptedBlock > 0) {
 			droppedTable++
-			s.logf("table@recovery dropped @%d Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
+			s.logf("table@recovery dropped @%d K·%s Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, ks, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
 			return nil
 		}
 
@@ -520,7 +634,7 @@ func recoverTable(s *session, o *opt.Options) error {
 				// Rebuild the table.
 				s.logf("table@recovery rebuilding @%d", fd.Num)
 				iter := tr.NewIterator(nil, nil)
-				tmpFd, newSize, err := buildTable(iter)
+				tmpFd, newSize, err := buildTable(iter, ks)
 				iter.Release()
 				if err != nil {
 					return err
@@ -536,12 +650,17 @@ func recoverTable(s *session, o *opt.Options) error {
 				maxSeq = tSeq
 			}
 			recoveredKey += tgoodKey
-			// Add table to level 0.
-			rec.addTable(0, fd.Num, size, imin, imax)
-			s.logf("table@recovery recovered @%d Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
+			// Add table to level 0 of its tree.
+			if ks == opt.KeyspaceState {
+				rec.addTable_s(0, fd.Num, size, imin, imax)
+				recoveredState++
+			} else {
+				rec.addTable(0, fd.Num, size, imin, imax)
+			}
+			s.logf("table@recovery recovered @%d K·%s Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, ks, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
 		} else {
 			droppedTable++
-			s.logf("table@recovery unrecoverable @%d Ck·%d Cb·%d S·%d", fd.Num, tcorruptedKey, tcorruptedBlock, size)
+			s.logf("table@recovery unrecoverable @%d K·%s Ck·%d Cb·%d S·%d", fd.Num, ks, tcorruptedKey, tcorruptedBlock, size)
 		}
 
 		return nil
@@ -560,7 +679,7 @@ func recoverTable(s *session, o *opt.Options) error {
 			}
 		}
 
-		s.logf("table@recovery recovered F·%d N·%d Gk·%d Ck·%d Q·%d", len(fds), recoveredKey, goodKey, corruptedKey, maxSeq)
+		s.logf("table@recovery recovered F·%d Fs·%d N·%d Gk·%d Ck·%d Q·%d", len(fds), recoveredState, recoveredKey, goodKey, corruptedKey, maxSeq)
 	}
 
 	// Set sequence number.
@@ -667,6 +786,7 @@ func (db *DB) recoverJournal() error {
 			} else {
 				jr.Reset(fr, dropper{db.s, fd}, strict, checksum)
 			}
+			jr.SetNum(fd.Num)
 			//fmt.Println(rec.addedTables)
 			// Flush memdb and remove obsolete journal file.
 			if !ofd.Zero() { //基本不会执行？ ofd.Zero() is true
@@ -685,7 +805,7 @@ func (db *DB) recoverJournal() error {
 				}
 				rec.resetAddedTables()
 
-				db.s.stor.Remove(ofd)
+				db.retireJournal(ofd)
 				ofd = storage.FileDesc{}
 			}
 			//fmt.Println("ASDASDASDASADADADAD2222")
@@ -725,8 +845,11 @@ func (db *DB) recoverJournal() error {
 					return errors.SetFd(err, fd)
 				}
 				//fmt.Println("mdb的容量：",mdb.Size())
-				// Save sequence number.
-				db.seq = batchSeq + uint64(batchLen)
+				// Save sequence number. The two journal families share the
+				// sequence, keep the largest one seen.
+				if seq := batchSeq + uint64(batchLen); seq > db.seq {
+					db.seq = seq
+				}
 
 				// Flush it if large enough.
 				if mdb.Size() >= writeBuffer {
@@ -770,7 +893,7 @@ func (db *DB) recoverJournal() error {
 
 	// Remove the last obsolete journal file.
 	if !ofd.Zero() {
-		db.s.stor.Remove(ofd)
+		db.retireJournal(ofd)
 	}
 
 	return nil
@@ -830,6 +953,7 @@ func (db *DB) recoverJournal_s() error {
 			} else {
 				jr.Reset(fr, dropper{db.s, fd}, strict, checksum)
 			}
+			jr.SetNum(fd.Num)
 			// Flush memdb and remove obsolete journal file.
 			if !ofd.Zero() {
 				if mdbs.Len_s() > 0 {
@@ -848,7 +972,7 @@ func (db *DB) recoverJournal_s() error {
 				}
 				rec.resetAddedTables_s()
 
-				db.s.stor.Remove(ofd)
+				db.retireJournal(ofd)
 				ofd = storage.FileDesc{}
 			}
 			//fmt.Println("ASDASDASDASADADADAD3333")
@@ -887,8 +1011,10 @@ func (db *DB) recoverJournal_s() error {
 					return errors.SetFd(err, fd)
 				}
 				//fmt.Println("12345")
-				// Save sequence number.
-				db.seq = batchSeq + uint64(batchLen)
+				// Save sequence number, see recoverJournal.
+				if seq := batchSeq + uint64(batchLen); seq > db.seq {
+					db.seq = seq
+				}
 				//fmt.Println("mdbs的容量：",mdbs.Size_s())
 				// Flush it if large enough.
 				if mdbs.Size_s() >= writeBuffer {
@@ -934,36 +1060,43 @@ func (db *DB) recoverJournal_s() error {
 
 	// Remove the last obsolete journal file.
 	if !ofd.Zero() {
-		db.s.stor.Remove(ofd)
+		db.retireJournal(ofd)
 	}
 
 	return nil
 } //写日志的时候有问题，List，如果要改应该写入两个日志之中；
 func (db *DB) recoverJournalRO() error {
-	// Get all journals and sort it by file number.
-	rawFds, err := db.s.stor.List(storage.TypeJournal)
+	mdb, seq, err := db.readJournalsRO()
 	if err != nil {
 		return err
 	}
-	sortFds(rawFds)
+	db.mem = &memDB{db: db, DB: mdb, ref: 1}
+	if seq > db.seq {
+		db.seq = seq
+	}
+	return nil
+}
 
-	// Journals that will be recovered.
-	var fds []storage.FileDesc
-	for _, fd := range rawFds {
-		if fd.Num >= db.s.stJournalNum || fd.Num == db.s.stPrevJournalNum {
-			fds = append(fds, fd)
-		}
+// readJournalsRO replays the journals into a new memdb without writing
+// anything, and returns the memdb and the largest sequence seen.
+func (db *DB) readJournalsRO() (mdb *memdb.DB, seq uint64, err error) {
+	// Get all journals and sort it by file number. Like recoverJournal every
+	// journal left on disk is replayed, the journal number of the manifest
+	// is shared by both journal families and can't be used to filter.
+	fds, err := db.s.stor.List(storage.TypeJournal)
+	if err != nil {
+		return
 	}
+	sortFds(fds)
 
 	var (
 		// Options.
 		strict      = db.s.o.GetStrict(opt.StrictJournal)
 		checksum    = db.s.o.GetStrict(opt.StrictJournalChecksum)
 		writeBuffer = db.s.o.GetWriteBuffer()
-		//创建一个初始化的mdb，是只添加
-		mdb  = memdb.New(db.s.icmp, writeBuffer)
-		mdbs = memdb.New_s(db.s.icmp, writeBuffer)
 	)
+	//创建一个初始化的mdb，是只添加
+	mdb = memdb.New(db.s.icmp, writeBuffer)
 
 	// Recover journals.
 	if len(fds) > 0 {
@@ -981,7 +1114,12 @@ func (db *DB) recoverJournalRO() error {
 
 			fr, err := db.s.stor.Open(fd)
 			if err != nil {
-				return err
+				if os.IsNotExist(err) {
+					// 主库在 flush 之后删除了它，内容已经在表里。
+					db.logf("journal@recovery missing @%d (skipped)", fd.Num)
+					continue
+				}
+				return nil, 0, err
 			}
 
 			// Create or reset journal reader instance.
@@ -990,6 +1128,7 @@ func (db *DB) recoverJournalRO() error {
 			} else {
 				jr.Reset(fr, dropper{db.s, fd}, strict, checksum)
 			}
+			jr.SetNum(fd.Num)
 
 			// Replay journal to memdb.
 			for {
@@ -1000,7 +1139,7 @@ func (db *DB) recoverJournalRO() error {
 					}
 
 					fr.Close()
-					return errors.SetFd(err, fd)
+					return nil, 0, errors.SetFd(err, fd)
 				}
 
 				buf.Reset()
@@ -1011,9 +1150,9 @@ func (db *DB) recoverJournalRO() error {
 					}
 
 					fr.Close()
-					return errors.SetFd(err, fd)
+					return nil, 0, errors.SetFd(err, fd)
 				}
-				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
+				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.getSeq(), mdb)
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
 						db.s.logf("journal error: %v (skipped)", err)
@@ -1022,24 +1161,133 @@ func (db *DB) recoverJournalRO() error {
 					}
 
 					fr.Close()
-					return errors.SetFd(err, fd)
+					return nil, 0, errors.SetFd(err, fd)
 				}
 
-				// Save sequence number.
-				db.seq = batchSeq + uint64(batchLen)
+				// Save sequence number, see recoverJournal.
+				if batchSeq+uint64(batchLen) > seq {
+					seq = batchSeq + uint64(batchLen)
+				}
 			}
 
 			fr.Close()
 		}
 	}
 
-	// Set memDB.
-	//db.mem为memDB类型，
-	db.mem = &memDB{db: db, DB: mdb, ref: 1}
-	db.mems = &memDB{db: db, DBs: mdbs, refs: 1}
+	return mdb, seq, nil
+}
+
+// recoverJournalRO_s replays the state journals into a read-only memdb.
+func (db *DB) recoverJournalRO_s() error {
+	mdbs, seq, err := db.readJournalsRO_s()
+	if err != nil {
+		return err
+	}
+	db.mems = &memDB{db: db, Table_s: mdbs, refs: 1}
+	if seq > db.seq {
+		db.seq = seq
+	}
 	return nil
 }
 
+// readJournalsRO_s is readJournalsRO for the state journals.
+func (db *DB) readJournalsRO_s() (mdbs memdb.Table_s, seq uint64, err error) {
+	// Get all state journals and sort it by file number, see
+	// recoverJournalRO.
+	fds, err := db.s.stor.List(storage.TypeJournals)
+	if err != nil {
+		return
+	}
+	sortFds(fds)
+
+	var (
+		// Options.
+		strict      = db.s.o.GetStrict(opt.StrictJournal)
+		checksum    = db.s.o.GetStrict(opt.StrictJournalChecksum)
+		writeBuffer = db.s.o.GetWriteBuffer2()
+	)
+	//创建一个初始化的mdb，是只添加
+	mdbs = memdb.New_s(db.s.icmp, writeBuffer)
+
+	// Recover journals.
+	if len(fds) > 0 {
+		db.logf("journal@recovery RO·Mode Fs·%d", len(fds))
+
+		var (
+			jr       *journal.Reader
+			buf      = &util.Buffer{}
+			batchSeq uint64
+			batchLen int
+		)
+
+		for _, fd := range fds {
+			db.logf("journal@recovery recovering logs @%d", fd.Num)
+
+			fr, err := db.s.stor.Open(fd)
+			if err != nil {
+				if os.IsNotExist(err) {
+					// 主库在 flush 之后删除了它，内容已经在表里。
+					db.logf("journal@recovery missing @%d (skipped)", fd.Num)
+					continue
+				}
+				return nil, 0, err
+			}
+
+			// Create or reset journal reader instance.
+			if jr == nil {
+				jr = journal.NewReader(fr, dropper{db.s, fd}, strict, checksum)
+			} else {
+				jr.Reset(fr, dropper{db.s, fd}, strict, checksum)
+			}
+			jr.SetNum(fd.Num)
+
+			// Replay journal to memdb.
+			for {
+				r, err := jr.Next()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+
+					fr.Close()
+					return nil, 0, errors.SetFd(err, fd)
+				}
+
+				buf.Reset()
+				if _, err := buf.ReadFrom(r); err != nil {
+					if err == io.ErrUnexpectedEOF {
+						// This is error returned due to corruption, with strict == false.
+						continue
+					}
+
+					fr.Close()
+					return nil, 0, errors.SetFd(err, fd)
+				}
+				batchSeq, batchLen, err = decodeBatchToMem_s(buf.Bytes(), db.getSeq(), mdbs)
+				if err != nil {
+					if !strict && errors.IsCorrupted(err) {
+						db.s.logf("journal error: %v (skipped)", err)
+						// We won't apply sequence number as it might be corrupted.
+						continue
+					}
+
+					fr.Close()
+					return nil, 0, errors.SetFd(err, fd)
+				}
+
+				// Save sequence number, see recoverJournal.
+				if batchSeq+uint64(batchLen) > seq {
+					seq = batchSeq + uint64(batchLen)
+				}
+			}
+
+			fr.Close()
+		}
+	}
+
+	return mdbs, seq, nil
+}
+
 func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
 	mk, mv, err := mdb.Find(ikey)
 	if err == nil {
@@ -1049,8 +1297,11 @@ func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byt
 			panic(kerr)
 		}
 		if icmp.uCompare(ukey, ikey.ukey()) == 0 {
-			if kt == keyTypeDel {
+			switch kt {
+			case keyTypeDel:
 				return true, nil, ErrNotFound
+			case keyTypeMerge:
+				return true, mv, errMergeOperand
 			}
 			return true, mv, nil
 
@@ -1060,7 +1311,7 @@ func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byt
 	}
 	return
 }
-func memGet_s(mdb *memdb.DBs, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
+func memGet_s(mdb memdb.Table_s, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
 	mk, mv, err := mdb.Find_s(ikey)
 	if err == nil {
 		ukey, _, kt, kerr := parseInternalKey(mk)
@@ -1069,8 +1320,11 @@ func memGet_s(mdb *memdb.DBs, ikey internalKey, icmp *iComparer) (ok bool, mv []
 			panic(kerr)
 		}
 		if icmp.uCompare(ukey, ikey.ukey()) == 0 {
-			if kt == keyTypeDel {
+			switch kt {
+			case keyTypeDel:
 				return true, nil, ErrNotFound
+			case keyTypeMerge:
+				return true, mv, errMergeOperand
 			}
 			return true, mv, nil
 
@@ -1086,6 +1340,9 @@ func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 
 	if auxm != nil {
 		if ok, mv, me := memGet(auxm, ikey, db.s.icmp); ok {
+			if me == errMergeOperand {
+				return db.getMerge(auxm, auxt, ikey, ro)
+			}
 			//内建函数append将元素追加到切片的末尾。若它有足够的容量，其目标就会
 			// 重新切片以容纳新的元素。否则，就会分配一个新的基本数组。append返回
 			// 更新后的切片，因此必须存储追加后的结果
@@ -1103,6 +1360,9 @@ func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 
 		if ok, mv, me := memGet(m.DB, ikey, db.s.icmp); ok {
 			fmt.Println("get from memDb")
+			if me == errMergeOperand {
+				return db.getMerge(auxm, auxt, ikey, ro)
+			}
 			return append([]byte{}, mv...), me
 		}
 	}
@@ -1117,13 +1377,19 @@ func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
 	}
+	if err == errMergeOperand {
+		return db.getMerge(auxm, auxt, ikey, ro)
+	}
 	return
 }
-func (db *DB) get_s(auxm *memdb.DBs, auxt sFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
+func (db *DB) get_s(auxm memdb.Table_s, auxt sFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek) //把key变为internalKey，其实就是加个8bytes，7bytes的seq N，1byte的操作类型
 
 	if auxm != nil {
 		if ok, mv, me := memGet_s(auxm, ikey, db.s.icmp); ok {
+			if me == errMergeOperand {
+				return db.getMerge_s(auxm, auxt, ikey, ro)
+			}
 			//内建函数append将元素追加到切片的末尾。若它有足够的容量，其目标就会
 			// 重新切片以容纳新的元素。否则，就会分配一个新的基本数组。append返回
 			// 更新后的切片，因此必须存储追加后的结果
@@ -1140,7 +1406,10 @@ func (db *DB) get_s(auxm *memdb.DBs, auxt sFiles, key []byte, seq uint64, ro *op
 		}
 		defer m.decref_s()
 
-		if ok, mv, me := memGet_s(m.DBs, ikey, db.s.icmp); ok {
+		if ok, mv, me := memGet_s(m.Table_s, ikey, db.s.icmp); ok {
+			if me == errMergeOperand {
+				return db.getMerge_s(auxm, auxt, ikey, ro)
+			}
 			return append([]byte{}, mv...), me
 		}
 	}
@@ -1153,6 +1422,9 @@ func (db *DB) get_s(auxm *memdb.DBs, auxt sFiles, key []byte, seq uint64, ro *op
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdCs)
 	}
+	if err == errMergeOperand {
+		return db.getMerge_s(auxm, auxt, ikey, ro)
+	}
 	return
 }
 
@@ -1163,12 +1435,19 @@ func nilIfNotFound(err error) error {
 	return err
 }
 
+func nilIfMergeOrNotFound(err error) error {
+	if err == errMergeOperand {
+		return nil
+	}
+	return nilIfNotFound(err)
+}
+
 func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
 
 	if auxm != nil {
 		if ok, _, me := memGet(auxm, ikey, db.s.icmp); ok {
-			return me == nil, nilIfNotFound(me)
+			return me == nil || me == errMergeOperand, nilIfMergeOrNotFound(me)
 		}
 	}
 
@@ -1180,7 +1459,7 @@ func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 		defer m.decref()
 
 		if ok, _, me := memGet(m.DB, ikey, db.s.icmp); ok {
-			return me == nil, nilIfNotFound(me)
+			return me == nil || me == errMergeOperand, nilIfMergeOrNotFound(me)
 		}
 	}
 
@@ -1191,8 +1470,8 @@ func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
 	}
-	if err == nil {
-		ret = true
+	if err == nil || err == errMergeOperand {
+		ret, err = true, nil
 	} else if err == ErrNotFound {
 		err = nil
 	}
@@ -1300,12 +1579,18 @@ func (db *DB) GetSnapshot() (*Snapshot, error) {
 //		Returns statistics of effective disk read and write.
 //	leveldb.writedelay
 //		Returns cumulative write delay caused by compaction.
+//	leveldb.ratelimit
+//		Returns rate limited table writes and wait time per keyspace.
 //	leveldb.sstables
 //		Returns sstables list for each level.
 //	leveldb.blockpool
 //		Returns block pool stats.
 //	leveldb.cachedblock
 //		Returns size of cached block.
+//	leveldb.memory
+//		Returns memdb and block cache allocations against their limits.
+//	leveldb.journalsync
+//		Returns journal sync policy, fsyncs and commit group sizes per keyspace.
 //	leveldb.openedtables
 //		Returns number of opened tables.
 //	leveldb.alivesnaps
@@ -1364,11 +1649,17 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 			totalTables, float64(totalSize)/1048576.0, totalDuration.Seconds(),
 			float64(totalRead)/1048576.0, float64(totalWrite)/1048576.0)
 	case p == "compcount":
-		value = fmt.Sprintf("MemComp:%d Level0Comp:%d NonLevel0Comp:%d SeekComp:%d", atomic.LoadUint32(&db.memComp), atomic.LoadUint32(&db.level0Comp), atomic.LoadUint32(&db.nonLevel0Comp), atomic.LoadUint32(&db.seekComp))
+		value = fmt.Sprintf("MemComp:%d Level0Comp:%d NonLevel0Comp:%d SeekComp:%d DeletionComp:%d", atomic.LoadUint32(&db.memComp), atomic.LoadUint32(&db.level0Comp), atomic.LoadUint32(&db.nonLevel0Comp), atomic.LoadUint32(&db.seekComp), atomic.LoadUint32(&db.deletionComp))
 	case p == "iostats":
 		value = fmt.Sprintf("Read(MB):%.5f Write(MB):%.5f",
 			float64(db.s.stor.reads())/1048576.0,
 			float64(db.s.stor.writes())/1048576.0)
+	case p == "ratelimit":
+		for _, ks := range []opt.Keyspace{opt.KeyspaceMain, opt.KeyspaceState} {
+			value += fmt.Sprintf("%s Write(MB):%.5f Wait:%s\n", ks,
+				float64(atomic.LoadInt64(&db.s.tops.limitedBytes[ks]))/1048576.0,
+				time.Duration(atomic.LoadInt64(&db.s.tops.limitedWait[ks])))
+		}
 	case p == "writedelay":
 		writeDelayN, writeDelay := atomic.LoadInt32(&db.cWriteDelayN), time.Duration(atomic.LoadInt64(&db.cWriteDelay))
 		paused := atomic.LoadInt32(&db.inWritePaused) == 1
@@ -1388,6 +1679,28 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 		} else {
 			value = "<nil>"
 		}
+	case p == "memory":
+		var s DBStats
+		db.memoryStats(&s)
+		for _, ks := range []opt.Keyspace{opt.KeyspaceMain, opt.KeyspaceState} {
+			value += fmt.Sprintf("%s MemTable(MB):%.5f WriteBuffer(MB):%.5f\n", ks,
+				float64(s.MemTableCapacity[ks])/1048576.0, float64(s.WriteBuffer[ks])/1048576.0)
+		}
+		value += fmt.Sprintf("BlockCache(MB):%.5f/%.5f Limit(MB):%.5f\n",
+			float64(s.BlockCacheSize)/1048576.0, float64(s.BlockCacheCapacity)/1048576.0,
+			float64(db.s.o.GetMemoryLimit())/1048576.0)
+	case p == "journalsync":
+		var s DBStats
+		db.journalSyncStats(&s)
+		for _, ks := range []opt.Keyspace{opt.KeyspaceMain, opt.KeyspaceState} {
+			var avg float64
+			if s.JournalSyncs[ks] > 0 {
+				avg = float64(s.JournalSyncRecords[ks]) / float64(s.JournalSyncs[ks])
+			}
+			value += fmt.Sprintf("%s Policy:%s Syncs:%d Time:%s Max:%s Records:%d Group:%.2f Bytes(MB):%.5f\n", ks,
+				db.journalSyncPolicy(ks), s.JournalSyncs[ks], s.JournalSyncTime[ks], s.JournalSyncMaxTime[ks],
+				s.JournalSyncRecords[ks], avg, float64(s.JournalSyncBytes[ks])/1048576.0)
+		}
 	case p == "openedtables":
 		value = fmt.Sprintf("%d", db.s.tops.cache.Size())
 	case p == "alivesnaps":
@@ -1413,8 +1726,35 @@ type DBStats struct {
 	IOWrite uint64
 	IORead  uint64
 
-	BlockCacheSize    int
-	OpenedTablesCount int
+	// Table writes passed through the rate limiter and time spent waiting
+	// for it, indexed by opt.Keyspace.
+	RateLimitedWrite [2]int64
+	RateLimitedWait  [2]time.Duration
+
+	BlockCacheSize     int
+	BlockCacheCapacity int
+	OpenedTablesCount  int
+
+	// MemTableCapacity is the buffer capacity of the active and frozen
+	// 'memdb', and WriteBuffer the size the next 'memdb' is created with,
+	// indexed by opt.Keyspace.
+	MemTableCapacity [2]int
+	WriteBuffer      [2]int
+
+	// Journal fsyncs, the time spent in them, and the records and bytes
+	// they made durable, indexed by opt.Keyspace. JournalSyncRecords
+	// divided by JournalSyncs is the average commit group size.
+	JournalSyncs       [2]int64
+	JournalSyncTime    [2]time.Duration
+	JournalSyncMaxTime [2]time.Duration
+	JournalSyncRecords [2]int64
+	JournalSyncBytes   [2]int64
+
+	// WALLessLost reports a WAL-less mark in the manifest of a read-only
+	// DB, indexed by opt.Keyspace, see opt.WriteOptions.DisableWAL: the
+	// writes were lost, or for a secondary, the primary hasn't flushed
+	// them yet.
+	WALLessLost [2]bool
 
 	LevelSizes        Sizes
 	LevelTablesCounts []int
@@ -1426,6 +1766,7 @@ type DBStats struct {
 	Level0Comp    uint32
 	NonLevel0Comp uint32
 	SeekComp      uint32
+	DeletionComp  uint32
 }
 
 // Stats populates s with database statistics.
@@ -1440,13 +1781,22 @@ func (db *DB) Stats(s *DBStats) error {
 	s.WriteDelayCount = atomic.LoadInt32(&db.cWriteDelayN)
 	s.WriteDelayDuration = time.Duration(atomic.LoadInt64(&db.cWriteDelay))
 	s.WritePaused = atomic.LoadInt32(&db.inWritePaused) == 1
+	for ks := range s.RateLimitedWrite {
+		s.RateLimitedWrite[ks] = atomic.LoadInt64(&db.s.tops.limitedBytes[ks])
+		s.RateLimitedWait[ks] = time.Duration(atomic.LoadInt64(&db.s.tops.limitedWait[ks]))
+	}
+	if db.s.o.GetReadOnly() {
+		// 可写的库在 bulk load 期间也有标记，那不算丢。
+		db.walLessMu.Lock()
+		for ks := range s.WALLessLost {
+			s.WALLessLost[ks] = db.s.walLess(opt.Keyspace(ks))
+		}
+		db.walLessMu.Unlock()
+	}
 
 	s.OpenedTablesCount = db.s.tops.cache.Size()
-	if db.s.tops.bcache != nil {
-		s.BlockCacheSize = db.s.tops.bcache.Size()
-	} else {
-		s.BlockCacheSize = 0
-	}
+	db.memoryStats(s)
+	db.journalSyncStats(s)
 
 	s.AliveIterators = atomic.LoadInt32(&db.aliveIters)
 	s.AliveSnapshots = atomic.LoadInt32(&db.aliveSnaps)
@@ -1473,9 +1823,57 @@ func (db *DB) Stats(s *DBStats) error {
 	s.Level0Comp = atomic.LoadUint32(&db.level0Comp)
 	s.NonLevel0Comp = atomic.LoadUint32(&db.nonLevel0Comp)
 	s.SeekComp = atomic.LoadUint32(&db.seekComp)
+	s.DeletionComp = atomic.LoadUint32(&db.deletionComp)
 	return nil
 }
 
+// TableProperties describes a live table of the DB.
+type TableProperties struct {
+	Level int
+	Num   int64
+	Size  int64
+
+	// Properties is nil if the table was written without a properties
+	// block, e.g. by an older version.
+	Properties *table.Properties
+}
+
+// GetTableProperties returns the properties of all live tables of the given
+// keyspace, ordered by level. Tables are opened through the table cache if
+// needed. The returned properties must not be modified.
+func (db *DB) GetTableProperties(ks opt.Keyspace) ([]TableProperties, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+
+	v := db.s.version()
+	defer v.release()
+
+	var props []TableProperties
+	if ks == opt.KeyspaceState {
+		for level, tables := range v.level_s {
+			for _, t := range tables {
+				p, err := db.s.tops.properties_s(t)
+				if err != nil {
+					return nil, err
+				}
+				props = append(props, TableProperties{Level: level, Num: t.fd.Num, Size: t.size, Properties: p})
+			}
+		}
+		return props, nil
+	}
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			p, err := db.s.tops.properties(t)
+			if err != nil {
+				return nil, err
+			}
+			props = append(props, TableProperties{Level: level, Num: t.fd.Num, Size: t.size, Properties: p})
+		}
+	}
+	return props, nil
+}
+
 // SizeOf calculates approximate sizes of the given key ranges.
 // The length of the returned sizes are equal with the length of the given
 // ranges. The returned sizes measure storage space usage, so if the user
@@ -1519,6 +1917,11 @@ func (db *DB) SizeOf(ranges []util.Range) (Sizes, error) {
 // It is valid to call Close multiple times. Other methods should not be
 // called after the DB has been closed.
 func (db *DB) Close() error {
+	// Flush WAL-less writes while compaction is still running.
+	var flushErr error
+	if !db.isClosed() {
+		flushErr = db.flushWALLess()
+	}
 	if !db.setClosed() {
 		return ErrClosed
 	}
@@ -1538,6 +1941,9 @@ func (db *DB) Close() error {
 		}
 	default:
 	}
+	if err == nil {
+		err = flushErr
+	}
 
 	// Signal all goroutines.
 	close(db.closeC)
@@ -1550,6 +1956,7 @@ func (db *DB) Close() error {
 	// Acquire writer lock.
 	db.writeLockC <- struct{}{}
 	db.writeLockCs <- struct{}{}
+	db.memw.wait()
 	// Wait for all gorotines to exit.
 	db.closeW.Wait()
 
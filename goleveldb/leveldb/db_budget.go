@@ -0,0 +1,167 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+// budgetInterval is the shortest period the write rates are measured over;
+// rotations closer together reuse the previous split.
+const budgetInterval = 100 * time.Millisecond
+
+// writeBudget splits opt.WriteBufferBudget between the two keyspaces by
+// their recent write rate.
+type writeBudget struct {
+	total   int
+	written [2]int64 // 上次调整之后写进 memdb 的字节数，atomic
+
+	mu     sync.Mutex
+	rate   [2]float64 // 平滑后的写入速率，bytes/s
+	target [2]int
+	last   time.Time
+}
+
+// newWriteBudget returns nil if the options set no budget.
+func newWriteBudget(o *opt.Options) *writeBudget {
+	total := o.GetWriteBufferBudget()
+	if total <= 0 {
+		return nil
+	}
+	b := &writeBudget{total: total, last: time.Now()}
+	// 一开始按 WriteBuffer 和 WriteBuffer2 的比例来分。
+	w, w2 := int64(o.GetWriteBuffer()), int64(o.GetWriteBuffer2())
+	b.target[opt.KeyspaceMain] = b.clamp(int(int64(total) * w / (w + w2)))
+	b.target[opt.KeyspaceState] = total - b.target[opt.KeyspaceMain]
+	return b
+}
+
+// clamp keeps a keyspace share between an eighth and seven eighths of the
+// budget.
+func (b *writeBudget) clamp(n int) int {
+	min := b.total / 8
+	if n < min {
+		return min
+	}
+	if n > b.total-min {
+		return b.total - min
+	}
+	return n
+}
+
+func (b *writeBudget) add(ks opt.Keyspace, batches []*Batch) {
+	if b == nil {
+		return
+	}
+	var n int
+	for _, batch := range batches {
+		n += batch.internalLen
+	}
+	atomic.AddInt64(&b.written[ks], int64(n))
+}
+
+// resize splits the budget again by the write rates since the last call.
+func (b *writeBudget) resize() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	d := now.Sub(b.last)
+	if d < budgetInterval {
+		return
+	}
+	for ks := range b.rate {
+		rate := float64(atomic.SwapInt64(&b.written[ks], 0)) / d.Seconds()
+		b.rate[ks] = (b.rate[ks] + rate) / 2
+	}
+	b.last = now
+	if sum := b.rate[opt.KeyspaceMain] + b.rate[opt.KeyspaceState]; sum > 0 {
+		b.target[opt.KeyspaceMain] = b.clamp(int(float64(b.total) * b.rate[opt.KeyspaceMain] / sum))
+		b.target[opt.KeyspaceState] = b.total - b.target[opt.KeyspaceMain]
+	}
+}
+
+func (b *writeBudget) get(ks opt.Keyspace) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.target[ks]
+}
+
+// writeBuffer returns the size new 'memdb' of the keyspace are created with.
+func (db *DB) writeBuffer(ks opt.Keyspace) int {
+	if db.budget != nil {
+		return db.budget.get(ks)
+	}
+	if ks == opt.KeyspaceState {
+		return db.s.o.GetWriteBuffer2()
+	}
+	return db.s.o.GetWriteBuffer()
+}
+
+// memdbCapacity returns the buffer capacity of the active and frozen
+// 'memdb' of each keyspace, the heap allocations of a concurrent 'memdb'
+// included. The caller must hold db.memMu.
+func (db *DB) memdbCapacity() (c [2]int) {
+	for _, m := range []*memDB{db.mem, db.frozenMem} {
+		if m != nil && m.DB != nil {
+			c[opt.KeyspaceMain] += m.Capacity()
+		}
+	}
+	for _, m := range []*memDB{db.mems, db.frozenMems} {
+		if m != nil && m.Table_s != nil {
+			c[opt.KeyspaceState] += m.Capacity_s()
+		}
+	}
+	return
+}
+
+// applyMemoryLimit gives the block cache what the 'memdb' leave of
+// opt.MemoryLimit, but no less than an eighth of it. The caller must hold
+// db.memMu.
+func (db *DB) applyMemoryLimit() {
+	limit := db.s.o.GetMemoryLimit()
+	if limit <= 0 || db.s.tops.bcache == nil {
+		return
+	}
+	c := db.memdbCapacity()
+	bcache := limit - c[opt.KeyspaceMain] - c[opt.KeyspaceState]
+	// 缓存清空的话每次读都要读盘，宁可超出一点限制。
+	if min := limit / 8; bcache < min {
+		bcache = min
+	}
+	db.s.tops.bcache.SetCapacity(bcache)
+}
+
+// memdbSpilled applies opt.MemoryLimit again once a concurrent 'memdb' has
+// no free buffer left: the keys and values beyond it come from the heap
+// and grow its capacity until it is rotated.
+func (db *DB) memdbSpilled(free int) {
+	if free > 0 || db.s.o.GetMemoryLimit() <= 0 {
+		return
+	}
+	db.memMu.Lock()
+	db.applyMemoryLimit()
+	db.memMu.Unlock()
+}
+
+// memoryStats fills the memory allocations of s.
+func (db *DB) memoryStats(s *DBStats) {
+	db.memMu.RLock()
+	s.MemTableCapacity = db.memdbCapacity()
+	db.memMu.RUnlock()
+	for ks := range s.WriteBuffer {
+		s.WriteBuffer[ks] = db.writeBuffer(opt.Keyspace(ks))
+	}
+	s.BlockCacheSize, s.BlockCacheCapacity = 0, 0
+	if db.s.tops.bcache != nil {
+		s.BlockCacheSize = db.s.tops.bcache.Size()
+		s.BlockCacheCapacity = db.s.tops.bcache.Capacity()
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+func TestWriteBudget_Resize(t *testing.T) {
+	b := newWriteBudget(&opt.Options{WriteBuffer: 1 << 20, WriteBuffer2: 3 << 20, WriteBufferBudget: 8 << 20})
+	if b.get(opt.KeyspaceMain) != 2<<20 || b.get(opt.KeyspaceState) != 6<<20 {
+		t.Fatalf("initial split: got %d/%d", b.get(opt.KeyspaceMain), b.get(opt.KeyspaceState))
+	}
+
+	// 只写状态库，主库的份额降到下限。
+	batch := new(Batch)
+	batch.Put([]byte("key"), bytes.Repeat([]byte("v"), 1000))
+	for i := 0; i < 100; i++ {
+		b.add(opt.KeyspaceState, []*Batch{batch})
+	}
+	b.last = time.Now().Add(-time.Second)
+	b.resize()
+	if b.get(opt.KeyspaceMain) != 1<<20 || b.get(opt.KeyspaceState) != 7<<20 {
+		t.Fatalf("after state burst: got %d/%d", b.get(opt.KeyspaceMain), b.get(opt.KeyspaceState))
+	}
+
+	// 两边写得一样多，慢慢回到对半分。
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 100; i++ {
+			b.add(opt.KeyspaceMain, []*Batch{batch})
+			b.add(opt.KeyspaceState, []*Batch{batch})
+		}
+		b.last = time.Now().Add(-time.Second)
+		b.resize()
+	}
+	if m := b.get(opt.KeyspaceMain); m < 4<<20-4<<10 || m > 4<<20 {
+		t.Fatalf("after even writes: got main %d, want about %d", m, 4<<20)
+	}
+	if b.get(opt.KeyspaceMain)+b.get(opt.KeyspaceState) != 8<<20 {
+		t.Fatal("split does not add up to the budget")
+	}
+
+	if newWriteBudget(&opt.Options{}) != nil {
+		t.Fatal("want no budget by default")
+	}
+	if got := (&opt.Options{MemoryLimit: 8 << 20}).GetWriteBufferBudget(); got != 2<<20 {
+		t.Fatalf("budget from MemoryLimit: got %d, want %d", got, 2<<20)
+	}
+}
+
+func TestDB_WriteBufferBudget(t *testing.T) {
+	const limit, budget = 4 << 20, 512 << 10
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MemoryLimit:                  limit,
+		WriteBufferBudget:            budget,
+	})
+	defer h.close()
+
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.WriteBuffer[opt.KeyspaceMain] != budget/2 || s.WriteBuffer[opt.KeyspaceState] != budget/2 {
+		t.Fatalf("initial WriteBuffer: got %v", s.WriteBuffer)
+	}
+
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 1000; i++ {
+		if i%200 == 0 {
+			h.db.budget.mu.Lock()
+			h.db.budget.last = time.Now().Add(-time.Second)
+			h.db.budget.mu.Unlock()
+		}
+		if err := h.db.Put_s([]byte(fmt.Sprintf("key%04d", i)), value, nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.WriteBuffer[opt.KeyspaceState] != budget-budget/8 {
+		t.Fatalf("WriteBuffer after state burst: got %v", s.WriteBuffer)
+	}
+	if s.MemTableCapacity[opt.KeyspaceState] < budget/2 {
+		t.Fatalf("MemTableCapacity: got %v, want the state memdb grown", s.MemTableCapacity)
+	}
+	if want := limit - s.MemTableCapacity[opt.KeyspaceMain] - s.MemTableCapacity[opt.KeyspaceState]; s.BlockCacheCapacity != want {
+		t.Fatalf("BlockCacheCapacity: got %d, want %d", s.BlockCacheCapacity, want)
+	}
+	if _, err := h.db.GetProperty("leveldb.memory"); err != nil {
+		t.Fatal("GetProperty: got error: ", err)
+	}
+	for i := 0; i < 1000; i += 97 {
+		key := fmt.Sprintf("key%04d", i)
+		if v, err := h.db.Get_s([]byte(key), nil); err != nil || !bytes.Equal(v, value) {
+			t.Fatalf("Get_s %s: got %d bytes, %v", key, len(v), err)
+		}
+	}
+}
+
+func TestDB_MemoryLimitSpill(t *testing.T) {
+	const limit = 4 << 20
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MemoryLimit:                  limit,
+		MemTable:                     opt.ConcurrentMemTable,
+	})
+	defer h.close()
+
+	// 并发 memdb 写满之后从堆上分配，也要算进限制里。
+	mdb := h.db.getEffectiveMem()
+	value := bytes.Repeat([]byte("v"), 64<<10)
+	for i := 0; mdb.Free() > 0 || i < limit/len(value); i++ {
+		mdb.Put(makeInternalKey(nil, []byte(fmt.Sprintf("key%04d", i)), 1, keyTypeVal), value)
+	}
+	h.db.memdbSpilled(mdb.Free())
+	mdb.decref()
+
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.MemTableCapacity[opt.KeyspaceMain] < limit {
+		t.Fatalf("MemTableCapacity: got %v, want the heap allocations counted", s.MemTableCapacity)
+	}
+	// 块缓存不会被挤到 0。
+	if s.BlockCacheCapacity != limit/8 {
+		t.Fatalf("BlockCacheCapacity: got %d, want %d", s.BlockCacheCapacity, limit/8)
+	}
+}
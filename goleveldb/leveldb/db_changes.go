@@ -0,0 +1,554 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/journal"
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// ChangeType is the type of a committed record.
+type ChangeType int
+
+// Types of Change.
+const (
+	ChangePut ChangeType = iota
+	ChangeDelete
+	ChangeMerge
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangePut:
+		return "put"
+	case ChangeDelete:
+		return "delete"
+	case ChangeMerge:
+		return "merge"
+	}
+	return fmt.Sprintf("<invalid:%d>", int(t))
+}
+
+// Change is a single committed record.
+type Change struct {
+	Seq   uint64
+	Type  ChangeType
+	Key   []byte
+	Value []byte // nil for ChangeDelete
+}
+
+// ChangeBatch is a group of records committed together to one keyspace,
+// as written to its journal. The records have consecutive sequence numbers
+// starting at Seq.
+//
+// A ChangeBatch may be shared by several subscriptions and must not be
+// modified.
+type ChangeBatch struct {
+	Keyspace opt.Keyspace
+	Seq      uint64
+	Changes  []Change
+}
+
+// decodeChangeBatch decodes a journal record of the given keyspace. The
+// changes point into data.
+func decodeChangeBatch(ks opt.Keyspace, data []byte) (*ChangeBatch, error) {
+	seq, batchLen, err := decodeBatchHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	cb := &ChangeBatch{Keyspace: ks, Seq: seq, Changes: make([]Change, 0, batchLen)}
+	data = data[batchHeaderLen:]
+	err = decodeBatch(data, func(i int, index batchIndex) error {
+		if i >= batchLen {
+			return newErrBatchCorrupted("invalid records length")
+		}
+		c := Change{Seq: seq + uint64(i), Key: index.k(data)}
+		switch index.keyType {
+		case keyTypeDel:
+			c.Type = ChangeDelete
+		case keyTypeMerge:
+			c.Type, c.Value = ChangeMerge, index.v(data)
+		default:
+			c.Type, c.Value = ChangePut, index.v(data)
+		}
+		cb.Changes = append(cb.Changes, c)
+		return nil
+	})
+	if err == nil && len(cb.Changes) != batchLen {
+		err = newErrBatchCorrupted(fmt.Sprintf("invalid records length: %d vs %d", batchLen, len(cb.Changes)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
+// trimChanges drops the records of cb before seq. It returns nil if no
+// record is left.
+func trimChanges(cb *ChangeBatch, seq uint64) *ChangeBatch {
+	if n := len(cb.Changes); n == 0 || cb.Seq+uint64(n) <= seq {
+		return nil
+	}
+	if cb.Seq >= seq {
+		return cb
+	}
+	skip := seq - cb.Seq
+	return &ChangeBatch{Keyspace: cb.Keyspace, Seq: seq, Changes: cb.Changes[skip:]}
+}
+
+// changeCursor reads the records of the journals of one keyspace in order.
+type changeCursor struct {
+	db  *DB
+	ks  opt.Keyspace
+	fds []storage.FileDesc
+
+	fd  storage.FileDesc
+	fr  storage.Reader
+	jr  *journal.Reader
+	buf []byte
+}
+
+// open opens the next journal. A journal may have been renamed to an old
+// journal since listed.
+func (c *changeCursor) open() error {
+	for len(c.fds) > 0 {
+		fd := c.fds[0]
+		c.fds = c.fds[1:]
+		fr, err := c.db.s.stor.Open(fd)
+		if os.IsNotExist(err) && (fd.Type == storage.TypeJournal || fd.Type == storage.TypeJournals) {
+			ofd := storage.FileDesc{Type: storage.TypeJournalOld, Num: fd.Num}
+			if fd.Type == storage.TypeJournals {
+				ofd.Type = storage.TypeJournalsOld
+			}
+			fd = ofd
+			fr, err = c.db.s.stor.Open(fd)
+		}
+		if err != nil {
+			return err
+		}
+		c.fd, c.fr = fd, fr
+		c.jr = journal.NewReader(fr, dropper{c.db.s, fd}, false, true)
+		c.jr.SetNum(fd.Num)
+		return nil
+	}
+	return io.EOF
+}
+
+func (c *changeCursor) close() {
+	if c.fr != nil {
+		c.fr.Close()
+		c.fr, c.jr = nil, nil
+	}
+}
+
+// next returns the next record, or nil if there is none left.
+func (c *changeCursor) next() (*ChangeBatch, error) {
+	for {
+		if c.jr == nil {
+			if err := c.open(); err != nil {
+				if err == io.EOF {
+					return nil, nil
+				}
+				return nil, err
+			}
+		}
+		r, err := c.jr.Next()
+		if err == nil {
+			// 每条记录单独分配，交给订阅者之后不能复用。
+			if c.buf, err = io.ReadAll(r); err == nil {
+				var cb *ChangeBatch
+				if cb, err = decodeChangeBatch(c.ks, c.buf); err == nil {
+					return cb, nil
+				}
+			}
+		}
+		switch {
+		case err == io.EOF:
+			c.close()
+		case err == io.ErrUnexpectedEOF || errors.IsCorrupted(err):
+			// 已经由 dropper 记录，和恢复时一样跳过。
+			c.db.logf("changes@replay skipping record @%d: %v", c.fd.Num, err)
+		default:
+			c.close()
+			return nil, errors.SetFd(err, c.fd)
+		}
+	}
+}
+
+// changeReplay merges the records of the journals of both keyspaces by
+// sequence number.
+type changeReplay struct {
+	live    uint64
+	cursors [2]*changeCursor
+	heads   [2]*ChangeBatch
+}
+
+func (r *changeReplay) close() {
+	for _, c := range r.cursors {
+		if c != nil {
+			c.close()
+		}
+	}
+}
+
+// next returns the next record up to live, or nil when done.
+func (r *changeReplay) next() (*ChangeBatch, error) {
+	for {
+		pick := -1
+		for i, c := range r.cursors {
+			if r.heads[i] == nil && c != nil {
+				cb, err := c.next()
+				if err != nil {
+					return nil, err
+				}
+				if cb == nil {
+					r.cursors[i] = nil
+					continue
+				}
+				r.heads[i] = cb
+			}
+			if r.heads[i] != nil && (pick < 0 || r.heads[i].Seq < r.heads[pick].Seq) {
+				pick = i
+			}
+		}
+		if pick < 0 {
+			return nil, nil
+		}
+		cb := r.heads[pick]
+		r.heads[pick] = nil
+		if cb.Seq <= r.live {
+			return cb, nil
+		}
+	}
+}
+
+type queuedChanges struct {
+	cb   *ChangeBatch
+	size int
+}
+
+// Subscription delivers committed records, see DB.Subscribe.
+type Subscription struct {
+	db   *DB
+	next uint64 // sequence number of the next record to deliver
+	live uint64 // last sequence number to replay
+
+	replayMu sync.Mutex
+	replay   *changeReplay
+	holding  bool // guarded by db.subsMu
+
+	mu      sync.Mutex
+	queue   []queuedChanges
+	queued  int
+	err     error
+	notifyC chan struct{}
+	closeC  chan struct{}
+	closed  bool
+}
+
+// Subscribe returns a subscription to the records committed to either
+// keyspace with a sequence number of at least seq, in sequence number
+// order. Records already committed are replayed from the journals kept on
+// disk, see opt.Options.JournalRetention, then records are delivered as
+// they are committed. A subscriber resumes after a restart by subscribing
+// from the sequence number after the last record it has seen.
+//
+// Records are delivered without gaps. Next returns ErrChangesUnavailable
+// if a record can't be delivered: its journal was removed, or it was
+// committed by a transaction, see OpenTransaction, which skips the
+// journals. Note that Write commits large batches by a transaction unless
+// DisableLargeBatchTransaction is set.
+//
+// The subscription must be closed after use, by calling Close method.
+func (db *DB) Subscribe(seq uint64) (*Subscription, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return nil, err
+	case <-db.closeC:
+		return nil, ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+	db.memw.wait()
+	return db.subscribeLocked(seq)
+}
+
+// subscribeLocked is Subscribe with the write lock held.
+func (db *DB) subscribeLocked(seq uint64) (*Subscription, error) {
+	if seq == 0 {
+		seq = 1
+	}
+	sub := &Subscription{
+		db:      db,
+		next:    seq,
+		notifyC: make(chan struct{}, 1),
+		closeC:  make(chan struct{}),
+	}
+	if live := db.seq; seq <= live {
+		// 先占住 journal，轮转出来的 journal 在读完之前不会被删除。
+		db.subsMu.Lock()
+		db.journalHold++
+		sub.holding = true
+		db.subsMu.Unlock()
+
+		// 正在写的 journal 不能和写入并发读取，里面有要重放的记录时才
+		// 轮转掉；要的记录都已经在冻结的 journal 里就不用动。
+		if db.journalLast[opt.KeyspaceMain] >= seq {
+			if _, err := db.rotateMem(0, false); err != nil {
+				db.releaseJournals(sub)
+				return nil, err
+			}
+		}
+		if db.journalLast[opt.KeyspaceState] >= seq {
+			if _, err := db.rotateMem_s(0, false); err != nil {
+				db.releaseJournals(sub)
+				return nil, err
+			}
+		}
+		db.memMu.RLock()
+		journalFd, journalFd2 := db.journalFd, db.journalFd2
+		db.memMu.RUnlock()
+
+		sub.live = live
+		sub.replay = &changeReplay{live: live}
+		for i, ft := range []storage.FileType{storage.TypeJournal | storage.TypeJournalOld, storage.TypeJournals | storage.TypeJournalsOld} {
+			fds, err := db.s.stor.List(ft)
+			if err != nil {
+				db.releaseJournals(sub)
+				return nil, err
+			}
+			c := &changeCursor{db: db, ks: opt.KeyspaceMain}
+			current := journalFd
+			if i == 1 {
+				c.ks, current = opt.KeyspaceState, journalFd2
+			}
+			for _, fd := range fds {
+				// 当前的 journal 里只有之后提交的记录。
+				if fd.Num != current.Num {
+					c.fds = append(c.fds, fd)
+				}
+			}
+			sort.Slice(c.fds, func(i, j int) bool { return c.fds[i].Num < c.fds[j].Num })
+			sub.replay.cursors[i] = c
+		}
+	}
+
+	db.subsMu.Lock()
+	if db.subs == nil {
+		db.subs = make(map[*Subscription]struct{})
+	}
+	db.subs[sub] = struct{}{}
+	atomic.AddInt32(&db.subsN, 1)
+	db.subsMu.Unlock()
+	return sub, nil
+}
+
+// releaseJournals drops the journal hold of sub, if any.
+func (db *DB) releaseJournals(sub *Subscription) {
+	db.subsMu.Lock()
+	if sub.holding {
+		sub.holding = false
+		db.journalHold--
+	}
+	db.subsMu.Unlock()
+}
+
+// publishChanges delivers the batches just committed to ks, starting at
+// seq, to every subscription; must be called with the write lock held.
+func (db *DB) publishChanges(ks opt.Keyspace, batches []*Batch, seq uint64) {
+	if atomic.LoadInt32(&db.subsN) == 0 {
+		return
+	}
+	data := encodeBatchHeader(nil, seq, batchesLen(batches))
+	for _, batch := range batches {
+		data = append(data, batch.data...)
+	}
+	cb, err := decodeChangeBatch(ks, data)
+	if err != nil {
+		db.logf("changes@publish %v", err)
+		return
+	}
+
+	limit := db.s.o.GetSubscriptionBuffer()
+	db.subsMu.Lock()
+	for sub := range db.subs {
+		if !sub.push(cb, len(data), limit) {
+			delete(db.subs, sub)
+			atomic.AddInt32(&db.subsN, -1)
+		}
+	}
+	db.subsMu.Unlock()
+}
+
+// push queues cb; it returns false if sub no longer takes records.
+func (sub *Subscription) push(cb *ChangeBatch, size, limit int) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed || sub.err != nil {
+		return false
+	}
+	if sub.queued+size > limit {
+		sub.err = ErrSubscriberLagged
+		sub.queue, sub.queued = nil, 0
+	} else {
+		sub.queue = append(sub.queue, queuedChanges{cb, size})
+		sub.queued += size
+	}
+	select {
+	case sub.notifyC <- struct{}{}:
+	default:
+	}
+	return sub.err == nil
+}
+
+func (sub *Subscription) nextReplay() (*ChangeBatch, error) {
+	sub.replayMu.Lock()
+	defer sub.replayMu.Unlock()
+	if sub.replay == nil {
+		return nil, nil
+	}
+	cb, err := sub.replay.next()
+	if cb == nil || err != nil {
+		sub.replay.close()
+		sub.replay = nil
+		sub.db.releaseJournals(sub)
+	}
+	return cb, err
+}
+
+// Next returns the next committed record, waiting for one if needed.
+//
+// Next isn't safe for concurrent use; Close may be called concurrently
+// with Next, which then returns ErrClosed. Next returns ErrSubscriberLagged
+// if the subscriber didn't keep up with the writes; it may subscribe again
+// from where it stopped.
+func (sub *Subscription) Next() (*ChangeBatch, error) {
+	for {
+		cb, err := sub.poll()
+		if err != nil {
+			if err != ErrClosed {
+				sub.fail(err)
+			}
+			return nil, err
+		}
+		if cb = trimChanges(cb, sub.next); cb == nil {
+			continue
+		}
+		// 不能跳过记录，缺了就报错。
+		if cb.Seq != sub.next {
+			return nil, sub.fail(ErrChangesUnavailable)
+		}
+		sub.next += uint64(len(cb.Changes))
+		return cb, nil
+	}
+}
+
+// fail makes every later Next return err.
+func (sub *Subscription) fail(err error) error {
+	sub.mu.Lock()
+	if sub.err == nil {
+		sub.err = err
+	}
+	sub.mu.Unlock()
+	return err
+}
+
+// poll returns the next replayed or queued record, waiting for one if
+// needed.
+func (sub *Subscription) poll() (*ChangeBatch, error) {
+	for {
+		sub.mu.Lock()
+		closed, err := sub.closed, sub.err
+		sub.mu.Unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if sub.replaying() {
+			cb, err := sub.nextReplay()
+			if err != nil || cb != nil {
+				return cb, err
+			}
+			// 重放完了，之后的记录都在队列里。
+			if sub.next <= sub.live {
+				return nil, ErrChangesUnavailable
+			}
+		}
+
+		sub.mu.Lock()
+		if len(sub.queue) > 0 {
+			q := sub.queue[0]
+			sub.queue[0] = queuedChanges{}
+			sub.queue = sub.queue[1:]
+			sub.queued -= q.size
+			sub.mu.Unlock()
+			return q.cb, nil
+		}
+		sub.mu.Unlock()
+
+		select {
+		case <-sub.notifyC:
+		case <-sub.closeC:
+		case <-sub.db.closeC:
+			return nil, ErrClosed
+		}
+	}
+}
+
+func (sub *Subscription) replaying() bool {
+	sub.replayMu.Lock()
+	defer sub.replayMu.Unlock()
+	return sub.replay != nil
+}
+
+// Close closes the subscription and lets the journals it was replaying
+// be removed.
+func (sub *Subscription) Close() error {
+	sub.mu.Lock()
+	if sub.closed {
+		sub.mu.Unlock()
+		return ErrClosed
+	}
+	sub.closed = true
+	sub.queue, sub.queued = nil, 0
+	close(sub.closeC)
+	sub.mu.Unlock()
+
+	sub.replayMu.Lock()
+	if sub.replay != nil {
+		sub.replay.close()
+		sub.replay = nil
+	}
+	sub.replayMu.Unlock()
+
+	db := sub.db
+	db.releaseJournals(sub)
+	db.subsMu.Lock()
+	if _, ok := db.subs[sub]; ok {
+		delete(db.subs, sub)
+		atomic.AddInt32(&db.subsN, -1)
+	}
+	db.subsMu.Unlock()
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+func formatChanges(cb *ChangeBatch) string {
+	s := fmt.Sprintf("%s@%d", cb.Keyspace, cb.Seq)
+	for _, c := range cb.Changes {
+		s += fmt.Sprintf(" %s:%s=%s", c.Type, c.Key, c.Value)
+	}
+	return s
+}
+
+func expectChanges(t *testing.T, sub *Subscription, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		cb, err := sub.Next()
+		if err != nil {
+			t.Fatalf("Next: got error %v, want %s", err, w)
+		}
+		if got := formatChanges(cb); got != w {
+			t.Fatalf("Next: got %s, want %s", got, w)
+		}
+	}
+}
+
+func TestDB_Subscribe(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true, JournalRetention: 3})
+	defer h.close()
+
+	h.put("key001", "v1")
+	if err := h.db.Put_s([]byte("skey01"), []byte("s1"), h.wo); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	h.delete("key001")
+
+	sub, err := h.db.Subscribe(0)
+	if err != nil {
+		t.Fatal("Subscribe: got error: ", err)
+	}
+	expectChanges(t, sub, "main@1 put:key001=v1", "state@2 put:skey01=s1", "main@3 delete:key001=")
+
+	b := new(Batch)
+	b.Put([]byte("key002"), []byte("v2"))
+	b.Put([]byte("key003"), []byte("v3"))
+	if err := h.db.Write(b, h.wo); err != nil {
+		t.Fatal("Write: got error: ", err)
+	}
+	expectChanges(t, sub, "main@4 put:key002=v2 put:key003=v3")
+
+	// 从一个批次的中间开始订阅。
+	sub2, err := h.db.Subscribe(5)
+	if err != nil {
+		t.Fatal("Subscribe: got error: ", err)
+	}
+	h.put("key004", "v4")
+	expectChanges(t, sub2, "main@5 put:key003=v3", "main@6 put:key004=v4")
+	expectChanges(t, sub, "main@6 put:key004=v4")
+	sub.Close()
+	sub2.Close()
+	if _, err := sub.Next(); err != ErrClosed {
+		t.Fatalf("Next after Close: got %v, want %v", err, ErrClosed)
+	}
+
+	// 重启之后从保留的 journal 里重放。
+	h.reopenDB()
+	if fds, _ := h.stor.List(storage.TypeJournalOld); len(fds) == 0 || len(fds) > 3 {
+		t.Fatalf("got %d old journals, want 1 to 3", len(fds))
+	}
+	sub, err = h.db.Subscribe(2)
+	if err != nil {
+		t.Fatal("Subscribe: got error: ", err)
+	}
+	expectChanges(t, sub, "state@2 put:skey01=s1", "main@3 delete:key001=", "main@4 put:key002=v2 put:key003=v3")
+	sub.Close()
+
+	// Without retention the flushed journals are gone.
+	h.o.JournalRetention = 0
+	h.reopenDB()
+	if fds, _ := h.stor.List(storage.TypeJournalOld | storage.TypeJournalsOld); len(fds) != 0 {
+		t.Fatalf("got old journals %v, want none", fds)
+	}
+	sub, err = h.db.Subscribe(1)
+	if err != nil {
+		t.Fatal("Subscribe: got error: ", err)
+	}
+	if _, err := sub.Next(); err != ErrChangesUnavailable {
+		t.Fatalf("Next: got %v, want %v", err, ErrChangesUnavailable)
+	}
+	sub.Close()
+}
+
+func TestDB_SubscribeLagged(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true, SubscriptionBuffer: 1024})
+	defer h.close()
+
+	sub, err := h.db.Subscribe(h.db.getSeq() + 1)
+	if err != nil {
+		t.Fatal("Subscribe: got error: ", err)
+	}
+	defer sub.Close()
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("key%03d", i), "value")
+	}
+	if _, err := sub.Next(); err != ErrSubscriberLagged {
+		t.Fatalf("Next: got %v, want %v", err, ErrSubscriberLagged)
+	}
+}
+
+func TestDB_SubscribeRotate(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("key001", "v1")
+	if err := h.db.Put_s([]byte("skey01"), []byte("s1"), h.wo); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	journals := func() (storage.FileDesc, storage.FileDesc) {
+		h.db.memMu.RLock()
+		defer h.db.memMu.RUnlock()
+		return h.db.journalFd, h.db.journalFd2
+	}
+	fd, fd2 := journals()
+
+	// 只有状态库的 journal 里有要重放的记录，主库的不用轮转。
+	sub, err := h.db.Subscribe(2)
+	if err != nil {
+		t.Fatal("Subscribe: got error: ", err)
+	}
+	defer sub.Close()
+	if nfd, nfd2 := journals(); nfd != fd || nfd2 == fd2 {
+		t.Fatalf("journals after Subscribe: got %v %v, was %v %v", nfd, nfd2, fd, fd2)
+	}
+	expectChanges(t, sub, "state@2 put:skey01=s1")
+
+	// 从最新的位置订阅什么都不用轮转。
+	fd, fd2 = journals()
+	sub2, err := h.db.Subscribe(3)
+	if err != nil {
+		t.Fatal("Subscribe: got error: ", err)
+	}
+	defer sub2.Close()
+	if nfd, nfd2 := journals(); nfd != fd || nfd2 != fd2 {
+		t.Fatalf("journals after Subscribe: got %v %v, was %v %v", nfd, nfd2, fd, fd2)
+	}
+	h.put("key002", "v2")
+	expectChanges(t, sub2, "main@3 put:key002=v2")
+}
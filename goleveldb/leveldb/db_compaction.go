@@ -7,13 +7,14 @@
 package leveldb
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"awesomeProject1/goleveldb/leveldb/errors"
 	"awesomeProject1/goleveldb/leveldb/opt"
-	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/util"
 )
 
 var (
@@ -363,6 +364,7 @@ func (db *DB) compactionCommit(name string, rec *sessionRecord) {
 	db.compactionTransactFunc(name+"@commit", func(cnt *compactionTransactCounter) error {
 		return db.s.commit(rec, true)
 	}, nil)
+	db.kickTier()
 }
 func (db *DB) compactionCommit_s(name string, rec *sessionRecord) {
 	db.compCommitLk.Lock()
@@ -370,6 +372,7 @@ func (db *DB) compactionCommit_s(name string, rec *sessionRecord) {
 	db.compactionTransactFunc_s(name+"@commit", func(cnt *compactionTransactCounter) error {
 		return db.s.commit(rec, true)
 	}, nil)
+	db.kickTier()
 }
 
 func (db *DB) memCompaction() {
@@ -390,6 +393,10 @@ func (db *DB) memCompaction() {
 		db.dropFrozenMem()
 		return
 	}
+	flushInfo := opt.FlushInfo{Keyspace: opt.KeyspaceMain, Entries: mdb.Len(), Size: mdb.Size()}
+	db.s.o.EventListener.FlushBegin(flushInfo)
+	flushEnded := false
+	defer db.endFlushOnPanic(&flushInfo, &flushEnded)
 	//	fmt.Println("中断tablecompaction")
 	//中断tablecompaction，由此可知tablecompaction与memcompaction不会同时进行。
 	resumeC := make(chan struct{})
@@ -420,7 +427,7 @@ func (db *DB) memCompaction() {
 	}, func() error {
 		for _, r := range rec.addedTables {
 			db.logf("memdb@flush revert @%d", r.num)
-			if err := db.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
+			if err := db.s.removeTable(opt.KeyspaceMain, r.num); err != nil {
 				return err
 			}
 		}
@@ -431,7 +438,7 @@ func (db *DB) memCompaction() {
 	rec.setSeqNum(db.frozenSeq)
 	//将fulshmemdb的结果进行提交，并记录log，提交的过程主要是为了将新生成的表信息写入到MANIFEST文件中，同时生成新的version
 	stats.startTimer()
-	db.compactionCommit("memdb", rec)
+	db.commitFlush(opt.KeyspaceMain, rec)
 	stats.stopTimer()
 
 	db.logf("memdb@flush committed F·%d T·%v", len(rec.addedTables), stats.duration)
@@ -442,6 +449,10 @@ func (db *DB) memCompaction() {
 	}
 	db.compStats.addStat(flushLevel, stats)
 	atomic.AddUint32(&db.memComp, 1)
+	flushInfo.Output, _ = atRecordsInfo(opt.KeyspaceMain, rec.addedTables)
+	flushInfo.Duration = stats.duration
+	flushEnded = true
+	db.s.o.EventListener.FlushEnd(flushInfo)
 
 	// Drop frozen memdb.minor compaction之后把指向frozon的memory重新放回mempool中
 	db.dropFrozenMem()
@@ -477,6 +488,10 @@ func (db *DB) memCompaction_s() {
 		db.dropFrozenMem_s()
 		return
 	}
+	flushInfo := opt.FlushInfo{Keyspace: opt.KeyspaceState, Entries: mdb.Len_s(), Size: mdb.Size_s()}
+	db.s.o.EventListener.FlushBegin(flushInfo)
+	flushEnded := false
+	defer db.endFlushOnPanic(&flushInfo, &flushEnded)
 	//fmt.Print(" 中断tablecompaction ")
 	//中断tablecompaction，由此可知tablecompaction与memcompaction不会同时进行。
 	resumeC := make(chan struct{})
@@ -499,14 +514,14 @@ func (db *DB) memCompaction_s() {
 	//fmt.Println(" 执行compactionTransactFunc ")
 	db.compactionTransactFunc_s("memdb@flush", func(cnt *compactionTransactCounter) (err error) {
 		stats.startTimer()
-		//通过flushMemdb将数据刷新到磁盘，这里的mdb.DBs为Frozenmem
-		flushLevel, err = db.s.flushMemdb_s(rec, mdb.DBs, db.memdbMaxLevel)
+		//通过flushMemdb将数据刷新到磁盘，这里的mdb.Table_s为Frozenmem
+		flushLevel, err = db.s.flushMemdb_s(rec, mdb.Table_s, db.memdbMaxLevel)
 		stats.stopTimer()
 		return
 	}, func() error {
 		for _, r := range rec.addedTabless {
 			db.logf("memdb@flush revert @%d", r.num)
-			if err := db.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
+			if err := db.s.removeTable(opt.KeyspaceState, r.num); err != nil {
 				return err
 			}
 		}
@@ -519,7 +534,7 @@ func (db *DB) memCompaction_s() {
 	rec.setSeqNum(db.frozenSeq2)
 	//将fulshmemdb的结果进行提交，并记录log，提交的过程主要是为了将新生成的表信息写入到MANIFEST文件中，同时生成新的version
 	stats.startTimer()
-	db.compactionCommit_s("memdb", rec)
+	db.commitFlush(opt.KeyspaceState, rec)
 	stats.stopTimer()
 	//fmt.Println("comapctionCommits完成")
 	db.logf("memdb@flush committed F·%d T·%v", len(rec.addedTabless), stats.duration)
@@ -530,6 +545,10 @@ func (db *DB) memCompaction_s() {
 	}
 	db.compStats.addStat(flushLevel, stats)
 	atomic.AddUint32(&db.memComps, 1) //记录合并次数
+	flushInfo.Output, _ = atRecordsInfo(opt.KeyspaceState, rec.addedTabless)
+	flushInfo.Duration = stats.duration
+	flushEnded = true
+	db.s.o.EventListener.FlushEnd(flushInfo)
 
 	// Drop frozen memdb.
 	db.dropFrozenMem_s()
@@ -570,6 +589,10 @@ type tableCompactionBuilder struct {
 	strict    bool
 	tableSize int
 
+	// pause is set for subcompactions, which leave the pause requests to
+	// tableCompactionBuild.
+	pause *compactionPauseGate
+
 	tw *tWriter
 }
 
@@ -577,7 +600,9 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 	// Create new table if not already.
 	if b.tw == nil {
 		// Check for pause event.
-		if b.db != nil {
+		if b.pause != nil {
+			b.pause.wait(b.db)
+		} else if b.db != nil {
 			select {
 			case ch := <-b.db.tcompPauseC:
 				b.db.pauseCompaction(ch)
@@ -589,10 +614,11 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 
 		// Create new table.
 		var err error
-		b.tw, err = b.s.tops.create()
+		b.tw, err = b.s.tops.create(util.IOPriorityLow, b.s.isCold(opt.KeyspaceMain, b.c.outputLevel()))
 		if err != nil {
 			return err
 		}
+		b.tw.props.Producer = fmt.Sprintf("compaction L%d->L%d", b.c.sourceLevel, b.c.outputLevel())
 	}
 
 	// Write key/value into table.
@@ -602,7 +628,9 @@ func (b *tableCompactionBuilder) appendKV_s(key, value []byte) error {
 	// Create new table if not already.
 	if b.tw == nil {
 		// Check for pause event.
-		if b.db != nil {
+		if b.pause != nil {
+			b.pause.wait(b.db)
+		} else if b.db != nil {
 			select {
 			case ch := <-b.db.tcompPauseCs:
 				b.db.pauseCompaction(ch)
@@ -614,10 +642,11 @@ func (b *tableCompactionBuilder) appendKV_s(key, value []byte) error {
 
 		// Create new table.
 		var err error
-		b.tw, err = b.s.tops.create()
+		b.tw, err = b.s.tops.create_s(util.IOPriorityLow, b.s.isCold(opt.KeyspaceState, b.c.outputLevel()))
 		if err != nil {
 			return err
 		}
+		b.tw.props.Producer = fmt.Sprintf("compaction L%d->L%d", b.c.sourceLevel, b.c.outputLevel())
 	}
 
 	// Write key/value into table.
@@ -632,9 +661,9 @@ func (b *tableCompactionBuilder) flush() error { //run逻辑
 	if err != nil {
 		return err
 	}
-	b.rec.addTableFile(b.c.sourceLevel+1, t)
+	b.rec.addTableFile(b.c.outputLevel(), t)
 	b.stat1.write += t.size
-	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.sourceLevel+1, t.fd.Num, b.tw.tw.EntriesLen(), shortenb(int(t.size)), t.imin, t.imax)
+	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.outputLevel(), t.fd.Num, b.tw.tw.EntriesLen(), shortenb(int(t.size)), t.imin, t.imax)
 	b.tw = nil
 	return nil
 } //跑在run里面
@@ -643,9 +672,9 @@ func (b *tableCompactionBuilder) flush_s() error { //run_s逻辑
 	if err != nil {
 		return err
 	}
-	b.rec.addTableFile_s(b.c.sourceLevel+1, t) //记录合并出来的新的sst，以及写在了哪一层level
+	b.rec.addTableFile_s(b.c.outputLevel(), t) //记录合并出来的新的sst，以及写在了哪一层level
 	b.stat0.write += t.size
-	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.sourceLevel+1, t.fd.Num, b.tw.tw.EntriesLen(), shortenb(int(t.size)), t.imin, t.imax)
+	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.outputLevel(), t.fd.Num, b.tw.tw.EntriesLen(), shortenb(int(t.size)), t.imin, t.imax)
 	b.tw = nil
 	return nil
 } //应该用以另一个compaction中
@@ -668,6 +697,8 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 	b.dropCnt = b.snapDropCnt
 	// Restore compaction state.
 	b.c.restore()
+	// Merge operands of lastUkey that are not yet written.
+	mc := &mergeCollector{mo: b.s.o.GetMergeOperator()}
 
 	defer b.cleanup()
 
@@ -701,6 +732,13 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 			if !hasLastUkey || b.s.icmp.uCompare(lastUkey, ukey) != 0 {
 				// First occurrence of this user key.
 
+				// No older record for lastUkey within this compaction.
+				if mc.active() {
+					if _, err := mc.finish(b.c.baseLevelForKey(lastUkey), nil, b.appendKV); err != nil {
+						return err
+					}
+				}
+
 				// Only rotate tables if ukey doesn't hop across.
 				if b.tw != nil && (shouldStop || b.needFlush()) {
 					if err := b.flush(); err != nil {
@@ -722,28 +760,60 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 				lastSeq = keyMaxSeq
 			}
 
-			switch {
-			case lastSeq <= b.minSeq:
-				// Dropped because newer entry for same user key exist
-				fallthrough // (A)
-			case kt == keyTypeDel && seq <= b.minSeq && b.c.baseLevelForKey(lastUkey):
-				// For this user key:
-				// (1) there is no data in higher levels
-				// (2) data in lower levels will have larger seq numbers
-				// (3) data in layers that are being compacted here and have
-				//     smaller seq numbers will be dropped in the next
-				//     few iterations of this loop (by rule (A) above).
-				// Therefore this deletion marker is obsolete and can be dropped.
-				lastSeq = seq
-				b.dropCnt++
-				continue
-			default:
+			if mc.active() {
+				// Older record of a key whose operands are being collected.
 				lastSeq = seq
+				if kt == keyTypeMerge {
+					mc.add(ikey, iter.Value())
+					continue
+				}
+				var base []byte
+				if kt == keyTypeVal {
+					base = iter.Value()
+				}
+				consumed, err := mc.finish(true, base, b.appendKV)
+				if err != nil {
+					return err
+				}
+				if consumed {
+					b.dropCnt++
+					continue
+				}
+			} else {
+				switch {
+				case lastSeq <= b.minSeq:
+					// Dropped because newer entry for same user key exist
+					fallthrough // (A)
+				case kt == keyTypeDel && seq <= b.minSeq && b.c.baseLevelForKey(lastUkey):
+					// For this user key:
+					// (1) there is no data in higher levels
+					// (2) data in lower levels will have larger seq numbers
+					// (3) data in layers that are being compacted here and have
+					//     smaller seq numbers will be dropped in the next
+					//     few iterations of this loop (by rule (A) above).
+					// Therefore this deletion marker is obsolete and can be dropped.
+					lastSeq = seq
+					b.dropCnt++
+					continue
+				case kt == keyTypeMerge && seq <= b.minSeq && mc.mo != nil:
+					// No snapshot can observe this operand separately, so it
+					// may be merged with the older records of this user key.
+					lastSeq = seq
+					mc.add(ikey, iter.Value())
+					continue
+				default:
+					lastSeq = seq
+				}
 			}
 		} else {
 			if b.strict {
 				return kerr
 			}
+			if mc.active() {
+				if _, err := mc.finish(false, nil, b.appendKV); err != nil {
+					return err
+				}
+			}
 
 			// Don't drop corrupted keys.
 			hasLastUkey = false
@@ -760,6 +830,11 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 	if err := iter.Error(); err != nil {
 		return err
 	}
+	if mc.active() {
+		if _, err := mc.finish(b.c.baseLevelForKey(lastUkey), nil, b.appendKV); err != nil {
+			return err
+		}
+	}
 
 	// Finish last table.
 	if b.tw != nil && !b.tw.empty() {
@@ -776,7 +851,9 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 	b.kerrCnt = b.snapKerrCnt
 	b.dropCnt = b.snapDropCnt
 	// Restore compaction state.
-	b.c.restore() //ref--
+	b.c.restore()
+	// Merge operands of lastUkey that are not yet written.
+	mc := &mergeCollector{mo: b.s.o.GetMergeOperator()} //ref--
 
 	defer b.cleanup()
 
@@ -810,6 +887,13 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 			if !hasLastUkey || b.s.icmp.uCompare(lastUkey, ukey) != 0 {
 				// First occurrence of this user key.
 
+				// No older record for lastUkey within this compaction.
+				if mc.active() {
+					if _, err := mc.finish(b.c.baseLevelForKey_s(lastUkey), nil, b.appendKV_s); err != nil {
+						return err
+					}
+				}
+
 				// Only rotate tables if ukey doesn't hop across.
 				if b.tw != nil && (shouldStop || b.needFlush()) {
 					if err := b.flush_s(); err != nil {
@@ -831,28 +915,60 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 				lastSeq = keyMaxSeq
 			}
 
-			switch {
-			case lastSeq <= b.minSeq:
-				// Dropped because newer entry for same user key exist
-				fallthrough // (A)
-			case kt == keyTypeDel && seq <= b.minSeq && b.c.baseLevelForKey_s(lastUkey):
-				// For this user key:
-				// (1) there is no data in higher levels
-				// (2) data in lower levels will have larger seq numbers
-				// (3) data in layers that are being compacted here and have
-				//     smaller seq numbers will be dropped in the next
-				//     few iterations of this loop (by rule (A) above).
-				// Therefore this deletion marker is obsolete and can be dropped.
-				lastSeq = seq
-				b.dropCnt++
-				continue
-			default:
+			if mc.active() {
+				// Older record of a key whose operands are being collected.
 				lastSeq = seq
+				if kt == keyTypeMerge {
+					mc.add(ikey, iter.Value())
+					continue
+				}
+				var base []byte
+				if kt == keyTypeVal {
+					base = iter.Value()
+				}
+				consumed, err := mc.finish(true, base, b.appendKV_s)
+				if err != nil {
+					return err
+				}
+				if consumed {
+					b.dropCnt++
+					continue
+				}
+			} else {
+				switch {
+				case lastSeq <= b.minSeq:
+					// Dropped because newer entry for same user key exist
+					fallthrough // (A)
+				case kt == keyTypeDel && seq <= b.minSeq && b.c.baseLevelForKey_s(lastUkey):
+					// For this user key:
+					// (1) there is no data in higher levels
+					// (2) data in lower levels will have larger seq numbers
+					// (3) data in layers that are being compacted here and have
+					//     smaller seq numbers will be dropped in the next
+					//     few iterations of this loop (by rule (A) above).
+					// Therefore this deletion marker is obsolete and can be dropped.
+					lastSeq = seq
+					b.dropCnt++
+					continue
+				case kt == keyTypeMerge && seq <= b.minSeq && mc.mo != nil:
+					// No snapshot can observe this operand separately, so it
+					// may be merged with the older records of this user key.
+					lastSeq = seq
+					mc.add(ikey, iter.Value())
+					continue
+				default:
+					lastSeq = seq
+				}
 			}
 		} else {
 			if b.strict {
 				return kerr
 			}
+			if mc.active() {
+				if _, err := mc.finish(false, nil, b.appendKV_s); err != nil {
+					return err
+				}
+			}
 
 			// Don't drop corrupted keys.
 			hasLastUkey = false
@@ -869,6 +985,11 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 	if err := iter.Error(); err != nil {
 		return err
 	}
+	if mc.active() {
+		if _, err := mc.finish(b.c.baseLevelForKey_s(lastUkey), nil, b.appendKV_s); err != nil {
+			return err
+		}
+	}
 
 	// Finish last table.
 	if b.tw != nil && !b.tw.empty() {
@@ -879,7 +1000,7 @@ func (b *tableCompactionBuilder) run_s(cnt *compactionTransactCounter) error {
 func (b *tableCompactionBuilder) revert() error {
 	for _, at := range b.rec.addedTables {
 		b.s.logf("table@build revert @%d", at.num)
-		if err := b.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: at.num}); err != nil {
+		if err := b.s.removeTable(opt.KeyspaceMain, at.num); err != nil {
 			return err
 		}
 	}
@@ -888,13 +1009,139 @@ func (b *tableCompactionBuilder) revert() error {
 func (b *tableCompactionBuilder) revert_s() error {
 	for _, at := range b.rec.addedTabless {
 		b.s.logf("table@build revert @%d", at.num)
-		if err := b.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: at.num}); err != nil {
+		if err := b.s.removeTable(opt.KeyspaceState, at.num); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// tableCompactionBuild runs the build step of c and adds the created tables
+// to rec. If MaxSubcompactions allows, c is split into key-range partitions
+// which are built concurrently, each within its own transaction; the outputs
+// don't overlap since the partitions don't. state selects the keyspace.
+func (db *DB) tableCompactionBuild(c *compaction, rec *sessionRecord, stat *cStatStaging, minSeq uint64, state bool) (kerrCnt, dropCnt int) {
+	subs := c.split(db.s.o.GetMaxSubcompactions())
+	builders := make([]*tableCompactionBuilder, len(subs))
+	for i, sc := range subs {
+		b := &tableCompactionBuilder{
+			db:        db,
+			s:         db.s,
+			c:         sc,
+			rec:       rec,
+			minSeq:    minSeq,
+			strict:    db.s.o.GetStrict(opt.StrictCompaction),
+			tableSize: db.s.o.GetCompactionTableSize(c.outputLevel()),
+		}
+		bstat := stat
+		if len(subs) > 1 {
+			b.rec = &sessionRecord{}
+			bstat = &cStatStaging{}
+		}
+		if state {
+			b.stat0 = bstat
+		} else {
+			b.stat1 = bstat
+		}
+		builders[i] = b
+	}
+	if len(builders) == 1 {
+		if state {
+			db.compactionTransact_s("table@build", builders[0])
+		} else {
+			db.compactionTransact("table@build", builders[0])
+		}
+		return builders[0].kerrCnt, builders[0].dropCnt
+	}
+
+	db.logf("table@compaction split into %d subcompactions", len(builders))
+	var (
+		wg    sync.WaitGroup
+		exit  = make([]interface{}, len(builders))
+		gate  = &compactionPauseGate{}
+		doneC = make(chan struct{})
+	)
+	pauseC := db.tcompPauseC
+	if state {
+		pauseC = db.tcompPauseCs
+	}
+	stat.startTimer()
+	for i, b := range builders {
+		b.pause = gate
+		wg.Add(1)
+		go func(i int, b *tableCompactionBuilder) {
+			defer wg.Done()
+			defer func() {
+				exit[i] = recover()
+			}()
+			if state {
+				db.compactionTransact_s("table@build", b)
+			} else {
+				db.compactionTransact("table@build", b)
+			}
+		}(i, b)
+	}
+	go func() {
+		wg.Wait()
+		close(doneC)
+	}()
+	// A flush pauses every subcompaction, not just one of them.
+	for running := true; running; {
+		select {
+		case <-doneC:
+			running = false
+		case ch := <-pauseC:
+			gate.pause()
+			select {
+			case ch <- struct{}{}:
+			case <-db.closeC:
+			}
+			gate.resume()
+		}
+	}
+	stat.stopTimer()
+
+	for _, x := range exit {
+		if x == nil {
+			continue
+		}
+		// A subcompaction exiting with the DB already reverted its own
+		// tables, revert the others whatever they ended with.
+		for i, b := range builders {
+			if exit[i] == errCompactionTransactExiting {
+				continue
+			}
+			var err error
+			if state {
+				err = b.revert_s()
+			} else {
+				err = b.revert()
+			}
+			if err != nil {
+				db.logf("table@build revert error %q", err)
+			}
+		}
+		panic(x)
+	}
+
+	for _, b := range builders {
+		for _, r := range b.rec.addedTables {
+			rec.addTable(r.level, r.num, r.size, r.imin, r.imax)
+		}
+		for _, r := range b.rec.addedTabless {
+			rec.addTable_s(r.level, r.num, r.size, r.imin, r.imax)
+		}
+		if state {
+			stat.write += b.stat0.write
+		} else {
+			stat.write += b.stat1.write
+		}
+		kerrCnt += b.kerrCnt
+		dropCnt += b.dropCnt
+	}
+	return
+}
+
 // tablecompaction的核心只有2步，build && commit。 其中build的过程db.compactionTransact(“table@build”, b)是将
 // 需要合并的表读出来，排序，写到新表，即read,sort,write 3个步骤。compactionTransact的核心在于run()，其他的都是变量定义和异常处理
 // c包含了要合并的表的信息
@@ -907,10 +1154,17 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 
 	if !noTrivial && c.trivial() {
 		t := c.levels[0][0]
-		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
+		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.dstLevel)
 		rec.delTable(c.sourceLevel, t.fd.Num)
-		rec.addTableFile(c.sourceLevel+1, t)
+		rec.addTableFile(c.dstLevel, t)
+		info := opt.CompactionInfo{Keyspace: opt.KeyspaceMain, SourceLevel: c.sourceLevel, Trivial: true, Input: tFilesInfo(c.sourceLevel, c.levels[0]), InputBytes: t.size}
+		db.s.o.EventListener.CompactionBegin(info)
+		ended := false
+		defer db.endCompactionOnPanic(&info, &ended)
 		db.compactionCommit("table-move", rec)
+		ended = true
+		info.Output, info.OutputBytes = tFilesInfo(c.dstLevel, c.levels[0]), t.size
+		db.s.o.EventListener.CompactionEnd(info)
 		return
 	}
 
@@ -919,25 +1173,25 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 		for _, t := range tables {
 			stats[i].read += t.size
 			// Insert deleted tables into record
-			rec.delTable(c.sourceLevel+i, t.fd.Num)
+			rec.delTable(c.inputLevel(i), t.fd.Num)
 		}
 	}
 	sourceSize := int(stats[0].read + stats[1].read)
+	info := opt.CompactionInfo{
+		Keyspace:    opt.KeyspaceMain,
+		SourceLevel: c.sourceLevel,
+		IntraL0:     c.typ == intraL0Compaction,
+		Input:       append(tFilesInfo(c.sourceLevel, c.levels[0]), tFilesInfo(c.dstLevel, c.levels[1])...),
+		InputBytes:  int64(sourceSize),
+	}
+	db.s.o.EventListener.CompactionBegin(info)
+	ended := false
+	defer db.endCompactionOnPanic(&info, &ended)
 	minSeq := db.minSeq()
-	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.sourceLevel+1, len(c.levels[1]), shortenb(sourceSize), minSeq)
-
-	b := &tableCompactionBuilder{
-		db:        db,
-		s:         db.s,
-		c:         c,
-		rec:       rec,
-		stat1:     &stats[1],
-		minSeq:    minSeq,
-		strict:    db.s.o.GetStrict(opt.StrictCompaction),
-		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
-	}
+	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.outputLevel(), len(c.levels[1]), shortenb(sourceSize), minSeq)
+
 	//将需要合并的表读出来，排序，写到新表
-	db.compactionTransact("table@build", b)
+	kerrCnt, dropCnt := db.tableCompactionBuild(c, rec, &stats[1], minSeq, false)
 
 	// Commit.提交，主要是写入version和manifest
 	stats[1].startTimer()
@@ -945,19 +1199,26 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	stats[1].stopTimer()
 
 	resultSize := int(stats[1].write)
-	db.logf("table@compaction committed F%s S%s Ke·%d D·%d T·%v", sint(len(rec.addedTables)-len(rec.deletedTables)), sshortenb(resultSize-sourceSize), b.kerrCnt, b.dropCnt, stats[1].duration)
+	db.logf("table@compaction committed F%s S%s Ke·%d D·%d T·%v", sint(len(rec.addedTables)-len(rec.deletedTables)), sshortenb(resultSize-sourceSize), kerrCnt, dropCnt, stats[1].duration)
+
+	info.Output, info.OutputBytes = atRecordsInfo(opt.KeyspaceMain, rec.addedTables)
+	info.Duration = stats[1].duration
+	ended = true
+	db.s.o.EventListener.CompactionEnd(info)
 
 	// Save compaction stats
 	for i := range stats {
-		db.compStats.addStat(c.sourceLevel+1, &stats[i])
+		db.compStats.addStat(c.outputLevel(), &stats[i])
 	}
 	switch c.typ {
-	case level0Compaction:
+	case level0Compaction, intraL0Compaction:
 		atomic.AddUint32(&db.level0Comp, 1)
 	case nonLevel0Compaction:
 		atomic.AddUint32(&db.nonLevel0Comp, 1)
 	case seekCompaction:
 		atomic.AddUint32(&db.seekComp, 1)
+	case deletionCompaction:
+		atomic.AddUint32(&db.deletionComp, 1)
 	}
 }
 func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
@@ -968,10 +1229,17 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 
 	if !noTrivial && c.trivial_s() {
 		t := c.level_s[0][0] //合并的那一层的第一个sfile？
-		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
+		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.dstLevel)
 		rec.delTable_s(c.sourceLevel, t.fd.Num)
-		rec.addTableFile_s(c.sourceLevel+1, t)
+		rec.addTableFile_s(c.dstLevel, t)
+		info := opt.CompactionInfo{Keyspace: opt.KeyspaceState, SourceLevel: c.sourceLevel, Trivial: true, Input: sFilesInfo(c.sourceLevel, c.level_s[0]), InputBytes: t.size}
+		db.s.o.EventListener.CompactionBegin(info)
+		ended := false
+		defer db.endCompactionOnPanic(&info, &ended)
 		db.compactionCommit_s("table-move", rec)
+		ended = true
+		info.Output, info.OutputBytes = sFilesInfo(c.dstLevel, c.level_s[0]), t.size
+		db.s.o.EventListener.CompactionEnd(info)
 		return
 	}
 
@@ -980,25 +1248,25 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 		for _, t := range tables {
 			stats[i].read += t.size
 			// Insert deleted tables into record,~~~~i取值0、1,把要删除的两层的文件记录，放入deletedtabless中
-			rec.delTable_s(c.sourceLevel+i, t.fd.Num)
+			rec.delTable_s(c.inputLevel(i), t.fd.Num)
 		}
 	}
 	sourceSize := int(stats[0].read + stats[1].read)
+	info := opt.CompactionInfo{
+		Keyspace:    opt.KeyspaceState,
+		SourceLevel: c.sourceLevel,
+		IntraL0:     c.typ == intraL0Compaction,
+		Input:       append(sFilesInfo(c.sourceLevel, c.level_s[0]), sFilesInfo(c.dstLevel, c.level_s[1])...),
+		InputBytes:  int64(sourceSize),
+	}
+	db.s.o.EventListener.CompactionBegin(info)
+	ended := false
+	defer db.endCompactionOnPanic(&info, &ended)
 	minSeq := db.minSeq()
-	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.level_s[0]), c.sourceLevel+1, len(c.level_s[1]), shortenb(sourceSize), minSeq)
-
-	b := &tableCompactionBuilder{
-		db:        db,
-		s:         db.s,
-		c:         c,
-		rec:       rec,
-		stat0:     &stats[1], //第二层
-		minSeq:    minSeq,
-		strict:    db.s.o.GetStrict(opt.StrictCompaction),
-		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
-	}
+	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.level_s[0]), c.outputLevel(), len(c.level_s[1]), shortenb(sourceSize), minSeq)
+
 	//将需要合并的表读出来，排序，写到新表,这是build的重点
-	db.compactionTransact_s("table@build", b) //addedtabless应该是记录新的sfiles了
+	kerrCnt, dropCnt := db.tableCompactionBuild(c, rec, &stats[1], minSeq, true) //addedtabless应该是记录新的sfiles了
 
 	// Commit.提交
 	stats[1].startTimer()
@@ -1006,23 +1274,33 @@ func (db *DB) tableCompaction_s(c *compaction, noTrivial bool) {
 	stats[1].stopTimer()
 
 	resultSize := int(stats[1].write)
-	db.logf("table@compaction committed F%s S%s Ke·%d D·%d T·%v", sint(len(rec.addedTabless)-len(rec.deletedTabless)), sshortenb(resultSize-sourceSize), b.kerrCnt, b.dropCnt, stats[1].duration)
+	db.logf("table@compaction committed F%s S%s Ke·%d D·%d T·%v", sint(len(rec.addedTabless)-len(rec.deletedTabless)), sshortenb(resultSize-sourceSize), kerrCnt, dropCnt, stats[1].duration)
+
+	info.Output, info.OutputBytes = atRecordsInfo(opt.KeyspaceState, rec.addedTabless)
+	info.Duration = stats[1].duration
+	ended = true
+	db.s.o.EventListener.CompactionEnd(info)
 
 	// Save compaction stats
 	for i := range stats {
-		db.compStats.addStat(c.sourceLevel+1, &stats[i])
+		db.compStats.addStat(c.outputLevel(), &stats[i])
 	}
 	switch c.typ {
-	case level0Compaction:
+	case level0Compaction, intraL0Compaction:
 		atomic.AddUint32(&db.level0Comps, 1)
 	case nonLevel0Compaction:
 		atomic.AddUint32(&db.nonLevel0Comps, 1)
 	case seekCompaction:
 		atomic.AddUint32(&db.seekComp, 1)
+	case deletionCompaction:
+		atomic.AddUint32(&db.deletionComp, 1)
 	}
 }
 
 func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
+	db.compAcquire(opt.KeyspaceMain, db.tcompPauseC)
+	defer db.compSched.release(opt.KeyspaceMain)
+
 	db.logf("table@compaction range L%d %q:%q", level, umin, umax)
 	if level >= 0 {
 		if c := db.s.getCompactionRange(level, umin, umax, true); c != nil {
@@ -1060,6 +1338,9 @@ func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
 	return nil
 }
 func (db *DB) tableRangeCompaction_s(level int, umin, umax []byte) error {
+	db.compAcquire(opt.KeyspaceState, db.tcompPauseCs)
+	defer db.compSched.release(opt.KeyspaceState)
+
 	db.logf("table@compaction range L%d %q:%q", level, umin, umax)
 	if level >= 0 {
 		if c := db.s.getCompactionRange_s(level, umin, umax, true); c != nil {
@@ -1099,12 +1380,16 @@ func (db *DB) tableRangeCompaction_s(level int, umin, umax []byte) error {
 
 func (db *DB) tableAutoCompaction() {
 	//fmt.Println("This is tableAutoCompaction")
+	db.compAcquire(opt.KeyspaceMain, db.tcompPauseC)
+	defer db.compSched.release(opt.KeyspaceMain)
 	if c := db.s.pickCompaction(); c != nil { //c会返回一个compaction类型，包含了要合并的文件的tfiles
 		db.tableCompaction(c, false)
 	}
 }
 func (db *DB) tableAutoCompaction_s() {
 	//fmt.Println("This is tableAutoCompaction_s")
+	db.compAcquire(opt.KeyspaceState, db.tcompPauseCs)
+	defer db.compSched.release(opt.KeyspaceState)
 	if c := db.s.pickCompaction_s(); c != nil {
 		db.tableCompaction_s(c, false)
 	}
@@ -1125,7 +1410,7 @@ func (db *DB) tableNeedCompaction_s() bool {
 func (db *DB) resumeWrite() bool {
 	v := db.s.version()
 	defer v.release()
-	if v.tLen(0) < db.s.o.GetWriteL0PauseTrigger() {
+	if v.l0Runs() < db.s.o.GetWriteL0PauseTrigger() {
 		return true
 	}
 	return false
@@ -1133,7 +1418,7 @@ func (db *DB) resumeWrite() bool {
 func (db *DB) resumeWrite_s() bool {
 	v := db.s.version()
 	defer v.release()
-	if v.tLen_s(0) < db.s.o.GetWriteL0PauseTrigger2() { //12,如果l0有12个，就停止写入
+	if v.l0Runs_s() < db.s.o.GetWriteL0PauseTrigger2() { //12,如果l0有12个，就停止写入
 		return true
 	}
 	return false
@@ -1147,6 +1432,47 @@ func (db *DB) pauseCompaction(ch chan<- struct{}) {
 	}
 }
 
+// compactionPauseGate holds the subcompactions of a table compaction
+// before their next table while a flush has paused the compaction.
+type compactionPauseGate struct {
+	mu      sync.Mutex
+	resumeC chan struct{} // 暂停时非 nil
+}
+
+func (g *compactionPauseGate) pause() {
+	g.mu.Lock()
+	g.resumeC = make(chan struct{})
+	g.mu.Unlock()
+}
+
+func (g *compactionPauseGate) resume() {
+	g.mu.Lock()
+	close(g.resumeC)
+	g.resumeC = nil
+	g.mu.Unlock()
+}
+
+// wait waits until the compaction is resumed, or exits the compaction
+// transaction if the DB is closed.
+func (g *compactionPauseGate) wait(db *DB) {
+	g.mu.Lock()
+	resumeC := g.resumeC
+	g.mu.Unlock()
+	if resumeC == nil {
+		select {
+		case <-db.closeC:
+			db.compactionExitTransact()
+		default:
+		}
+		return
+	}
+	select {
+	case <-resumeC:
+	case <-db.closeC:
+		db.compactionExitTransact()
+	}
+}
+
 type cCmd interface {
 	ack(err error)
 }
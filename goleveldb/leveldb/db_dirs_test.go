@@ -0,0 +1,271 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+func TestDB_FileDirs(t *testing.T) {
+	temp := t.TempDir()
+	root := filepath.Join(temp, "db")
+	o := &opt.Options{
+		TableDir:      filepath.Join(temp, "chain"),
+		StateTableDir: filepath.Join(temp, "state"),
+		JournalDir:    filepath.Join(temp, "wal"),
+	}
+
+	// 先用单目录写一些表，再换成多目录。
+	db, err := OpenFile(root, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	put := func(db *DB, from, to int) {
+		for i := from; i < to; i++ {
+			key := []byte(fmt.Sprintf("key%04d", i))
+			if err := db.Put(key, []byte("main"), nil); err != nil {
+				t.Fatal("Put: got error: ", err)
+			}
+			if err := db.Put_s(key, []byte("state"), nil); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+	}
+	check := func(db *DB, n int) {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%04d", i))
+			if v, err := db.Get(key, nil); err != nil || string(v) != "main" {
+				t.Fatalf("Get %s: got %q, %v", key, v, err)
+			}
+			if v, err := db.Get_s(key, nil); err != nil || string(v) != "state" {
+				t.Fatalf("Get_s %s: got %q, %v", key, v, err)
+			}
+		}
+	}
+	flush := func(db *DB) {
+		if err := db.CompactRange(util.Range{}); err != nil {
+			t.Fatal("CompactRange: got error: ", err)
+		}
+		db.writeLockC <- struct{}{}
+		_, err := db.rotateMem_s(0, true)
+		<-db.writeLockC
+		if err != nil {
+			t.Fatal("rotateMem_s: got error: ", err)
+		}
+	}
+	put(db, 0, 100)
+	flush(db)
+	if err := db.Close(); err != nil {
+		t.Fatal("Close: got error: ", err)
+	}
+
+	files := func(dir, suffix string) (names []string) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), suffix) {
+				names = append(names, e.Name())
+			}
+		}
+		return
+	}
+	tables := func(db *DB) (main, state []string) {
+		v := db.s.version()
+		defer v.release()
+		for _, tt := range v.levels {
+			for _, t := range tt {
+				main = append(main, fmt.Sprintf("%06d.ldb", t.fd.Num))
+			}
+		}
+		for _, tt := range v.level_s {
+			for _, t := range tt {
+				state = append(state, fmt.Sprintf("%06d.ldb", t.fd.Num))
+			}
+		}
+		sort.Strings(main)
+		sort.Strings(state)
+		return
+	}
+	same := func(what string, got, want []string) {
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("%s: got %v, want %v", what, got, want)
+		}
+	}
+
+	db, err = OpenFile(root, o)
+	if err != nil {
+		t.Fatal("OpenFile with dirs: got error: ", err)
+	}
+	check(db, 100)
+	main, state := tables(db)
+	if len(main) == 0 || len(state) == 0 {
+		t.Fatalf("tables: got %v %v", main, state)
+	}
+	same("chain dir", files(o.TableDir, ".ldb"), main)
+	same("state dir", files(o.StateTableDir, ".ldb"), state)
+	if len(files(root, ".ldb")) != 0 || len(files(root, ".log")) != 0 || len(files(o.JournalDir, ".log")) == 0 {
+		t.Fatalf("DB directory still has tables or journals")
+	}
+
+	// 新的表和日志直接写到各自的目录。
+	put(db, 100, 200)
+	flush(db)
+	if err := db.Close(); err != nil {
+		t.Fatal("Close: got error: ", err)
+	}
+
+	// 不给目录的时候沿用上次的。
+	db, err = OpenFile(root, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	check(db, 200)
+	main, state = tables(db)
+	same("chain dir", files(o.TableDir, ".ldb"), main)
+	same("state dir", files(o.StateTableDir, ".ldb"), state)
+	if err := db.Close(); err != nil {
+		t.Fatal("Close: got error: ", err)
+	}
+
+	// 全部搬回 DB 目录。
+	db, err = OpenFile(root, &opt.Options{TableDir: root, StateTableDir: root, JournalDir: root})
+	if err != nil {
+		t.Fatal("OpenFile back: got error: ", err)
+	}
+	defer db.Close()
+	check(db, 200)
+	main, state = tables(db)
+	all := append(main, state...)
+	sort.Strings(all)
+	same("DB dir", files(root, ".ldb"), all)
+	if len(files(o.TableDir, ".ldb")) != 0 || len(files(o.StateTableDir, ".ldb")) != 0 {
+		t.Fatal("tables left in the old directories")
+	}
+}
+
+// putStateTables writes n overlapping state tables to level 0.
+func putStateTables(t *testing.T, db *DB, n int) {
+	for i := 0; i < n; i++ {
+		for j := 0; j < 50; j++ {
+			key := []byte(fmt.Sprintf("key%04d", j))
+			if err := db.Put_s(key, []byte(fmt.Sprintf("v%d", i)), nil); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+		db.writeLockC <- struct{}{}
+		_, err := db.rotateMem_s(0, true)
+		<-db.writeLockC
+		if err != nil {
+			t.Fatal("rotateMem_s: got error: ", err)
+		}
+	}
+}
+
+func TestDB_FileDirsStateCompaction(t *testing.T) {
+	temp := t.TempDir()
+	o := &opt.Options{
+		DisableLargeBatchTransaction: true,
+		TableDir:                     filepath.Join(temp, "chain"),
+		StateTableDir:                filepath.Join(temp, "state"),
+	}
+	db, err := OpenFile(filepath.Join(temp, "db"), o)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+
+	// 压缩状态树写出来的表也是状态表。
+	putStateTables(t, db, 3)
+	if err := db.compTriggerRange(db.tcompCmdCs, -1, nil, nil); err != nil {
+		t.Fatal("state table compaction: got error: ", err)
+	}
+
+	v := db.s.version()
+	defer v.release()
+	if len(v.level_s[0]) != 0 {
+		t.Fatalf("state level 0: got %d tables after compaction", len(v.level_s[0]))
+	}
+	for level, tables := range v.level_s {
+		for _, tf := range tables {
+			name := fmt.Sprintf("%06d.ldb", tf.fd.Num)
+			if _, err := os.Stat(filepath.Join(o.StateTableDir, name)); err != nil {
+				t.Errorf("state L%d table %s not in the state table directory: %v", level, name, err)
+			}
+		}
+	}
+	if names, err := filepath.Glob(filepath.Join(o.TableDir, "*.ldb")); err != nil || len(names) != 0 {
+		t.Fatalf("chain table directory: got tables %v, %v", names, err)
+	}
+}
+
+func TestDB_FileDirsStateTableRemoved(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		deleted = make(map[int64]opt.Keyspace)
+	)
+	temp := t.TempDir()
+	o := &opt.Options{
+		DisableLargeBatchTransaction: true,
+		TableDir:                     filepath.Join(temp, "chain"),
+		StateTableDir:                filepath.Join(temp, "state"),
+		EventListener: &opt.EventListener{
+			TableDeleted: func(info opt.TableInfo) {
+				mu.Lock()
+				deleted[info.Num] = info.Keyspace
+				mu.Unlock()
+			},
+		},
+	}
+	db, err := OpenFile(filepath.Join(temp, "db"), o)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+
+	putStateTables(t, db, 3)
+	v := db.s.version()
+	var inputs []int64
+	for _, tf := range v.level_s[0] {
+		inputs = append(inputs, tf.fd.Num)
+	}
+	v.release()
+	if err := db.compTriggerRange(db.tcompCmdCs, -1, nil, nil); err != nil {
+		t.Fatal("state table compaction: got error: ", err)
+	}
+
+	// 压缩掉的状态表不再被引用之后从状态表的目录删掉。
+	for _, num := range inputs {
+		path := filepath.Join(o.StateTableDir, fmt.Sprintf("%06d.ldb", num))
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("compacted state table %s not removed", path)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		ks, ok := deleted[num]
+		mu.Unlock()
+		if !ok || ks != opt.KeyspaceState {
+			t.Errorf("TableDeleted @%d: got keyspace %v, reported %v", num, ks, ok)
+		}
+	}
+}
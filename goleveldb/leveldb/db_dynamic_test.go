@@ -0,0 +1,140 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+func TestLevelTargets(t *testing.T) {
+	s := &session{}
+	s.setOptions(&opt.Options{DynamicLevelBytes: true, CompactionTotalSize: 100 << 10})
+	min := s.o.GetCompactionTotalSize(1)
+
+	// 空库：全部落到最后一层。
+	targets, base := s.levelTargets(nil)
+	if len(targets) != opt.DefaultDynamicLevels || base != opt.DefaultDynamicLevels-1 || targets[base] != min {
+		t.Fatalf("empty: got %v, base %d", targets, base)
+	}
+
+	targets, base = s.levelTargets([]int64{1 << 20, 0, 0, 0, 0, 0, 50 * min})
+	if base != 5 || targets[6] != 50*min || targets[5] != 5*min || targets[4] != 0 {
+		t.Fatalf("50x: got %v, base %d", targets, base)
+	}
+	if got := dynamicScore(6*min, 5, base, targets); got != 1.2 {
+		t.Fatalf("score of L5: got %v, want 1.2", got)
+	}
+	if dynamicScore(100*min, 6, base, targets) != 0 || dynamicScore(1, 2, base, targets) != 1 {
+		t.Fatal("score of the last level must be 0, of a level above the base 1")
+	}
+
+	// 比 DynamicLevels 更深的层也算最后一层。
+	targets, base = s.levelTargets([]int64{0, 0, 0, 0, 0, 0, 0, 0, 2000 * min})
+	if len(targets) != 9 || base != 5 || targets[5] != 2*min {
+		t.Fatalf("deep: got %v, base %d", targets, base)
+	}
+
+	s.setOptions(&opt.Options{})
+	if targets, base := s.levelTargets([]int64{0, 1 << 30}); targets != nil || base != 1 {
+		t.Fatalf("static: got %v, base %d", targets, base)
+	}
+}
+
+func TestDB_DynamicLevelBytes(t *testing.T) {
+	const (
+		numKey   = 2000
+		valueLen = 200
+	)
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DynamicLevelBytes:            true,
+		Compression:                  opt.NoCompression,
+		WriteBuffer:                  64 << 10,
+		WriteBuffer2:                 64 << 10,
+		CompactionTableSize:          32 << 10,
+		CompactionTotalSize:          4 << 10,
+	})
+	defer h.close()
+
+	var live int64
+	for round := 0; round < 6; round++ {
+		live = 0
+		for i := 0; i < numKey; i++ {
+			key := fmt.Sprintf("key%06d", i)
+			value := fmt.Sprintf("%d%s", round, strings.Repeat("v", valueLen-1))
+			h.put(key, value)
+			if err := h.db.Put_s([]byte(key), []byte(value), nil); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+			live += int64(len(key) + len(value))
+		}
+		h.compactMem()
+		h.db.writeLockC <- struct{}{}
+		if _, err := h.db.rotateMem_s(0, true); err != nil {
+			t.Fatal("rotateMem_s: got error: ", err)
+		}
+		<-h.db.writeLockC
+	}
+	// 等两边的 compaction 都做完。
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		v := h.db.s.version()
+		done := !v.needCompaction() && !v.needCompaction_s()
+		v.release()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("compaction not done, tables per level %s", h.getTablesPerLevel())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	check := func(name string, sizes []int64) {
+		t.Helper()
+		targets, base := h.db.s.levelTargets(sizes)
+		last := len(targets) - 1
+		if last != opt.DefaultDynamicLevels-1 {
+			t.Fatalf("%s: data left the last level, sizes %v", name, sizes)
+		}
+		var upper, total int64
+		for level := 1; level < len(sizes); level++ {
+			total += sizes[level]
+			if level < base && sizes[level] > 0 {
+				t.Fatalf("%s: L%d above base level %d not empty, sizes %v", name, level, base, sizes)
+			}
+			if level < last {
+				upper += sizes[level]
+			}
+		}
+		// 上面几层加起来不会超过最后一层的 1/(10-1)。
+		if upper*9 > sizes[last]+int64(h.o.CompactionTableSize) {
+			t.Fatalf("%s: upper levels hold %d bytes over last level %d, sizes %v", name, upper, sizes[last], sizes)
+		}
+		if float64(total) > 1.3*float64(live) {
+			t.Fatalf("%s: space amplification %.2f, sizes %v", name, float64(total)/float64(live), sizes)
+		}
+		t.Logf("%s: space amplification %.2f, base level %d, sizes %v", name, float64(total)/float64(live), base, sizes)
+	}
+	v := h.db.s.version()
+	check("main", v.levelSizes())
+	check("state", v.levelSizes_s())
+	v.release()
+
+	for i := 0; i < numKey; i += 37 {
+		key := fmt.Sprintf("key%06d", i)
+		want := "5" + strings.Repeat("v", valueLen-1)
+		h.getVal(key, want)
+		if v, err := h.db.Get_s([]byte(key), nil); err != nil || string(v) != want {
+			t.Fatalf("Get_s %s: got %d bytes, %v", key, len(v), err)
+		}
+	}
+}
@@ -223,6 +223,12 @@ func (i *dbIter) next() bool {
 						i.dir = dirForward
 						return true
 					}
+				case keyTypeMerge:
+					if i.dir == dirSOI || i.icmp.uCompare(ukey, i.key) > 0 {
+						i.key = append(i.key[:0], ukey...)
+						i.dir = dirForward
+						return i.mergeForward()
+					}
 				}
 			}
 		} else if i.strict {
@@ -238,6 +244,23 @@ func (i *dbIter) next() bool {
 	return false
 }
 
+// mergeForward resolves the merge operands of i.key, the underlying iterator
+// is left at the last record of i.key so that Next works as usual.
+func (i *dbIter) mergeForward() bool {
+	value, err := i.db.resolveMerge(i.iter, i.key)
+	if err == nil && i.iter.Valid() {
+		if ukey, _, _, kerr := parseInternalKey(i.iter.Key()); kerr == nil && i.icmp.uCompare(ukey, i.key) != 0 {
+			i.iter.Prev()
+		}
+	}
+	if err != nil {
+		i.setErr(err)
+		return false
+	}
+	i.value = append(i.value[:0], value...)
+	return true
+}
+
 func (i *dbIter) Next() bool {
 	if i.dir == dirEOI || i.err != nil {
 		return false
@@ -257,19 +280,36 @@ func (i *dbIter) Next() bool {
 func (i *dbIter) prev() bool {
 	i.dir = dirBackward
 	del := true
+	// Merge operands of i.key, oldest first, and whether i.value holds the
+	// value they apply to.
+	var (
+		operands [][]byte
+		hasBase  bool
+		lastSeq  uint64
+	)
 	if i.iter.Valid() {
 		for {
 			if ukey, seq, kt, kerr := parseInternalKey(i.iter.Key()); kerr == nil {
 				i.sampleSeek()
 				if seq <= i.seq {
 					if !del && i.icmp.uCompare(ukey, i.key) < 0 {
-						return true
+						return i.mergeBackward(operands, hasBase)
 					}
 					del = (kt == keyTypeDel)
-					if !del {
+					switch kt {
+					case keyTypeDel:
+						operands, hasBase = operands[:0], false
+					case keyTypeVal:
 						i.key = append(i.key[:0], ukey...)
 						i.value = append(i.value[:0], i.iter.Value()...)
+						operands, hasBase = operands[:0], true
+					case keyTypeMerge:
+						if len(operands) == 0 || seq != lastSeq {
+							i.key = append(i.key[:0], ukey...)
+							operands = append(operands, append([]byte{}, i.iter.Value()...))
+						}
 					}
+					lastSeq = seq
 				}
 			} else if i.strict {
 				i.setErr(kerr)
@@ -285,6 +325,28 @@ func (i *dbIter) prev() bool {
 		i.iterErr()
 		return false
 	}
+	return i.mergeBackward(operands, hasBase)
+}
+
+// mergeBackward applies the operands collected by prev on top of i.value.
+func (i *dbIter) mergeBackward(operands [][]byte, hasBase bool) bool {
+	if len(operands) == 0 {
+		return true
+	}
+	var base []byte
+	if hasBase {
+		base = i.value
+	}
+	ops := make([][]byte, len(operands))
+	for n, op := range operands {
+		ops[len(operands)-1-n] = op
+	}
+	value, err := fullMerge(i.db.s.o.GetMergeOperator(), i.key, base, ops)
+	if err != nil {
+		i.setErr(err)
+		return false
+	}
+	i.value = append(i.value[:0], value...)
 	return true
 }
 
@@ -0,0 +1,79 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// recyclable reports whether flushed journals are kept for reuse.
+func (db *DB) recyclable() bool {
+	return db.s.o.GetJournalRecycle() > 0 && db.s.stor.canReuse()
+}
+
+// recycleJournal keeps the flushed journal fd for reuse if there is room
+// in the free list; it returns false if fd should be removed instead.
+func (db *DB) recycleJournal(fd storage.FileDesc) bool {
+	if !db.recyclable() {
+		return false
+	}
+	db.jfreeMu.Lock()
+	defer db.jfreeMu.Unlock()
+	if len(db.jfree) >= db.s.o.GetJournalRecycle() {
+		return false
+	}
+	ffd := storage.FileDesc{Type: storage.TypeJournalFree, Num: fd.Num}
+	if err := db.s.stor.Rename(fd, ffd); err != nil {
+		db.logf("journal@recycle renaming @%d %q", fd.Num, err)
+		return false
+	}
+	db.jfree = append(db.jfree, ffd)
+	db.logf("journal@recycle kept @%d", fd.Num)
+	return true
+}
+
+// keepFreeJournal adds a free journal found on open to the free list, or
+// returns false if it is not needed anymore.
+func (db *DB) keepFreeJournal(fd storage.FileDesc) bool {
+	if !db.recyclable() {
+		return false
+	}
+	db.jfreeMu.Lock()
+	defer db.jfreeMu.Unlock()
+	// 恢复 journal 时回收的文件已经在列表里了。
+	for _, ffd := range db.jfree {
+		if ffd == fd {
+			return true
+		}
+	}
+	if len(db.jfree) >= db.s.o.GetJournalRecycle() {
+		return false
+	}
+	db.jfree = append(db.jfree, fd)
+	return true
+}
+
+// createJournal creates the journal file fd, overwriting a free journal
+// if there is one.
+func (db *DB) createJournal(fd storage.FileDesc) (storage.Writer, error) {
+	db.jfreeMu.Lock()
+	var ffd storage.FileDesc
+	if n := len(db.jfree); n > 0 {
+		ffd = db.jfree[0]
+		db.jfree = append(db.jfree[:0], db.jfree[1:]...)
+	}
+	db.jfreeMu.Unlock()
+	if !ffd.Zero() {
+		w, err := db.s.stor.Reuse(ffd, fd)
+		if err == nil {
+			db.logf("journal@recycle reused @%d for @%d", ffd.Num, fd.Num)
+			return w, nil
+		}
+		db.logf("journal@recycle reusing @%d %q", ffd.Num, err)
+	}
+	return db.s.stor.Create(fd)
+}
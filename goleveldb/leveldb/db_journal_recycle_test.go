@@ -0,0 +1,79 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+func TestDB_JournalRecycle(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		JournalCompression:           opt.SnappyCompression,
+		JournalRecycle:               2,
+	})
+	defer h.close()
+
+	free := func() []storage.FileDesc {
+		fds, err := h.stor.List(storage.TypeJournalFree)
+		if err != nil {
+			t.Fatal("List: got error: ", err)
+		}
+		return fds
+	}
+	put := func(round, n int) {
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key%02d-%03d", round, i)
+			h.put(key, key+"-value-value-value")
+			if err := h.db.Put_s([]byte(key), []byte(key), nil); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+	}
+	rotate := func() {
+		h.compactMem()
+		h.db.writeLockC <- struct{}{}
+		_, err := h.db.rotateMem_s(0, true)
+		<-h.db.writeLockC
+		if err != nil {
+			t.Fatal("rotateMem_s: got error: ", err)
+		}
+	}
+
+	const rounds = 6
+	for r := 0; r < rounds; r++ {
+		put(r, 50)
+		rotate()
+		if n := len(free()); n > 2 {
+			t.Fatalf("round %d: got %d free journals, want at most 2", r, n)
+		}
+	}
+	if len(free()) == 0 {
+		t.Fatal("no journal kept for reuse")
+	}
+
+	// 覆盖写过的旧文件里残留的记录不能被当成新记录回放。
+	put(rounds, 20)
+	h.reopenDB()
+	if n := len(free()); n == 0 || n > 2 {
+		t.Fatalf("after reopen: got %d free journals", n)
+	}
+	for r := 0; r <= rounds; r++ {
+		for i := 0; i < 20; i += 3 {
+			key := fmt.Sprintf("key%02d-%03d", r, i)
+			h.getVal(key, key+"-value-value-value")
+			if v, err := h.db.Get_s([]byte(key), nil); err != nil || string(v) != key {
+				t.Fatalf("Get_s %s: got %q, %v", key, v, err)
+			}
+		}
+	}
+	h.get("key06-020", false)
+}
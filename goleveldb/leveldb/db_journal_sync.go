@@ -0,0 +1,152 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sync/atomic"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// journalSyncStats counts the journal fsyncs of a keyspace. All fields are
+// accessed atomically.
+type journalSyncStats struct {
+	syncs    int64
+	nanos    int64
+	maxNanos int64
+	records  int64 // 已经 sync 的记录数
+	bytes    int64
+
+	// 写进日志但还没 sync 的部分。
+	pendRecords int64
+	pendBytes   int64
+}
+
+func (db *DB) journalSyncPolicy(ks opt.Keyspace) opt.JournalSync {
+	if ks == opt.KeyspaceState {
+		return db.s.o.GetJournalSync2()
+	}
+	return db.s.o.GetJournalSync()
+}
+
+// syncEveryWrite reports whether every write of ks syncs the journal, see
+// opt.SyncGroup.
+func (db *DB) syncEveryWrite(ks opt.Keyspace) bool {
+	return db.journalSyncPolicy(ks) == opt.SyncGroup && !db.s.o.GetNoSync()
+}
+
+// journalWritten accounts batches written to the journal of ks and wakes
+// the background syncer once enough bytes are pending.
+func (db *DB) journalWritten(ks opt.Keyspace, batches []*Batch) {
+	var n int
+	for _, batch := range batches {
+		n += batch.internalLen
+	}
+	st := &db.jsync[ks]
+	atomic.AddInt64(&st.pendRecords, int64(batchesLen(batches)))
+	pending := atomic.AddInt64(&st.pendBytes, int64(n))
+	if db.jsyncKickC != nil && pending >= int64(db.s.o.GetJournalSyncBytes()) {
+		select {
+		case db.jsyncKickC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// syncJournal syncs w, the journal of ks, and records the fsync.
+func (db *DB) syncJournal(ks opt.Keyspace, w storage.Writer) error {
+	st := &db.jsync[ks]
+	records := atomic.SwapInt64(&st.pendRecords, 0)
+	bytes := atomic.SwapInt64(&st.pendBytes, 0)
+	start := time.Now()
+	err := w.Sync()
+	d := int64(time.Since(start))
+	atomic.AddInt64(&st.syncs, 1)
+	atomic.AddInt64(&st.nanos, d)
+	for {
+		max := atomic.LoadInt64(&st.maxNanos)
+		if d <= max || atomic.CompareAndSwapInt64(&st.maxNanos, max, d) {
+			break
+		}
+	}
+	atomic.AddInt64(&st.records, records)
+	atomic.AddInt64(&st.bytes, bytes)
+	return err
+}
+
+// closeJournalWriter closes w, the journal of ks replaced by newMem. The
+// background syncer only sees the new journal, so the records still
+// unsynced under opt.SyncBackground are synced here; the pending counters
+// start over for the new journal either way. Must be called with
+// db.jsyncMu held.
+func (db *DB) closeJournalWriter(ks opt.Keyspace, w storage.Writer) {
+	st := &db.jsync[ks]
+	if db.journalSyncPolicy(ks) == opt.SyncBackground && !db.s.o.GetNoSync() && atomic.LoadInt64(&st.pendRecords) > 0 {
+		if err := db.syncJournal(ks, w); err != nil {
+			db.logf("journal@sync %s error E·%q", ks, err)
+		}
+	} else {
+		atomic.StoreInt64(&st.pendRecords, 0)
+		atomic.StoreInt64(&st.pendBytes, 0)
+	}
+	w.Close()
+}
+
+// syncJournals syncs the journals of the keyspaces using opt.SyncBackground
+// that have unsynced writes. db.jsyncMu keeps newMem from closing them
+// meanwhile.
+func (db *DB) syncJournals() {
+	db.jsyncMu.Lock()
+	defer db.jsyncMu.Unlock()
+	for _, ks := range []opt.Keyspace{opt.KeyspaceMain, opt.KeyspaceState} {
+		if db.journalSyncPolicy(ks) != opt.SyncBackground || atomic.LoadInt64(&db.jsync[ks].pendRecords) == 0 {
+			continue
+		}
+		w := db.journalWriter
+		if ks == opt.KeyspaceState {
+			w = db.journalWriter2
+		}
+		if w == nil {
+			continue
+		}
+		if err := db.syncJournal(ks, w); err != nil {
+			db.logf("journal@sync %s error E·%q", ks, err)
+		}
+	}
+}
+
+// jSyncLoop is the opt.SyncBackground syncer. It syncs once more before
+// exiting, so a clean close loses nothing.
+func (db *DB) jSyncLoop() {
+	defer db.closeW.Done()
+	ticker := time.NewTicker(db.s.o.GetJournalSyncInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-db.jsyncKickC:
+		case <-db.closeC:
+			db.syncJournals()
+			return
+		}
+		db.syncJournals()
+	}
+}
+
+// journalSyncStats fills the journal fsync counters of s.
+func (db *DB) journalSyncStats(s *DBStats) {
+	for ks := range db.jsync {
+		st := &db.jsync[ks]
+		s.JournalSyncs[ks] = atomic.LoadInt64(&st.syncs)
+		s.JournalSyncTime[ks] = time.Duration(atomic.LoadInt64(&st.nanos))
+		s.JournalSyncMaxTime[ks] = time.Duration(atomic.LoadInt64(&st.maxNanos))
+		s.JournalSyncRecords[ks] = atomic.LoadInt64(&st.records)
+		s.JournalSyncBytes[ks] = atomic.LoadInt64(&st.bytes)
+	}
+}
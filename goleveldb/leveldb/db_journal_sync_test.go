@@ -0,0 +1,166 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/testutil"
+)
+
+func TestDB_JournalSyncGroup(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		JournalSync:                  opt.SyncGroup,
+		JournalGroupCommitDelay:      time.Millisecond,
+	})
+	defer h.close()
+
+	// 第一个 fsync 卡住的时候，其他写入排队，之后合并成一组。
+	h.stor.Stall(testutil.ModeSync, storage.TypeJournal)
+	const writers, n = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := h.db.Put([]byte(fmt.Sprintf("key%d-%03d", w, i)), []byte("v"), nil); err != nil {
+					t.Error("Put: got error: ", err)
+					return
+				}
+			}
+		}(w)
+	}
+	time.Sleep(50 * time.Millisecond)
+	h.stor.Release(testutil.ModeSync, storage.TypeJournal)
+	wg.Wait()
+	if err := h.db.Put_s([]byte("state-key"), []byte("v"), nil); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	main := opt.KeyspaceMain
+	if s.JournalSyncRecords[main] != writers*n {
+		t.Fatalf("JournalSyncRecords: got %d, want every write synced (%d)", s.JournalSyncRecords[main], writers*n)
+	}
+	if s.JournalSyncs[main] == 0 || s.JournalSyncs[main] >= writers*n {
+		t.Fatalf("JournalSyncs: got %d for %d writes, want them grouped", s.JournalSyncs[main], writers*n)
+	}
+	if s.JournalSyncMaxTime[main] <= 0 || s.JournalSyncTime[main] < s.JournalSyncMaxTime[main] {
+		t.Fatalf("JournalSyncTime: got %v, max %v", s.JournalSyncTime[main], s.JournalSyncMaxTime[main])
+	}
+	// 状态库还是默认策略，不带 Sync 的写不会 fsync。
+	if s.JournalSyncs[opt.KeyspaceState] != 0 {
+		t.Fatalf("state JournalSyncs: got %d, want 0", s.JournalSyncs[opt.KeyspaceState])
+	}
+	t.Logf("%d writes in %d fsyncs", s.JournalSyncRecords[main], s.JournalSyncs[main])
+
+	value, err := h.db.GetProperty("leveldb.journalsync")
+	if err != nil {
+		t.Fatal("GetProperty: got error: ", err)
+	}
+	if !strings.Contains(value, "Policy:group") || !strings.Contains(value, "Policy:on-request") {
+		t.Fatalf("GetProperty: got %q", value)
+	}
+}
+
+func TestDB_JournalSyncBackground(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		JournalSync2:                 opt.SyncBackground,
+		JournalSyncInterval:          time.Hour,
+		JournalSyncBytes:             4 << 10,
+	})
+	defer h.close()
+
+	stats := func() (s DBStats) {
+		if err := h.db.Stats(&s); err != nil {
+			t.Fatal("Stats: got error: ", err)
+		}
+		return
+	}
+	state := opt.KeyspaceState
+	value := bytes.Repeat([]byte("v"), 100)
+	put := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := h.db.Put_s([]byte(fmt.Sprintf("key%04d", i)), value, nil); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+	}
+
+	// 没到 JournalSyncBytes，也没到间隔，不 sync。
+	put(0, 10)
+	time.Sleep(50 * time.Millisecond)
+	if s := stats(); s.JournalSyncs[state] != 0 {
+		t.Fatalf("JournalSyncs before the threshold: got %d", s.JournalSyncs[state])
+	}
+
+	// 超过 JournalSyncBytes 之后后台 sync 一次。
+	put(10, 60)
+	for deadline := time.Now().Add(5 * time.Second); stats().JournalSyncs[state] == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no background sync after JournalSyncBytes")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Sync 写照样同步。
+	if err := h.db.Put_s([]byte("key-sync"), value, &opt.WriteOptions{Sync: true}); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	s := stats()
+	if s.JournalSyncRecords[state] != 61 || s.JournalSyncs[opt.KeyspaceMain] != 0 {
+		t.Fatalf("after sync write: got %d records in %d syncs, main %d syncs",
+			s.JournalSyncRecords[state], s.JournalSyncs[state], s.JournalSyncs[opt.KeyspaceMain])
+	}
+}
+
+func TestDB_JournalSyncBackgroundRotate(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		JournalSync:                  opt.SyncBackground,
+		JournalSyncInterval:          time.Hour,
+		JournalSyncBytes:             1 << 20,
+	})
+	defer h.close()
+
+	for i := 0; i < 10; i++ {
+		h.put(fmt.Sprintf("key%04d", i), "v")
+	}
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.JournalSyncs[opt.KeyspaceMain] != 0 {
+		t.Fatalf("JournalSyncs before rotation: got %d", s.JournalSyncs[opt.KeyspaceMain])
+	}
+
+	// 轮转的时候旧日志里没 sync 的记录要 sync 掉，不能算到新日志上。
+	h.compactMem()
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if s.JournalSyncs[opt.KeyspaceMain] != 1 || s.JournalSyncRecords[opt.KeyspaceMain] != 10 {
+		t.Fatalf("after rotation: got %d records in %d syncs", s.JournalSyncRecords[opt.KeyspaceMain], s.JournalSyncs[opt.KeyspaceMain])
+	}
+	if n := atomic.LoadInt64(&h.db.jsync[opt.KeyspaceMain].pendRecords); n != 0 {
+		t.Fatalf("pending records after rotation: got %d", n)
+	}
+}
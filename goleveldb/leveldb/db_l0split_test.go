@@ -0,0 +1,159 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+func TestMaxOverlap(t *testing.T) {
+	icmp := &iComparer{comparer.DefaultComparer}
+	r := func(a, b string) [2][]byte { return [2][]byte{[]byte(a), []byte(b)} }
+	for i, c := range []struct {
+		ranges [][2][]byte
+		want   int
+	}{
+		{nil, 0},
+		{[][2][]byte{r("a", "c"), r("d", "f"), r("g", "z")}, 1},
+		// 闭区间，端点相同也算重叠。
+		{[][2][]byte{r("a", "c"), r("c", "f")}, 2},
+		{[][2][]byte{r("a", "z"), r("b", "c"), r("d", "e"), r("d", "d")}, 3},
+	} {
+		if got := maxOverlap(icmp, c.ranges); got != c.want {
+			t.Errorf("#%d: got %d, want %d", i, got, c.want)
+		}
+	}
+
+	b := newKeyBounds(icmp, [][]byte{[]byte("m"), []byte("f")})
+	var crossed []string
+	for _, k := range []string{"a", "b", "f", "g", "z"} {
+		if b.cross(icmp, []byte(k)) {
+			crossed = append(crossed, k)
+		}
+	}
+	if fmt.Sprint(crossed) != "[f z]" {
+		t.Fatalf("keyBounds: crossed at %v, want [f z]", crossed)
+	}
+	if newKeyBounds(icmp, nil).cross(icmp, []byte("a")) {
+		t.Fatal("nil keyBounds: crossed")
+	}
+}
+
+func TestDB_FlushGuards(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		FlushGuards:                  [][]byte{[]byte("key050")},
+		FlushGuards2:                 [][]byte{[]byte("key030"), []byte("key060")},
+	})
+	defer h.close()
+	h.db.memdbMaxLevel = 0
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		h.put(key, "v"+key)
+		if err := h.db.Put_s([]byte(key), []byte("s"+key), nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.db.writeLockC <- struct{}{}
+	if _, err := h.db.rotateMem(0, true); err != nil {
+		t.Fatal("rotateMem: got error: ", err)
+	}
+	if _, err := h.db.rotateMem_s(0, true); err != nil {
+		t.Fatal("rotateMem_s: got error: ", err)
+	}
+	<-h.db.writeLockC
+
+	check := func(name string, ranges [][2]string, guards ...string) {
+		t.Helper()
+		if len(ranges) != len(guards)+1 {
+			t.Fatalf("%s: got %d level-0 tables, want %d", name, len(ranges), len(guards)+1)
+		}
+		for _, r := range ranges {
+			for _, g := range guards {
+				if r[0] < g && r[1] >= g {
+					t.Fatalf("%s: table %q..%q spans guard %q", name, r[0], r[1], g)
+				}
+			}
+		}
+	}
+	v := h.db.s.version()
+	var ranges, ranges2 [][2]string
+	for _, tf := range v.levels[0] {
+		ranges = append(ranges, [2]string{string(tf.imin.ukey()), string(tf.imax.ukey())})
+	}
+	for _, tf := range v.level_s[0] {
+		ranges2 = append(ranges2, [2]string{string(tf.imin.ukey()), string(tf.imax.ukey())})
+	}
+	check("main", ranges, "key050")
+	check("state", ranges2, "key030", "key060")
+	if n, n2 := v.l0Runs(), v.l0Runs_s(); n != 1 || n2 != 1 {
+		t.Fatalf("l0Runs: got %d/%d, want 1/1", n, n2)
+	}
+	v.release()
+
+	h.reopenDB()
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		h.getVal(key, "v"+key)
+		if v, err := h.db.Get_s([]byte(key), nil); err != nil || string(v) != "s"+key {
+			t.Fatalf("Get_s %s: got %q, %v", key, v, err)
+		}
+	}
+}
+
+func TestDB_IntraL0Compaction(t *testing.T) {
+	var intraL0 int32
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		IntraL0Ratio:                 2,
+		EventListener: &opt.EventListener{
+			CompactionEnd: func(info opt.CompactionInfo) {
+				if info.IntraL0 {
+					atomic.AddInt32(&intraL0, 1)
+				}
+			},
+		},
+	})
+	defer h.close()
+	h.db.memdbMaxLevel = 0
+
+	// 先在 L1 放一大块数据。
+	for i := 0; i < 2000; i++ {
+		h.put(fmt.Sprintf("key%04d", i), fmt.Sprintf("old%04d", i))
+	}
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+
+	// 再用几个小的 L0 文件触发 L0 compaction，它们比 L1 小得多。
+	for round := 0; round < opt.DefaultCompactionL0Trigger; round++ {
+		for i := round; i < 2000; i += 100 {
+			h.put(fmt.Sprintf("key%04d", i), fmt.Sprintf("new%04d", i))
+		}
+		h.compactMem()
+	}
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&intraL0) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("no intra-L0 compaction, tables per level %s", h.getTablesPerLevel())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 2000; i++ {
+		want := "old"
+		if i%100 < opt.DefaultCompactionL0Trigger {
+			want = "new"
+		}
+		h.getVal(fmt.Sprintf("key%04d", i), fmt.Sprintf("%s%04d", want, i))
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/memdb"
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+func TestDB_ConcurrentMemTable(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MemTable:                     opt.ConcurrentMemTable,
+		MemTable2:                    opt.ConcurrentMemTable,
+		WriteBuffer:                  32 * 1024,
+		WriteBuffer2:                 32 * 1024,
+	})
+	defer h.close()
+
+	if !h.db.mem.DB.Concurrent() || !h.db.mems.Table_s.Concurrent_s() {
+		t.Fatal("memdb: want concurrent memdb for both keyspaces")
+	}
+
+	const writers, n = 8, 300
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				key := []byte(fmt.Sprintf("key%02d%04d", w, i))
+				if err := h.db.Put(key, key, nil); err != nil {
+					t.Error("Put: got error: ", err)
+					return
+				}
+				if err := h.db.Put_s(key, key, nil); err != nil {
+					t.Error("Put_s: got error: ", err)
+					return
+				}
+				// 写入组交出写锁之后才插入 memdb，返回时必须已经可读。
+				if _, err := h.db.Get(key, nil); err != nil {
+					t.Errorf("Get %s: got error %v", key, err)
+					return
+				}
+				if _, err := h.db.Get_s(key, nil); err != nil {
+					t.Errorf("Get_s %s: got error %v", key, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	check := func() {
+		t.Helper()
+		for w := 0; w < writers; w++ {
+			for i := 0; i < n; i++ {
+				key := fmt.Sprintf("key%02d%04d", w, i)
+				if v, err := h.db.Get([]byte(key), nil); err != nil || string(v) != key {
+					t.Fatalf("Get %s: got %q, %v", key, v, err)
+				}
+				if v, err := h.db.Get_s([]byte(key), nil); err != nil || string(v) != key {
+					t.Fatalf("Get_s %s: got %q, %v", key, v, err)
+				}
+			}
+		}
+		iter := h.db.NewIterator(nil, nil)
+		defer iter.Release()
+		count := 0
+		for iter.Next() {
+			count++
+		}
+		if count != writers*n {
+			t.Fatalf("iterator: got %d entries, want %d", count, writers*n)
+		}
+	}
+	check()
+	if got := h.db.getSeq(); got != 2*writers*n {
+		t.Fatalf("seq: got %d, want %d", got, 2*writers*n)
+	}
+	h.reopenDB()
+	check()
+}
+
+// TestDB_ConcurrentMemTableAck checks that a write merged into a write group
+// returns only once its own group has synced and inserted it, even while an
+// earlier pipelined group is still acking its merged writes.
+func TestDB_ConcurrentMemTableAck(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MemTable:                     opt.ConcurrentMemTable,
+		MemTable2:                    opt.ConcurrentMemTable,
+		WriteBuffer:                  64 * 1024,
+		WriteBuffer2:                 64 * 1024,
+		// 合并之后、写日志之前停一下，前一组的回复有机会被收走。
+		JournalGroupCommitDelay: time.Millisecond,
+	})
+	defer h.close()
+
+	const writers, n = 16, 200
+	wo := &opt.WriteOptions{Sync: true}
+	// 两个 keyspace 共用 writeMergeC，分开压测。
+	for _, ks := range []opt.Keyspace{opt.KeyspaceMain, opt.KeyspaceState} {
+		put, get := h.db.Put, h.db.Get
+		if ks == opt.KeyspaceState {
+			put, get = h.db.Put_s, h.db.Get_s
+		}
+		var returned int64
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < n; i++ {
+					key := []byte(fmt.Sprintf("key%02d%04d", w, i))
+					if err := put(key, key, wo); err != nil {
+						t.Errorf("%s: Put %s: got error %v", ks, key, err)
+						return
+					}
+					// 返回过的同步写入都应该已经 sync 过了。
+					done := atomic.AddInt64(&returned, 1)
+					if synced := atomic.LoadInt64(&h.db.jsync[ks].records); synced < done {
+						t.Errorf("%s: Put %s: returned before sync, %d records synced, %d writes returned", ks, key, synced, done)
+						return
+					}
+					if v, err := get(key, nil); err != nil || string(v) != string(key) {
+						t.Errorf("%s: Get %s: got %q, %v", ks, key, v, err)
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		if t.Failed() {
+			return
+		}
+	}
+}
+
+func TestDB_MemWritersOrder(t *testing.T) {
+	db := &DB{memw: newMemWriters()}
+	w := db.memw
+	seq1 := w.next(db.getSeq())
+	w.reserve(seq1, 2)
+	seq2 := w.next(db.getSeq())
+	w.reserve(seq2, 1)
+	if seq1 != 1 || seq2 != 3 {
+		t.Fatalf("next: got %d and %d, want 1 and 3", seq1, seq2)
+	}
+
+	// 后一组先插完也要等前一组发布。
+	published := make(chan struct{})
+	go func() {
+		w.publish(db, seq2, 1)
+		w.done()
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("publish: second group published before the first")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got := db.getSeq(); got != 0 {
+		t.Fatalf("seq: got %d, want 0", got)
+	}
+	w.publish(db, seq1, 2)
+	w.done()
+	<-published
+	w.wait()
+	if got := db.getSeq(); got != 3 {
+		t.Fatalf("seq: got %d, want 3", got)
+	}
+	if got := w.next(db.getSeq()); got != 4 {
+		t.Fatalf("next: got %d, want 4", got)
+	}
+}
+
+func TestDB_HashMemTable(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MemTable2:                    opt.HashMemTable,
+		WriteBuffer2:                 16 * 1024,
+	})
+	defer h.close()
+
+	if _, ok := h.db.mems.Table_s.(*memdb.HashDBs); !ok {
+		t.Fatalf("memdb: got %T, want %T", h.db.mems.Table_s, (*memdb.HashDBs)(nil))
+	}
+
+	const n = 1000
+	put := func(round int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key%04d", i)
+			if err := h.db.Put_s([]byte(key), []byte(fmt.Sprintf("%s-%d", key, round)), nil); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+	}
+	check := func(round int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key%04d", i)
+			want := fmt.Sprintf("%s-%d", key, round)
+			if v, err := h.db.Get_s([]byte(key), nil); err != nil || string(v) != want {
+				t.Fatalf("Get_s %s: got %q, %v, want %q", key, v, err, want)
+			}
+		}
+		if _, err := h.db.Get_s([]byte("missing"), nil); err != ErrNotFound {
+			t.Fatalf("Get_s missing: got %v, want %v", err, ErrNotFound)
+		}
+	}
+
+	// 写满几次 memdb，每一轮都覆盖上一轮的值。
+	put(0)
+	put(1)
+	check(1)
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem_s(0, true)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+	check(1)
+	put(2)
+	check(2)
+	h.reopenDB()
+	check(2)
+}
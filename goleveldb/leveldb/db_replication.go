@@ -0,0 +1,616 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/journal"
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+// The replication stream is a sequence of frames:
+//
+//	type (1 byte) | num (uvarint) | length (uvarint) | payload | crc (4 bytes)
+//
+// A batch frame carries a journal record, header included. A checkpoint is
+// a checkpoint frame, the table files and a manifest frame with the table
+// layout; batch frames following it continue after its sequence number.
+const (
+	replBatch      = 1 // num unused
+	replBatch_s    = 2 // num unused
+	replCheckpoint = 3 // num is the sequence number of the checkpoint
+	replTable      = 4 // num is the file number
+	replManifest   = 5 // num unused
+	replTable_s    = 6 // num is the file number of a state table
+)
+
+// replMaxRecord bounds the frames read into memory; table files are
+// streamed to storage instead.
+const replMaxRecord = 1 << 30
+
+func newErrReplCorrupted(reason string) error {
+	return errors.NewErrCorrupted(storage.FileDesc{}, errors.New("leveldb/replication: "+reason))
+}
+
+type replWriter struct {
+	w   *bufio.Writer
+	crc util.CRC
+	buf [1 + 2*binary.MaxVarintLen64]byte
+}
+
+func newReplWriter(w io.Writer) *replWriter {
+	return &replWriter{w: bufio.NewWriter(w)}
+}
+
+func (rw *replWriter) Write(p []byte) (int, error) {
+	rw.crc = rw.crc.Update(p)
+	return rw.w.Write(p)
+}
+
+func (rw *replWriter) begin(typ byte, num uint64, n int64) error {
+	rw.buf[0] = typ
+	i := 1 + binary.PutUvarint(rw.buf[1:], num)
+	i += binary.PutUvarint(rw.buf[i:], uint64(n))
+	rw.crc = 0
+	_, err := rw.w.Write(rw.buf[:i])
+	return err
+}
+
+func (rw *replWriter) end() error {
+	binary.LittleEndian.PutUint32(rw.buf[:4], rw.crc.Value())
+	_, err := rw.w.Write(rw.buf[:4])
+	return err
+}
+
+func (rw *replWriter) frame(typ byte, num uint64, data []byte) error {
+	if err := rw.begin(typ, num, int64(len(data))); err != nil {
+		return err
+	}
+	if _, err := rw.Write(data); err != nil {
+		return err
+	}
+	return rw.end()
+}
+
+type replReader struct {
+	r   *bufio.Reader
+	crc util.CRC
+}
+
+func newReplReader(r io.Reader) *replReader {
+	return &replReader{r: bufio.NewReader(r)}
+}
+
+// next reads the header of the next frame; it returns io.EOF if the stream
+// ends between frames.
+func (rr *replReader) next() (typ byte, num, n uint64, err error) {
+	if typ, err = rr.r.ReadByte(); err != nil {
+		return
+	}
+	if num, err = binary.ReadUvarint(rr.r); err == nil {
+		n, err = binary.ReadUvarint(rr.r)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// body copies the n bytes of payload to w and checks them.
+func (rr *replReader) body(n uint64, w io.Writer) error {
+	rr.crc = 0
+	if _, err := io.CopyN(w, io.TeeReader(rr.r, rr), int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(rr.r, sum[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != rr.crc.Value() {
+		return newErrReplCorrupted("checksum mismatch")
+	}
+	return nil
+}
+
+func (rr *replReader) Write(p []byte) (int, error) {
+	rr.crc = rr.crc.Update(p)
+	return len(p), nil
+}
+
+func (rr *replReader) record(n uint64) ([]byte, error) {
+	if n > replMaxRecord {
+		return nil, newErrReplCorrupted("record too large")
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, n))
+	if err := rr.body(n, buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeChangeBatch turns cb back into a journal record.
+func encodeChangeBatch(cb *ChangeBatch) []byte {
+	b := new(Batch)
+	for _, c := range cb.Changes {
+		switch c.Type {
+		case ChangePut:
+			b.Put(c.Key, c.Value)
+		case ChangeDelete:
+			b.Delete(c.Key)
+		case ChangeMerge:
+			b.Merge(c.Key, c.Value)
+		}
+	}
+	return append(encodeBatchHeader(nil, cb.Seq, b.Len()), b.data...)
+}
+
+// Ship streams the records of both keyspaces committed with a sequence
+// number of at least seq to w, for a follower to apply, see Follower.
+// Records are read from the journals kept on disk, see
+// opt.Options.JournalRetention, and then as they are committed. If they
+// aren't all available, or seq is ahead of the DB, Ship sends a checkpoint
+// of the DB first, which replaces the content of the follower.
+//
+// Ship returns when writing to w fails, the DB is closed, or the follower
+// falls behind by more than opt.Options.SubscriptionBuffer.
+func (db *DB) Ship(w io.Writer, seq uint64) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+
+	rw := newReplWriter(w)
+	var sub *Subscription
+	if seq <= db.getSeq()+1 {
+		var err error
+		if sub, err = db.Subscribe(seq); err == ErrChangesUnavailable {
+			db.logf("replication@ship changes unavailable from seq %d, sending checkpoint", seq)
+			sub = nil
+		} else if err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if sub != nil {
+			sub.Close()
+		}
+	}()
+	for {
+		if sub == nil {
+			var err error
+			if sub, err = db.shipCheckpoint(rw); err != nil {
+				return err
+			}
+		}
+		cb, err := sub.Next()
+		if err == ErrChangesUnavailable {
+			db.logf("replication@ship changes unavailable from seq %d, sending checkpoint", seq)
+			sub.Close()
+			sub = nil
+			continue
+		} else if err != nil {
+			return err
+		}
+		typ := byte(replBatch)
+		if cb.Keyspace == opt.KeyspaceState {
+			typ = replBatch_s
+		}
+		if err := rw.frame(typ, 0, encodeChangeBatch(cb)); err != nil {
+			return err
+		}
+		if err := rw.w.Flush(); err != nil {
+			return err
+		}
+		seq = cb.Seq + uint64(len(cb.Changes))
+	}
+}
+
+// ServeFollower reads the handshake of a follower from rw, see
+// Follower.Follow, then ships to it, see Ship.
+func (db *DB) ServeFollower(rw io.ReadWriter) error {
+	var seq uint64
+	if err := binary.Read(rw, binary.LittleEndian, &seq); err != nil {
+		return err
+	}
+	return db.Ship(rw, seq)
+}
+
+// shipCheckpoint writes a checkpoint of the DB to rw and returns a
+// subscription to the records committed after it.
+func (db *DB) shipCheckpoint(rw *replWriter) (*Subscription, error) {
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return nil, err
+	case <-db.closeC:
+		return nil, ErrClosed
+	}
+
+	// 两个 memdb 都刷成表，checkpoint 只需要表文件。
+	db.memw.wait()
+	var err error
+	mem := db.getEffectiveMem()
+	n := mem.Len()
+	mem.decref()
+	if n > 0 {
+		_, err = db.rotateMem(0, true)
+	} else {
+		err = db.compTriggerWait(db.mcompCmdC)
+	}
+	if err == nil {
+		mems := db.getEffectiveMem_s()
+		n = mems.Len_s()
+		mems.decref_s()
+		if n > 0 {
+			_, err = db.rotateMem_s(0, true)
+		} else {
+			err = db.compTriggerWait(db.mcompCmdCs)
+		}
+	}
+	if err != nil {
+		<-db.writeLockC
+		return nil, err
+	}
+	seq := db.seq
+	rec := &sessionRecord{}
+	rec.setComparer(db.s.icmp.uName())
+	// 两个 keyspace 的 journal 在恢复时都是整个重放的，从库清空之后没有
+	// journal，journal number 给 0 就够了。
+	rec.setJournalNum(0)
+	rec.setNextFileNum(db.s.nextFileNum())
+	rec.setSeqNum(seq)
+	// 版本和 compaction pointer 都由 compaction commit 修改。
+	db.compCommitLk.Lock()
+	v := db.s.version()
+	for level, ik := range db.s.stCompPtrs {
+		if ik != nil {
+			rec.addCompPtr(level, ik)
+		}
+	}
+	for level, ik := range db.s.stCompPtrs2 {
+		if ik != nil {
+			rec.addCompPtr_s(level, ik)
+		}
+	}
+	db.compCommitLk.Unlock()
+	defer v.release()
+	v.fillRecord(rec)
+	v.fillRecord_s(rec)
+	sub, err := db.subscribeLocked(seq + 1)
+	<-db.writeLockC
+	if err != nil {
+		return nil, err
+	}
+
+	err = rw.frame(replCheckpoint, seq, nil)
+	for _, t := range rec.addedTables {
+		if err != nil {
+			break
+		}
+		err = db.shipTable(rw, replTable, t.num, t.size)
+	}
+	for _, t := range rec.addedTabless {
+		if err != nil {
+			break
+		}
+		err = db.shipTable(rw, replTable_s, t.num, t.size)
+	}
+	if err == nil {
+		var buf bytes.Buffer
+		if err = rec.encode(&buf); err == nil {
+			err = rw.frame(replManifest, 0, buf.Bytes())
+		}
+	}
+	if err == nil {
+		err = rw.w.Flush()
+	}
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+	db.logf("replication@checkpoint shipped seq %d, %d tables", seq, len(rec.addedTables)+len(rec.addedTabless))
+	return sub, nil
+}
+
+func (db *DB) shipTable(rw *replWriter, typ byte, num, size int64) error {
+	fd := storage.FileDesc{Type: storage.TypeTable, Num: num}
+	r, err := db.s.stor.Open(fd)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := rw.begin(typ, uint64(num), size); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(rw, r, size); err != nil {
+		if err == io.EOF {
+			err = errors.NewErrCorrupted(fd, errors.New("leveldb/replication: table shorter than recorded"))
+		}
+		return err
+	}
+	return rw.end()
+}
+
+// Follower is a DB that applies the records shipped by a primary DB, see
+// DB.Ship, with the same sequence numbers. The DB must not be written
+// otherwise.
+type Follower struct {
+	stor storage.Storage
+	o    *opt.Options
+
+	mu sync.RWMutex
+	db *DB
+}
+
+// OpenFollower opens or creates the DB in stor as a follower.
+//
+// The follower must be closed after use, by calling Close method.
+func OpenFollower(stor storage.Storage, o *opt.Options) (*Follower, error) {
+	db, err := Open(stor, o)
+	if err != nil {
+		return nil, err
+	}
+	return &Follower{stor: stor, o: o, db: db}, nil
+}
+
+// DB returns the DB of the follower. Applying a checkpoint closes the DB
+// and opens a new one, after which the returned DB fails with ErrClosed;
+// call DB again for every use, or use View to keep the DB open while
+// reading it.
+func (f *Follower) DB() *DB {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.db
+}
+
+// View calls fn with the DB of the follower. A checkpoint isn't applied
+// until fn returns, so the DB stays open in the meantime; fn must not call
+// Apply or Follow.
+func (f *Follower) View(fn func(db *DB) error) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.db == nil {
+		return ErrClosed
+	}
+	return fn(f.db)
+}
+
+// Seq returns the sequence number of the last record applied.
+func (f *Follower) Seq() uint64 {
+	if db := f.DB(); db != nil {
+		return db.getSeq()
+	}
+	return 0
+}
+
+// Follow sends the handshake to the primary over rw, see
+// DB.ServeFollower, then applies the stream it gets back, see Apply.
+func (f *Follower) Follow(rw io.ReadWriter) error {
+	if err := binary.Write(rw, binary.LittleEndian, f.Seq()+1); err != nil {
+		return err
+	}
+	return f.Apply(rw)
+}
+
+// Apply applies the replication stream read from r until it ends. A
+// stream starting after the last record applied returns
+// ErrReplicationGap, records already applied are skipped.
+//
+// Records are written without syncing; after a crash the follower resumes
+// from what was kept.
+func (f *Follower) Apply(r io.Reader) error {
+	rr := newReplReader(r)
+	for {
+		typ, num, n, err := rr.next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch typ {
+		case replBatch, replBatch_s:
+			data, err := rr.record(n)
+			if err != nil {
+				return err
+			}
+			ks := opt.KeyspaceMain
+			if typ == replBatch_s {
+				ks = opt.KeyspaceState
+			}
+			db := f.DB()
+			if db == nil {
+				return ErrClosed
+			}
+			if err := db.applyReplicated(ks, data); err != nil {
+				return err
+			}
+		case replCheckpoint:
+			if err := rr.body(n, io.Discard); err != nil {
+				return err
+			}
+			if err := f.bootstrap(rr, num); err != nil {
+				return err
+			}
+		default:
+			return newErrReplCorrupted("unexpected frame")
+		}
+	}
+}
+
+// applyReplicated writes the journal record data at its own sequence
+// number.
+func (db *DB) applyReplicated(ks opt.Keyspace, data []byte) error {
+	seq, n, err := decodeBatchHeader(data)
+	if err != nil {
+		return err
+	}
+	batch := new(Batch)
+	if err := batch.decode(data[batchHeaderLen:], n); err != nil {
+		return err
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	db.memw.wait()
+	switch last := db.seq; {
+	case seq+uint64(n) <= last+1:
+		// 重连之后重发的记录。
+		<-db.writeLockC
+		return nil
+	case seq != last+1:
+		<-db.writeLockC
+		return ErrReplicationGap
+	}
+	if ks == opt.KeyspaceState {
+		return db.writeLocked_s(batch, nil, false, false, false)
+	}
+	return db.writeLocked(batch, nil, false, false, false)
+}
+
+// bootstrap replaces the DB with the checkpoint at seq read from rr.
+func (f *Follower) bootstrap(rr *replReader, seq uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.db != nil {
+		if err := f.db.Close(); err != nil {
+			return err
+		}
+		f.db = nil
+	}
+	fds, err := f.stor.List(storage.TypeAll)
+	if err != nil {
+		return err
+	}
+	for _, fd := range fds {
+		if err := f.stor.Remove(fd); err != nil {
+			return err
+		}
+	}
+
+	for {
+		typ, num, n, err := rr.next()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		switch typ {
+		case replTable, replTable_s:
+			if err := f.receiveTable(rr, int64(num), n, typ == replTable_s); err != nil {
+				return err
+			}
+		case replManifest:
+			data, err := rr.record(n)
+			if err != nil {
+				return err
+			}
+			if err := f.createManifest(data); err != nil {
+				return err
+			}
+			db, err := Open(f.stor, f.o)
+			if err != nil {
+				return err
+			}
+			f.db = db
+			if db.getSeq() != seq {
+				return newErrReplCorrupted("checkpoint sequence number mismatch")
+			}
+			db.logf("replication@checkpoint applied seq %d", seq)
+			return nil
+		default:
+			return newErrReplCorrupted("unexpected frame in checkpoint")
+		}
+	}
+}
+
+func (f *Follower) receiveTable(rr *replReader, num int64, n uint64, state bool) error {
+	fd := storage.FileDesc{Type: storage.TypeTable, Num: num}
+	create := f.stor.Create
+	if state {
+		create = f.stor.Create_s
+	}
+	w, err := create(fd)
+	if err != nil {
+		return err
+	}
+	if err := rr.body(n, w); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// createManifest writes the table layout of a checkpoint as the manifest.
+func (f *Follower) createManifest(data []byte) error {
+	rec := &sessionRecord{}
+	if err := rec.decode(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	fd := storage.FileDesc{Type: storage.TypeManifest, Num: rec.nextFileNum}
+	rec.setNextFileNum(fd.Num + 1)
+
+	writer, err := f.stor.Create(fd)
+	if err != nil {
+		return err
+	}
+	jw := journal.NewWriter(writer)
+	w, err := jw.Next()
+	if err == nil {
+		err = rec.encode(w)
+	}
+	if err == nil {
+		err = jw.Close()
+	}
+	if err == nil {
+		err = writer.Sync()
+	}
+	if cerr := writer.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return f.stor.SetMeta(fd)
+}
+
+// Close closes the DB of the follower.
+func (f *Follower) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.db == nil {
+		return ErrClosed
+	}
+	err := f.db.Close()
+	f.db = nil
+	return err
+}
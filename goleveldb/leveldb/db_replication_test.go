@@ -0,0 +1,222 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+type replTest struct {
+	t        *testing.T
+	primary  *DB
+	follower *Follower
+	stor     storage.Storage
+	shipC    chan error
+	followC  chan error
+}
+
+func newReplTest(t *testing.T, o *opt.Options) *replTest {
+	primary, err := OpenFile(t.TempDir(), o)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	stor, err := storage.OpenFile(t.TempDir(), false)
+	if err != nil {
+		t.Fatal("storage.OpenFile: got error: ", err)
+	}
+	follower, err := OpenFollower(stor, &opt.Options{DisableLargeBatchTransaction: true})
+	if err != nil {
+		t.Fatal("OpenFollower: got error: ", err)
+	}
+	return &replTest{t: t, primary: primary, follower: follower, stor: stor}
+}
+
+func (r *replTest) put(prefix string, n int) {
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("%s%03d", prefix, i))
+		if err := r.primary.Put(key, []byte(prefix), nil); err != nil {
+			r.t.Fatal("Put: got error: ", err)
+		}
+		if err := r.primary.Put_s(key, []byte(prefix+"_s"), nil); err != nil {
+			r.t.Fatal("Put_s: got error: ", err)
+		}
+	}
+}
+
+func (r *replTest) connect() {
+	c1, c2 := net.Pipe()
+	r.shipC, r.followC = make(chan error, 1), make(chan error, 1)
+	go func() {
+		r.shipC <- r.primary.ServeFollower(c1)
+		c1.Close()
+	}()
+	go func() {
+		r.followC <- r.follower.Follow(c2)
+		c2.Close()
+	}()
+}
+
+// wait waits until the follower has caught up with the primary.
+func (r *replTest) wait() {
+	r.t.Helper()
+	want := r.primary.getSeq()
+	for deadline := time.Now().Add(10 * time.Second); r.follower.Seq() != want; {
+		select {
+		case err := <-r.followC:
+			r.t.Fatalf("Follow: got error %v at seq %d, want seq %d", err, r.follower.Seq(), want)
+		default:
+		}
+		if time.Now().After(deadline) {
+			r.t.Fatalf("follower at seq %d, want %d", r.follower.Seq(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (r *replTest) get(key, want string) {
+	r.t.Helper()
+	db := r.follower.DB()
+	if v, err := db.Get([]byte(key), nil); want == "" && err != ErrNotFound || want != "" && (err != nil || string(v) != want) {
+		r.t.Fatalf("Get %s: got %q, %v, want %q", key, v, err, want)
+	}
+	if want != "" {
+		want += "_s"
+	}
+	if v, err := db.Get_s([]byte(key), nil); want == "" && err != ErrNotFound || want != "" && (err != nil || string(v) != want) {
+		r.t.Fatalf("Get_s %s: got %q, %v, want %q", key, v, err, want)
+	}
+}
+
+func (r *replTest) close() {
+	r.primary.Close()
+	if err := <-r.shipC; err != ErrClosed {
+		r.t.Errorf("ServeFollower: got %v, want %v", err, ErrClosed)
+	}
+	<-r.followC
+	r.follower.Close()
+	r.stor.Close()
+}
+
+func TestDB_Replication(t *testing.T) {
+	r := newReplTest(t, &opt.Options{DisableLargeBatchTransaction: true, JournalRetention: 4})
+	r.put("a", 10)
+	r.connect()
+	r.wait()
+	r.get("a005", "a")
+
+	r.put("b", 10)
+	if err := r.primary.Delete([]byte("a003"), nil); err != nil {
+		t.Fatal("Delete: got error: ", err)
+	}
+	b := new(Batch)
+	b.Put([]byte("c000"), []byte("c"))
+	b.Put([]byte("c001"), []byte("c"))
+	if err := r.primary.Write(b, nil); err != nil {
+		t.Fatal("Write: got error: ", err)
+	}
+	r.wait()
+	r.get("b009", "b")
+	if _, err := r.follower.DB().Get([]byte("a003"), nil); err != ErrNotFound {
+		t.Fatalf("Get a003: got %v, want %v", err, ErrNotFound)
+	}
+	if v, err := r.follower.DB().Get([]byte("c001"), nil); err != nil || string(v) != "c" {
+		t.Fatalf("Get c001: got %q, %v", v, err)
+	}
+	r.close()
+}
+
+func TestDB_ReplicationCheckpoint(t *testing.T) {
+	r := newReplTest(t, &opt.Options{DisableLargeBatchTransaction: true})
+	// 落后的从库里的旧数据会被 checkpoint 替换掉。
+	if err := r.follower.DB().Put([]byte("stale0"), []byte("x"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	r.put("a", 50)
+	if err := r.primary.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	r.primary.writeLockC <- struct{}{}
+	_, err := r.primary.rotateMem_s(0, true)
+	<-r.primary.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+	r.put("b", 10)
+
+	r.connect()
+	r.wait()
+	r.get("a025", "a")
+	r.get("b005", "b")
+	r.get("stale0", "")
+
+	r.put("c", 10)
+	r.wait()
+	r.get("c005", "c")
+	r.close()
+}
+
+func TestDB_ReplicationCheckpointStateDir(t *testing.T) {
+	primary, err := OpenFile(t.TempDir(), &opt.Options{DisableLargeBatchTransaction: true})
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	stateDir := filepath.Join(t.TempDir(), "state")
+	stor, err := storage.OpenFileDirs(t.TempDir(), storage.FileDirs{StateTable: stateDir}, false)
+	if err != nil {
+		t.Fatal("storage.OpenFileDirs: got error: ", err)
+	}
+	follower, err := OpenFollower(stor, &opt.Options{DisableLargeBatchTransaction: true})
+	if err != nil {
+		t.Fatal("OpenFollower: got error: ", err)
+	}
+	r := &replTest{t: t, primary: primary, follower: follower, stor: stor}
+	r.put("a", 50)
+	if err := r.primary.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	r.primary.writeLockC <- struct{}{}
+	_, err = r.primary.rotateMem_s(0, true)
+	<-r.primary.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+
+	r.connect()
+	r.wait()
+	// 状态表要通过 Create_s 建在状态表的目录里。
+	err = r.follower.View(func(db *DB) error {
+		v := db.s.version()
+		defer v.release()
+		n := 0
+		for _, tables := range v.level_s {
+			for _, t := range tables {
+				if _, err := os.Stat(filepath.Join(stateDir, fmt.Sprintf("%06d.ldb", t.fd.Num))); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		if n == 0 {
+			return fmt.Errorf("no state table shipped")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("View: got error: ", err)
+	}
+	r.get("a025", "a")
+	r.close()
+}
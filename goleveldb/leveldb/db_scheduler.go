@@ -0,0 +1,188 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sync"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+// compScheduler decides which of the two table compaction loops (tCompaction
+// and tCompaction_s) may run a compaction. Each loop runs one compaction at
+// a time, so budget is 1 or 2, see opt.Options.MaxBackgroundCompactions.
+// When the loops compete for the only slot the keyspace stalling writes goes
+// first, then the one with the higher compaction score.
+type compScheduler struct {
+	mu       sync.Mutex
+	budget   int
+	paused   bool
+	running  [2]bool
+	pending  [2]bool
+	changeC  chan struct{} // closed and replaced whenever the state changes
+	priority func(ks opt.Keyspace) (score float64, stall bool)
+}
+
+func newCompScheduler(budget int, priority func(ks opt.Keyspace) (float64, bool)) *compScheduler {
+	return &compScheduler{
+		budget:   budget,
+		changeC:  make(chan struct{}),
+		priority: priority,
+	}
+}
+
+// Must be called with mu held.
+func (s *compScheduler) notify() {
+	close(s.changeC)
+	s.changeC = make(chan struct{})
+}
+
+// Must be called with mu held.
+func (s *compScheduler) nrunning() (n int) {
+	for _, r := range s.running {
+		if r {
+			n++
+		}
+	}
+	return
+}
+
+// before reports whether keyspace a should be given a slot before b.
+func (s *compScheduler) before(a, b opt.Keyspace) bool {
+	as, astall := s.priority(a)
+	bs, bstall := s.priority(b)
+	if astall != bstall {
+		return astall
+	}
+	return as > bs
+}
+
+// acquire tries to take a compaction slot for ks. If it fails, the returned
+// channel is closed once it is worth trying again; the caller must then
+// either retry or call cancel.
+func (s *compScheduler) acquire(ks opt.Keyspace) (bool, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[ks] = true
+	free := s.budget - s.nrunning()
+	if s.paused || free <= 0 {
+		return false, s.changeC
+	}
+	if other := 1 - ks; free == 1 && s.pending[other] && s.before(other, ks) {
+		// Let the other loop retry, it may be waiting on an older decision.
+		s.notify()
+		return false, s.changeC
+	}
+	s.pending[ks] = false
+	s.running[ks] = true
+	return true, nil
+}
+
+func (s *compScheduler) cancel(ks opt.Keyspace) {
+	s.mu.Lock()
+	s.pending[ks] = false
+	s.notify()
+	s.mu.Unlock()
+}
+
+func (s *compScheduler) release(ks opt.Keyspace) {
+	s.mu.Lock()
+	s.running[ks] = false
+	s.notify()
+	s.mu.Unlock()
+}
+
+func (s *compScheduler) setPaused(paused bool) {
+	s.mu.Lock()
+	s.paused = paused
+	s.notify()
+	s.mu.Unlock()
+}
+
+// compPriority returns the compaction score of the given keyspace and
+// whether its level-0 is large enough to slow down or pause writes.
+func (db *DB) compPriority(ks opt.Keyspace) (score float64, stall bool) {
+	v := db.s.version()
+	defer v.release()
+	if ks == opt.KeyspaceState {
+		return v.cScores, v.l0Runs_s() >= db.s.o.GetWriteL0SlowdownTrigger2()
+	}
+	return v.cScore, v.l0Runs() >= db.s.o.GetWriteL0SlowdownTrigger()
+}
+
+// compAcquire waits for a compaction slot for ks, while still serving pause
+// requests of memdb compaction on pauseC.
+func (db *DB) compAcquire(ks opt.Keyspace, pauseC chan chan<- struct{}) {
+	for {
+		ok, wakeC := db.compSched.acquire(ks)
+		if ok {
+			return
+		}
+		select {
+		case <-wakeC:
+		case ch := <-pauseC:
+			db.pauseCompaction(ch)
+		case <-db.closeC:
+			db.compSched.cancel(ks)
+			db.compactionExitTransact()
+		}
+	}
+}
+
+// CompactionKeyspaceStats holds the scheduler state of one keyspace.
+type CompactionKeyspaceStats struct {
+	Running bool
+	Pending bool
+	Score   float64
+	Stall   bool
+}
+
+// CompactionSchedulerStats holds the state of the compaction scheduler.
+// Keyspaces is indexed by opt.Keyspace.
+type CompactionSchedulerStats struct {
+	Budget    int
+	Paused    bool
+	Keyspaces [2]CompactionKeyspaceStats
+}
+
+// CompactionScheduler returns the state of the compaction scheduler.
+func (db *DB) CompactionScheduler() (CompactionSchedulerStats, error) {
+	if err := db.ok(); err != nil {
+		return CompactionSchedulerStats{}, err
+	}
+	s := db.compSched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := CompactionSchedulerStats{Budget: s.budget, Paused: s.paused}
+	for ks := range st.Keyspaces {
+		kst := &st.Keyspaces[ks]
+		kst.Running, kst.Pending = s.running[ks], s.pending[ks]
+		kst.Score, kst.Stall = s.priority(opt.Keyspace(ks))
+	}
+	return st, nil
+}
+
+// PauseCompactions stops the scheduler from starting new table compactions
+// in both keyspaces, running ones are completed. Manual compactions wait as
+// well. Memdb compactions are not affected, so writes will eventually stall
+// if compactions stay paused.
+func (db *DB) PauseCompactions() error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	db.compSched.setPaused(true)
+	return nil
+}
+
+// ResumeCompactions undoes PauseCompactions.
+func (db *DB) ResumeCompactions() error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	db.compSched.setPaused(false)
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+func TestCompScheduler_Priority(t *testing.T) {
+	stall := [2]bool{false, true}
+	s := newCompScheduler(1, func(ks opt.Keyspace) (float64, bool) {
+		return 1, stall[ks]
+	})
+
+	if ok, _ := s.acquire(opt.KeyspaceMain); !ok {
+		t.Fatal("acquire: no slot for idle scheduler")
+	}
+	ok, wakeC := s.acquire(opt.KeyspaceState)
+	if ok {
+		t.Fatal("acquire: budget exceeded")
+	}
+	// Main asks again while state is pending and stalling writes.
+	s.release(opt.KeyspaceMain)
+	<-wakeC
+	if ok, _ := s.acquire(opt.KeyspaceMain); ok {
+		t.Fatal("acquire: main got the slot before stalling state")
+	}
+	if ok, _ := s.acquire(opt.KeyspaceState); !ok {
+		t.Fatal("acquire: stalling state didn't get the slot")
+	}
+	s.release(opt.KeyspaceState)
+
+	s.setPaused(true)
+	if ok, _ := s.acquire(opt.KeyspaceMain); ok {
+		t.Fatal("acquire: got a slot while paused")
+	}
+	s.setPaused(false)
+	if ok, _ := s.acquire(opt.KeyspaceMain); !ok {
+		t.Fatal("acquire: no slot after resume")
+	}
+}
+
+func TestDB_PauseCompactions(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	if err := h.db.PauseCompactions(); err != nil {
+		t.Fatal("PauseCompactions: got error: ", err)
+	}
+	n := opt.DefaultCompactionL0Trigger + 1
+	for i := 0; i < n; i++ {
+		h.put(fmt.Sprintf("key%d", i), "v")
+		h.compactMem()
+	}
+	time.Sleep(50 * time.Millisecond)
+	h.tablesPerLevel(fmt.Sprint(n))
+
+	st, err := h.db.CompactionScheduler()
+	if err != nil {
+		t.Fatal("CompactionScheduler: got error: ", err)
+	}
+	if !st.Paused || !st.Keyspaces[opt.KeyspaceMain].Pending || st.Keyspaces[opt.KeyspaceMain].Score < 1 {
+		t.Fatalf("CompactionScheduler: unexpected state %+v", st)
+	}
+
+	if err := h.db.ResumeCompactions(); err != nil {
+		t.Fatal("ResumeCompactions: got error: ", err)
+	}
+	for i := 0; ; i++ {
+		v := h.db.s.version()
+		l0 := v.tLen(0)
+		v.release()
+		if l0 < opt.DefaultCompactionL0Trigger {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("level-0 not compacted after resume: %s", h.getTablesPerLevel())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDB_MaxBackgroundCompactions(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{MaxBackgroundCompactions: 8})
+	defer h.close()
+
+	// 每个 keyspace 同时只跑一个 compaction，多给的 slot 没有意义。
+	st, err := h.db.CompactionScheduler()
+	if err != nil {
+		t.Fatal("CompactionScheduler: got error: ", err)
+	}
+	if st.Budget != 2 {
+		t.Fatalf("Budget: got %d, want 2", st.Budget)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"io"
+	"os"
+
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/journal"
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// secondaryRetries is how many times opening or catching up is retried when
+// the primary removes a file while it is being read.
+const secondaryRetries = 5
+
+// OpenSecondary opens a read-only DB following the DB at primaryPath, which
+// may be open by another process at the same time. Nothing is written to
+// primaryPath; the lock and the LOG of the secondary go to secondaryPath,
+// which is created if missing and must not be the primary directory.
+//
+// The secondary sees the primary as of the time it was opened, both
+// keyspaces included. Call TryCatchUpWithPrimary to see newer writes.
+// Tables removed by the primary stay readable by the secondary until it
+// catches up past them.
+//
+// ReadOnly and ErrorIfMissing are implied; a torn record at the end of a
+// journal is expected and StrictJournal is ignored.
+//
+// The returned DB instance is safe for concurrent use.
+// The DB must be closed after use, by calling Close method.
+func OpenSecondary(primaryPath, secondaryPath string, o *opt.Options) (db *DB, err error) {
+	o = dupOptions(o)
+	o.ReadOnly = true
+	o.ErrorIfMissing = true
+	o.ErrorIfExist = false
+	o.Strict &^= opt.StrictJournal
+
+	for i := 0; ; i++ {
+		var stor storage.Storage
+		stor, err = storage.OpenFileSecondary(primaryPath, secondaryPath)
+		if err != nil {
+			return
+		}
+		db, err = Open(stor, o)
+		if err == nil {
+			db.closer = stor
+			db.secondary = true
+			db.refTables()
+			// 在主库删除之前钉住当前版本的表。
+			if err = db.pinTables(); err == nil {
+				db.trackVersion()
+				return
+			}
+			db.Close()
+		} else {
+			stor.Close()
+		}
+		// 主库在打开期间轮转了 manifest 或者删除了表，重新打开。
+		if !secondaryRetry(err) || i+1 >= secondaryRetries {
+			return nil, err
+		}
+	}
+}
+
+// secondaryRetry returns whether err is caused by a file the primary
+// removed while the secondary was reading it.
+func secondaryRetry(err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+	// session.recover reports a missing manifest this way.
+	if e, ok := err.(*errors.ErrCorrupted); ok {
+		_, ok = e.Err.(*errors.ErrMissingFiles)
+		return ok
+	}
+	return false
+}
+
+// refTables makes the ref loop count the tables of the current version.
+// They are counted by the first commit after recovery, see newManifest, and
+// a read-only DB never commits; TryCatchUpWithPrimary depends on the counts
+// to release the tables it drops.
+func (db *DB) refTables() {
+	v := db.s.version()
+	defer v.release()
+	rec := &sessionRecord{}
+	nv := v.spawn(rec, false)
+	v.fillRecord(rec)
+	v.fillRecord_s(rec)
+	db.s.setVersion(rec, nv)
+}
+
+// pinTables opens every table of the current version once, so the storage
+// keeps them readable after the primary removes them.
+func (db *DB) pinTables() error {
+	v := db.s.version()
+	defer v.release()
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			if err := db.pinTable(t.fd); err != nil {
+				return err
+			}
+		}
+	}
+	for _, tables := range v.level_s {
+		for _, t := range tables {
+			if err := db.pinTable(t.fd); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// trackVersion records the current version for unpinTables.
+func (db *DB) trackVersion() {
+	db.s.vmu.Lock()
+	db.secVersions = append(db.secVersions, db.s.stVersion)
+	db.s.vmu.Unlock()
+}
+
+// unpinTables releases the pins of the tables no version in use references
+// anymore. The ref loop would only remove them once it converts the
+// versions it caches, see maxCachedTime.
+func (db *DB) unpinTables() {
+	pinner, ok := db.s.stor.Storage.(storage.Pinner)
+	if !ok {
+		return
+	}
+	live := make(map[int64]struct{})
+	db.s.vmu.Lock()
+	versions := db.secVersions[:0]
+	for _, v := range db.secVersions {
+		// 被释放的版本不会再被用到。
+		if v.released {
+			continue
+		}
+		versions = append(versions, v)
+		for _, tables := range v.levels {
+			for _, t := range tables {
+				live[t.fd.Num] = struct{}{}
+			}
+		}
+		for _, tables := range v.level_s {
+			for _, t := range tables {
+				live[t.fd.Num] = struct{}{}
+			}
+		}
+	}
+	clear(db.secVersions[len(versions):])
+	db.secVersions = versions
+	db.s.vmu.Unlock()
+
+	for _, fd := range pinner.Pinned() {
+		if _, ok := live[fd.Num]; !ok {
+			// 先让表缓存关掉它的 reader，文件才会真正关闭。
+			db.s.tops.cache.Evict(0, uint64(fd.Num))
+			db.s.stor.Remove(fd)
+		}
+	}
+}
+
+func (db *DB) pinTable(fd storage.FileDesc) error {
+	r, err := db.s.stor.Open(fd)
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+// readManifestRO replays the current manifest of the primary into the table
+// layout of both keyspaces, and returns the last sequence number recorded.
+func (db *DB) readManifestRO() (chain, state *manifestReplay, seq uint64, err error) {
+	fd, err := db.s.stor.GetMeta()
+	if err != nil {
+		return
+	}
+	reader, err := db.s.stor.Open(fd)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	// 主库可能正在追加 manifest，末尾不完整的记录留到下一次。
+	jr := journal.NewReader(reader, dropper{db.s, fd}, false, true)
+	chain, state = newManifestReplay(), newManifestReplay()
+	for {
+		var r io.Reader
+		r, err = jr.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+				return
+			}
+			err = errors.SetFd(err, fd)
+			return
+		}
+		rec := &sessionRecord{}
+		if derr := rec.decode(r); derr != nil {
+			if errors.IsCorrupted(derr) {
+				continue
+			}
+			err = errors.SetFd(derr, fd)
+			return
+		}
+		chain.apply(rec.compPtrs, rec.deletedTables, rec.addedTables)
+		state.apply(rec.compPtrs2, rec.deletedTabless, rec.addedTabless)
+		if rec.has(recSeqNum) && rec.seqNum > seq {
+			seq = rec.seqNum
+		}
+	}
+}
+
+// TryCatchUpWithPrimary makes the writes the primary made since the
+// secondary was opened, or last caught up, visible to the secondary. Both
+// keyspaces are caught up at once. Iterators created before the call keep
+// seeing the older state. Snapshots don't hold tables, so a snapshot taken
+// before the call may miss entries the primary has compacted away since.
+// Tables removed by the primary are released by the first call after no
+// iterator reads them.
+//
+// It returns ErrNotSecondary if the DB wasn't opened by OpenSecondary.
+// Catching up concurrently with itself is serialized.
+func (db *DB) TryCatchUpWithPrimary() (err error) {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	if !db.secondary {
+		return ErrNotSecondary
+	}
+	db.catchUpMu.Lock()
+	defer db.catchUpMu.Unlock()
+
+	for i := 0; i < secondaryRetries; i++ {
+		if err = db.catchUp(); !secondaryRetry(err) {
+			if err == nil {
+				// catchUp 放掉旧版本之后才能看出哪些表不再用到。
+				db.unpinTables()
+			}
+			return
+		}
+		db.logf("secondary@catchup retrying, %v", err)
+	}
+	return
+}
+
+func (db *DB) catchUp() error {
+	// 先读 journal 再读 manifest：读 journal 时被主库删掉的那些已经 flush
+	// 成表了，会出现在之后读到的 manifest 里。
+	mdb, seq, err := db.readJournalsRO()
+	if err != nil {
+		return err
+	}
+	mdbs, seqs, err := db.readJournalsRO_s()
+	if err != nil {
+		return err
+	}
+	if seqs > seq {
+		seq = seqs
+	}
+	chain, state, mseq, err := db.readManifestRO()
+	if err != nil {
+		return err
+	}
+	if mseq > seq {
+		seq = mseq
+	}
+
+	v := db.s.version()
+	defer v.release()
+
+	// 和当前版本比较，生成从当前版本到主库版本的记录。
+	rec := &sessionRecord{}
+	var added []storage.FileDesc
+	current := make(map[int64]struct{})
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			current[t.fd.Num] = struct{}{}
+			if r, ok := chain.tables[t.fd.Num]; ok && r.level == level {
+				delete(chain.tables, t.fd.Num)
+			} else {
+				rec.delTable(level, t.fd.Num)
+			}
+		}
+	}
+	for level, tables := range v.level_s {
+		for _, t := range tables {
+			current[t.fd.Num] = struct{}{}
+			if r, ok := state.tables[t.fd.Num]; ok && r.level == level {
+				delete(state.tables, t.fd.Num)
+			} else {
+				rec.delTable_s(level, t.fd.Num)
+			}
+		}
+	}
+	// 剩下的都是新加的表。
+	for _, r := range chain.tables {
+		rec.addTable(r.level, r.num, r.size, r.imin, r.imax)
+		added = append(added, storage.FileDesc{Type: storage.TypeTable, Num: r.num})
+	}
+	for _, r := range state.tables {
+		rec.addTable_s(r.level, r.num, r.size, r.imin, r.imax)
+		added = append(added, storage.FileDesc{Type: storage.TypeTable, Num: r.num})
+	}
+
+	// 新版本的表必须在主库删除之前钉住，失败的话放掉这次钉住的。
+	for i, fd := range added {
+		if err := db.pinTable(fd); err != nil {
+			for _, fd := range added[:i] {
+				if _, ok := current[fd.Num]; !ok {
+					db.s.stor.Remove(fd)
+				}
+			}
+			return err
+		}
+	}
+
+	if len(rec.addedTables)+len(rec.addedTabless)+len(rec.deletedTables)+len(rec.deletedTabless) > 0 {
+		db.s.setVersion(rec, v.spawn(rec, false))
+		db.trackVersion()
+	}
+
+	// 表已经就位，再换 memdb，读到的数据不会倒退。
+	db.memMu.Lock()
+	mem, mems := db.mem, db.mems
+	db.mem = &memDB{db: db, DB: mdb, ref: 1}
+	db.mems = &memDB{db: db, Table_s: mdbs, refs: 1}
+	db.memMu.Unlock()
+	if mem != nil {
+		mem.decref()
+	}
+	if mems != nil {
+		mems.decref_s()
+	}
+
+	if seq > db.getSeq() {
+		db.setSeq(seq)
+	}
+	db.logf("secondary@catchup done seq %d, +%d -%d tables", seq,
+		len(rec.addedTables)+len(rec.addedTabless), len(rec.deletedTables)+len(rec.deletedTabless))
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+func TestDB_Secondary(t *testing.T) {
+	dir, dir2 := t.TempDir(), t.TempDir()
+	primary, err := OpenFile(dir, &opt.Options{DisableLargeBatchTransaction: true})
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer primary.Close()
+
+	put := func(prefix string, n int) {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("%s%03d", prefix, i))
+			if err := primary.Put(key, []byte(prefix), nil); err != nil {
+				t.Fatal("Put: got error: ", err)
+			}
+			if err := primary.Put_s(key, []byte(prefix), nil); err != nil {
+				t.Fatal("Put_s: got error: ", err)
+			}
+		}
+	}
+	compact := func() {
+		if err := primary.CompactRange(util.Range{}); err != nil {
+			t.Fatal("CompactRange: got error: ", err)
+		}
+		primary.writeLockC <- struct{}{}
+		_, err := primary.rotateMem_s(0, true)
+		<-primary.writeLockC
+		if err != nil {
+			t.Fatal("state memdb compaction: got error: ", err)
+		}
+	}
+	put("a", 100)
+	compact()
+	put("b", 10)
+
+	db, err := OpenSecondary(dir, dir2, nil)
+	if err != nil {
+		t.Fatal("OpenSecondary: got error: ", err)
+	}
+	defer db.Close()
+
+	get := func(key, want string) {
+		t.Helper()
+		if v, err := db.Get([]byte(key), nil); want == "" && err != ErrNotFound || want != "" && (err != nil || string(v) != want) {
+			t.Fatalf("Get %s: got %q, %v, want %q", key, v, err, want)
+		}
+		if v, err := db.Get_s([]byte(key), nil); want == "" && err != ErrNotFound || want != "" && (err != nil || string(v) != want) {
+			t.Fatalf("Get_s %s: got %q, %v, want %q", key, v, err, want)
+		}
+	}
+	get("a050", "a")
+	get("b005", "b")
+	get("c005", "")
+	if err := db.Put([]byte("xkey00"), []byte("x"), nil); err != ErrReadOnly {
+		t.Fatalf("Put: got %v, want %v", err, ErrReadOnly)
+	}
+
+	// 打开一个迭代器，然后让主库把它正在读的表合并掉。
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	put("c", 10)
+	for i := 0; i < 100; i += 2 {
+		if err := primary.Delete([]byte(fmt.Sprintf("a%03d", i)), nil); err != nil {
+			t.Fatal("Delete: got error: ", err)
+		}
+	}
+	compact()
+	put("d", 10)
+
+	if err := db.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal("TryCatchUpWithPrimary: got error: ", err)
+	}
+	if _, err := db.Get([]byte("a050"), nil); err != ErrNotFound {
+		t.Fatalf("Get a050: got %v, want %v", err, ErrNotFound)
+	}
+	get("a051", "a")
+	get("c005", "c")
+	get("d005", "d")
+
+	// 追上之后旧版本的表仍然可读。
+	n := 0
+	for iter.Next() {
+		n++
+	}
+	if err := iter.Error(); err != nil || n != 110 {
+		t.Fatalf("iterator: got %d entries, %v, want 110", n, err)
+	}
+
+	// 迭代器放掉之后，下一次追赶就不再钉住旧版本的表。
+	pinned := func() string {
+		return fmt.Sprint(db.s.stor.Storage.(storage.Pinner).Pinned())
+	}
+	tables := func() string {
+		v := db.s.version()
+		defer v.release()
+		var fds []storage.FileDesc
+		for _, tables := range v.levels {
+			for _, t := range tables {
+				fds = append(fds, t.fd)
+			}
+		}
+		for _, tables := range v.level_s {
+			for _, t := range tables {
+				fds = append(fds, t.fd)
+			}
+		}
+		sortFds(fds)
+		return fmt.Sprint(fds)
+	}
+	if pinned() == tables() {
+		t.Fatalf("pinned tables: got %s, want the old version's tables as well", pinned())
+	}
+	iter.Release()
+	if err := db.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal("TryCatchUpWithPrimary: got error: ", err)
+	}
+	if got, want := pinned(), tables(); got != want {
+		t.Fatalf("pinned tables: got %s, want %s", got, want)
+	}
+	get("a051", "a")
+
+	primary.Put_s([]byte("e"), []byte("e"), nil)
+	if err := db.TryCatchUpWithPrimary(); err != nil {
+		t.Fatal("TryCatchUpWithPrimary: got error: ", err)
+	}
+	if v, err := db.Get_s([]byte("e"), nil); err != nil || string(v) != "e" {
+		t.Fatalf("Get_s e: got %q, %v", v, err)
+	}
+
+	if err := primary.TryCatchUpWithPrimary(); err != ErrNotSecondary {
+		t.Fatalf("TryCatchUpWithPrimary on primary: got %v, want %v", err, ErrNotSecondary)
+	}
+}
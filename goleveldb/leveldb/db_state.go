@@ -13,6 +13,7 @@ import (
 
 	"awesomeProject1/goleveldb/leveldb/journal"
 	"awesomeProject1/goleveldb/leveldb/memdb"
+	"awesomeProject1/goleveldb/leveldb/opt"
 	"awesomeProject1/goleveldb/leveldb/storage"
 )
 
@@ -22,11 +23,11 @@ var (
 
 // 存放了是数据库和内存数据库的指针
 type memDB struct {
-	db         *DB //数据库指针
-	*memdb.DB      //继承结构体DB
-	*memdb.DBs     //另外的一个内存数据库DBs
-	ref        int32
-	refs       int32
+	db            *DB //数据库指针
+	*memdb.DB         //继承结构体DB
+	memdb.Table_s     //另外的一个内存数据库，见 memdb.Table_s
+	ref           int32
+	refs          int32
 }
 
 // 这里这个m就相当于memDB的指针，也相当于Package memdb，
@@ -45,7 +46,7 @@ func (m *memDB) incref_s() { //加引用
 func (m *memDB) decref() { //减引用
 	if ref := atomic.AddInt32(&m.ref, -1); ref == 0 { //if ref=1
 		// Only put back memdb with std capacity.
-		if m.Capacity() == m.db.s.o.GetWriteBuffer() { //达到阈值
+		if m.Capacity() == m.db.writeBuffer(opt.KeyspaceMain) { //达到阈值
 			m.Reset()           //mems置空
 			m.db.mpoolPut(m.DB) //mem->mpool?
 		}
@@ -58,12 +59,12 @@ func (m *memDB) decref() { //减引用
 func (m *memDB) decref_s() { //减引用
 	if refs := atomic.AddInt32(&m.refs, -1); refs == 0 { //if ref=1
 		// Only put back memdb with std capacity.
-		if m.Capacity_s() == m.db.s.o.GetWriteBuffer2() { //达到阈值4MiB
-			m.Reset_s()            //mems置空
-			m.db.mpoolPut_s(m.DBs) //mems->mpools?
+		if m.Capacity_s() == m.db.writeBuffer(opt.KeyspaceState) { //达到阈值4MiB
+			m.Reset_s()                //mems置空
+			m.db.mpoolPut_s(m.Table_s) //mems->mpools?
 		}
 		m.db = nil //memdb置空
-		m.DBs = nil
+		m.Table_s = nil
 	} else if refs < 0 {
 		panic("negative memdb ref")
 	}
@@ -110,7 +111,7 @@ func (db *DB) mpoolPut(mem *memdb.DB) {
 		}
 	}
 }
-func (db *DB) mpoolPut_s(mems *memdb.DBs) {
+func (db *DB) mpoolPut_s(mems memdb.Table_s) {
 	if !db.isClosed() {
 		select {
 		case db.memPools <- mems:
@@ -126,8 +127,17 @@ func (db *DB) mpoolGet(n int) *memDB {
 	case mdb = <-db.memPool:
 	default:
 	}
-	if mdb == nil || mdb.Capacity() < n {
-		mdb = memdb.New(db.s.icmp, maxInt(db.s.o.GetWriteBuffer(), n))
+	if db.budget != nil {
+		db.budget.resize()
+	}
+	// 预算调整过之后，池里大小不对的 memdb 就不用了。
+	wb := db.writeBuffer(opt.KeyspaceMain)
+	if mdb == nil || mdb.Capacity() < n || mdb.Capacity() != wb {
+		if db.s.o.GetMemTable() == opt.ConcurrentMemTable {
+			mdb = memdb.NewConcurrent(db.s.icmp, maxInt(wb, n))
+		} else {
+			mdb = memdb.New(db.s.icmp, maxInt(wb, n))
+		}
 	}
 	return &memDB{
 		db: db,
@@ -135,17 +145,31 @@ func (db *DB) mpoolGet(n int) *memDB {
 	}
 }
 func (db *DB) mpoolGet_s(n int) *memDB {
-	var mdb *memdb.DBs
+	var mdb memdb.Table_s
 	select {
 	case mdb = <-db.memPools:
 	default:
 	}
-	if mdb == nil || mdb.Capacity_s() < n {
-		mdb = memdb.New_s(db.s.icmp, maxInt(db.s.o.GetWriteBuffer2(), n))
+	if db.budget != nil {
+		db.budget.resize()
+	}
+	wb := db.writeBuffer(opt.KeyspaceState)
+	if mdb == nil || mdb.Capacity_s() < n || mdb.Capacity_s() != wb {
+		switch db.s.o.GetMemTable2() {
+		case opt.ConcurrentMemTable:
+			mdb = memdb.NewConcurrent_s(db.s.icmp, maxInt(wb, n))
+		case opt.HashMemTable:
+			// 按 user key 做 hash，同一个 key 的各个版本在一个桶里。
+			mdb = memdb.NewHash_s(db.s.icmp, maxInt(wb, n), func(key []byte) []byte {
+				return internalKey(key).ukey()
+			})
+		default:
+			mdb = memdb.New_s(db.s.icmp, maxInt(wb, n))
+		}
 	}
 	return &memDB{
-		db:  db,
-		DBs: mdb,
+		db:      db,
+		Table_s: mdb,
 	}
 }
 func (db *DB) mpoolDrain() {
@@ -197,8 +221,10 @@ func (db *DB) mpoolDrain_s() {
 // newMem only called synchronously by the writer.
 // memtable变immutable
 func (db *DB) newMem(n int) (mem *memDB, err error) {
+	// 在途的写入组插完之后才能冻结。
+	db.memw.wait()
 	fd := storage.FileDesc{Type: storage.TypeJournal, Num: db.s.allocFileNum()}
-	w, err := db.s.stor.Create(fd)
+	w, err := db.createJournal(fd)
 	if err != nil {
 		db.s.reuseFileNum(fd.Num)
 		return
@@ -211,20 +237,30 @@ func (db *DB) newMem(n int) (mem *memDB, err error) {
 		return nil, errHasFrozenMem
 	}
 
+	db.jsyncMu.Lock()
 	if db.journal == nil {
 		db.journal = journal.NewWriter(w)
 	} else {
 		db.journal.Reset(w)
-		db.journalWriter.Close()
+		db.closeJournalWriter(opt.KeyspaceMain, db.journalWriter)
 		db.frozenJournalFd = db.journalFd
 	}
+	db.journal.SetCompression(db.s.o.GetJournalCompression() == opt.SnappyCompression)
+	if db.recyclable() {
+		db.journal.SetNum(fd.Num)
+	}
 	db.journalWriter = w
+	db.jsyncMu.Unlock()
 	db.journalFd = fd
+	db.journalLast[opt.KeyspaceMain] = 0
 	db.frozenMem = db.mem
 	mem = db.mpoolGet(n)
 	mem.incref() // for self
 	mem.incref() // for caller
 	db.mem = mem
+	db.applyMemoryLimit()
+	// WAL-less writes, if any, are in the frozen memdb now.
+	atomic.StoreUint32(&db.walLess[opt.KeyspaceMain], 0)
 	// The seq only incremented by the writer. And whoever called newMem
 	// should hold write lock, so no need additional synchronization here.
 	db.frozenSeq = db.seq
@@ -233,8 +269,9 @@ func (db *DB) newMem(n int) (mem *memDB, err error) {
 
 // //关于mem_s的操作
 func (db *DB) newMem_s(n int) (mem *memDB, err error) {
+	db.memw.wait()
 	fd := storage.FileDesc{Type: storage.TypeJournals, Num: db.s.allocFileNum()} //生成一个日志文件
-	w, err := db.s.stor.Create(fd)                                               //返回一个storage.writer？
+	w, err := db.createJournal(fd)                                               //返回一个storage.writer？
 	if err != nil {
 		db.s.reuseFileNum(fd.Num)
 		return
@@ -247,20 +284,29 @@ func (db *DB) newMem_s(n int) (mem *memDB, err error) {
 		return nil, errHasFrozenMem
 	}
 
+	db.jsyncMu.Lock()
 	if db.journal2 == nil {
 		db.journal2 = journal.NewWriter_s(w)
 	} else {
 		db.journal2.Reset(w)
-		db.journalWriter2.Close()
+		db.closeJournalWriter(opt.KeyspaceState, db.journalWriter2)
 		db.frozenJournalFd2 = db.journalFd2
 	}
+	db.journal2.SetCompression(db.s.o.GetJournalCompression() == opt.SnappyCompression)
+	if db.recyclable() {
+		db.journal2.SetNum(fd.Num)
+	}
 	db.journalWriter2 = w
+	db.jsyncMu.Unlock()
 	db.journalFd2 = fd
+	db.journalLast[opt.KeyspaceState] = 0
 	db.frozenMems = db.mems //mems变成frozenmems
 	mem = db.mpoolGet_s(n)  //此方法会调用mem.New_s方法初始化一个新的mem
 	mem.incref_s()          // for self
 	mem.incref_s()          // for caller
 	db.mems = mem           //让初始化的mem变为mems
+	db.applyMemoryLimit()
+	atomic.StoreUint32(&db.walLess[opt.KeyspaceState], 0)
 	// The seq only incremented by the writer. And whoever called newMem
 	// should hold write lock, so no need additional synchronization here.
 	db.frozenSeq2 = db.seq
@@ -354,26 +400,20 @@ func (db *DB) getFrozenMem_s() *memDB {
 // Drop frozen memdb; assume that frozen memdb isn't nil.
 func (db *DB) dropFrozenMem() {
 	db.memMu.Lock()
-	if err := db.s.stor.Remove(db.frozenJournalFd); err != nil {
-		db.logf("journal@remove removing @%d %q", db.frozenJournalFd.Num, err)
-	} else {
-		db.logf("journal@remove removed @%d", db.frozenJournalFd.Num)
-	}
+	db.retireJournal(db.frozenJournalFd)
 	db.frozenJournalFd = storage.FileDesc{}
 	db.frozenMem.decref()
 	db.frozenMem = nil
+	db.applyMemoryLimit()
 	db.memMu.Unlock()
 }
 func (db *DB) dropFrozenMem_s() {
 	db.memMu.Lock()
-	if err := db.s.stor.Remove(db.frozenJournalFd2); err != nil {
-		db.logf("journal@remove removing @%d %q", db.frozenJournalFd2.Num, err)
-	} else {
-		db.logf("journal@remove removed @%d", db.frozenJournalFd2.Num)
-	}
+	db.retireJournal(db.frozenJournalFd2)
 	db.frozenJournalFd2 = storage.FileDesc{}
 	db.frozenMems.decref_s()
 	db.frozenMems = nil
+	db.applyMemoryLimit()
 	db.memMu.Unlock()
 }
 
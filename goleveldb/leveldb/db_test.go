@@ -2540,7 +2540,7 @@ func TestDB_TableCompactionBuilder(t *testing.T) {
 		value      = bytes.Repeat([]byte{'0'}, 100)
 	)
 	for i := 0; i < 2; i++ {
-		tw, err := s.tops.create()
+		tw, err := s.tops.create(util.IOPriorityHigh, false)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -2815,6 +2815,45 @@ func TestDB_ReadOnly(t *testing.T) {
 	h.assertNumKeys(4)
 }
 
+func TestDB_ReadOnlyStateJournal(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("foo", "v1")
+	if err := h.db.Put_s([]byte("sfoo"), []byte("s1"), h.wo); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem_s(0, true)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+
+	// Only in the journals.
+	if err := h.db.Put_s([]byte("sbar"), []byte("s2"), h.wo); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	h.put("bar", "v2")
+	seq := h.db.seq
+
+	h.o.ReadOnly = true
+	h.reopenDB()
+	mode := testutil.ModeCreate | testutil.ModeRemove | testutil.ModeRename | testutil.ModeWrite | testutil.ModeSync
+	h.stor.EmulateError(mode, storage.TypeAll, errors.New("read-only DB shouldn't writes"))
+
+	h.getVal("foo", "v1")
+	h.getVal("bar", "v2")
+	for key, want := range map[string]string{"sfoo": "s1", "sbar": "s2"} {
+		if v, err := h.db.Get_s([]byte(key), h.ro); err != nil || string(v) != want {
+			t.Fatalf("Get_s %s: got %q, %v, want %q", key, v, err, want)
+		}
+	}
+	if h.db.seq < seq {
+		t.Fatalf("seq: got %d, want at least %d", h.db.seq, seq)
+	}
+}
+
 func TestDB_BulkInsertDelete(t *testing.T) {
 	h := newDbHarnessWopt(t, &opt.Options{
 		DisableLargeBatchTransaction: true,
@@ -0,0 +1,98 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// isCold reports whether the tables of ks at level belong in the cold
+// tier, see opt.Options.ColdLevel.
+func (s *session) isCold(ks opt.Keyspace, level int) bool {
+	cold := s.o.GetColdLevel()
+	if ks == opt.KeyspaceState {
+		cold = s.o.GetColdLevel2()
+	}
+	return cold > 0 && level >= cold
+}
+
+// kickTier wakes the mover up after the version changed.
+func (db *DB) kickTier() {
+	if db.tierKickC == nil {
+		return
+	}
+	select {
+	case db.tierKickC <- struct{}{}:
+	default:
+	}
+}
+
+// tierLoop is the cold tier mover. Compactions already write to the tier
+// of their output level, the mover handles the tables that changed level
+// by a trivial move, and all of them once after open in case ColdLevel or
+// the directories changed. It only runs with both a cold directory and a
+// cold level, otherwise the tables already in the cold tier stay there
+// until a compaction rewrites them.
+func (db *DB) tierLoop() {
+	defer db.closeW.Done()
+	db.tiers = make(map[int64]bool)
+	for {
+		db.tierTables()
+		select {
+		case <-db.tierKickC:
+		case <-db.closeC:
+			return
+		}
+	}
+}
+
+// tierTables moves the tables of the current version to their tier.
+func (db *DB) tierTables() {
+	v := db.s.version()
+	defer v.release()
+	live := make(map[int64]bool)
+	tier := func(fd storage.FileDesc, ks opt.Keyspace, level int) bool {
+		cold := db.s.isCold(ks, level)
+		live[fd.Num] = true
+		if known, ok := db.tiers[fd.Num]; ok && known == cold {
+			return true
+		}
+		if err := db.s.stor.Tier(fd, ks == opt.KeyspaceState, cold); err != nil {
+			db.logf("table@tier @%d L%d K·%s E·%q", fd.Num, level, ks, err)
+			// 文件可能已经被 compaction 删掉了，下一次再看。
+			delete(db.tiers, fd.Num)
+		} else {
+			db.tiers[fd.Num] = cold
+		}
+		select {
+		case <-db.closeC:
+			return false
+		default:
+			return true
+		}
+	}
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			if !tier(t.fd, opt.KeyspaceMain, level) {
+				return
+			}
+		}
+	}
+	for level, tables := range v.level_s {
+		for _, t := range tables {
+			if !tier(t.fd, opt.KeyspaceState, level) {
+				return
+			}
+		}
+	}
+	for num := range db.tiers {
+		if !live[num] {
+			delete(db.tiers, num)
+		}
+	}
+}
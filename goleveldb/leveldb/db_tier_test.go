@@ -0,0 +1,149 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+func TestDB_ColdTier(t *testing.T) {
+	temp := t.TempDir()
+	root := filepath.Join(temp, "db")
+	cold := filepath.Join(temp, "cold")
+	o := &opt.Options{ColdDir: cold, ColdLevel: 1, ColdLevel2: 1}
+
+	db, err := OpenFile(root, o)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		if err := db.Put(key, []byte("main"), nil); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+		if err := db.Put_s(key, []byte("state"), nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	db.writeLockC <- struct{}{}
+	_, err = db.rotateMem_s(0, true)
+	<-db.writeLockC
+	if err != nil {
+		t.Fatal("rotateMem_s: got error: ", err)
+	}
+	if err := db.compTriggerRange(db.tcompCmdCs, -1, nil, nil); err != nil {
+		t.Fatal("compTriggerRange: got error: ", err)
+	}
+
+	// 每张表所在的层和目录要对上：level-0 在热层，其他在冷层。
+	exists := func(dir string, num int64) bool {
+		_, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%06d.ldb", num)))
+		return err == nil
+	}
+	placed := func(db *DB, coldLevel int) (ok bool, n int) {
+		v := db.s.version()
+		defer v.release()
+		ok = true
+		for level, tables := range v.levels {
+			for _, t := range tables {
+				want := coldLevel > 0 && level >= coldLevel
+				ok = ok && exists(cold, t.fd.Num) == want && exists(root, t.fd.Num) != want
+				n++
+			}
+		}
+		for level, tables := range v.level_s {
+			for _, t := range tables {
+				want := coldLevel > 0 && level >= coldLevel
+				ok = ok && exists(cold, t.fd.Num) == want && exists(root, t.fd.Num) != want
+				n++
+			}
+		}
+		return
+	}
+	wait := func(db *DB, coldLevel int) {
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			ok, n := placed(db, coldLevel)
+			if ok && n > 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("tables not moved to their tier, ColdLevel %d", coldLevel)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait(db, 1)
+	v := db.s.version()
+	if len(v.levels) < 2 || len(v.levels[1]) == 0 || len(v.level_s) < 2 || len(v.level_s[1]) == 0 {
+		t.Fatal("no table compacted to level-1")
+	}
+	v.release()
+	check := func(db *DB) {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key%04d", i))
+			if v, err := db.Get(key, nil); err != nil || string(v) != "main" {
+				t.Fatalf("Get %s: got %q, %v", key, v, err)
+			}
+			if v, err := db.Get_s(key, nil); err != nil || string(v) != "state" {
+				t.Fatalf("Get_s %s: got %q, %v", key, v, err)
+			}
+		}
+	}
+	check(db)
+	if err := db.Close(); err != nil {
+		t.Fatal("Close: got error: ", err)
+	}
+
+	// 关掉冷层之后没有 mover，已经在冷层的表留在原地照样能读。
+	db, err = OpenFile(root, &opt.Options{ColdDir: cold})
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	if db.tierKickC != nil {
+		t.Fatal("cold tier mover running without ColdLevel")
+	}
+	if ok, _ := placed(db, 1); !ok {
+		t.Fatal("tables moved without a cold tier mover")
+	}
+	check(db)
+}
+
+func TestDB_ColdTierMover(t *testing.T) {
+	temp := t.TempDir()
+	for i, c := range []struct {
+		o     *opt.Options
+		mover bool
+	}{
+		{nil, false},
+		{&opt.Options{ColdDir: filepath.Join(temp, "cold")}, false},
+		{&opt.Options{ColdLevel: 1, ColdLevel2: 1}, false},
+		{&opt.Options{ColdDir: filepath.Join(temp, "cold"), ColdLevel: 1}, true},
+		{&opt.Options{ColdDir: filepath.Join(temp, "cold"), ColdLevel2: 1}, true},
+	} {
+		db, err := OpenFile(filepath.Join(temp, fmt.Sprintf("db%d", i)), c.o)
+		if err != nil {
+			t.Fatalf("#%d OpenFile: got error: %v", i, err)
+		}
+		if mover := db.tierKickC != nil; mover != c.mover {
+			t.Errorf("#%d cold tier mover: got %v, want %v", i, mover, c.mover)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("#%d Close: got error: %v", i, err)
+		}
+	}
+}
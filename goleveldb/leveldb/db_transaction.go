@@ -372,14 +372,14 @@ func (tr *Transaction) Discard() {
 }
 
 func (db *DB) waitCompaction() error {
-	if db.s.tLen(0) >= db.s.o.GetWriteL0PauseTrigger() {
+	if db.s.l0Runs() >= db.s.o.GetWriteL0PauseTrigger() {
 		return db.compTriggerWait(db.tcompCmdC)
 	}
 	return nil
 }
 
 func (db *DB) waitCompaction2() error {
-	if db.s.tLen(0) >= db.s.o.GetWriteL0PauseTrigger2() {
+	if db.s.l0Runs() >= db.s.o.GetWriteL0PauseTrigger2() {
 		return db.compTriggerWait(db.tcompCmdC)
 	}
 	return nil
@@ -415,6 +415,7 @@ func (db *DB) OpenTransaction() (*Transaction, error) {
 	if db.tr != nil {
 		panic("leveldb: has open transaction")
 	}
+	db.memw.wait()
 
 	// Flush current memdb.
 	if db.mem != nil && db.mem.Len() != 0 {
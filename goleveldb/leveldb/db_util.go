@@ -7,6 +7,8 @@
 package leveldb
 
 import (
+	"io"
+
 	"awesomeProject1/goleveldb/leveldb/errors"
 	"awesomeProject1/goleveldb/leveldb/iterator"
 	"awesomeProject1/goleveldb/leveldb/opt"
@@ -76,6 +78,8 @@ func (db *DB) checkAndCleanFiles() error {
 			} else {
 				keep = fd.Num >= db.journalFd2.Num
 			}
+		case storage.TypeJournalFree:
+			keep = db.keepFreeJournal(fd)
 		case storage.TypeTable: //如果是sst文件
 			_, keep = tmap[fd.Num] //所有的keep赋值为false
 			if keep {
@@ -103,9 +107,130 @@ func (db *DB) checkAndCleanFiles() error {
 	db.logf("db@janitor F·%d G·%d", len(fds), len(rem))
 	for _, fd := range rem {
 		db.logf("db@janitor removing %s-%d", fd.Type, fd.Num)
-		if err := db.s.stor.Remove(fd); err != nil {
+		if fd.Type == storage.TypeTable {
+			// 版本里没有的表分不出 keyspace，按主库报。
+			if err := db.s.removeTable(opt.KeyspaceMain, fd.Num); err != nil {
+				return err
+			}
+		} else if err := db.s.stor.Remove(fd); err != nil {
 			return err
 		}
 	}
+	// JournalRetention may have been lowered since the last open.
+	db.trimJournals(storage.TypeJournalOld)
+	db.trimJournals(storage.TypeJournalsOld)
 	return nil
 }
+
+// retireJournal disposes of a journal whose content has been flushed. It is
+// kept as an old journal if journal retention is enabled or a subscription
+// is replaying journals, kept for reuse if journal recycling is enabled, and
+// removed otherwise.
+func (db *DB) retireJournal(fd storage.FileDesc) {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	if (db.s.o.GetJournalRetention() > 0 || db.journalHold > 0) && !db.emptyJournal(fd) {
+		ofd := storage.FileDesc{Type: storage.TypeJournalOld, Num: fd.Num}
+		if fd.Type == storage.TypeJournals {
+			ofd.Type = storage.TypeJournalsOld
+		}
+		// 重命名失败的话只能删掉，留在原处会在下次打开时被重放。
+		err := db.s.stor.Rename(fd, ofd)
+		if err == nil {
+			db.logf("journal@retain kept @%d", fd.Num)
+			db.trimJournalsLocked(ofd.Type)
+			return
+		}
+		db.logf("journal@retain renaming @%d %q", fd.Num, err)
+	}
+	if db.recycleJournal(fd) {
+		return
+	}
+	if err := db.s.stor.Remove(fd); err != nil {
+		db.logf("journal@remove removing @%d %q", fd.Num, err)
+	} else {
+		db.logf("journal@remove removed @%d", fd.Num)
+	}
+}
+
+// emptyJournal returns whether fd holds no record; such journals aren't
+// worth keeping.
+func (db *DB) emptyJournal(fd storage.FileDesc) bool {
+	r, err := db.s.stor.Open(fd)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	size, err := r.Seek(0, io.SeekEnd)
+	return err == nil && size == 0
+}
+
+// trimJournals removes the oldest old journals of type ft beyond the
+// journal retention.
+func (db *DB) trimJournals(ft storage.FileType) {
+	db.subsMu.Lock()
+	db.trimJournalsLocked(ft)
+	db.subsMu.Unlock()
+}
+
+func (db *DB) trimJournalsLocked(ft storage.FileType) {
+	if db.journalHold > 0 {
+		return
+	}
+	fds, err := db.s.stor.List(ft)
+	if err != nil {
+		return
+	}
+	sortFds(fds)
+	for len(fds) > db.s.o.GetJournalRetention() {
+		if err := db.s.stor.Remove(fds[0]); err != nil {
+			db.logf("journal@retain removing @%d %q", fds[0].Num, err)
+		} else {
+			db.logf("journal@retain removed @%d", fds[0].Num)
+		}
+		fds = fds[1:]
+	}
+}
+
+// openFileStorage opens the file storage at path with the directories set
+// in o, or with the ones last used if none is set.
+func openFileStorage(path string, o *opt.Options, readOnly bool) (storage.Storage, error) {
+	dirs := storage.FileDirs{
+		Table:      o.GetTableDir(),
+		StateTable: o.GetStateTableDir(),
+		Journal:    o.GetJournalDir(),
+		Cold:       o.GetColdDir(),
+	}
+	if dirs == (storage.FileDirs{}) {
+		return storage.OpenFile(path, readOnly)
+	}
+	return storage.OpenFileDirs(path, dirs, readOnly)
+}
+
+// placeTables moves the tables to the directory of their keyspace, see
+// storage.Placer. Tables that shared a directory with the other keyspace
+// before are only told apart by the version. A table that could not be
+// moved is still found where it is.
+func (db *DB) placeTables() {
+	placer, ok := db.s.stor.Storage.(storage.Placer)
+	if !ok {
+		return
+	}
+	v := db.s.version()
+	defer v.release()
+	place := func(fd storage.FileDesc, state bool) {
+		if err := placer.Place(fd, state); err != nil {
+			db.logf("db@place @%d %q", fd.Num, err)
+		}
+	}
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			place(t.fd, false)
+		}
+	}
+	for _, tables := range v.level_s {
+		for _, t := range tables {
+			place(t.fd, true)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+// markWALLess records that the effective memdb of ks takes a write made
+// with opt.WriteOptions.DisableWAL. The first such write since the last
+// flush also marks the manifest, so that a crash before the flush is
+// detected by the next open. The caller must hold the write lock.
+//
+// Subscriptions read the journals, such a write would never reach them, so
+// it is rejected while one is open.
+func (db *DB) markWALLess(ks opt.Keyspace) error {
+	if atomic.LoadInt32(&db.subsN) > 0 {
+		return ErrWALLessSubscribed
+	}
+	db.walLessMu.Lock()
+	defer db.walLessMu.Unlock()
+	atomic.StoreUint32(&db.walLess[ks], 1)
+	if db.s.walLess(ks) {
+		return nil
+	}
+	rec := &sessionRecord{}
+	if ks == opt.KeyspaceState {
+		rec.setWALLess_s(true)
+	} else {
+		rec.setWALLess(true)
+	}
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+	return db.s.commit(rec, false)
+}
+
+// commitFlush commits rec of a memdb flush of ks. Once no WAL-less write
+// is left in memory the manifest mark is cleared by the same record.
+func (db *DB) commitFlush(ks opt.Keyspace, rec *sessionRecord) {
+	db.walLessMu.Lock()
+	defer db.walLessMu.Unlock()
+	// 只有一个 frozen memdb，它刷完之后只剩当前的 memdb。
+	clear := db.s.walLess(ks) && atomic.LoadUint32(&db.walLess[ks]) == 0
+	if ks == opt.KeyspaceState {
+		if clear {
+			rec.setWALLess_s(false)
+		}
+		db.compactionCommit_s("memdb", rec)
+	} else {
+		if clear {
+			rec.setWALLess(false)
+		}
+		db.compactionCommit("memdb", rec)
+	}
+}
+
+// flushWALLess flushes the memdbs holding WAL-less writes, they are in no
+// journal. Called by Close before the compaction goroutines stop.
+func (db *DB) flushWALLess() error {
+	// 只读的库没有自己的写入，标记是别人留下的。
+	if db.s.o.GetReadOnly() {
+		return nil
+	}
+	for _, ks := range []opt.Keyspace{opt.KeyspaceMain, opt.KeyspaceState} {
+		db.walLessMu.Lock()
+		pending := db.s.walLess(ks)
+		db.walLessMu.Unlock()
+		if !pending {
+			continue
+		}
+		select {
+		case db.writeLockC <- struct{}{}:
+		case <-db.closeC:
+			return ErrClosed
+		}
+		var err error
+		if ks == opt.KeyspaceState {
+			_, err = db.rotateMem_s(0, true)
+		} else {
+			_, err = db.rotateMem(0, true)
+		}
+		<-db.writeLockC
+		if err != nil {
+			return err
+		}
+		db.logf("db@close flushed WAL-less memdb K·%s", ks)
+	}
+	return nil
+}
+
+// checkWALLess fails the open if the manifest still has a WAL-less mark,
+// i.e. the DB was not closed after a bulk load and the writes are gone.
+// A read-only open only logs it, see DBStats.WALLessLost; a secondary
+// also sees the mark while the primary is still loading.
+func (db *DB) checkWALLess() error {
+	var lost []string
+	for _, ks := range []opt.Keyspace{opt.KeyspaceMain, opt.KeyspaceState} {
+		if db.s.walLess(ks) {
+			lost = append(lost, ks.String())
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+	if db.s.o.GetReadOnly() {
+		db.logf("db@open warning: WAL-less writes not flushed K·%s", strings.Join(lost, ","))
+		return nil
+	}
+	db.logf("db@open WAL-less writes lost K·%s", strings.Join(lost, ","))
+	return newErrManifestCorrupted(db.s.manifestFd, "wal-less", "unflushed writes without journal lost: "+strings.Join(lost, ", "))
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+var noWAL = &opt.WriteOptions{DisableWAL: true}
+
+func TestDB_DisableWAL(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	walLess := func() (bool, bool) {
+		h.db.walLessMu.Lock()
+		defer h.db.walLessMu.Unlock()
+		return h.db.s.stWALLess, h.db.s.stWALLess2
+	}
+
+	h.put("logged", "v")
+	if a, b := walLess(); a || b {
+		t.Fatal("WAL-less mark set by a normal write")
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("bulk%04d", i))
+		if err := h.db.Put(key, key, noWAL); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+		if err := h.db.Put_s(key, key, noWAL); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	if a, b := walLess(); !a || !b {
+		t.Fatalf("WAL-less mark: got %v %v, want both set", a, b)
+	}
+
+	// 刷盘之后标记清掉。
+	h.compactMem()
+	if a, b := walLess(); a || !b {
+		t.Fatalf("WAL-less mark after main flush: got %v %v", a, b)
+	}
+
+	// 关库时把状态库的 memdb 刷下去。
+	h.reopenDB()
+	if a, b := walLess(); a || b {
+		t.Fatalf("WAL-less mark after reopen: got %v %v", a, b)
+	}
+	h.getVal("logged", "v")
+	for i := 0; i < 100; i += 7 {
+		key := fmt.Sprintf("bulk%04d", i)
+		h.getVal(key, key)
+		if v, err := h.db.Get_s([]byte(key), nil); err != nil || string(v) != key {
+			t.Fatalf("Get_s %s: got %q, %v", key, v, err)
+		}
+	}
+}
+
+func TestDB_DisableWALCrash(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("logged"), []byte("v"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("bulk%04d", i))
+		if err := db.Put(key, key, noWAL); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+	}
+
+	// 库还开着的时候把文件拷走，相当于进程崩溃。
+	crashed := t.TempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() == "LOCK" {
+			continue
+		}
+		copyFile(t, filepath.Join(dir, e.Name()), filepath.Join(crashed, e.Name()))
+	}
+
+	if _, err := OpenFile(crashed, nil); !errors.IsCorrupted(err) {
+		t.Fatalf("OpenFile after crash: got %v, want corruption", err)
+	}
+
+	// 只读打开不报错，从 Stats 看得到。
+	ro, err := OpenFile(crashed, &opt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal("OpenFile read-only after crash: got error: ", err)
+	}
+	var st DBStats
+	if err := ro.Stats(&st); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	if !st.WALLessLost[opt.KeyspaceMain] || st.WALLessLost[opt.KeyspaceState] {
+		t.Fatalf("WALLessLost: got %v", st.WALLessLost)
+	}
+	if v, err := ro.Get([]byte("logged"), nil); err != nil || string(v) != "v" {
+		t.Fatalf("Get logged read-only: got %q, %v", v, err)
+	}
+	ro.Close()
+
+	// Recover 可以打开，日志里的写还在，不写日志的没了。
+	rdb, err := RecoverFile(crashed, nil)
+	if err != nil {
+		t.Fatal("RecoverFile: got error: ", err)
+	}
+	defer rdb.Close()
+	if v, err := rdb.Get([]byte("logged"), nil); err != nil || string(v) != "v" {
+		t.Fatalf("Get logged: got %q, %v", v, err)
+	}
+	if _, err := rdb.Get([]byte("bulk0000"), nil); err != ErrNotFound {
+		t.Fatalf("Get bulk0000: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestDB_DisableWALSubscribed(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	sub, err := h.db.Subscribe(1)
+	if err != nil {
+		t.Fatal("Subscribe: got error: ", err)
+	}
+	// 订阅者读不到不写日志的数据，直接拒绝。
+	if err := h.db.Put([]byte("bulk0000"), []byte("v"), noWAL); err != ErrWALLessSubscribed {
+		t.Fatalf("Put: got %v, want %v", err, ErrWALLessSubscribed)
+	}
+	if err := h.db.Put_s([]byte("bulk0000"), []byte("v"), noWAL); err != ErrWALLessSubscribed {
+		t.Fatalf("Put_s: got %v, want %v", err, ErrWALLessSubscribed)
+	}
+	sub.Close()
+	if err := h.db.Put([]byte("bulk0000"), []byte("v"), noWAL); err != nil {
+		t.Fatal("Put after Close: got error: ", err)
+	}
+	h.getVal("bulk0000", "v")
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -9,6 +9,7 @@ package leveldb
 import (
 	"fmt"
 	//"log"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -29,8 +30,10 @@ func (db *DB) writeJournal(batches []*Batch, seq uint64, sync bool) error {
 	if err := db.journal.Flush(); err != nil { //？
 		return err
 	}
+	db.journalWritten(opt.KeyspaceMain, batches)
+	db.journalLast[opt.KeyspaceMain] = seq + uint64(batchesLen(batches)) - 1
 	if sync {
-		return db.journalWriter.Sync() //？
+		return db.syncJournal(opt.KeyspaceMain, db.journalWriter)
 	}
 	return nil
 }
@@ -47,12 +50,107 @@ func (db *DB) writeJournal_s(batches []*Batch, seq uint64, sync bool) error {
 	if err := db.journal2.Flush(); err != nil {
 		return err
 	}
+	db.journalWritten(opt.KeyspaceState, batches)
+	db.journalLast[opt.KeyspaceState] = seq + uint64(batchesLen(batches)) - 1
 	if sync {
-		return db.journalWriter2.Sync()
+		return db.syncJournal(opt.KeyspaceState, db.journalWriter2)
 	}
 	return nil
 }
 
+// putMemConcurrent inserts the merged batches into a concurrent memdb in
+// parallel, each at its own sequence number starting at seq. The batches
+// are already in the journal, so the order of the inserts doesn't matter.
+func putMemConcurrent(batches []*Batch, seq uint64, put func(batch *Batch, seq uint64) error) {
+	var wg sync.WaitGroup
+	// 第一个批次留给当前 goroutine。
+	first := seq
+	seq += uint64(batches[0].Len())
+	for _, batch := range batches[1:] {
+		wg.Add(1)
+		go func(batch *Batch, seq uint64) {
+			defer wg.Done()
+			if err := put(batch, seq); err != nil {
+				panic(err)
+			}
+		}(batch, seq)
+		seq += uint64(batch.Len())
+	}
+	if err := put(batches[0], first); err != nil {
+		panic(err)
+	}
+	wg.Wait()
+}
+
+// memWriters tracks the write groups between reserving their seqs, with
+// the write lock held, and publishing them. A group inserting into a
+// concurrent memdb hands the write lock on before inserting, so groups of
+// independent writers insert in parallel; their seqs are still published
+// in order.
+type memWriters struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	n    int    // 在途的写入组
+	last uint64 // 最后一个分配出去的 seq，n > 0 时有效
+}
+
+func newMemWriters() *memWriters {
+	w := &memWriters{}
+	w.cond.L = &w.mu
+	return w
+}
+
+// next returns the first seq a write group would get, given the published
+// seq. The caller must hold the write lock.
+func (w *memWriters) next(seq uint64) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.n > 0 {
+		return w.last + 1
+	}
+	return seq + 1
+}
+
+// reserve marks n seqs from seq, got from next, as being written. The
+// caller must hold the write lock.
+func (w *memWriters) reserve(seq, n uint64) {
+	w.mu.Lock()
+	w.n++
+	w.last = seq + n - 1
+	w.mu.Unlock()
+}
+
+// publish waits for the write groups before the one at seq to publish,
+// then publishes its n seqs.
+func (w *memWriters) publish(db *DB, seq, n uint64) {
+	w.mu.Lock()
+	for db.getSeq() != seq-1 {
+		w.cond.Wait()
+	}
+	db.addSeq(n)
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// done ends a write group once its merged writes are acked.
+func (w *memWriters) done() {
+	w.mu.Lock()
+	w.n--
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// wait waits until every write group is done, so that db.seq is the last
+// seq written and every memdb holds all its records. The caller must hold
+// the write lock, so no group can start meanwhile.
+func (w *memWriters) wait() {
+	w.mu.Lock()
+	for w.n > 0 {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+}
+
 func (db *DB) rotateMem(n int, wait bool) (mem *memDB, err error) {
 	//fmt.Print("Mem空间不足")
 	retryLimit := 3
@@ -145,8 +243,8 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) { //n为batch.in
 				mdb = nil
 			}
 		}()
-		tLen := db.s.tLen(0) //?
-		mdbFree = mdb.Free() //空闲的memdb大小 cap（kvdata）-len（kvdata）
+		tLen := db.s.l0Runs() //?
+		mdbFree = mdb.Free()  //空闲的memdb大小 cap（kvdata）-len（kvdata）
 		switch {
 		case tLen >= slowdownTrigger && !delayed:
 			//	fmt.Print(" case 1 ")
@@ -160,9 +258,12 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) { //n为batch.in
 			delayed = true
 			// Set the write paused flag explicitly.
 			atomic.StoreInt32(&db.inWritePaused, 1)
+			db.s.o.EventListener.WriteStallBegin(opt.WriteStallInfo{Keyspace: opt.KeyspaceMain})
+			stallStart := time.Now()
 			err = db.compTriggerWait(db.tcompCmdC)
 			// Unset the write paused flag.
 			atomic.StoreInt32(&db.inWritePaused, 0)
+			db.s.o.EventListener.WriteStallEnd(opt.WriteStallInfo{Keyspace: opt.KeyspaceMain, Duration: time.Since(stallStart)})
 			if err != nil {
 				return false
 			}
@@ -229,7 +330,7 @@ func (db *DB) flush_s(n int) (mdb *memDB, mdbFree int, err error) { //此时，m
 				mdb = nil
 			}
 		}()
-		tLen := db.s.tLen_s(0)
+		tLen := db.s.l0Runs_s()
 		mdbFree = mdb.Free_s() //得到mem的大小
 		//fmt.Print(mdbFree)
 		switch {
@@ -245,9 +346,12 @@ func (db *DB) flush_s(n int) (mdb *memDB, mdbFree int, err error) { //此时，m
 			delayed = true
 			// Set the write paused flag explicitly.
 			atomic.StoreInt32(&db.inWritePaused, 1)
+			db.s.o.EventListener.WriteStallBegin(opt.WriteStallInfo{Keyspace: opt.KeyspaceState})
+			stallStart := time.Now()
 			err = db.compTriggerWait(db.tcompCmdCs)
 			// Unset the write paused flag.
 			atomic.StoreInt32(&db.inWritePaused, 0)
+			db.s.o.EventListener.WriteStallEnd(opt.WriteStallInfo{Keyspace: opt.KeyspaceState, Duration: time.Since(stallStart)})
 			if err != nil {
 				return false
 			}
@@ -299,19 +403,41 @@ func (db *DB) flush_s(n int) (mdb *memDB, mdbFree int, err error) { //此时，m
 
 // 写入合并
 type writeMerge struct {
+	ks         opt.Keyspace // 只合并同一个 keyspace 的写入
 	sync       bool
 	batch      *Batch
 	keyType    keyType
 	key, value []byte
 }
 
-func (db *DB) unlockWrite(overflow bool, merged int, err error) {
+func (db *DB) unlockWrite(ackC chan error, overflow bool, merged int, err error) {
+	db.ackWrite(ackC, merged, err)
+	db.releaseWrite(overflow)
+}
+
+// ackWrite reports err to the writes merged into the current one through
+// ackC, the channel handed to them with writeMergedC.
+func (db *DB) ackWrite(ackC chan error, merged int, err error) {
 	for i := 0; i < merged; i++ {
-		db.writeAckC <- err
+		ackC <- err
 	}
+}
+
+// groupAckC returns the channel the writes merged into a write group get
+// their ack from. A pipelined group acks after handing the write lock on,
+// so it gets its own channel; otherwise the writes merged into the next
+// group could take its acks.
+func (db *DB) groupAckC(pipelined bool) chan error {
+	if pipelined {
+		return make(chan error)
+	}
+	return db.writeAckC
+}
+
+func (db *DB) releaseWrite(overflow bool) {
 	if overflow {
 		// Pass lock to the next write (that failed to merge).
-		db.writeMergedC <- false
+		db.writeMergedC <- nil
 	} else {
 		// Release lock.
 		<-db.writeLockC
@@ -338,7 +464,7 @@ func (db *DB) PrintTime() {
 	fmt.Println("CompactionTime", TcountCom, TcountCom2)
 }
 
-func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
+func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync, noWAL bool) error {
 	// Try to flush memdb. This method would also trying to throttle writes
 	// if it is too fast and compaction cannot catch-up.
 	//1.尝试flush db的数据 如果有需要
@@ -352,14 +478,21 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 	*/
 
 	if err != nil {
-		db.unlockWrite(false, 0, err)
+		db.unlockWrite(db.writeAckC, false, 0, err)
 		return err
 	}
 	defer mdb.decref() //释放当前引用数量
+	sync = sync || db.syncEveryWrite(opt.KeyspaceMain)
+
+	// 并发 memdb 不用轮换的话，写日志之后就把写锁交出去，
+	// 下一组写入可以同时写日志、插入 memdb。
+	pipelined := mdb.DB.Concurrent() && batch.internalLen < mdbFree
+	ackC := db.groupAckC(pipelined)
 
 	var (
 		overflow bool
 		merged   int
+		grouped  bool
 		batches  = []*Batch{batch} //data、index、internallen
 	)
 
@@ -381,6 +514,11 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 		for mergeLimit > 0 {
 			select {
 			case incoming := <-db.writeMergeC:
+				if incoming.ks != opt.KeyspaceMain {
+					// 另一个 keyspace 的写入不能合并进来，把写锁交给它。
+					overflow = true
+					break merge
+				}
 				if incoming.batch != nil { //writeMergeC 中存储的是batch的情况
 					// Merge batch.
 					if incoming.batch.internalLen > mergeLimit {
@@ -410,9 +548,18 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 				}
 				sync = sync || incoming.sync //同步的情况需要通知写入的等待线程写入完毕
 				merged++
-				db.writeMergedC <- true
+				db.writeMergedC <- ackC
 
 			default:
+				// 组提交：已经有写入合并进来，说明还有并发的写，
+				// 等一会儿让更多写入赶上同一次 fsync。
+				if sync && merged > 0 && !grouped {
+					if delay := db.s.o.GetJournalGroupCommitDelay(); delay > 0 {
+						grouped = true
+						time.Sleep(delay)
+						continue
+					}
+				}
 				break merge
 			}
 		}
@@ -424,38 +571,70 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 	}
 
 	// Seq number.
-	seq := db.seq + 1 ///seq是实际batch的数量编号, 此时db的实际seq并未更新
+	seq := db.memw.next(db.getSeq()) ///seq是实际batch的数量编号, 此时db的实际seq并未更新
 
 	// Write journal.
 	// 2.batch中的信息写入日志，调用db.writeJournal
 	t1 := time.Now()
-	if err := db.writeJournal(batches, seq, sync); err != nil {
-		db.unlockWrite(overflow, merged, err)
+	if noWAL {
+		// 不写日志，先在 manifest 里留下标记。
+		if err := db.markWALLess(opt.KeyspaceMain); err != nil {
+			db.unlockWrite(ackC, overflow, merged, err)
+			return err
+		}
+	} else if err := db.writeJournal(batches, seq, sync); err != nil {
+		db.unlockWrite(ackC, overflow, merged, err)
 		return err
 	}
 	t2 := time.Now()
 	t3 := t2.Sub(t1).Seconds()
 	TcountPutJou += t3
 
+	n := uint64(batchesLen(batches))
+	db.memw.reserve(seq, n)
+	db.publishChanges(opt.KeyspaceMain, batches, seq)
+	db.budget.add(opt.KeyspaceMain, batches)
+
+	if pipelined {
+		db.releaseWrite(overflow)
+	}
+
 	// Put batches.
 	//3. 遍历batches，把batch 数据写入内存数据库 mendb
 	//fmt.Println("准备写进内存")
 	t4 := time.Now()
-	for _, batch := range batches {
-		//putMem就是给key加上internal，然后调用mdb.put插入mem
-		//putMem定义于batch.go,此方法调用mdb中的put，把kv对插入到skip list
-		//mdb是*memDB的实例，可以直接调用Package memdb中的成员
-		if err := batch.putMem(seq, mdb.DB); err != nil {
-			panic(err)
+	if len(batches) > 1 && mdb.DB.Concurrent() {
+		putMemConcurrent(batches, seq, func(batch *Batch, seq uint64) error {
+			return batch.putMem(seq, mdb.DB)
+		})
+		seq += uint64(batchesLen(batches))
+	} else {
+		for _, batch := range batches {
+			//putMem就是给key加上internal，然后调用mdb.put插入mem
+			//putMem定义于batch.go,此方法调用mdb中的put，把kv对插入到skip list
+			//mdb是*memDB的实例，可以直接调用Package memdb中的成员
+			if err := batch.putMem(seq, mdb.DB); err != nil {
+				panic(err)
+			}
+			seq += uint64(batch.Len())
 		}
-		seq += uint64(batch.Len())
 	}
 	t5 := time.Now()
 	t6 := t5.Sub(t4).Seconds()
-	TcountPutMem += t6
+	// 交出写锁之后没有锁保护 TcountPutMem，不计时。
+	if !pipelined {
+		TcountPutMem += t6
+	}
 
 	// Incr seq number.更新seq
-	db.addSeq(uint64(batchesLen(batches)))
+	db.memw.publish(db, seq-n, n)
+	if pipelined {
+		db.memdbSpilled(mdb.Free())
+		db.ackWrite(ackC, merged, nil)
+		db.memw.done()
+		return nil
+	}
+	db.memw.done()
 
 	// Rotate memdb if it's reach the threshold.
 	///如果memory不够写batch的内容，调用rotateMem，
@@ -477,13 +656,13 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 	//	}
 	//}
 
-	db.unlockWrite(overflow, merged, nil)
+	db.unlockWrite(ackC, overflow, merged, nil)
 	//fmt.Println("写入完毕，return")
 	return nil
 }
 
 // 改动为，把flush的返回值改成了mem_s
-func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
+func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync, noWAL bool) error {
 	//fmt.Println("writeLocked_s程序启动，准备调用flush")
 	// Try to flush memdb. This method would also trying to throttle writes
 	// if it is too fast and compaction cannot catch-up.
@@ -491,14 +670,20 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 	// 返回DB的mdb以及mdb的剩余空间，如果mdbFree不够则会对mdb进行扩容操作
 	mdb, mdbFree, err := db.flush_s(batch.internalLen) //这个mdb可以调用好多方法 .db和*memdb.db？
 	if err != nil {
-		db.unlockWrite(false, 0, err)
+		db.unlockWrite(db.writeAckC, false, 0, err)
 		return err
 	}
 	defer mdb.decref_s() //释放当前引用数量
+	sync = sync || db.syncEveryWrite(opt.KeyspaceState)
+
+	// 同 writeLocked，并发 memdb 插入之前先交出写锁。
+	pipelined := mdb.Table_s.Concurrent_s() && batch.internalLen < mdbFree
+	ackC := db.groupAckC(pipelined)
 
 	var (
 		overflow bool
 		merged   int
+		grouped  bool
 		batches  = []*Batch{batch}
 	)
 	//fmt.Println("准备执行merge")
@@ -520,6 +705,10 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 		for mergeLimit > 0 {
 			select {
 			case incoming := <-db.writeMergeC:
+				if incoming.ks != opt.KeyspaceState {
+					overflow = true
+					break merge
+				}
 				if incoming.batch != nil {
 					// Merge batch.
 					if incoming.batch.internalLen > mergeLimit {
@@ -547,9 +736,18 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 				}
 				sync = sync || incoming.sync
 				merged++
-				db.writeMergedC <- true
+				db.writeMergedC <- ackC
 
 			default:
+				// 组提交：已经有写入合并进来，说明还有并发的写，
+				// 等一会儿让更多写入赶上同一次 fsync。
+				if sync && merged > 0 && !grouped {
+					if delay := db.s.o.GetJournalGroupCommitDelay(); delay > 0 {
+						grouped = true
+						time.Sleep(delay)
+						continue
+					}
+				}
 				break merge
 			}
 		}
@@ -560,33 +758,64 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 	}
 
 	// Seq number.
-	seq := db.seq + 1 ///seq是实际batch的数量编号, 此时db的实际seq并未更新
+	seq := db.memw.next(db.getSeq()) ///seq是实际batch的数量编号, 此时db的实际seq并未更新
 
 	//2.batch中的信息写入日志
 	t1 := time.Now()
-	if err := db.writeJournal_s(batches, seq, sync); err != nil {
-		db.unlockWrite(overflow, merged, err)
+	if noWAL {
+		if err := db.markWALLess(opt.KeyspaceState); err != nil {
+			db.unlockWrite(ackC, overflow, merged, err)
+			return err
+		}
+	} else if err := db.writeJournal_s(batches, seq, sync); err != nil {
+		db.unlockWrite(ackC, overflow, merged, err)
 		return err
 	}
 	t2 := time.Now()
 	t3 := t2.Sub(t1).Seconds()
 	TcountPutJou += t3
 
+	n := uint64(batchesLen(batches))
+	db.memw.reserve(seq, n)
+	db.publishChanges(opt.KeyspaceState, batches, seq)
+	db.budget.add(opt.KeyspaceState, batches)
+
+	if pipelined {
+		db.releaseWrite(overflow)
+	}
+
 	//3. batch 数据写入内存数据库 mendb ,遍历batches
 	//putMem就是给key加上internal，然后调用mdb.put插入mem ,
 	t4 := time.Now()
-	for _, batch := range batches {
-		//这里mem.DB是内存数据库*memdb.DB,而mdb.db.mem_s是*memDB类型
-		if err := batch.putMem_s(seq, mdb.DBs); err != nil {
-			panic(err)
+	if len(batches) > 1 && mdb.Table_s.Concurrent_s() {
+		putMemConcurrent(batches, seq, func(batch *Batch, seq uint64) error {
+			return batch.putMem_s(seq, mdb.Table_s)
+		})
+		seq += uint64(batchesLen(batches))
+	} else {
+		for _, batch := range batches {
+			//这里mem.DB是内存数据库*memdb.DB,而mdb.db.mem_s是*memDB类型
+			if err := batch.putMem_s(seq, mdb.Table_s); err != nil {
+				panic(err)
+			}
+			seq += uint64(batch.Len())
 		}
-		seq += uint64(batch.Len())
 	}
 	t5 := time.Now()
 	t6 := t5.Sub(t4).Seconds()
-	TcountPutMem += t6
+	// 交出写锁之后没有锁保护 TcountPutMem，不计时。
+	if !pipelined {
+		TcountPutMem += t6
+	}
 	// Incr seq number.更新seq
-	db.addSeq(uint64(batchesLen(batches)))
+	db.memw.publish(db, seq-n, n)
+	if pipelined {
+		db.memdbSpilled(mdb.Free_s())
+		db.ackWrite(ackC, merged, nil)
+		db.memw.done()
+		return nil
+	}
+	db.memw.done()
 
 	// Rotate memdb if it's reach the threshold.,这里的mdfree就是开头flush得到的，所以实际上插入之后mdfree应该没有了
 	//fmt.Print("PAY ATTENTION!",batch.internalLen,mdbFree)
@@ -594,7 +823,7 @@ func (db *DB) writeLocked_s(batch, ourBatch *Batch, merge, sync bool) error {
 		//fmt.Println("为什么不执行阿")
 		db.rotateMem_s(0, false)
 	}
-	db.unlockWrite(overflow, merged, nil)
+	db.unlockWrite(ackC, overflow, merged, nil)
 	//fmt.Println("return，一次写过程调用完成")
 	//fmt.Println("  Write Success， return")
 	return nil
@@ -612,6 +841,9 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 	if err := db.ok(); err != nil || batch == nil || batch.Len() == 0 {
 		return err
 	}
+	if batch.hasMerge() && db.s.o.GetMergeOperator() == nil {
+		return ErrNoMergeOperator
+	}
 	//如果批处理大小大于写缓冲区，则可以使用事务进行写。使用事务将批处理直接写入表中，跳过日志记录。
 	if batch.internalLen > db.s.o.GetWriteBuffer() && !db.s.o.GetDisableLargeBatchTransaction() {
 		tr, err := db.OpenTransaction()
@@ -625,16 +857,18 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 		return tr.Commit()
 	}
 
-	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
+	// WAL-less writes are never merged, see opt.WriteOptions.DisableWAL.
+	noWAL := wo.GetDisableWAL()
+	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge() && !noWAL
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
 
 	// Acquire write lock.
 	if merge {
 		select {
-		case db.writeMergeC <- writeMerge{sync: sync, batch: batch}:
-			if <-db.writeMergedC {
+		case db.writeMergeC <- writeMerge{ks: opt.KeyspaceMain, sync: sync, batch: batch}:
+			if ackC := <-db.writeMergedC; ackC != nil {
 				// Write is merged.
-				return <-db.writeAckC
+				return <-ackC
 			}
 			// Write is not merged, the write lock is handed to us. Continue.
 		case db.writeLockC <- struct{}{}:
@@ -659,12 +893,15 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 		}
 	}
 
-	return db.writeLocked(batch, nil, merge, sync)
+	return db.writeLocked(batch, nil, merge, sync, noWAL)
 }
 func (db *DB) Write_s(batch *Batch, wo *opt.WriteOptions) error {
 	if err := db.ok(); err != nil || batch == nil || batch.Len() == 0 {
 		return err
 	}
+	if batch.hasMerge() && db.s.o.GetMergeOperator() == nil {
+		return ErrNoMergeOperator
+	}
 	//如果批处理大小大于写缓冲区，则可以使用事务进行写。使用事务将批处理直接写入表中，跳过日志记录。
 	if batch.internalLen > db.s.o.GetWriteBuffer2() && !db.s.o.GetDisableLargeBatchTransaction() {
 		tr, err := db.OpenTransaction()
@@ -678,16 +915,18 @@ func (db *DB) Write_s(batch *Batch, wo *opt.WriteOptions) error {
 		return tr.Commit_s()
 	}
 
-	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
+	// WAL-less writes are never merged, see opt.WriteOptions.DisableWAL.
+	noWAL := wo.GetDisableWAL()
+	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge() && !noWAL
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
 
 	// Acquire write lock.
 	if merge {
 		select {
-		case db.writeMergeC <- writeMerge{sync: sync, batch: batch}:
-			if <-db.writeMergedC {
+		case db.writeMergeC <- writeMerge{ks: opt.KeyspaceState, sync: sync, batch: batch}:
+			if ackC := <-db.writeMergedC; ackC != nil {
 				// Write is merged.
-				return <-db.writeAckC
+				return <-ackC
 			}
 			// Write is not merged, the write lock is handed to us. Continue.
 		case db.writeLockC <- struct{}{}:
@@ -712,7 +951,7 @@ func (db *DB) Write_s(batch *Batch, wo *opt.WriteOptions) error {
 		}
 	}
 
-	return db.writeLocked_s(batch, nil, merge, sync)
+	return db.writeLocked_s(batch, nil, merge, sync, noWAL)
 }
 
 // 事务写的逻辑
@@ -731,7 +970,9 @@ func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error
 		return err
 	}
 	//merge 和sync 以数据库的初始化配置为主
-	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
+	// WAL-less writes are never merged, see opt.WriteOptions.DisableWAL.
+	noWAL := wo.GetDisableWAL()
+	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge() && !noWAL
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
 	//log.Println("OLD(putRec1):",OLD)
 	/*    [Added by czh]
@@ -741,12 +982,12 @@ func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error
 	if merge {
 		select {
 		//<-表示数据的流动方向，通过channel实现多线程的通信
-		case db.writeMergeC <- writeMerge{sync: sync, keyType: kt, key: key, value: value}:
+		case db.writeMergeC <- writeMerge{ks: opt.KeyspaceMain, sync: sync, keyType: kt, key: key, value: value}:
 			//如果能向writeMergeC 写入新插入的key value 数据
 			//则等待新的key value与老的数据进行merge操作
-			if <-db.writeMergedC {
+			if ackC := <-db.writeMergedC; ackC != nil {
 				// Write is merged.
-				return <-db.writeAckC
+				return <-ackC
 			}
 			// Write is not merged, the write lock is handed to us. Continue.
 		case db.writeLockC <- struct{}{}: //尝试获取写锁
@@ -779,7 +1020,7 @@ func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error
 	//batch的put和delete的实现，即往batch中写数据，然后准备往内存和日志中写
 	batch.appendRec(kt, key, value)
 	//log.Println("OLD(putRec3):",OLD)
-	return db.writeLocked(batch, batch, merge, sync)
+	return db.writeLocked(batch, batch, merge, sync, noWAL)
 }
 
 // Put_s调用的putRec_s
@@ -789,19 +1030,21 @@ func (db *DB) putRec_s(kt keyType, key, value []byte, wo *opt.WriteOptions) erro
 		return err
 	}
 	//merge 和sync 以数据库的初始化配置为主
-	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
+	// WAL-less writes are never merged, see opt.WriteOptions.DisableWAL.
+	noWAL := wo.GetDisableWAL()
+	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge() && !noWAL
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
 	//fmt.Println("Process One")
 	// Acquire write lock.
 	if merge {
 		select {
 		//<-表示数据的流动方向，通过channel实现多线程的通信
-		case db.writeMergeC <- writeMerge{sync: sync, keyType: kt, key: key, value: value}:
+		case db.writeMergeC <- writeMerge{ks: opt.KeyspaceState, sync: sync, keyType: kt, key: key, value: value}:
 			//如果能向writeMergeC 写入新插入的key value 数据
 			//则等待新的key value与老的数据进行merge操作
-			if <-db.writeMergedC {
+			if ackC := <-db.writeMergedC; ackC != nil {
 				// Write is merged.
-				return <-db.writeAckC
+				return <-ackC
 			}
 			// Write is not merged, the write lock is handed to us. Continue.
 		case db.writeLockC <- struct{}{}: //尝试获取写锁
@@ -835,7 +1078,7 @@ func (db *DB) putRec_s(kt keyType, key, value []byte, wo *opt.WriteOptions) erro
 	//fmt.Println("Process Four")
 	batch.appendRec(kt, key, value)
 	//fmt.Println("准备启动writeLocked_s程序")
-	return db.writeLocked_s(batch, batch, merge, sync)
+	return db.writeLocked_s(batch, batch, merge, sync, noWAL)
 }
 
 // Put sets the value for the given key. It overwrites any previous value
@@ -871,6 +1114,28 @@ func (db *DB) Delete(key []byte, wo *opt.WriteOptions) error {
 	return db.putRec(keyTypeDel, key, nil, wo)
 }
 
+// Merge appends the given operand to the value of the given key. The operand
+// is combined with the existing value by Options.MergeOperator lazily, i.e.
+// when the key is read or compacted. Merge returns ErrNoMergeOperator if no
+// merge operator is defined. Write merge also applies for Merge, see Write.
+//
+// It is safe to modify the contents of the arguments after Merge returns but
+// not before.
+func (db *DB) Merge(key, operand []byte, wo *opt.WriteOptions) error {
+	if db.s.o.GetMergeOperator() == nil {
+		return ErrNoMergeOperator
+	}
+	return db.putRec(keyTypeMerge, key, operand, wo)
+}
+
+// Merge_s是Merge在状态数据上的版本
+func (db *DB) Merge_s(key, operand []byte, wo *opt.WriteOptions) error {
+	if db.s.o.GetMergeOperator() == nil {
+		return ErrNoMergeOperator
+	}
+	return db.putRec_s(keyTypeMerge, key, operand, wo)
+}
+
 func isMemOverlaps(icmp *iComparer, mem *memdb.DB, min, max []byte) bool {
 	iter := mem.NewIterator(nil)
 	defer iter.Release()
@@ -902,6 +1167,7 @@ func (db *DB) CompactRange(r util.Range) error {
 	}
 
 	// Check for overlaps in memdb.
+	db.memw.wait()
 	mdb := db.getEffectiveMem()
 	if mdb == nil {
 		return ErrClosed
@@ -939,6 +1205,7 @@ func (db *DB) CompactRange_s(r util.Range) error {
 	}
 
 	// Check for overlaps in memdb.
+	db.memw.wait()
 	mdb := db.getEffectiveMem_s()
 	if mdb == nil {
 		return ErrClosed
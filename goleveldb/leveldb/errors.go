@@ -17,4 +17,11 @@ var (
 	ErrSnapshotReleased = errors.New("leveldb: snapshot released")
 	ErrIterReleased     = errors.New("leveldb: iterator released")
 	ErrClosed           = errors.New("leveldb: closed")
+	ErrNoMergeOperator  = errors.New("leveldb: merge operator not set")
+	ErrNotSecondary     = errors.New("leveldb: not a secondary instance")
+
+	ErrChangesUnavailable = errors.New("leveldb: changes no longer retained")
+	ErrSubscriberLagged   = errors.New("leveldb: subscriber fell behind")
+	ErrReplicationGap     = errors.New("leveldb: replicated record out of order")
+	ErrWALLessSubscribed  = errors.New("leveldb: write without journal while subscribed")
 )
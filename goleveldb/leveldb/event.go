@@ -0,0 +1,118 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// newEventListener returns a copy of l with nil callbacks replaced by no-op
+// ones, so the callbacks can be invoked unconditionally.
+func newEventListener(l *opt.EventListener) *opt.EventListener {
+	nl := &opt.EventListener{}
+	if l != nil {
+		*nl = *l
+	}
+	if nl.FlushBegin == nil {
+		nl.FlushBegin = func(opt.FlushInfo) {}
+	}
+	if nl.FlushEnd == nil {
+		nl.FlushEnd = func(opt.FlushInfo) {}
+	}
+	if nl.CompactionBegin == nil {
+		nl.CompactionBegin = func(opt.CompactionInfo) {}
+	}
+	if nl.CompactionEnd == nil {
+		nl.CompactionEnd = func(opt.CompactionInfo) {}
+	}
+	if nl.WriteStallBegin == nil {
+		nl.WriteStallBegin = func(opt.WriteStallInfo) {}
+	}
+	if nl.WriteStallEnd == nil {
+		nl.WriteStallEnd = func(opt.WriteStallInfo) {}
+	}
+	if nl.TableCreated == nil {
+		nl.TableCreated = func(opt.TableInfo) {}
+	}
+	if nl.TableDeleted == nil {
+		nl.TableDeleted = func(opt.TableInfo) {}
+	}
+	if nl.ManifestCreated == nil {
+		nl.ManifestCreated = func(opt.ManifestInfo) {}
+	}
+	return nl
+}
+
+// abandonErr turns the value a flush or compaction panicked with into the
+// error reported by its end event.
+func abandonErr(x interface{}) error {
+	if x == errCompactionTransactExiting {
+		return ErrClosed
+	}
+	if err, ok := x.(error); ok {
+		return err
+	}
+	return fmt.Errorf("leveldb: %v", x)
+}
+
+// endFlushOnPanic emits FlushEnd with the error if the flush panics before
+// it reported its end; it must be deferred.
+func (db *DB) endFlushOnPanic(info *opt.FlushInfo, ended *bool) {
+	if x := recover(); x != nil {
+		if !*ended {
+			info.Err = abandonErr(x)
+			db.s.o.EventListener.FlushEnd(*info)
+		}
+		panic(x)
+	}
+}
+
+// endCompactionOnPanic is endFlushOnPanic for table compactions.
+func (db *DB) endCompactionOnPanic(info *opt.CompactionInfo, ended *bool) {
+	if x := recover(); x != nil {
+		if !*ended {
+			info.Err = abandonErr(x)
+			db.s.o.EventListener.CompactionEnd(*info)
+		}
+		panic(x)
+	}
+}
+
+// removeTable removes the table file num of ks that no version refers to,
+// and reports it.
+func (s *session) removeTable(ks opt.Keyspace, num int64) error {
+	if err := s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: num}); err != nil {
+		return err
+	}
+	s.o.EventListener.TableDeleted(opt.TableInfo{Keyspace: ks, Level: -1, Num: num})
+	return nil
+}
+
+func atRecordsInfo(ks opt.Keyspace, recs []atRecord) (infos []opt.TableInfo, size int64) {
+	for _, r := range recs {
+		infos = append(infos, opt.TableInfo{Keyspace: ks, Level: r.level, Num: r.num, Size: r.size})
+		size += r.size
+	}
+	return
+}
+
+func tFilesInfo(level int, tables tFiles) (infos []opt.TableInfo) {
+	for _, t := range tables {
+		infos = append(infos, opt.TableInfo{Keyspace: opt.KeyspaceMain, Level: level, Num: t.fd.Num, Size: t.size})
+	}
+	return
+}
+
+func sFilesInfo(level int, tables sFiles) (infos []opt.TableInfo) {
+	for _, t := range tables {
+		infos = append(infos, opt.TableInfo{Keyspace: opt.KeyspaceState, Level: level, Num: t.fd.Num, Size: t.size})
+	}
+	return
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/testutil"
+)
+
+type testEventRecorder struct {
+	mu          sync.Mutex
+	flushes     []opt.FlushInfo
+	compactions []opt.CompactionInfo
+	created     []opt.TableInfo
+	deleted     []opt.TableInfo
+	manifests   []opt.ManifestInfo
+	begins      int
+}
+
+func (r *testEventRecorder) listener() *opt.EventListener {
+	return &opt.EventListener{
+		FlushBegin: func(opt.FlushInfo) {
+			r.mu.Lock()
+			r.begins++
+			r.mu.Unlock()
+		},
+		FlushEnd: func(info opt.FlushInfo) {
+			r.mu.Lock()
+			r.flushes = append(r.flushes, info)
+			r.mu.Unlock()
+		},
+		CompactionEnd: func(info opt.CompactionInfo) {
+			r.mu.Lock()
+			r.compactions = append(r.compactions, info)
+			r.mu.Unlock()
+		},
+		TableCreated: func(info opt.TableInfo) {
+			r.mu.Lock()
+			r.created = append(r.created, info)
+			r.mu.Unlock()
+		},
+		TableDeleted: func(info opt.TableInfo) {
+			r.mu.Lock()
+			r.deleted = append(r.deleted, info)
+			r.mu.Unlock()
+		},
+		ManifestCreated: func(info opt.ManifestInfo) {
+			r.mu.Lock()
+			r.manifests = append(r.manifests, info)
+			r.mu.Unlock()
+		},
+	}
+}
+
+func TestDB_EventListener(t *testing.T) {
+	r := &testEventRecorder{}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		EventListener:                r.listener(),
+	})
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.put("bar", "v1")
+	h.compactMem()
+	h.put("foo", "v2")
+	h.compactMem()
+	h.compactRange("", "")
+
+	if err := h.db.Put_s([]byte("state"), []byte("v1"), nil); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem_s(0, true)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.manifests) == 0 {
+		t.Fatalf("ManifestCreated: got %v", r.manifests)
+	}
+	if r.begins != len(r.flushes) {
+		t.Fatalf("FlushBegin called %d times, FlushEnd %d times", r.begins, len(r.flushes))
+	}
+	var mainFlushes, stateFlushes int
+	for _, f := range r.flushes {
+		if len(f.Output) != 1 || f.Output[0].Size == 0 {
+			t.Fatalf("FlushEnd: unexpected output %v", f.Output)
+		}
+		switch f.Keyspace {
+		case opt.KeyspaceMain:
+			mainFlushes++
+		case opt.KeyspaceState:
+			stateFlushes++
+		}
+	}
+	if mainFlushes < 2 || stateFlushes < 1 {
+		t.Fatalf("FlushEnd: got %d main and %d state flushes", mainFlushes, stateFlushes)
+	}
+	var merged bool
+	for _, c := range r.compactions {
+		if c.Keyspace == opt.KeyspaceMain && !c.Trivial && len(c.Input) >= 2 && len(c.Output) > 0 {
+			merged = true
+		}
+	}
+	if !merged {
+		t.Fatalf("CompactionEnd: no main compaction merging tables in %v", r.compactions)
+	}
+	if len(r.created) < len(r.flushes) {
+		t.Fatalf("TableCreated: got %d events for %d flushes", len(r.created), len(r.flushes))
+	}
+}
+
+func TestDB_EventListenerAbandoned(t *testing.T) {
+	r := &testEventRecorder{}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		EventListener:                r.listener(),
+	})
+	defer h.close()
+
+	// 写不出表，flush 一直重试，关库时放弃。
+	h.stor.EmulateError(testutil.ModeCreate, storage.TypeTable, errors.New("create failed"))
+	h.put("key001", "v1")
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem(0, false)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("rotateMem: got error: ", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		r.mu.Lock()
+		begins := r.begins
+		r.mu.Unlock()
+		if begins > 0 {
+			break
+		}
+		h.db.compTrigger(h.db.mcompCmdC)
+		if time.Now().After(deadline) {
+			t.Fatal("FlushBegin not called")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	h.closeDB0()
+	h.db = nil
+
+	r.mu.Lock()
+	if len(r.flushes) != 1 || r.flushes[0].Err != ErrClosed || len(r.flushes[0].Output) != 0 {
+		r.mu.Unlock()
+		t.Fatalf("FlushEnd: got %+v", r.flushes)
+	}
+	r.deleted = nil
+	r.mu.Unlock()
+
+	// 打开时清理掉的残留表也要报。
+	h.stor.EmulateError(testutil.ModeCreate, storage.TypeTable, nil)
+	w, err := h.stor.Create(storage.FileDesc{Type: storage.TypeTable, Num: 999})
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	w.Close()
+	h.openDB()
+	h.getVal("key001", "v1")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found bool
+	for _, info := range r.deleted {
+		found = found || info.Num == 999
+	}
+	if !found {
+		t.Fatalf("TableDeleted: got %v, want table 999", r.deleted)
+	}
+}
@@ -76,6 +76,13 @@
 // The wire format allows for limited recovery in the face of data corruption:
 // on a format error (such as a checksum mismatch), the reader moves to the
 // next block and looks for the next full or first chunk.
+//
+// Two flags may be OR'ed into the chunk type. compressedChunkFlag marks the
+// chunks of a journal whose payload is snappy compressed, see
+// Writer.SetCompression. recyclableChunkFlag marks a chunk whose header is
+// followed by the 4 byte little-endian number of the journal file, also
+// covered by the checksum, see Writer.SetNum. Chunks with another number are
+// leftovers of a recycled file and end the stream.
 package journal
 
 import (
@@ -83,6 +90,8 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/golang/snappy"
+
 	"awesomeProject1/goleveldb/leveldb/errors"
 	"awesomeProject1/goleveldb/leveldb/storage"
 	"awesomeProject1/goleveldb/leveldb/util"
@@ -94,6 +103,9 @@ const (
 	firstChunkType  = 2
 	middleChunkType = 3
 	lastChunkType   = 4
+
+	compressedChunkFlag = 0x80
+	recyclableChunkFlag = 0x40
 )
 
 // block单位为32KB
@@ -101,6 +113,8 @@ const (
 const (
 	blockSize  = 32 * 1024
 	headerSize = 7
+
+	recyclableHeaderSize = headerSize + 4
 )
 
 // minor compaction？
@@ -112,10 +126,12 @@ type flusher interface {
 type ErrCorrupted struct {
 	Size   int
 	Reason string
+	// Offset is the position of the dropped bytes in the stream.
+	Offset int64
 }
 
 func (e *ErrCorrupted) Error() string {
-	return fmt.Sprintf("leveldb/journal: block/chunk corrupted: %s (%d bytes)", e.Reason, e.Size)
+	return fmt.Sprintf("leveldb/journal: block/chunk corrupted: %s (%d bytes at offset %d)", e.Reason, e.Size, e.Offset)
 }
 
 // Dropper is the interface that wrap simple Drop method. The Drop
@@ -140,13 +156,27 @@ type Reader struct {
 	// buf[i:j] is the unread portion of the current chunk's payload.
 	// The low bound, i, excludes the chunk header.
 	i, j int
+	// pos is the position in buf of the current chunk's header.
+	pos int
 	// n is the number of bytes of buf that are valid. Once reading has started,
 	// only the final block can have n < blockSize.
 	n int //表示buf的size
 	// last is whether the current chunk is the last chunk of the journal.
 	last bool
+	// compressed is whether the current journal is compressed.
+	compressed bool
+	// num is the file number of recyclable chunks if hasNum; recycled is
+	// whether such a chunk was read.
+	num      uint32
+	hasNum   bool
+	recycled bool
 	// err is any accumulated error.
 	err error
+	// off is the stream offset of buf, recOff the offset of the first
+	// chunk of the current journal.
+	off, recOff int64
+	// rec and dec hold a compressed journal and its decoded content.
+	rec, dec []byte
 	// buf is the buffer.
 	buf [blockSize]byte
 }
@@ -164,59 +194,125 @@ func NewReader(r io.Reader, dropper Dropper, strict, checksum bool) *Reader {
 	}
 }
 
+// SetNum sets the number of the journal file being read. Recyclable chunks
+// with another number are leftovers of a recycled file. Without it the
+// number of the first recyclable chunk is taken. Reset clears it.
+func (r *Reader) SetNum(num int64) {
+	r.num = uint32(num)
+	r.hasNum = true
+}
+
 var errSkip = errors.New("leveldb/journal: skipped")
 
-func (r *Reader) corrupt(n int, reason string, skip bool) error {
+func (r *Reader) corrupt(pos, n int, reason string, skip bool) error {
+	off := r.off + int64(pos)
 	if r.dropper != nil {
-		r.dropper.Drop(&ErrCorrupted{n, reason})
+		r.dropper.Drop(&ErrCorrupted{n, reason, off})
 	}
 	if r.strict && !skip {
-		r.err = errors.NewErrCorrupted(storage.FileDesc{}, &ErrCorrupted{n, reason})
+		r.err = errors.NewErrCorrupted(storage.FileDesc{}, &ErrCorrupted{n, reason, off})
 		return r.err
 	}
 	return errSkip
 }
 
+// stale ends the stream at a chunk of a recycled file that was not written
+// by the current writer. Cutting a journal short is still a corruption.
+func (r *Reader) stale(pos int, first bool) error {
+	r.i = r.n
+	r.j = r.n
+	if !first {
+		return r.corrupt(pos, 0, "missing chunk part", false)
+	}
+	r.err = io.EOF
+	return r.err
+}
+
 // nextChunk sets r.buf[r.i:r.j] to hold the next chunk's payload, reading the
 // next block into the buffer if necessary.
 func (r *Reader) nextChunk(first bool) error {
 	for {
-		if r.j+headerSize <= r.n {
+		hdr := headerSize
+		if r.recycled {
+			hdr = recyclableHeaderSize
+		}
+		if r.j+hdr <= r.n {
+			pos := r.j
 			checksum := binary.LittleEndian.Uint32(r.buf[r.j+0 : r.j+4])
 			length := binary.LittleEndian.Uint16(r.buf[r.j+4 : r.j+6])
 			chunkType := r.buf[r.j+6]
+			flags := chunkType & (compressedChunkFlag | recyclableChunkFlag)
+			chunkType &^= flags
 			unprocBlock := r.n - r.j
 			if checksum == 0 && length == 0 && chunkType == 0 {
+				if r.recycled {
+					return r.stale(pos, first)
+				}
 				// Drop entire block.
 				r.i = r.n
 				r.j = r.n
-				return r.corrupt(unprocBlock, "zero header", false)
+				return r.corrupt(pos, unprocBlock, "zero header", false)
 			}
 			if chunkType < fullChunkType || chunkType > lastChunkType {
+				if r.recycled {
+					return r.stale(pos, first)
+				}
 				// Drop entire block.
 				r.i = r.n
 				r.j = r.n
-				return r.corrupt(unprocBlock, fmt.Sprintf("invalid chunk type %#x", chunkType), false)
+				return r.corrupt(pos, unprocBlock, fmt.Sprintf("invalid chunk type %#x", chunkType), false)
+			}
+			if flags&recyclableChunkFlag != 0 {
+				if r.j+recyclableHeaderSize > r.n {
+					// Drop entire block.
+					r.i = r.n
+					r.j = r.n
+					return r.corrupt(pos, unprocBlock, "chunk header overflows block", false)
+				}
+				hdr = recyclableHeaderSize
+			} else if r.recycled {
+				return r.stale(pos, first)
 			}
-			r.i = r.j + headerSize
-			r.j = r.j + headerSize + int(length)
+			r.i = r.j + hdr
+			r.j = r.j + hdr + int(length)
 			if r.j > r.n {
+				if r.recycled {
+					return r.stale(pos, first)
+				}
 				// Drop entire block.
 				r.i = r.n
 				r.j = r.n
-				return r.corrupt(unprocBlock, "chunk length overflows block", false)
-			} else if r.checksum && checksum != util.NewCRC(r.buf[r.i-1:r.j]).Value() {
+				return r.corrupt(pos, unprocBlock, "chunk length overflows block", false)
+			} else if r.checksum && checksum != util.NewCRC(r.buf[pos+6:r.j]).Value() {
+				if r.recycled {
+					return r.stale(pos, first)
+				}
 				// Drop entire block.
 				r.i = r.n
 				r.j = r.n
-				return r.corrupt(unprocBlock, "checksum mismatch", false)
+				return r.corrupt(pos, unprocBlock, "checksum mismatch", false)
+			}
+			if flags&recyclableChunkFlag != 0 {
+				num := binary.LittleEndian.Uint32(r.buf[pos+7 : pos+11])
+				if !r.hasNum {
+					r.num = num
+					r.hasNum = true
+				}
+				if num != r.num {
+					return r.stale(pos, first)
+				}
+				r.recycled = true
 			}
 			if first && chunkType != fullChunkType && chunkType != firstChunkType {
-				chunkLength := (r.j - r.i) + headerSize
+				chunkLength := r.j - pos
 				r.i = r.j
 				// Report the error, but skip it.
-				return r.corrupt(chunkLength, "orphan chunk", true)
+				return r.corrupt(pos, chunkLength, "orphan chunk", true)
+			}
+			if first {
+				r.compressed = flags&compressedChunkFlag != 0
 			}
+			r.pos = pos
 			r.last = chunkType == fullChunkType || chunkType == lastChunkType
 			return nil
 		}
@@ -224,7 +320,7 @@ func (r *Reader) nextChunk(first bool) error {
 		// The last block.
 		if r.n < blockSize && r.n > 0 {
 			if !first {
-				return r.corrupt(0, "missing chunk part", false)
+				return r.corrupt(r.n, 0, "missing chunk part", false)
 			}
 			r.err = io.EOF
 			return r.err
@@ -237,11 +333,12 @@ func (r *Reader) nextChunk(first bool) error {
 		}
 		if n == 0 {
 			if !first {
-				return r.corrupt(0, "missing chunk part", false)
+				return r.corrupt(r.n, 0, "missing chunk part", false)
 			}
 			r.err = io.EOF
 			return r.err
 		}
+		r.off += int64(r.n)
 		r.i, r.j, r.n = 0, 0, n
 	}
 }
@@ -263,7 +360,50 @@ func (r *Reader) Next() (io.Reader, error) {
 			return nil, err
 		}
 	}
-	return &singleReader{r, r.seq, nil}, nil
+	r.recOff = r.off + int64(r.pos)
+	x := &singleReader{r: r, seq: r.seq}
+	if r.compressed {
+		x.err = r.decompress()
+		x.data = r.dec
+	}
+	return x, nil
+}
+
+// decompress reads the rest of the current journal and decodes it into
+// r.dec.
+func (r *Reader) decompress() error {
+	pos := r.pos
+	r.rec = append(r.rec[:0], r.buf[r.i:r.j]...)
+	r.i = r.j
+	for !r.last {
+		if err := r.nextChunk(false); err != nil {
+			if err == errSkip {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		r.rec = append(r.rec, r.buf[r.i:r.j]...)
+		r.i = r.j
+	}
+	n, err := snappy.DecodedLen(r.rec)
+	if err == nil {
+		if cap(r.dec) < n {
+			r.dec = make([]byte, n)
+		}
+		r.dec, err = snappy.Decode(r.dec[:cap(r.dec)], r.rec)
+	}
+	if err != nil {
+		if err := r.corrupt(pos, len(r.rec), "decompression failed", false); err != errSkip {
+			return err
+		}
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// Offset returns the stream offset of the journal last returned by Next.
+func (r *Reader) Offset() int64 {
+	return r.recOff
 }
 
 // Reset resets the journal reader, allows reuse of the journal reader. Reset returns
@@ -277,8 +417,14 @@ func (r *Reader) Reset(reader io.Reader, dropper Dropper, strict, checksum bool)
 	r.checksum = checksum
 	r.i = 0
 	r.j = 0
+	r.pos = 0
 	r.n = 0
+	r.off = 0
+	r.recOff = 0
 	r.last = true
+	r.compressed = false
+	r.hasNum = false
+	r.recycled = false
 	r.err = nil
 	return err
 }
@@ -289,6 +435,8 @@ type singleReader struct {
 	r   *Reader
 	seq int
 	err error
+	// data is the unread part of a decompressed journal.
+	data []byte
 }
 
 func (x *singleReader) Read(p []byte) (int, error) {
@@ -299,6 +447,14 @@ func (x *singleReader) Read(p []byte) (int, error) {
 	if x.err != nil {
 		return 0, x.err
 	}
+	if r.compressed {
+		if len(x.data) == 0 {
+			return 0, io.EOF
+		}
+		n := copy(p, x.data)
+		x.data = x.data[n:]
+		return n, nil
+	}
 	if r.err != nil {
 		return 0, r.err
 	}
@@ -327,6 +483,14 @@ func (x *singleReader) ReadByte() (byte, error) {
 	if x.err != nil {
 		return 0, x.err
 	}
+	if r.compressed {
+		if len(x.data) == 0 {
+			return 0, io.EOF
+		}
+		c := x.data[0]
+		x.data = x.data[1:]
+		return c, nil
+	}
 	if r.err != nil {
 		return 0, r.err
 	}
@@ -365,6 +529,15 @@ type Writer struct {
 	first bool
 	// pending is whether a chunk is buffered but not yet written.
 	pending bool
+	// compress is whether journals are compressed. rec buffers the current
+	// journal until it is finished, flag is OR'ed into its chunk types.
+	compress bool
+	rec      []byte
+	scratch  []byte
+	flag     byte
+	// recyclable is whether chunks carry num, the journal file number.
+	recyclable bool
+	num        uint32
 	// err is any accumulated error.
 	err error
 	// buf is the buffer.
@@ -387,6 +560,15 @@ type Writer2 struct {
 	first bool
 	// pending is whether a chunk is buffered but not yet written.
 	pending bool
+	// compress is whether journals are compressed. rec buffers the current
+	// journal until it is finished, flag is OR'ed into its chunk types.
+	compress bool
+	rec      []byte
+	scratch  []byte
+	flag     byte
+	// recyclable is whether chunks carry num, the journal file number.
+	recyclable bool
+	num        uint32
 	// err is any accumulated error.
 	err error
 	// buf is the buffer.
@@ -409,9 +591,43 @@ func NewWriter_s(w io.Writer) *Writer2 {
 	}
 }
 
+// SetCompression sets whether the following journals are snappy
+// compressed. A journal is only stored compressed if that makes it smaller.
+func (w *Writer) SetCompression(compress bool) {
+	w.compress = compress
+}
+func (w *Writer2) SetCompression(compress bool) {
+	w.compress = compress
+}
+
+// SetNum makes the writer write recyclable chunks carrying num, the number
+// of the journal file, so that readers tell where the content of a recycled
+// file ends. It must be called before the first journal; Reset clears it.
+func (w *Writer) SetNum(num int64) {
+	w.recyclable = true
+	w.num = uint32(num)
+}
+func (w *Writer2) SetNum(num int64) {
+	w.recyclable = true
+	w.num = uint32(num)
+}
+
+func (w *Writer) hdrSize() int {
+	if w.recyclable {
+		return recyclableHeaderSize
+	}
+	return headerSize
+}
+func (w *Writer2) hdrSize() int {
+	if w.recyclable {
+		return recyclableHeaderSize
+	}
+	return headerSize
+}
+
 // fillHeader fills in the header for the pending chunk.
 func (w *Writer) fillHeader(last bool) {
-	if w.i+headerSize > w.j || w.j > blockSize {
+	if w.i+w.hdrSize() > w.j || w.j > blockSize {
 		panic("leveldb/journal: bad writer state")
 	}
 	if last {
@@ -427,11 +643,16 @@ func (w *Writer) fillHeader(last bool) {
 			w.buf[w.i+6] = middleChunkType
 		}
 	}
+	w.buf[w.i+6] |= w.flag
+	if w.recyclable {
+		w.buf[w.i+6] |= recyclableChunkFlag
+		binary.LittleEndian.PutUint32(w.buf[w.i+7:w.i+11], w.num)
+	}
 	binary.LittleEndian.PutUint32(w.buf[w.i+0:w.i+4], util.NewCRC(w.buf[w.i+6:w.j]).Value())
-	binary.LittleEndian.PutUint16(w.buf[w.i+4:w.i+6], uint16(w.j-w.i-headerSize))
+	binary.LittleEndian.PutUint16(w.buf[w.i+4:w.i+6], uint16(w.j-w.i-w.hdrSize()))
 }
 func (w *Writer2) fillHeader(last bool) {
-	if w.i+headerSize > w.j || w.j > blockSize {
+	if w.i+w.hdrSize() > w.j || w.j > blockSize {
 		panic("leveldb/journal: bad writer state")
 	}
 	if last {
@@ -447,8 +668,13 @@ func (w *Writer2) fillHeader(last bool) {
 			w.buf[w.i+6] = middleChunkType
 		}
 	}
+	w.buf[w.i+6] |= w.flag
+	if w.recyclable {
+		w.buf[w.i+6] |= recyclableChunkFlag
+		binary.LittleEndian.PutUint32(w.buf[w.i+7:w.i+11], w.num)
+	}
 	binary.LittleEndian.PutUint32(w.buf[w.i+0:w.i+4], util.NewCRC(w.buf[w.i+6:w.j]).Value())
-	binary.LittleEndian.PutUint16(w.buf[w.i+4:w.i+6], uint16(w.j-w.i-headerSize))
+	binary.LittleEndian.PutUint16(w.buf[w.i+4:w.i+6], uint16(w.j-w.i-w.hdrSize()))
 }
 
 // writeBlock writes the buffered block to the underlying writer, and reserves
@@ -456,16 +682,89 @@ func (w *Writer2) fillHeader(last bool) {
 func (w *Writer) writeBlock() {
 	_, w.err = w.w.Write(w.buf[w.written:])
 	w.i = 0
-	w.j = headerSize
+	w.j = w.hdrSize()
 	w.written = 0
 }
 func (w *Writer2) writeBlock() {
 	_, w.err = w.w.Write(w.buf[w.written:])
 	w.i = 0
-	w.j = headerSize
+	w.j = w.hdrSize()
 	w.written = 0
 }
 
+// write copies p into the current journal, writing out full blocks.
+func (w *Writer) write(p []byte) {
+	for len(p) > 0 {
+		// Write a block, if it is full.
+		if w.j == blockSize {
+			w.fillHeader(false)
+			w.writeBlock()
+			if w.err != nil {
+				return
+			}
+			w.first = false
+		}
+		// Copy bytes into the buffer.
+		n := copy(w.buf[w.j:], p)
+		w.j += n
+		p = p[n:]
+	}
+}
+func (w *Writer2) write(p []byte) {
+	for len(p) > 0 {
+		// Write a block, if it is full.
+		if w.j == blockSize {
+			w.fillHeader(false)
+			w.writeBlock()
+			if w.err != nil {
+				return
+			}
+			w.first = false
+		}
+		// Copy bytes into the buffer.
+		n := copy(w.buf[w.j:], p)
+		w.j += n
+		p = p[n:]
+	}
+}
+
+// finish fills in the header of the last chunk of the current journal. A
+// compressed journal is only written into the buffer now.
+func (w *Writer) finish() {
+	if w.compress {
+		data := w.rec
+		if n := snappy.MaxEncodedLen(len(w.rec)); len(w.scratch) < n {
+			w.scratch = make([]byte, n)
+		}
+		if compressed := snappy.Encode(w.scratch, w.rec); len(compressed) < len(w.rec) {
+			data = compressed
+			w.flag = compressedChunkFlag
+		}
+		w.write(data)
+		if w.err != nil {
+			return
+		}
+	}
+	w.fillHeader(true)
+}
+func (w *Writer2) finish() {
+	if w.compress {
+		data := w.rec
+		if n := snappy.MaxEncodedLen(len(w.rec)); len(w.scratch) < n {
+			w.scratch = make([]byte, n)
+		}
+		if compressed := snappy.Encode(w.scratch, w.rec); len(compressed) < len(w.rec) {
+			data = compressed
+			w.flag = compressedChunkFlag
+		}
+		w.write(data)
+		if w.err != nil {
+			return
+		}
+	}
+	w.fillHeader(true)
+}
+
 // writePending finishes the current journal and writes the buffer to the
 // underlying writer.
 func (w *Writer) writePending() {
@@ -473,8 +772,11 @@ func (w *Writer) writePending() {
 		return
 	}
 	if w.pending {
-		w.fillHeader(true)
+		w.finish()
 		w.pending = false
+		if w.err != nil {
+			return
+		}
 	}
 	_, w.err = w.w.Write(w.buf[w.written:w.j])
 	w.written = w.j
@@ -484,8 +786,11 @@ func (w *Writer2) writePending() {
 		return
 	}
 	if w.pending {
-		w.fillHeader(true)
+		w.finish()
 		w.pending = false
+		if w.err != nil {
+			return
+		}
 	}
 	_, w.err = w.w.Write(w.buf[w.written:w.j])
 	w.written = w.j
@@ -553,6 +858,9 @@ func (w *Writer) Reset(writer io.Writer) (err error) {
 	w.written = 0
 	w.first = false
 	w.pending = false
+	w.rec = w.rec[:0]
+	w.flag = 0
+	w.recyclable = false
 	w.err = nil
 	return
 }
@@ -569,6 +877,9 @@ func (w *Writer2) Reset(writer io.Writer) (err error) {
 	w.written = 0
 	w.first = false
 	w.pending = false
+	w.rec = w.rec[:0]
+	w.flag = 0
+	w.recyclable = false
 	w.err = nil
 	return
 }
@@ -581,10 +892,13 @@ func (w *Writer) Next() (io.Writer, error) {
 		return nil, w.err
 	}
 	if w.pending {
-		w.fillHeader(true)
+		w.finish()
+		if w.err != nil {
+			return nil, w.err
+		}
 	}
 	w.i = w.j
-	w.j = w.j + headerSize
+	w.j = w.j + w.hdrSize()
 	// Check if there is room in the block for the header.
 	if w.j > blockSize {
 		// Fill in the rest of the block with zeroes.
@@ -598,6 +912,8 @@ func (w *Writer) Next() (io.Writer, error) {
 	}
 	w.first = true
 	w.pending = true
+	w.flag = 0
+	w.rec = w.rec[:0]
 	return singleWriter{w, w.seq}, nil
 }
 func (w *Writer2) Next() (io.Writer, error) {
@@ -606,10 +922,13 @@ func (w *Writer2) Next() (io.Writer, error) {
 		return nil, w.err
 	}
 	if w.pending {
-		w.fillHeader(true)
+		w.finish()
+		if w.err != nil {
+			return nil, w.err
+		}
 	}
 	w.i = w.j
-	w.j = w.j + headerSize
+	w.j = w.j + w.hdrSize()
 	// Check if there is room in the block for the header.
 	if w.j > blockSize {
 		// Fill in the rest of the block with zeroes.
@@ -623,6 +942,8 @@ func (w *Writer2) Next() (io.Writer, error) {
 	}
 	w.first = true
 	w.pending = true
+	w.flag = 0
+	w.rec = w.rec[:0]
 	return singleWriter2{w, w.seq}, nil
 }
 
@@ -646,20 +967,13 @@ func (x singleWriter) Write(p []byte) (int, error) {
 		return 0, w.err
 	}
 	n0 := len(p)
-	for len(p) > 0 {
-		// Write a block, if it is full.
-		if w.j == blockSize {
-			w.fillHeader(false)
-			w.writeBlock()
-			if w.err != nil {
-				return 0, w.err
-			}
-			w.first = false
-		}
-		// Copy bytes into the buffer.
-		n := copy(w.buf[w.j:], p)
-		w.j += n
-		p = p[n:]
+	if w.compress {
+		w.rec = append(w.rec, p...)
+		return n0, nil
+	}
+	w.write(p)
+	if w.err != nil {
+		return 0, w.err
 	}
 	return n0, nil
 }
@@ -672,20 +986,13 @@ func (x singleWriter2) Write(p []byte) (int, error) {
 		return 0, w.err
 	}
 	n0 := len(p)
-	for len(p) > 0 {
-		// Write a block, if it is full.
-		if w.j == blockSize {
-			w.fillHeader(false)
-			w.writeBlock()
-			if w.err != nil {
-				return 0, w.err
-			}
-			w.first = false
-		}
-		// Copy bytes into the buffer.
-		n := copy(w.buf[w.j:], p)
-		w.j += n
-		p = p[n:]
+	if w.compress {
+		w.rec = append(w.rec, p...)
+		return n0, nil
+	}
+	w.write(p)
+	if w.err != nil {
+		return 0, w.err
 	}
 	return n0, nil
 }
@@ -816,3 +816,192 @@ func TestCorrupt_MiddleChuckLengthOverflow(t *testing.T) {
 		t.Fatalf("last next: unexpected error: %v", err)
 	}
 }
+
+type offsetDropper struct {
+	offsets []int64
+}
+
+func (d *offsetDropper) Drop(err error) {
+	if e, ok := err.(*ErrCorrupted); ok {
+		d.offsets = append(d.offsets, e.Offset)
+	}
+}
+
+func TestOffset(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	sizes := []int{10, blockSize, 20, 30}
+	for i, n := range sizes {
+		ww, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ww.Write(bytes.Repeat([]byte{byte('a' + i)}, n)); err != nil {
+			t.Fatalf("write #%d: unexpected error: %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Record #1 spans two blocks, so #2 starts in the second block.
+	want := []int64{0, headerSize + 10, 2*headerSize + 10 + blockSize + headerSize, 0}
+	want[3] = want[2] + headerSize + 20
+	r := NewReader(bytes.NewReader(buf.Bytes()), dropper{t}, false, true)
+	for i := range sizes {
+		rr, err := r.Next()
+		if err != nil {
+			t.Fatalf("next #%d: unexpected error: %v", i, err)
+		}
+		if _, err := io.Copy(ioutil.Discard, rr); err != nil {
+			t.Fatalf("read #%d: %v", i, err)
+		}
+		if got := r.Offset(); got != want[i] {
+			t.Fatalf("offset #%d: got %d want %d", i, got, want[i])
+		}
+	}
+
+	// Corrupt record #3, the rest of the second block is dropped.
+	b := append([]byte(nil), buf.Bytes()...)
+	b[want[3]+headerSize] ^= 0xff
+	d := &offsetDropper{}
+	r = NewReader(bytes.NewReader(b), d, false, true)
+	for {
+		rr, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, rr)
+	}
+	if len(d.offsets) != 1 || d.offsets[0] != want[3] {
+		t.Fatalf("dropped offsets: got %v want [%d]", d.offsets, want[3])
+	}
+}
+
+func writeJournals(t *testing.T, w *Writer, s []string) {
+	for _, x := range s {
+		ww, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ww.Write([]byte(x)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readJournals(t *testing.T, r *Reader, want []string) {
+	for i, s := range want {
+		rr, err := r.Next()
+		if err != nil {
+			t.Fatalf("journal #%d: got %v", i, err)
+		}
+		x, err := ioutil.ReadAll(rr)
+		if err != nil {
+			t.Fatalf("journal #%d: got %v", i, err)
+		}
+		if string(x) != s {
+			t.Fatalf("journal #%d: got %q, want %q", i, short(string(x)), short(s))
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	random := make([]byte, 3*blockSize)
+	rnd.Read(random)
+	s := []string{
+		"",
+		"x",
+		big("abcd", 1000),
+		string(random),
+		big("ABCDE", 5*blockSize),
+		strings.Repeat("z", blockSize-headerSize),
+	}
+	var raw int
+	for _, x := range s {
+		raw += len(x)
+	}
+	for _, recyclable := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		w.SetCompression(true)
+		if recyclable {
+			w.SetNum(7)
+		}
+		writeJournals(t, w, s)
+		// 只有能压缩的记录才压缩，随机数据原样写。
+		if n := buf.Len(); n >= raw-4*blockSize || n <= len(random) {
+			t.Fatalf("recyclable %v: got %d bytes for %d raw bytes", recyclable, n, raw)
+		}
+		readJournals(t, NewReader(buf, dropper{t}, true, true), s)
+	}
+
+	// ReadByte 也要走解压。
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.SetCompression(true)
+	writeJournals(t, w, []string{big("ab", 100)})
+	rr, err := NewReader(buf, dropper{t}, true, true).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := rr.(io.ByteReader).ReadByte(); err != nil || c != 'a' {
+		t.Fatalf("ReadByte: got %q, %v", c, err)
+	}
+}
+
+func TestRecycled(t *testing.T) {
+	var old, cur []string
+	for i := 0; i < 200; i++ {
+		old = append(old, big(fmt.Sprintf("old%d.", i), 700+i))
+	}
+	for i := 0; i < 30; i++ {
+		cur = append(cur, big(fmt.Sprintf("new%d.", i), 900+i))
+	}
+
+	for _, legacy := range []bool{false, true} {
+		oldBuf := new(bytes.Buffer)
+		w := NewWriter(oldBuf)
+		if !legacy {
+			w.SetNum(1)
+		}
+		writeJournals(t, w, old)
+
+		// 回收的文件：新内容从头覆盖，后面还是旧的。
+		recycle := func(s []string, compress bool) []byte {
+			buf := new(bytes.Buffer)
+			w := NewWriter(buf)
+			w.SetNum(2)
+			w.SetCompression(compress)
+			writeJournals(t, w, s)
+			if buf.Len() >= oldBuf.Len() {
+				t.Fatal("new content is longer than the recycled file")
+			}
+			return append(buf.Bytes(), oldBuf.Bytes()[buf.Len():]...)
+		}
+		for _, compress := range []bool{false, true} {
+			data := recycle(cur, compress)
+			r := NewReader(bytes.NewReader(data), dropper{t}, true, true)
+			r.SetNum(2)
+			readJournals(t, r, cur)
+			// 不给文件号就取第一个 chunk 的。
+			readJournals(t, NewReader(bytes.NewReader(data), dropper{t}, true, true), cur)
+		}
+
+		// 还没写新内容就崩了：旧内容一条也不能读出来。
+		if !legacy {
+			r := NewReader(bytes.NewReader(oldBuf.Bytes()), dropper{t}, true, true)
+			r.SetNum(2)
+			readJournals(t, r, nil)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"awesomeProject1/goleveldb/leveldb/journal"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// JournalEntry is a single record of a journal batch.
+type JournalEntry struct {
+	Seq   uint64 `json:"seq"`
+	Type  string `json:"type"` // "v", "d" or "m"
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// JournalRecord is a batch read from a journal file of either stream.
+type JournalRecord struct {
+	Stream string `json:"stream"` // "main" (.log) or "state" (.logs)
+	File   string `json:"file"`
+	// Offset is the position of the first chunk of the record in the file.
+	Offset int64 `json:"offset"`
+	Size   int   `json:"size"`
+
+	Seq     uint64         `json:"seq"`
+	Len     int            `json:"len"`
+	Entries []JournalEntry `json:"entries,omitempty"`
+
+	// Error is set if the batch can't be decoded, Data then holds what was
+	// read and Entries the records decoded so far.
+	Error string `json:"error,omitempty"`
+
+	// Data is the raw batch, as written to the journal.
+	Data []byte `json:"-"`
+}
+
+// JournalCorruption is a chunk dropped by the journal reader.
+type JournalCorruption struct {
+	Offset int64  `json:"offset"`
+	Size   int    `json:"size"`
+	Reason string `json:"reason"`
+}
+
+// JournalReport summarizes a journal file, see InspectJournal.
+type JournalReport struct {
+	Stream      string              `json:"stream"`
+	File        string              `json:"file"`
+	Records     int                 `json:"records"`
+	Entries     int                 `json:"entries"`
+	BadRecords  int                 `json:"badRecords"`
+	MinSeq      uint64              `json:"minSeq"`
+	MaxSeq      uint64              `json:"maxSeq"`
+	Corruptions []JournalCorruption `json:"corruptions"`
+}
+
+// journalStream returns the stream name of a journal file type.
+func journalStream(ft storage.FileType) string {
+	if ft == storage.TypeJournals {
+		return "state"
+	}
+	return "main"
+}
+
+type journalCorruptions []JournalCorruption
+
+func (c *journalCorruptions) Drop(err error) {
+	if e, ok := err.(*journal.ErrCorrupted); ok {
+		*c = append(*c, JournalCorruption{Offset: e.Offset, Size: e.Size, Reason: e.Reason})
+	} else {
+		*c = append(*c, JournalCorruption{Offset: -1, Reason: err.Error()})
+	}
+}
+
+// decodeJournalRecord decodes the batch in rec.Data the same way
+// decodeBatchToMem does.
+func decodeJournalRecord(rec *JournalRecord) error {
+	seq, batchLen, err := decodeBatchHeader(rec.Data)
+	if err != nil {
+		return err
+	}
+	rec.Seq, rec.Len = seq, batchLen
+	data := rec.Data[batchHeaderLen:]
+	err = decodeBatch(data, func(i int, index batchIndex) error {
+		if i >= batchLen {
+			return newErrBatchCorrupted("invalid records length")
+		}
+		rec.Entries = append(rec.Entries, JournalEntry{
+			Seq:   seq + uint64(i),
+			Type:  index.keyType.String(),
+			Key:   index.k(data),
+			Value: index.v(data),
+		})
+		return nil
+	})
+	if err == nil && len(rec.Entries) != batchLen {
+		err = newErrBatchCorrupted(fmt.Sprintf("invalid records length: %d vs %d", batchLen, len(rec.Entries)))
+	}
+	return err
+}
+
+// InspectJournal reads the journal file fd of either stream from r and calls
+// fn with every record in order. The checksum of every chunk is verified;
+// torn or corrupted chunks are skipped like a non-strict recovery would and
+// reported with their offsets. Records may be retained by fn.
+//
+// Decoding stops at the first error returned by fn.
+func InspectJournal(r io.Reader, fd storage.FileDesc, fn func(*JournalRecord) error) (*JournalReport, error) {
+	var (
+		report = &JournalReport{Stream: journalStream(fd.Type), File: fd.String()}
+		drops  = &journalCorruptions{}
+		jr     = journal.NewReader(r, drops, false, true)
+	)
+	// 回收的日志没有截断，旧文件残留的记录不能算到这个文件上。
+	jr.SetNum(fd.Num)
+	defer func() {
+		report.Corruptions = append([]JournalCorruption{}, *drops...)
+	}()
+	for {
+		jrr, err := jr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return report, nil
+			}
+			return report, err
+		}
+		rec := &JournalRecord{Stream: report.Stream, File: report.File, Offset: jr.Offset()}
+		rec.Data, err = io.ReadAll(jrr)
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				// 记录被截断，已经由 dropper 记录。
+				continue
+			}
+			return report, err
+		}
+		rec.Size = len(rec.Data)
+		report.Records++
+		if err := decodeJournalRecord(rec); err != nil {
+			rec.Error = err.Error()
+			report.BadRecords++
+		} else {
+			if report.Records-report.BadRecords == 1 || rec.Seq < report.MinSeq {
+				report.MinSeq = rec.Seq
+			}
+			if last := rec.Seq + uint64(rec.Len) - 1; rec.Len > 0 && last > report.MaxSeq {
+				report.MaxSeq = last
+			}
+		}
+		report.Entries += len(rec.Entries)
+		if fn != nil {
+			if err := fn(rec); err != nil {
+				return report, err
+			}
+		}
+	}
+}
+
+// InspectJournals inspects every journal file of the given types, which is
+// storage.TypeJournal, storage.TypeJournals or both, ordered by file number.
+func InspectJournals(stor storage.Storage, ft storage.FileType, fn func(*JournalRecord) error) ([]*JournalReport, error) {
+	fds, err := stor.List(ft & (storage.TypeJournal | storage.TypeJournals))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(fds, func(i, j int) bool {
+		if fds[i].Num != fds[j].Num {
+			return fds[i].Num < fds[j].Num
+		}
+		return fds[i].Type < fds[j].Type
+	})
+	reports := []*JournalReport{}
+	for _, fd := range fds {
+		reader, err := stor.Open(fd)
+		if err != nil {
+			return reports, err
+		}
+		report, err := InspectJournal(reader, fd, fn)
+		reader.Close()
+		reports = append(reports, report)
+		if err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
+}
+
+// SortJournalRecords orders records of both streams by sequence number,
+// which is shared by the two streams. Records whose batch header can't be
+// decoded have a zero sequence number and come first.
+func SortJournalRecords(records []*JournalRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Seq < records[j].Seq
+	})
+}
+
+func formatJournalBytes(b []byte, hex bool) string {
+	if hex {
+		return fmt.Sprintf("%x", b)
+	}
+	return fmt.Sprintf("%q", b)
+}
+
+// WriteJournalRecord writes rec in a human readable form. If entries is
+// set every record of the batch is written too, keys and values are quoted
+// or hex encoded.
+func WriteJournalRecord(w io.Writer, rec *JournalRecord, entries, hex bool) error {
+	ew := &errWriter{w: w}
+	fmt.Fprintf(ew, "%-5s %s @%d S·%d", rec.Stream, rec.File, rec.Offset, rec.Size)
+	if rec.Error != "" {
+		fmt.Fprintf(ew, " CORRUPTED: %s\n", rec.Error)
+	} else if rec.Len > 0 {
+		fmt.Fprintf(ew, " seq %d..%d N·%d\n", rec.Seq, rec.Seq+uint64(rec.Len)-1, rec.Len)
+	} else {
+		fmt.Fprintf(ew, " seq %d N·0\n", rec.Seq)
+	}
+	if entries {
+		for _, e := range rec.Entries {
+			fmt.Fprintf(ew, "  %s%d %s", e.Type, e.Seq, formatJournalBytes(e.Key, hex))
+			if e.Type != keyTypeDel.String() {
+				fmt.Fprintf(ew, " = %s", formatJournalBytes(e.Value, hex))
+			}
+			fmt.Fprintln(ew)
+		}
+	}
+	return ew.err
+}
+
+// WriteText writes the summary of r and its corruptions.
+func (r *JournalReport) WriteText(w io.Writer) error {
+	ew := &errWriter{w: w}
+	fmt.Fprintf(ew, "%s journal %s: records %d entries %d", r.Stream, r.File, r.Records, r.Entries)
+	if r.Records > r.BadRecords {
+		fmt.Fprintf(ew, " seq %d..%d", r.MinSeq, r.MaxSeq)
+	}
+	if r.BadRecords > 0 {
+		fmt.Fprintf(ew, " bad·%d", r.BadRecords)
+	}
+	fmt.Fprintln(ew)
+	for _, c := range r.Corruptions {
+		fmt.Fprintf(ew, "  dropped @%d S·%d: %s\n", c.Offset, c.Size, c.Reason)
+	}
+	return ew.err
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/journal"
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+func TestInspectJournal(t *testing.T) {
+	batches := []*Batch{new(Batch), new(Batch), new(Batch)}
+	batches[0].Put([]byte("foo"), []byte("v1"))
+	batches[0].Delete([]byte("bar"))
+	// 跨越第一个 block，最后一个 chunk 在第二个 block 里。
+	batches[1].Put([]byte("big"), bytes.Repeat([]byte{'x'}, 40000))
+	batches[2].Put([]byte("baz"), []byte("v3"))
+
+	var buf bytes.Buffer
+	jw := journal.NewWriter(&buf)
+	seq := uint64(10)
+	for _, b := range batches {
+		w, err := jw.Next()
+		if err != nil {
+			t.Fatal("journal.Next: got error: ", err)
+		}
+		if err := writeBatchesWithHeader(w, []*Batch{b}, seq); err != nil {
+			t.Fatal("writeBatchesWithHeader: got error: ", err)
+		}
+		seq += uint64(b.Len())
+	}
+	if err := jw.Close(); err != nil {
+		t.Fatal("journal.Close: got error: ", err)
+	}
+
+	fd := storage.FileDesc{Type: storage.TypeJournals, Num: 7}
+	var records []*JournalRecord
+	collect := func(rec *JournalRecord) error {
+		records = append(records, rec)
+		return nil
+	}
+	report, err := InspectJournal(bytes.NewReader(buf.Bytes()), fd, collect)
+	if err != nil {
+		t.Fatal("InspectJournal: got error: ", err)
+	}
+	if report.Stream != "state" || report.Records != 3 || report.Entries != 4 || len(report.Corruptions) != 0 {
+		t.Fatalf("InspectJournal: got %+v", report)
+	}
+	if report.MinSeq != 10 || report.MaxSeq != 13 {
+		t.Fatalf("InspectJournal: got seq %d..%d, want 10..13", report.MinSeq, report.MaxSeq)
+	}
+	if records[0].Offset != 0 || records[1].Offset != int64(7+records[0].Size) {
+		t.Fatalf("InspectJournal: got offsets %d, %d", records[0].Offset, records[1].Offset)
+	}
+	if e := records[0].Entries[1]; e.Type != "d" || e.Seq != 11 || string(e.Key) != "bar" {
+		t.Fatalf("InspectJournal: got entry %+v", e)
+	}
+	lastOffset := records[2].Offset
+
+	// 破坏第一条记录，整个第一个 block 被丢弃，第二个 block 开头是孤立的 chunk。
+	data := append([]byte(nil), buf.Bytes()...)
+	data[7+batchHeaderLen] ^= 0xff
+	records = nil
+	report, err = InspectJournal(bytes.NewReader(data), fd, collect)
+	if err != nil {
+		t.Fatal("InspectJournal: got error: ", err)
+	}
+	if len(records) != 1 || records[0].Seq != 13 || records[0].Offset != lastOffset {
+		t.Fatalf("InspectJournal: got %d records, want the last one @%d", len(records), lastOffset)
+	}
+	if len(report.Corruptions) != 2 {
+		t.Fatalf("InspectJournal: got corruptions %+v", report.Corruptions)
+	}
+	if c := report.Corruptions[0]; c.Offset != 0 || c.Reason != "checksum mismatch" {
+		t.Fatalf("InspectJournal: got corruption %+v", c)
+	}
+	if c := report.Corruptions[1]; c.Offset != 32*1024 || c.Reason != "orphan chunk" {
+		t.Fatalf("InspectJournal: got corruption %+v", c)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal("WriteText: got error: ", err)
+	}
+	if !strings.Contains(text.String(), "dropped @32768") {
+		t.Fatalf("WriteText: offset missing from output:\n%s", text.String())
+	}
+}
+
+func TestInspectJournalRecycled(t *testing.T) {
+	// 5 号日志回收成 8 号之后还没写入新记录。
+	var buf bytes.Buffer
+	jw := journal.NewWriter(&buf)
+	jw.SetNum(5)
+	b := new(Batch)
+	b.Put([]byte("foo"), []byte("v1"))
+	for seq := uint64(1); seq <= 3; seq++ {
+		w, err := jw.Next()
+		if err != nil {
+			t.Fatal("journal.Next: got error: ", err)
+		}
+		if err := writeBatchesWithHeader(w, []*Batch{b}, seq); err != nil {
+			t.Fatal("writeBatchesWithHeader: got error: ", err)
+		}
+	}
+	if err := jw.Close(); err != nil {
+		t.Fatal("journal.Close: got error: ", err)
+	}
+
+	fd := storage.FileDesc{Type: storage.TypeJournal, Num: 8}
+	report, err := InspectJournal(bytes.NewReader(buf.Bytes()), fd, func(rec *JournalRecord) error {
+		t.Fatalf("InspectJournal: got stale record seq %d", rec.Seq)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("InspectJournal: got error: ", err)
+	}
+	if report.Records != 0 || report.Entries != 0 {
+		t.Fatalf("InspectJournal: got %+v", report)
+	}
+
+	// 同一个文件按原来的号读，记录都在。
+	fd.Num = 5
+	if report, err = InspectJournal(bytes.NewReader(buf.Bytes()), fd, func(*JournalRecord) error { return nil }); err != nil || report.Records != 3 {
+		t.Fatalf("InspectJournal: got %+v, %v", report, err)
+	}
+}
+
+func TestInspectJournals(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	for i := 0; i < 3; i++ {
+		h.put(fmt.Sprintf("k%d", i), "v")
+		if err := h.db.Put_s([]byte(fmt.Sprintf("s%d", i)), []byte("v"), nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.closeDB()
+
+	var records []*JournalRecord
+	reports, err := InspectJournals(h.stor, storage.TypeJournal|storage.TypeJournals, func(rec *JournalRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("InspectJournals: got error: ", err)
+	}
+	var streams []string
+	for _, r := range reports {
+		if len(r.Corruptions) != 0 || r.BadRecords != 0 {
+			t.Fatalf("InspectJournals: got %+v", r)
+		}
+		streams = append(streams, r.Stream)
+	}
+	if len(records) != 6 {
+		t.Fatalf("InspectJournals: got %d records from %v, want 6", len(records), streams)
+	}
+
+	SortJournalRecords(records)
+	for i, rec := range records {
+		want := "main"
+		if i%2 == 1 {
+			want = "state"
+		}
+		if rec.Stream != want || (i > 0 && rec.Seq != records[i-1].Seq+1) {
+			t.Fatalf("record %d: got %s seq %d, want %s interleaved", i, rec.Stream, rec.Seq, want)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/errors"
+	"awesomeProject1/goleveldb/leveldb/journal"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+// ManifestTable describes a table of a manifest edit or of the replayed
+// layout. Size and the key range are empty for deleted tables.
+type ManifestTable struct {
+	Level int    `json:"level"`
+	Num   int64  `json:"num"`
+	Size  int64  `json:"size,omitempty"`
+	Min   string `json:"min,omitempty"`
+	Max   string `json:"max,omitempty"`
+}
+
+// ManifestCompPtr is a compaction pointer of a level.
+type ManifestCompPtr struct {
+	Level int    `json:"level"`
+	Key   string `json:"key"`
+}
+
+// ManifestEdit is a single decoded session record of a manifest. Fields
+// suffixed with State concern the state keyspace (level_s).
+type ManifestEdit struct {
+	Index          int     `json:"index"`
+	Comparer       string  `json:"comparer,omitempty"`
+	JournalNum     *int64  `json:"journalNum,omitempty"`
+	PrevJournalNum *int64  `json:"prevJournalNum,omitempty"`
+	NextFileNum    *int64  `json:"nextFileNum,omitempty"`
+	SeqNum         *uint64 `json:"seqNum,omitempty"`
+
+	CompPtrs           []ManifestCompPtr `json:"compPtrs,omitempty"`
+	CompPtrsState      []ManifestCompPtr `json:"compPtrsState,omitempty"`
+	AddedTables        []ManifestTable   `json:"addedTables,omitempty"`
+	AddedTablesState   []ManifestTable   `json:"addedTablesState,omitempty"`
+	DeletedTables      []ManifestTable   `json:"deletedTables,omitempty"`
+	DeletedTablesState []ManifestTable   `json:"deletedTablesState,omitempty"`
+
+	// Error is set if the record is corrupted; it is skipped on replay.
+	Error string `json:"error,omitempty"`
+}
+
+// ManifestLevel is a level of the replayed layout.
+type ManifestLevel struct {
+	Level  int             `json:"level"`
+	Size   int64           `json:"size"`
+	Tables []ManifestTable `json:"tables"`
+}
+
+// ManifestReport is the result of InspectManifest.
+type ManifestReport struct {
+	Manifest       string `json:"manifest"`
+	Comparer       string `json:"comparer"`
+	JournalNum     int64  `json:"journalNum"`
+	PrevJournalNum int64  `json:"prevJournalNum"`
+	NextFileNum    int64  `json:"nextFileNum"`
+	SeqNum         uint64 `json:"seqNum"`
+
+	Edits         []ManifestEdit    `json:"edits"`
+	Levels        []ManifestLevel   `json:"levels"`
+	LevelsState   []ManifestLevel   `json:"levelsState"`
+	CompPtrs      []ManifestCompPtr `json:"compPtrs,omitempty"`
+	CompPtrsState []ManifestCompPtr `json:"compPtrsState,omitempty"`
+}
+
+func formatInternalKey(ik internalKey) string {
+	ukey, seq, kt, err := parseInternalKey(ik)
+	if err != nil {
+		return fmt.Sprintf("<corrupted %q>", []byte(ik))
+	}
+	return fmt.Sprintf("%q,%s%d", ukey, kt, seq)
+}
+
+func manifestTables(recs []atRecord) (tables []ManifestTable) {
+	for _, r := range recs {
+		tables = append(tables, ManifestTable{
+			Level: r.level,
+			Num:   r.num,
+			Size:  r.size,
+			Min:   formatInternalKey(r.imin),
+			Max:   formatInternalKey(r.imax),
+		})
+	}
+	return
+}
+
+func manifestDeletedTables(recs []dtRecord) (tables []ManifestTable) {
+	for _, r := range recs {
+		tables = append(tables, ManifestTable{Level: r.level, Num: r.num})
+	}
+	return
+}
+
+func manifestCompPtrs(recs []cpRecord) (ptrs []ManifestCompPtr) {
+	for _, r := range recs {
+		ptrs = append(ptrs, ManifestCompPtr{Level: r.level, Key: formatInternalKey(r.ikey)})
+	}
+	return
+}
+
+// manifestReplay tracks the tables of one keyspace while replaying edits.
+type manifestReplay struct {
+	tables   map[int64]atRecord
+	compPtrs map[int]internalKey
+}
+
+func newManifestReplay() *manifestReplay {
+	return &manifestReplay{
+		tables:   make(map[int64]atRecord),
+		compPtrs: make(map[int]internalKey),
+	}
+}
+
+// apply applies deletions before additions, as versionStaging does.
+func (m *manifestReplay) apply(cps []cpRecord, deleted []dtRecord, added []atRecord) {
+	for _, r := range cps {
+		m.compPtrs[r.level] = r.ikey
+	}
+	for _, r := range deleted {
+		if t, ok := m.tables[r.num]; ok && t.level == r.level {
+			delete(m.tables, r.num)
+		}
+	}
+	for _, r := range added {
+		m.tables[r.num] = r
+	}
+}
+
+func (m *manifestReplay) levels(icmp *iComparer) (levels []ManifestLevel, ptrs []ManifestCompPtr) {
+	byLevel := make(map[int][]atRecord)
+	maxLevel := -1
+	for _, t := range m.tables {
+		byLevel[t.level] = append(byLevel[t.level], t)
+		if t.level > maxLevel {
+			maxLevel = t.level
+		}
+	}
+	for level := 0; level <= maxLevel; level++ {
+		recs := byLevel[level]
+		if level == 0 {
+			// Newest first, like tFiles.sortByNum.
+			sort.Slice(recs, func(i, j int) bool { return recs[i].num > recs[j].num })
+		} else {
+			sort.Slice(recs, func(i, j int) bool {
+				if c := icmp.Compare(recs[i].imin, recs[j].imin); c != 0 {
+					return c < 0
+				}
+				return recs[i].num < recs[j].num
+			})
+		}
+		ml := ManifestLevel{Level: level, Tables: manifestTables(recs)}
+		for _, t := range recs {
+			ml.Size += t.size
+		}
+		if ml.Tables == nil {
+			ml.Tables = []ManifestTable{}
+		}
+		levels = append(levels, ml)
+	}
+	for level := 0; level <= maxLevel || level < len(m.compPtrs); level++ {
+		if ikey, ok := m.compPtrs[level]; ok {
+			ptrs = append(ptrs, ManifestCompPtr{Level: level, Key: formatInternalKey(ikey)})
+		}
+	}
+	return
+}
+
+// InspectManifest decodes every session record of the manifest fd, or of
+// the current manifest if fd is zero, and replays them into the final
+// layout of both keyspaces. Corrupted records are reported and skipped.
+// Key ranges are ordered using the default comparer.
+func InspectManifest(stor storage.Storage, fd storage.FileDesc) (*ManifestReport, error) {
+	if fd.Zero() {
+		var err error
+		if fd, err = stor.GetMeta(); err != nil {
+			return nil, err
+		}
+	}
+	reader, err := stor.Open(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var (
+		report = &ManifestReport{Manifest: fd.String(), Edits: []ManifestEdit{}}
+		jr     = journal.NewReader(reader, nil, false, true)
+		chain  = newManifestReplay()
+		state  = newManifestReplay()
+		icmp   = &iComparer{comparer.DefaultComparer}
+	)
+	for i := 0; ; i++ {
+		r, err := jr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return report, errors.SetFd(err, fd)
+		}
+		rec := &sessionRecord{}
+		derr := rec.decode(r)
+
+		edit := ManifestEdit{
+			Index:              i,
+			CompPtrs:           manifestCompPtrs(rec.compPtrs),
+			CompPtrsState:      manifestCompPtrs(rec.compPtrs2),
+			AddedTables:        manifestTables(rec.addedTables),
+			AddedTablesState:   manifestTables(rec.addedTabless),
+			DeletedTables:      manifestDeletedTables(rec.deletedTables),
+			DeletedTablesState: manifestDeletedTables(rec.deletedTabless),
+		}
+		if rec.has(recComparer) {
+			edit.Comparer = rec.comparer
+			report.Comparer = rec.comparer
+		}
+		if rec.has(recJournalNum) {
+			edit.JournalNum = &rec.journalNum
+			report.JournalNum = rec.journalNum
+		}
+		if rec.has(recPrevJournalNum) {
+			edit.PrevJournalNum = &rec.prevJournalNum
+			report.PrevJournalNum = rec.prevJournalNum
+		}
+		if rec.has(recNextFileNum) {
+			edit.NextFileNum = &rec.nextFileNum
+			report.NextFileNum = rec.nextFileNum
+		}
+		if rec.has(recSeqNum) {
+			edit.SeqNum = &rec.seqNum
+			report.SeqNum = rec.seqNum
+		}
+		if derr != nil {
+			edit.Error = derr.Error()
+		} else {
+			chain.apply(rec.compPtrs, rec.deletedTables, rec.addedTables)
+			state.apply(rec.compPtrs2, rec.deletedTabless, rec.addedTabless)
+		}
+		report.Edits = append(report.Edits, edit)
+	}
+	report.Levels, report.CompPtrs = chain.levels(icmp)
+	report.LevelsState, report.CompPtrsState = state.levels(icmp)
+	return report, nil
+}
+
+func writeManifestTables(w io.Writer, prefix string, tables []ManifestTable) {
+	for _, t := range tables {
+		if t.Min == "" {
+			fmt.Fprintf(w, "  %s L%d@%d\n", prefix, t.Level, t.Num)
+		} else {
+			fmt.Fprintf(w, "  %s L%d@%d S·%s %s:%s\n", prefix, t.Level, t.Num, shortenb(int(t.Size)), t.Min, t.Max)
+		}
+	}
+}
+
+func writeManifestLevels(w io.Writer, name string, levels []ManifestLevel) {
+	for _, l := range levels {
+		fmt.Fprintf(w, "--- %s %d: F·%d S·%s ---\n", name, l.Level, len(l.Tables), shortenb(int(l.Size)))
+		for _, t := range l.Tables {
+			fmt.Fprintf(w, "  @%d S·%s %s:%s\n", t.Num, shortenb(int(t.Size)), t.Min, t.Max)
+		}
+	}
+}
+
+// WriteText writes r in a human readable form.
+func (r *ManifestReport) WriteText(w io.Writer) error {
+	ew := &errWriter{w: w}
+	fmt.Fprintf(ew, "manifest %s\n", r.Manifest)
+	for _, e := range r.Edits {
+		fmt.Fprintf(ew, "edit #%d\n", e.Index)
+		if e.Error != "" {
+			fmt.Fprintf(ew, "  error: %s (skipped)\n", e.Error)
+		}
+		if e.Comparer != "" {
+			fmt.Fprintf(ew, "  comparer %s\n", e.Comparer)
+		}
+		if e.JournalNum != nil {
+			fmt.Fprintf(ew, "  journal-num %d\n", *e.JournalNum)
+		}
+		if e.PrevJournalNum != nil {
+			fmt.Fprintf(ew, "  prev-journal-num %d\n", *e.PrevJournalNum)
+		}
+		if e.NextFileNum != nil {
+			fmt.Fprintf(ew, "  next-file-num %d\n", *e.NextFileNum)
+		}
+		if e.SeqNum != nil {
+			fmt.Fprintf(ew, "  seq-num %d\n", *e.SeqNum)
+		}
+		for _, p := range e.CompPtrs {
+			fmt.Fprintf(ew, "  comp-ptr L%d %s\n", p.Level, p.Key)
+		}
+		for _, p := range e.CompPtrsState {
+			fmt.Fprintf(ew, "  comp-ptr_s L%d %s\n", p.Level, p.Key)
+		}
+		writeManifestTables(ew, "del-table", e.DeletedTables)
+		writeManifestTables(ew, "del-table_s", e.DeletedTablesState)
+		writeManifestTables(ew, "add-table", e.AddedTables)
+		writeManifestTables(ew, "add-table_s", e.AddedTablesState)
+	}
+	fmt.Fprintf(ew, "comparer %s journal-num %d prev-journal-num %d next-file-num %d seq-num %d\n",
+		r.Comparer, r.JournalNum, r.PrevJournalNum, r.NextFileNum, r.SeqNum)
+	writeManifestLevels(ew, "level", r.Levels)
+	writeManifestLevels(ew, "level_s", r.LevelsState)
+	for _, p := range r.CompPtrs {
+		fmt.Fprintf(ew, "comp-ptr L%d %s\n", p.Level, p.Key)
+	}
+	for _, p := range r.CompPtrsState {
+		fmt.Fprintf(ew, "comp-ptr_s L%d %s\n", p.Level, p.Key)
+	}
+	return ew.err
+}
+
+// errWriter keeps the first write error so the fmt calls above needn't be
+// checked one by one.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	var n int
+	n, w.err = w.w.Write(p)
+	return n, w.err
+}
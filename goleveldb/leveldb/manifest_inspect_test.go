@@ -0,0 +1,82 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+func TestInspectManifest(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.put("bar", "v1")
+	h.compactMem()
+	if err := h.db.Put_s([]byte("state"), []byte("v1"), nil); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem_s(0, true)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+
+	var want, wants int
+	v := h.db.s.version()
+	for _, tables := range v.levels {
+		want += len(tables)
+	}
+	for _, tables := range v.level_s {
+		wants += len(tables)
+	}
+	v.release()
+	h.closeDB()
+
+	report, err := InspectManifest(h.stor, storage.FileDesc{})
+	if err != nil {
+		t.Fatal("InspectManifest: got error: ", err)
+	}
+	if len(report.Edits) == 0 || report.Comparer == "" {
+		t.Fatalf("InspectManifest: got %d edits, comparer %q", len(report.Edits), report.Comparer)
+	}
+	for _, e := range report.Edits {
+		if e.Error != "" {
+			t.Fatalf("edit %d: got error %s", e.Index, e.Error)
+		}
+	}
+	count := func(levels []ManifestLevel) (n int) {
+		for _, l := range levels {
+			n += len(l.Tables)
+		}
+		return
+	}
+	if got := count(report.Levels); got != want || want == 0 {
+		t.Fatalf("Levels: got %d tables, want %d", got, want)
+	}
+	if got := count(report.LevelsState); got != wants || wants == 0 {
+		t.Fatalf("LevelsState: got %d tables, want %d", got, wants)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteText(&buf); err != nil {
+		t.Fatal("WriteText: got error: ", err)
+	}
+	if !strings.Contains(buf.String(), report.Manifest) {
+		t.Fatalf("WriteText: manifest name missing from output:\n%s", buf.String())
+	}
+	if _, err := json.Marshal(report); err != nil {
+		t.Fatal("json.Marshal: got error: ", err)
+	}
+}
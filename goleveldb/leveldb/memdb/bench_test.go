@@ -11,6 +11,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/rand"
+	"sync/atomic"
 	"testing"
 )
 
@@ -75,3 +76,90 @@ func BenchmarkGetRandom(b *testing.B) {
 		p.Get(buf[rand.Int()%b.N][:])
 	}
 }
+
+func benchmarkPutParallel(b *testing.B, p *DB) {
+	var n uint32
+	b.RunParallel(func(pb *testing.PB) {
+		var key [4]byte
+		for pb.Next() {
+			binary.LittleEndian.PutUint32(key[:], atomic.AddUint32(&n, 1)*2654435761)
+			p.Put(key[:], nil)
+		}
+	})
+}
+
+func BenchmarkPutParallel(b *testing.B) {
+	benchmarkPutParallel(b, New(comparer.DefaultComparer, 0))
+}
+
+func BenchmarkPutParallelConcurrent(b *testing.B) {
+	benchmarkPutParallel(b, NewConcurrent(comparer.DefaultComparer, b.N*4))
+}
+
+func benchmarkGetParallel(b *testing.B, p *DB) {
+	const n = 1 << 16
+	var key [4]byte
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint32(key[:], uint32(i))
+		p.Put(key[:], nil)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var key [4]byte
+		for pb.Next() {
+			binary.LittleEndian.PutUint32(key[:], rand.Uint32()%n)
+			p.Get(key[:])
+		}
+	})
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	benchmarkGetParallel(b, New(comparer.DefaultComparer, 0))
+}
+
+func BenchmarkGetParallelConcurrent(b *testing.B) {
+	benchmarkGetParallel(b, NewConcurrent(comparer.DefaultComparer, 0))
+}
+
+// benchmarkGetWhilePut measures reads racing a writer.
+func benchmarkGetWhilePut(b *testing.B, p *DB) {
+	const n = 1 << 16
+	var key [4]byte
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint32(key[:], uint32(i))
+		p.Put(key[:], nil)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var key [4]byte
+		for i := uint32(n); ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			binary.LittleEndian.PutUint32(key[:], i)
+			p.Put(key[:], nil)
+		}
+	}()
+	defer close(done)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var key [4]byte
+		for pb.Next() {
+			binary.LittleEndian.PutUint32(key[:], rand.Uint32()%n)
+			p.Get(key[:])
+		}
+	})
+}
+
+func BenchmarkGetWhilePut(b *testing.B) {
+	benchmarkGetWhilePut(b, New(comparer.DefaultComparer, 0))
+}
+
+func BenchmarkGetWhilePutConcurrent(b *testing.B) {
+	benchmarkGetWhilePut(b, NewConcurrent(comparer.DefaultComparer, 0))
+}
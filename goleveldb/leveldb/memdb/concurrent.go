@@ -0,0 +1,462 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memdb
+
+import (
+	"math/rand"
+	"sync/atomic"
+
+	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/iterator"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+// arena hands out key/value buffers from a preallocated block without
+// locking. Once the block is used up buffers come from the heap.
+type arena struct {
+	buf  []byte
+	n    atomic.Int64 // bytes handed out, may exceed len(buf)
+	heap atomic.Int64 // bytes allocated from the heap
+}
+
+func (a *arena) alloc(b []byte) []byte {
+	n := int64(len(b))
+	end := a.n.Add(n)
+	var dst []byte
+	if end <= int64(len(a.buf)) {
+		dst = a.buf[end-n : end : end]
+	} else {
+		dst = make([]byte, n)
+		a.heap.Add(n)
+	}
+	copy(dst, b)
+	return dst
+}
+
+// size returns the bytes held by the arena, the heap allocations
+// included.
+func (a *arena) size() int {
+	return len(a.buf) + int(a.heap.Load())
+}
+
+func (a *arena) free() int {
+	if n := a.n.Load(); n < int64(len(a.buf)) {
+		return len(a.buf) - int(n)
+	}
+	return 0
+}
+
+type cNode struct {
+	key   []byte
+	value atomic.Pointer[[]byte] // nil once deleted
+	next  []atomic.Pointer[cNode]
+}
+
+// newNode allocates the node together with its tower; most nodes are
+// short.
+func newNode(h int) *cNode {
+	switch {
+	case h == 1:
+		x := &struct {
+			cNode
+			tower [1]atomic.Pointer[cNode]
+		}{}
+		x.next = x.tower[:]
+		return &x.cNode
+	case h == 2:
+		x := &struct {
+			cNode
+			tower [2]atomic.Pointer[cNode]
+		}{}
+		x.next = x.tower[:]
+		return &x.cNode
+	case h <= 4:
+		x := &struct {
+			cNode
+			tower [4]atomic.Pointer[cNode]
+		}{}
+		x.next = x.tower[:h]
+		return &x.cNode
+	}
+	return &cNode{next: make([]atomic.Pointer[cNode], h)}
+}
+
+// skiplist is a skiplist taking inserts from any number of goroutines at
+// once, and reads without locking. Nodes are never unlinked: Delete only
+// clears the value, a later Put of the same key sets it again.
+type skiplist struct {
+	cmp    comparer.BasicComparer
+	arena  arena
+	head   *cNode
+	height atomic.Int32
+	n      atomic.Int64
+	kvSize atomic.Int64
+}
+
+func newSkiplist(cmp comparer.BasicComparer, capacity int) *skiplist {
+	l := &skiplist{
+		cmp:   cmp,
+		arena: arena{buf: make([]byte, capacity)},
+	}
+	l.reset()
+	return l
+}
+
+// reset must not run concurrently with any other method.
+func (l *skiplist) reset() {
+	l.arena.n.Store(0)
+	l.arena.heap.Store(0)
+	l.head = &cNode{next: make([]atomic.Pointer[cNode], tMaxHeight)}
+	l.height.Store(1)
+	l.n.Store(0)
+	l.kvSize.Store(0)
+}
+
+func (l *skiplist) randHeight() (h int) {
+	const branching = 4
+	h = 1
+	for h < tMaxHeight && rand.Uint32()%branching == 0 {
+		h++
+	}
+	return
+}
+
+// findSplice fills preds and succs with the nodes around key at every
+// level; it returns the node holding key, if any.
+func (l *skiplist) findSplice(key []byte, preds, succs *[tMaxHeight]*cNode) *cNode {
+	x := l.head
+	for h := tMaxHeight - 1; h >= 0; h-- {
+		preds[h], succs[h] = l.findSpliceAt(key, x, h)
+		x = preds[h]
+	}
+	if succs[0] != nil && l.cmp.Compare(succs[0].key, key) == 0 {
+		return succs[0]
+	}
+	return nil
+}
+
+// findSpliceAt walks level h from x, whose key is less than key.
+func (l *skiplist) findSpliceAt(key []byte, x *cNode, h int) (pred, succ *cNode) {
+	for {
+		next := x.next[h].Load()
+		if next == nil || l.cmp.Compare(next.key, key) >= 0 {
+			return x, next
+		}
+		x = next
+	}
+}
+
+func (l *skiplist) put(key, value []byte) {
+	var preds, succs [tMaxHeight]*cNode
+	if x := l.findSplice(key, &preds, &succs); x != nil {
+		l.set(x, value)
+		return
+	}
+
+	h := l.randHeight()
+	for {
+		cur := l.height.Load()
+		if int32(h) <= cur || l.height.CompareAndSwap(cur, int32(h)) {
+			break
+		}
+	}
+	x := newNode(h)
+	x.key = l.arena.alloc(key)
+	v := l.arena.alloc(value)
+	x.value.Store(&v)
+	for i := 0; i < h; i++ {
+		for {
+			x.next[i].Store(succs[i])
+			if preds[i].next[i].CompareAndSwap(succs[i], x) {
+				break
+			}
+			// 有别的写入插在了这里，重新找这一层的位置。
+			preds[i], succs[i] = l.findSpliceAt(key, preds[i], i)
+			if i == 0 && succs[0] != nil && l.cmp.Compare(succs[0].key, key) == 0 {
+				// 同一个 key 被并发插入了，x 还没有链上，直接丢掉。
+				l.set(succs[0], value)
+				return
+			}
+		}
+	}
+	l.n.Add(1)
+	l.kvSize.Add(int64(len(key) + len(value)))
+}
+
+func (l *skiplist) set(x *cNode, value []byte) {
+	v := l.arena.alloc(value)
+	if old := x.value.Swap(&v); old != nil {
+		l.kvSize.Add(int64(len(value) - len(*old)))
+	} else {
+		l.n.Add(1)
+		l.kvSize.Add(int64(len(x.key) + len(value)))
+	}
+}
+
+func (l *skiplist) delete(key []byte) error {
+	var preds, succs [tMaxHeight]*cNode
+	x := l.findSplice(key, &preds, &succs)
+	if x == nil {
+		return ErrNotFound
+	}
+	old := x.value.Swap(nil)
+	if old == nil {
+		return ErrNotFound
+	}
+	l.n.Add(-1)
+	l.kvSize.Add(-int64(len(x.key) + len(*old)))
+	return nil
+}
+
+// findGE returns the first node whose key is greater than or equal to
+// key, and whether it is equal; the node may be deleted.
+func (l *skiplist) findGE(key []byte) (*cNode, bool) {
+	x := l.head
+	for h := int(l.height.Load()) - 1; ; h-- {
+		next := x.next[h].Load()
+		cmp := 1
+		if next != nil {
+			cmp = l.cmp.Compare(next.key, key)
+		}
+		if cmp < 0 {
+			x = next
+			h++
+		} else if cmp == 0 || h == 0 {
+			return next, cmp == 0
+		}
+	}
+}
+
+func (l *skiplist) skipForward(x *cNode) *cNode {
+	for x != nil && x.value.Load() == nil {
+		x = x.next[0].Load()
+	}
+	return x
+}
+
+// findLT returns the last live node whose key is less than key, or nil.
+func (l *skiplist) findLT(key []byte) *cNode {
+	for {
+		x := l.head
+		for h := int(l.height.Load()) - 1; h >= 0; h-- {
+			x, _ = l.findSpliceAt(key, x, h)
+		}
+		if x == l.head {
+			return nil
+		}
+		if x.value.Load() != nil {
+			return x
+		}
+		key = x.key
+	}
+}
+
+func (l *skiplist) findLast() *cNode {
+	x := l.head
+	for h := int(l.height.Load()) - 1; h >= 0; h-- {
+		for next := x.next[h].Load(); next != nil; next = x.next[h].Load() {
+			x = next
+		}
+	}
+	if x == l.head {
+		return nil
+	}
+	if x.value.Load() == nil {
+		return l.findLT(x.key)
+	}
+	return x
+}
+
+func (l *skiplist) get(key []byte) (value []byte, err error) {
+	if x, exact := l.findGE(key); exact {
+		if v := x.value.Load(); v != nil {
+			return *v, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (l *skiplist) find(key []byte) (rkey, value []byte, err error) {
+	x, _ := l.findGE(key)
+	for ; x != nil; x = x.next[0].Load() {
+		if v := x.value.Load(); v != nil {
+			return x.key, *v, nil
+		}
+	}
+	return nil, nil, ErrNotFound
+}
+
+type cIter struct {
+	util.BasicReleaser
+	l          *skiplist
+	slice      *util.Range
+	node       *cNode
+	forward    bool
+	key, value []byte
+	err        error
+}
+
+func (i *cIter) fill(checkStart, checkLimit bool) bool {
+	for i.node != nil {
+		v := i.node.value.Load()
+		if v == nil {
+			// 定位之后被删除了，顺着方向跳过去。
+			if i.forward {
+				i.node = i.l.skipForward(i.node)
+			} else {
+				i.node = i.l.findLT(i.node.key)
+			}
+			continue
+		}
+		i.key = i.node.key
+		if i.slice != nil {
+			switch {
+			case checkLimit && i.slice.Limit != nil && i.l.cmp.Compare(i.key, i.slice.Limit) >= 0:
+				fallthrough
+			case checkStart && i.slice.Start != nil && i.l.cmp.Compare(i.key, i.slice.Start) < 0:
+				i.node = nil
+				continue
+			}
+		}
+		i.value = *v
+		return true
+	}
+	i.key = nil
+	i.value = nil
+	return false
+}
+
+func (i *cIter) Valid() bool {
+	return i.node != nil
+}
+
+func (i *cIter) First() bool {
+	if i.Released() {
+		i.err = ErrIterReleased
+		return false
+	}
+
+	i.forward = true
+	if i.slice != nil && i.slice.Start != nil {
+		i.node, _ = i.l.findGE(i.slice.Start)
+	} else {
+		i.node = i.l.head.next[0].Load()
+	}
+	return i.fill(false, true)
+}
+
+func (i *cIter) Last() bool {
+	if i.Released() {
+		i.err = ErrIterReleased
+		return false
+	}
+
+	i.forward = false
+	if i.slice != nil && i.slice.Limit != nil {
+		i.node = i.l.findLT(i.slice.Limit)
+	} else {
+		i.node = i.l.findLast()
+	}
+	return i.fill(true, false)
+}
+
+func (i *cIter) Seek(key []byte) bool {
+	if i.Released() {
+		i.err = ErrIterReleased
+		return false
+	}
+
+	i.forward = true
+	if i.slice != nil && i.slice.Start != nil && i.l.cmp.Compare(key, i.slice.Start) < 0 {
+		key = i.slice.Start
+	}
+	i.node, _ = i.l.findGE(key)
+	return i.fill(false, true)
+}
+
+func (i *cIter) Next() bool {
+	if i.Released() {
+		i.err = ErrIterReleased
+		return false
+	}
+
+	if i.node == nil {
+		if !i.forward {
+			return i.First()
+		}
+		return false
+	}
+	i.forward = true
+	i.node = i.node.next[0].Load()
+	return i.fill(false, true)
+}
+
+func (i *cIter) Prev() bool {
+	if i.Released() {
+		i.err = ErrIterReleased
+		return false
+	}
+
+	if i.node == nil {
+		if i.forward {
+			return i.Last()
+		}
+		return false
+	}
+	i.forward = false
+	i.node = i.l.findLT(i.key)
+	return i.fill(true, false)
+}
+
+func (i *cIter) Key() []byte {
+	return i.key
+}
+
+func (i *cIter) Value() []byte {
+	return i.value
+}
+
+func (i *cIter) Error() error { return i.err }
+
+func (i *cIter) Release() {
+	if !i.Released() {
+		i.l = nil
+		i.node = nil
+		i.key = nil
+		i.value = nil
+		i.BasicReleaser.Release()
+	}
+}
+
+var _ iterator.Iterator = (*cIter)(nil)
+
+// NewConcurrent creates a DB like New, backed by a skiplist that takes
+// Put from several goroutines in parallel and serves reads without
+// locking. The capacity is preallocated; keys and values beyond it are
+// allocated one by one and counted in Capacity.
+//
+// Reset must not be called concurrently with other methods.
+func NewConcurrent(cmp comparer.BasicComparer, capacity int) *DB {
+	return &DB{cmp: cmp, c: newSkiplist(cmp, capacity)}
+}
+
+// NewConcurrent_s is NewConcurrent for DBs.
+func NewConcurrent_s(cmp comparer.BasicComparer, capacity int) *DBs {
+	return &DBs{cmp: cmp, c: newSkiplist(cmp, capacity)}
+}
+
+// Concurrent returns whether Put may be called from several goroutines in
+// parallel without serializing them, see NewConcurrent.
+func (p *DB) Concurrent() bool {
+	return p.c != nil
+}
+
+// Concurrent_s returns whether Put_s may be called from several goroutines
+// in parallel without serializing them, see NewConcurrent_s.
+func (p *DBs) Concurrent_s() bool {
+	return p.c != nil
+}
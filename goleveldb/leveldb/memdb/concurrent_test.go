@@ -0,0 +1,69 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memdb
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/comparer"
+)
+
+func TestConcurrentPut(t *testing.T) {
+	const writers, n = 8, 2000
+	db := NewConcurrent(comparer.DefaultComparer, 1024)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var key [8]byte
+			for i := 0; i < n; i++ {
+				// 各个写入者的 key 交错在一起，另外都写一个共同的 key。
+				binary.BigEndian.PutUint32(key[:], uint32(i))
+				binary.BigEndian.PutUint32(key[4:], uint32(w))
+				db.Put(key[:], key[4:])
+				db.Put([]byte("shared"), key[4:])
+				if _, err := db.Get(key[:]); err != nil {
+					t.Errorf("Get %x: got error %v", key, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got := db.Len(); got != writers*n+1 {
+		t.Fatalf("Len: got %d, want %d", got, writers*n+1)
+	}
+	iter := db.NewIterator(nil)
+	defer iter.Release()
+	var prev []byte
+	count := 0
+	for iter.Next() {
+		if prev != nil && comparer.DefaultComparer.Compare(prev, iter.Key()) >= 0 {
+			t.Fatalf("iterator: %x after %x", iter.Key(), prev)
+		}
+		prev = append(prev[:0], iter.Key()...)
+		count++
+	}
+	if count != writers*n+1 {
+		t.Fatalf("iterator: got %d entries, want %d", count, writers*n+1)
+	}
+	if db.Free() != 0 {
+		t.Fatalf("Free: got %d, want 0", db.Free())
+	}
+	// 超出预分配的部分从堆上分配，也要算进 Capacity；"shared" 只有值是新分配的。
+	if want := writers * n * (8 + 4 + 4); db.Capacity() < want {
+		t.Fatalf("Capacity: got %d, want at least %d", db.Capacity(), want)
+	}
+	db.Reset()
+	if db.Capacity() != 1024 {
+		t.Fatalf("Capacity after Reset: got %d, want 1024", db.Capacity())
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memdb
+
+import (
+	"sort"
+	"sync"
+
+	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/iterator"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+type hEntry struct {
+	off, klen, vlen int // key 和 value 在 kvData 中的位置
+}
+
+// hBucket holds the entries whose keys share a prefix, sorted by the
+// comparer.
+type hBucket struct {
+	ents []hEntry
+}
+
+// hashTable is a memtable indexed by a hash of the key prefix. Point
+// lookups touch a single bucket; the total order is only built, by sorting,
+// when an iterator is asked for. Writes after that are merged into it by
+// the next iterator.
+type hashTable struct {
+	cmp    comparer.BasicComparer
+	prefix func(key []byte) []byte
+
+	mu      sync.RWMutex
+	kvData  []byte
+	buckets map[string]*hBucket
+	n       int
+	kvSize  int
+
+	// sorted 是上一次排好序的结果。之后的写入记在 pending 里，下次迭代时
+	// 归并进去，不用整个重新排序。
+	sortMu  sync.Mutex
+	sorted  *hSorted
+	pending []hEntry // vlen < 0 的是删除
+}
+
+// hSorted is a sorted snapshot of the table. kvData is append only, so the
+// snapshot stays valid after later writes until the table is reset.
+type hSorted struct {
+	cmp    comparer.BasicComparer
+	kvData []byte
+	ents   []hEntry
+}
+
+func (s *hSorted) key(i int) []byte {
+	e := s.ents[i]
+	return s.kvData[e.off : e.off+e.klen]
+}
+
+func (s *hSorted) Len() int { return len(s.ents) }
+
+func (s *hSorted) Search(key []byte) int {
+	return sort.Search(len(s.ents), func(i int) bool {
+		return s.cmp.Compare(s.key(i), key) >= 0
+	})
+}
+
+func (s *hSorted) Index(i int) (key, value []byte) {
+	e := s.ents[i]
+	return s.kvData[e.off : e.off+e.klen], s.kvData[e.off+e.klen : e.off+e.klen+e.vlen]
+}
+
+// slice narrows the snapshot to the given range.
+func (s *hSorted) slice(r *util.Range) *hSorted {
+	if r == nil {
+		return s
+	}
+	lo, hi := 0, len(s.ents)
+	if r.Start != nil {
+		lo = s.Search(r.Start)
+	}
+	if r.Limit != nil {
+		hi = s.Search(r.Limit)
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return &hSorted{cmp: s.cmp, kvData: s.kvData, ents: s.ents[lo:hi]}
+}
+
+func newHashTable(cmp comparer.BasicComparer, capacity int, prefix func(key []byte) []byte) *hashTable {
+	return &hashTable{
+		cmp:     cmp,
+		prefix:  prefix,
+		kvData:  make([]byte, 0, capacity),
+		buckets: make(map[string]*hBucket),
+	}
+}
+
+func (t *hashTable) key(e hEntry) []byte {
+	return t.kvData[e.off : e.off+e.klen]
+}
+
+func (t *hashTable) value(e hEntry) []byte {
+	return t.kvData[e.off+e.klen : e.off+e.klen+e.vlen]
+}
+
+// search returns the position of the first entry in b not less than key,
+// and whether it is equal to key.
+func (t *hashTable) search(b *hBucket, key []byte) (int, bool) {
+	i := sort.Search(len(b.ents), func(i int) bool {
+		return t.cmp.Compare(t.key(b.ents[i]), key) >= 0
+	})
+	return i, i < len(b.ents) && t.cmp.Compare(t.key(b.ents[i]), key) == 0
+}
+
+// note records a write for the next sort. Nothing is recorded without a
+// sorted snapshot to merge into; once the writes outnumber the entries,
+// sorting again is as cheap and the snapshot is dropped. t.mu must be held.
+func (t *hashTable) note(e hEntry) {
+	t.sortMu.Lock()
+	if t.sorted != nil {
+		if len(t.pending) < t.n {
+			t.pending = append(t.pending, e)
+		} else {
+			t.sorted, t.pending = nil, nil
+		}
+	}
+	t.sortMu.Unlock()
+}
+
+func (t *hashTable) put(key, value []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := hEntry{off: len(t.kvData), klen: len(key), vlen: len(value)}
+	t.kvData = append(t.kvData, key...)
+	t.kvData = append(t.kvData, value...)
+
+	pk := t.prefix(key)
+	b := t.buckets[string(pk)]
+	if b == nil {
+		b = &hBucket{}
+		t.buckets[string(pk)] = b
+	}
+	i, exact := t.search(b, key)
+	if exact {
+		t.kvSize += len(value) - b.ents[i].vlen
+		b.ents[i] = e
+	} else {
+		b.ents = append(b.ents, hEntry{})
+		copy(b.ents[i+1:], b.ents[i:])
+		b.ents[i] = e
+		t.kvSize += len(key) + len(value)
+		t.n++
+	}
+	t.note(e)
+}
+
+func (t *hashTable) delete(key []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pk := t.prefix(key)
+	b := t.buckets[string(pk)]
+	if b == nil {
+		return ErrNotFound
+	}
+	i, exact := t.search(b, key)
+	if !exact {
+		return ErrNotFound
+	}
+	e := b.ents[i]
+	b.ents = append(b.ents[:i], b.ents[i+1:]...)
+	if len(b.ents) == 0 {
+		delete(t.buckets, string(pk))
+	}
+	t.kvSize -= e.klen + e.vlen
+	t.n--
+	t.note(hEntry{off: e.off, klen: e.klen, vlen: -1})
+	return nil
+}
+
+func (t *hashTable) get(key []byte) (value []byte, err error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if b := t.buckets[string(t.prefix(key))]; b != nil {
+		if i, exact := t.search(b, key); exact {
+			return t.value(b.ents[i]), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (t *hashTable) find(key []byte) (rkey, value []byte, err error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if b := t.buckets[string(t.prefix(key))]; b != nil {
+		if i, _ := t.search(b, key); i < len(b.ents) {
+			return t.key(b.ents[i]), t.value(b.ents[i]), nil
+		}
+	}
+	return nil, nil, ErrNotFound
+}
+
+// sort returns the entries in comparer order. The writes since the last
+// call are merged into its result; the whole table is only sorted the
+// first time.
+func (t *hashTable) sort() *hSorted {
+	t.sortMu.Lock()
+	defer t.sortMu.Unlock()
+	if t.sorted != nil {
+		if len(t.pending) > 0 {
+			t.sorted, t.pending = t.merge(), nil
+		}
+		return t.sorted
+	}
+	s := &hSorted{cmp: t.cmp, kvData: t.kvData, ents: make([]hEntry, 0, t.n)}
+	for _, b := range t.buckets {
+		s.ents = append(s.ents, b.ents...)
+	}
+	sort.Slice(s.ents, func(i, j int) bool {
+		return t.cmp.Compare(s.key(i), s.key(j)) < 0
+	})
+	t.sorted = s
+	return s
+}
+
+// merge returns t.sorted with t.pending applied. The caller must hold
+// sortMu.
+func (t *hashTable) merge() *hSorted {
+	p := t.pending
+	// 稳定排序，同一个 key 的多次写入保持先后顺序，只取最后一次。
+	sort.SliceStable(p, func(i, j int) bool {
+		return t.cmp.Compare(t.key(p[i]), t.key(p[j])) < 0
+	})
+	old := t.sorted.ents
+	ents := make([]hEntry, 0, t.n)
+	i := 0
+	for j, e := range p {
+		key := t.key(e)
+		if j+1 < len(p) && t.cmp.Compare(key, t.key(p[j+1])) == 0 {
+			continue
+		}
+		for i < len(old) && t.cmp.Compare(t.key(old[i]), key) < 0 {
+			ents = append(ents, old[i])
+			i++
+		}
+		if i < len(old) && t.cmp.Compare(t.key(old[i]), key) == 0 {
+			i++
+		}
+		if e.vlen >= 0 {
+			ents = append(ents, e)
+		}
+	}
+	ents = append(ents, old[i:]...)
+	return &hSorted{cmp: t.cmp, kvData: t.kvData, ents: ents}
+}
+
+func (t *hashTable) newIterator(slice *util.Range) iterator.Iterator {
+	t.mu.RLock()
+	s := t.sort()
+	t.mu.RUnlock()
+	return iterator.NewArrayIterator(s.slice(slice))
+}
+
+func (t *hashTable) reset() {
+	t.mu.Lock()
+	t.kvData = t.kvData[:0]
+	clear(t.buckets)
+	t.n = 0
+	t.kvSize = 0
+	t.sortMu.Lock()
+	t.sorted, t.pending = nil, nil
+	t.sortMu.Unlock()
+	t.mu.Unlock()
+}
+
+// HashDBs is a memtable for keys that are almost always read by exact
+// match. Keys are hashed by the prefix given to NewHash_s, so Get_s and
+// Find_s cost one bucket lookup instead of a skiplist search; the keys are
+// only sorted when NewIterator_s is called, e.g. when the memtable is
+// flushed.
+//
+// Find_s only looks at the keys sharing the prefix of the given key: if
+// none of them is greater than or equal to the key it returns ErrNotFound,
+// even when a greater key with another prefix exists. Keys with the same
+// prefix must also sort next to each other.
+//
+// The returned HashDBs is safe for concurrent use.
+type HashDBs struct {
+	t *hashTable
+}
+
+// NewHash_s creates a HashDBs. The capacity is the initial key/value buffer
+// capacity, and prefix returns the part of a key that is hashed; it must
+// not modify the key.
+func NewHash_s(cmp comparer.BasicComparer, capacity int, prefix func(key []byte) []byte) *HashDBs {
+	return &HashDBs{t: newHashTable(cmp, capacity, prefix)}
+}
+
+// Put_s sets the value for the given key. It overwrites any previous value
+// for that key.
+func (p *HashDBs) Put_s(key []byte, value []byte) error {
+	p.t.put(key, value)
+	return nil
+}
+
+// Delete_s deletes the value for the given key. It returns ErrNotFound if
+// the DB does not contain the key.
+func (p *HashDBs) Delete_s(key []byte) error {
+	return p.t.delete(key)
+}
+
+// Contains_s returns true if the given key are in the DB.
+func (p *HashDBs) Contains_s(key []byte) bool {
+	_, err := p.t.get(key)
+	return err == nil
+}
+
+// Get_s gets the value for the given key. It returns ErrNotFound if the
+// DB does not contain the key.
+func (p *HashDBs) Get_s(key []byte) (value []byte, err error) {
+	return p.t.get(key)
+}
+
+// Find_s finds the first key/value pair with the prefix of the given key
+// whose key is greater than or equal to it, see HashDBs.
+func (p *HashDBs) Find_s(key []byte) (rkey, value []byte, err error) {
+	return p.t.find(key)
+}
+
+// NewIterator_s returns an iterator over a sorted snapshot of the DB; the
+// writes since the last snapshot are merged into it rather than sorting
+// the whole DB again.
+func (p *HashDBs) NewIterator_s(slice *util.Range) iterator.Iterator {
+	return p.t.newIterator(slice)
+}
+
+// Capacity_s returns keys/values buffer capacity.
+func (p *HashDBs) Capacity_s() int {
+	p.t.mu.RLock()
+	defer p.t.mu.RUnlock()
+	return cap(p.t.kvData)
+}
+
+// Size_s returns sum of keys and values length.
+func (p *HashDBs) Size_s() int {
+	p.t.mu.RLock()
+	defer p.t.mu.RUnlock()
+	return p.t.kvSize
+}
+
+// Free_s returns keys/values free buffer before need to grow.
+func (p *HashDBs) Free_s() int {
+	p.t.mu.RLock()
+	defer p.t.mu.RUnlock()
+	return cap(p.t.kvData) - len(p.t.kvData)
+}
+
+// Len_s returns the number of entries in the DB.
+func (p *HashDBs) Len_s() int {
+	p.t.mu.RLock()
+	defer p.t.mu.RUnlock()
+	return p.t.n
+}
+
+// Reset_s resets the DB to initial empty state. Allows reuse the buffer.
+func (p *HashDBs) Reset_s() {
+	p.t.reset()
+}
+
+// Concurrent_s always returns false, writes to a HashDBs are serialized.
+func (p *HashDBs) Concurrent_s() bool {
+	return false
+}
+
+var _ Table_s = (*HashDBs)(nil)
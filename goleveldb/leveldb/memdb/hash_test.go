@@ -0,0 +1,155 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/comparer"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+// hashKeyPrefix hashes by the part before the last byte, so every prefix
+// has a few versions like the internal keys of a user key.
+func hashKeyPrefix(key []byte) []byte {
+	return key[:len(key)-1]
+}
+
+func TestHashMatchesSkiplist(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	want := New_s(comparer.DefaultComparer, 0)
+	got := NewHash_s(comparer.DefaultComparer, 0, hashKeyPrefix)
+	randKey := func() []byte {
+		return []byte(fmt.Sprintf("k%03d%c", rnd.Intn(200), 'a'+rnd.Intn(4)))
+	}
+
+	check := func(slice *util.Range) {
+		t.Helper()
+		wi, gi := want.NewIterator_s(slice), got.NewIterator_s(slice)
+		defer wi.Release()
+		defer gi.Release()
+		for wi.Next() {
+			if !gi.Next() || !bytes.Equal(wi.Key(), gi.Key()) || !bytes.Equal(wi.Value(), gi.Value()) {
+				t.Fatalf("iterator %v: got %q=%q, want %q=%q", slice, gi.Key(), gi.Value(), wi.Key(), wi.Value())
+			}
+		}
+		if gi.Next() {
+			t.Fatalf("iterator %v: got extra key %q", slice, gi.Key())
+		}
+	}
+
+	for i := 0; i < 5000; i++ {
+		key := randKey()
+		switch rnd.Intn(4) {
+		case 0:
+			if (want.Delete_s(key) == nil) != (got.Delete_s(key) == nil) {
+				t.Fatalf("Delete_s %q: results differ", key)
+			}
+		default:
+			value := []byte(fmt.Sprint(i))
+			want.Put_s(key, value)
+			got.Put_s(key, value)
+		}
+
+		key = randKey()
+		wv, werr := want.Get_s(key)
+		gv, gerr := got.Get_s(key)
+		if werr != gerr || !bytes.Equal(wv, gv) {
+			t.Fatalf("Get_s %q: got %q, %v, want %q, %v", key, gv, gerr, wv, werr)
+		}
+		// 只有同一个前缀下的结果才和跳表一致。
+		wk, wv, werr := want.Find_s(key)
+		gk, gv, gerr := got.Find_s(key)
+		if werr == nil && bytes.Equal(hashKeyPrefix(wk), hashKeyPrefix(key)) {
+			if gerr != nil || !bytes.Equal(wk, gk) || !bytes.Equal(wv, gv) {
+				t.Fatalf("Find_s %q: got %q=%q, %v, want %q=%q", key, gk, gv, gerr, wk, wv)
+			}
+		} else if gerr != ErrNotFound {
+			t.Fatalf("Find_s %q: got %q, %v, want %v", key, gk, gerr, ErrNotFound)
+		}
+
+		if i%500 == 0 {
+			check(nil)
+			check(&util.Range{Start: randKey(), Limit: randKey()})
+		}
+	}
+	check(nil)
+	if got.Len_s() != want.Len_s() || got.Size_s() != want.Size_s() {
+		t.Fatalf("Len_s/Size_s: got %d/%d, want %d/%d", got.Len_s(), got.Size_s(), want.Len_s(), want.Size_s())
+	}
+
+	got.Reset_s()
+	if got.Len_s() != 0 || got.Size_s() != 0 || got.Contains_s([]byte("k000a")) {
+		t.Fatal("Reset_s: DB not empty")
+	}
+}
+
+func TestHashIteratorSnapshot(t *testing.T) {
+	p := NewHash_s(comparer.DefaultComparer, 0, hashKeyPrefix)
+	p.Put_s([]byte("a1"), []byte("1"))
+	p.Put_s([]byte("c1"), []byte("3"))
+	iter := p.NewIterator_s(nil)
+	defer iter.Release()
+	// 已经创建的迭代器看不到之后的写入。
+	p.Put_s([]byte("b1"), []byte("2"))
+	var keys []string
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	if fmt.Sprint(keys) != "[a1 c1]" {
+		t.Fatalf("iterator: got %v, want [a1 c1]", keys)
+	}
+
+	iter2 := p.NewIterator_s(&util.Range{Start: []byte("b"), Limit: []byte("c")})
+	defer iter2.Release()
+	if !iter2.Next() || string(iter2.Key()) != "b1" || iter2.Next() {
+		t.Fatal("iterator: want only b1 after the write")
+	}
+}
+
+func TestHashSortMerge(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	want := New_s(comparer.DefaultComparer, 0)
+	got := NewHash_s(comparer.DefaultComparer, 0, hashKeyPrefix)
+	iterated := false
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("k%03d%c", rnd.Intn(100), 'a'+rnd.Intn(4)))
+		if rnd.Intn(3) == 0 {
+			want.Delete_s(key)
+			got.Delete_s(key)
+		} else {
+			value := []byte(fmt.Sprint(i))
+			want.Put_s(key, value)
+			got.Put_s(key, value)
+		}
+		if rnd.Intn(10) != 0 {
+			continue
+		}
+		// 上次排好的结果还在，这次的写入是归并进去的。
+		if iterated && got.t.sorted == nil && got.Len_s() > 10 {
+			t.Fatalf("write %d: sorted snapshot dropped", i)
+		}
+		iterated = true
+		wi, gi := want.NewIterator_s(nil), got.NewIterator_s(nil)
+		for wi.Next() {
+			if !gi.Next() || !bytes.Equal(wi.Key(), gi.Key()) || !bytes.Equal(wi.Value(), gi.Value()) {
+				t.Fatalf("write %d: iterator got %q=%q, want %q=%q", i, gi.Key(), gi.Value(), wi.Key(), wi.Value())
+			}
+		}
+		if gi.Next() {
+			t.Fatalf("write %d: iterator got extra key %q", i, gi.Key())
+		}
+		wi.Release()
+		gi.Release()
+		if len(got.t.pending) != 0 {
+			t.Fatalf("write %d: %d writes left pending", i, len(got.t.pending))
+		}
+	}
+}
@@ -321,6 +321,8 @@ type DB struct {
 	maxHeight int
 	n         int //kv对的数量
 	kvSize    int //kv对的大小
+
+	c *skiplist // 由 NewConcurrent 创建时使用，上面的字段不用
 }
 
 // 写一个结构体继承DB，为is a的关系
@@ -335,8 +337,30 @@ type DBs struct {
 	maxHeight int
 	n         int //kv对的数量
 	kvSize    int //kv对的大小
+
+	c *skiplist
+}
+
+// Table_s is the memtable of the state keyspace. *DBs implements it, and so
+// does the hash indexed memtable created by NewHash_s; the DB rotates, refcounts
+// and pools memtables only through this interface.
+type Table_s interface {
+	Put_s(key []byte, value []byte) error
+	Delete_s(key []byte) error
+	Contains_s(key []byte) bool
+	Get_s(key []byte) (value []byte, err error)
+	Find_s(key []byte) (rkey, value []byte, err error)
+	NewIterator_s(slice *util.Range) iterator.Iterator
+	Capacity_s() int
+	Size_s() int
+	Free_s() int
+	Len_s() int
+	Reset_s()
+	Concurrent_s() bool
 }
 
+var _ Table_s = (*DBs)(nil)
+
 // 跳表是否向上一层
 func (p *DB) randHeight() (h int) {
 	const branching = 4
@@ -495,6 +519,10 @@ func (p *DBs) findLast() int {
 // It is safe to modify the contents of the arguments after Put returns.
 // 向内存中的跳表结构中插入数据，Put
 func (p *DB) Put(key []byte, value []byte) error {
+	if p.c != nil {
+		p.c.put(key, value)
+		return nil
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock() //互斥锁
 
@@ -538,6 +566,10 @@ func (p *DB) Put(key []byte, value []byte) error {
 	return nil
 }
 func (p *DBs) Put_s(key []byte, value []byte) error {
+	if p.c != nil {
+		p.c.put(key, value)
+		return nil
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock() //互斥锁
 
@@ -586,6 +618,9 @@ func (p *DBs) Put_s(key []byte, value []byte) error {
 //
 // It is safe to modify the contents of the arguments after Delete returns.
 func (p *DB) Delete(key []byte) error {
+	if p.c != nil {
+		return p.c.delete(key)
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -605,6 +640,9 @@ func (p *DB) Delete(key []byte) error {
 	return nil
 }
 func (p *DBs) Delete_s(key []byte) error {
+	if p.c != nil {
+		return p.c.delete(key)
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -628,12 +666,20 @@ func (p *DBs) Delete_s(key []byte) error {
 //
 // It is safe to modify the contents of the arguments after Contains returns.
 func (p *DB) Contains(key []byte) bool {
+	if p.c != nil {
+		_, err := p.c.get(key)
+		return err == nil
+	}
 	p.mu.RLock()
 	_, exact := p.findGE(key, false)
 	p.mu.RUnlock()
 	return exact
 }
 func (p *DBs) Contains_s(key []byte) bool {
+	if p.c != nil {
+		_, err := p.c.get(key)
+		return err == nil
+	}
 	p.mu.RLock()
 	_, exact := p.findGE(key, false)
 	p.mu.RUnlock()
@@ -647,6 +693,9 @@ func (p *DBs) Contains_s(key []byte) bool {
 // it is safe to modify the contents of the argument after Get returns.
 // 从跳表中读数据
 func (p *DB) Get(key []byte) (value []byte, err error) {
+	if p.c != nil {
+		return p.c.get(key)
+	}
 	p.mu.RLock()
 	//调用findGE
 	if node, exact := p.findGE(key, false); exact {
@@ -659,6 +708,9 @@ func (p *DB) Get(key []byte) (value []byte, err error) {
 	return
 }
 func (p *DBs) Get_s(key []byte) (value []byte, err error) {
+	if p.c != nil {
+		return p.c.get(key)
+	}
 	p.mu.RLock()
 	//调用findGE
 	if node, exact := p.findGE(key, false); exact {
@@ -678,6 +730,9 @@ func (p *DBs) Get_s(key []byte) (value []byte, err error) {
 // The caller should not modify the contents of the returned slice, but
 // it is safe to modify the contents of the argument after Find returns.
 func (p *DB) Find(key []byte) (rkey, value []byte, err error) {
+	if p.c != nil {
+		return p.c.find(key)
+	}
 	p.mu.RLock()
 	if node, _ := p.findGE(key, false); node != 0 {
 		n := p.nodeData[node]
@@ -691,6 +746,9 @@ func (p *DB) Find(key []byte) (rkey, value []byte, err error) {
 	return
 }
 func (p *DBs) Find_s(key []byte) (rkey, value []byte, err error) {
+	if p.c != nil {
+		return p.c.find(key)
+	}
 	p.mu.RLock()
 	if node, _ := p.findGE(key, false); node != 0 {
 		n := p.nodeData[node]
@@ -725,20 +783,32 @@ func (p *DBs) Find_s(key []byte) (rkey, value []byte, err error) {
 // Also read Iterator documentation of the leveldb/iterator package.
 // 迭代器
 func (p *DB) NewIterator(slice *util.Range) iterator.Iterator {
+	if p.c != nil {
+		return &cIter{l: p.c, slice: slice}
+	}
 	return &dbIter{p: p, slice: slice}
 }
 func (q *DBs) NewIterator_s(slice *util.Range) iterator.Iterator {
+	if q.c != nil {
+		return &cIter{l: q.c, slice: slice}
+	}
 	return &dbIter{q: q, slice: slice}
 }
 
 // Capacity returns keys/values buffer capacity.
 // 返回的是buffer的容量
 func (p *DB) Capacity() int {
+	if p.c != nil {
+		return p.c.arena.size()
+	}
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return cap(p.kvData)
 }
 func (p *DBs) Capacity_s() int {
+	if p.c != nil {
+		return p.c.arena.size()
+	}
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return cap(p.kvData)
@@ -749,11 +819,17 @@ func (p *DBs) Capacity_s() int {
 // the buffer, since the buffer is append only.
 // 返回键和值长度的和。请注意，删除的键/值将不被考虑，但它仍然会消耗缓冲区，因为缓冲区只是追加的。
 func (p *DB) Size() int {
+	if p.c != nil {
+		return int(p.c.kvSize.Load())
+	}
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.kvSize
 }
 func (p *DBs) Size_s() int {
+	if p.c != nil {
+		return int(p.c.kvSize.Load())
+	}
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.kvSize
@@ -762,11 +838,17 @@ func (p *DBs) Size_s() int {
 // Free returns keys/values free buffer before need to grow.
 // 在需要增长之前，返回KV的空闲缓存大小？
 func (p *DB) Free() int {
+	if p.c != nil {
+		return p.c.arena.free()
+	}
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return cap(p.kvData) - len(p.kvData)
 }
 func (q *DBs) Free_s() int {
+	if q.c != nil {
+		return q.c.arena.free()
+	}
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 	return cap(q.kvData) - len(q.kvData)
@@ -774,11 +856,17 @@ func (q *DBs) Free_s() int {
 
 // Len returns the number of entries in the DB.
 func (p *DB) Len() int {
+	if p.c != nil {
+		return int(p.c.n.Load())
+	}
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.n
 }
 func (p *DBs) Len_s() int {
+	if p.c != nil {
+		return int(p.c.n.Load())
+	}
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.n
@@ -786,6 +874,10 @@ func (p *DBs) Len_s() int {
 
 // Reset resets the DB to initial empty state. Allows reuse the buffer.
 func (p *DB) Reset() {
+	if p.c != nil {
+		p.c.reset()
+		return
+	}
 	p.mu.Lock()
 	p.rnd = rand.New(rand.NewSource(0xdeadbeef))
 	p.maxHeight = 1
@@ -804,6 +896,10 @@ func (p *DB) Reset() {
 	p.mu.Unlock()
 } //置空
 func (p *DBs) Reset_s() {
+	if p.c != nil {
+		p.c.reset()
+		return
+	}
 	p.mu.Lock()
 	p.rnd = rand.New(rand.NewSource(0xdeadbeef))
 	p.maxHeight = 1
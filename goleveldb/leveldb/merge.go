@@ -0,0 +1,187 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"errors"
+
+	"awesomeProject1/goleveldb/leveldb/iterator"
+	"awesomeProject1/goleveldb/leveldb/memdb"
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+// errMergeOperand is returned by memGet and version.get when the newest
+// visible record of a key is a merge operand; the caller must then resolve
+// the key with getMerge.
+var errMergeOperand = errors.New("leveldb: unresolved merge operand")
+
+// fullMerge applies operands, ordered from newest to oldest as they are
+// collected, on top of base.
+func fullMerge(mo opt.MergeOperator, ukey, base []byte, operands [][]byte) ([]byte, error) {
+	if mo == nil {
+		return nil, ErrNoMergeOperator
+	}
+	ops := make([][]byte, len(operands))
+	for i, op := range operands {
+		ops[len(operands)-1-i] = op
+	}
+	return mo.FullMerge(ukey, base, ops)
+}
+
+// resolveMerge walks iter, which must be positioned at the newest visible
+// record of ukey, collecting merge operands until a value, a deletion or
+// another user key is reached. Records with the same sequence number may
+// be yielded twice while a memdb is being flushed, those are skipped.
+func (db *DB) resolveMerge(iter iterator.Iterator, ukey []byte) ([]byte, error) {
+	var (
+		operands [][]byte
+		base     []byte
+		lastSeq  = keyMaxSeq + 1
+	)
+	for ok := iter.Valid(); ok; ok = iter.Next() {
+		fukey, fseq, fkt, fkerr := parseInternalKey(iter.Key())
+		if fkerr != nil {
+			return nil, fkerr
+		}
+		if db.s.icmp.uCompare(fukey, ukey) != 0 {
+			break
+		}
+		if fseq == lastSeq {
+			continue
+		}
+		lastSeq = fseq
+		if fkt == keyTypeMerge {
+			operands = append(operands, append([]byte{}, iter.Value()...))
+			continue
+		}
+		if fkt == keyTypeVal {
+			base = append([]byte{}, iter.Value()...)
+		}
+		break
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if len(operands) == 0 {
+		if base == nil {
+			return nil, ErrNotFound
+		}
+		return base, nil
+	}
+	return fullMerge(db.s.o.GetMergeOperator(), ukey, base, operands)
+}
+
+// getMerge resolves the value of the key whose newest visible record is a
+// merge operand. It scans every layer with a merged iterator since the
+// operands may be spread over memdbs and tables.
+func (db *DB) getMerge(auxm *memdb.DB, auxt tFiles, ikey internalKey, ro *opt.ReadOptions) ([]byte, error) {
+	var its []iterator.Iterator
+	if auxm != nil {
+		its = append(its, auxm.NewIterator(nil))
+	}
+	em, fm := db.getMems()
+	defer em.decref()
+	its = append(its, em.NewIterator(nil))
+	if fm != nil {
+		defer fm.decref()
+		its = append(its, fm.NewIterator(nil))
+	}
+	v := db.s.version()
+	defer v.release()
+	for _, t := range auxt {
+		its = append(its, v.s.tops.newIterator(t, nil, ro))
+	}
+	its = append(its, v.getIterators(nil, ro)...)
+
+	iter := iterator.NewMergedIterator(its, db.s.icmp, opt.GetStrict(db.s.o.Options, ro, opt.StrictReader))
+	defer iter.Release()
+	iter.Seek(ikey)
+	return db.resolveMerge(iter, ikey.ukey())
+}
+
+func (db *DB) getMerge_s(auxm memdb.Table_s, auxt sFiles, ikey internalKey, ro *opt.ReadOptions) ([]byte, error) {
+	var its []iterator.Iterator
+	if auxm != nil {
+		its = append(its, auxm.NewIterator_s(nil))
+	}
+	em, fm := db.getMems_s()
+	defer em.decref_s()
+	its = append(its, em.NewIterator_s(nil))
+	if fm != nil {
+		defer fm.decref_s()
+		its = append(its, fm.NewIterator_s(nil))
+	}
+	v := db.s.version()
+	defer v.release()
+	for _, t := range auxt {
+		its = append(its, v.s.tops.newIterator_s(t, nil, ro))
+	}
+	its = append(its, v.getIterators_s(nil, ro)...)
+
+	iter := iterator.NewMergedIterator(its, db.s.icmp, opt.GetStrict(db.s.o.Options, ro, opt.StrictReader))
+	defer iter.Release()
+	iter.Seek(ikey)
+	return db.resolveMerge(iter, ikey.ukey())
+}
+
+// mergeCollector gathers the merge operands of a single user key during
+// table compaction, so that they can be collapsed before written out.
+type mergeCollector struct {
+	mo       opt.MergeOperator
+	ukey     []byte
+	ikeys    []internalKey // newest first
+	operands [][]byte      // newest first
+}
+
+func (mc *mergeCollector) active() bool {
+	return len(mc.ikeys) > 0
+}
+
+func (mc *mergeCollector) add(ikey internalKey, operand []byte) {
+	if !mc.active() {
+		mc.ukey = append(mc.ukey[:0], ikey.ukey()...)
+	}
+	mc.ikeys = append(mc.ikeys, append(internalKey{}, ikey...))
+	mc.operands = append(mc.operands, append([]byte{}, operand...))
+}
+
+func (mc *mergeCollector) reset() {
+	mc.ikeys = mc.ikeys[:0]
+	mc.operands = mc.operands[:0]
+}
+
+// finish writes out the collected operands using emit. If hasBase is true
+// the operands are fully merged on top of base, which is nil for a deletion
+// or when no older record can exist; otherwise they are partially merged
+// into a single operand where possible. The collected operands are written
+// as is if the merge operator fails. It returns false if the record that
+// terminated the run must be kept as well.
+func (mc *mergeCollector) finish(hasBase bool, base []byte, emit func(key, value []byte) error) (consumed bool, err error) {
+	defer mc.reset()
+	newest := mc.ikeys[0]
+	seq, _ := newest.parseNum()
+	if hasBase {
+		if value, merr := fullMerge(mc.mo, mc.ukey, base, mc.operands); merr == nil {
+			return true, emit(makeInternalKey(nil, mc.ukey, seq, keyTypeVal), value)
+		}
+	} else {
+		operand := mc.operands[len(mc.operands)-1]
+		ok := true
+		for i := len(mc.operands) - 2; i >= 0 && ok; i-- {
+			operand, ok = mc.mo.PartialMerge(mc.ukey, operand, mc.operands[i])
+		}
+		if ok {
+			return true, emit(newest, operand)
+		}
+	}
+	for i, ikey := range mc.ikeys {
+		if err := emit(ikey, mc.operands[i]); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+// testMergeOperator joins operands with ','. Partial merge is refused for
+// operands starting with '!' so both merge paths of compaction are covered.
+type testMergeOperator struct{}
+
+func (testMergeOperator) Name() string { return "leveldb.TestJoin" }
+
+func (testMergeOperator) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	parts := operands
+	if existing != nil {
+		parts = append([][]byte{existing}, operands...)
+	}
+	return bytes.Join(parts, []byte{','}), nil
+}
+
+func (testMergeOperator) PartialMerge(key, older, newer []byte) ([]byte, bool) {
+	if bytes.HasPrefix(older, []byte{'!'}) || bytes.HasPrefix(newer, []byte{'!'}) {
+		return nil, false
+	}
+	return bytes.Join([][]byte{older, newer}, []byte{','}), true
+}
+
+func newMergeHarness(t *testing.T) *dbHarness {
+	return newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MergeOperator:                testMergeOperator{},
+	})
+}
+
+func (h *dbHarness) merge(key, operand string) {
+	if err := h.db.Merge([]byte(key), []byte(operand), h.wo); err != nil {
+		h.t.Error("Merge: got error: ", err)
+	}
+}
+
+func TestDB_Merge(t *testing.T) {
+	h := newMergeHarness(t)
+	defer h.close()
+
+	h.put("key-a", "v1")
+	h.merge("key-a", "m1")
+	h.merge("key-b", "m1")
+	h.merge("key-b", "m2")
+	h.put("key-c", "v1")
+	h.delete("key-c")
+	h.merge("key-c", "m1")
+	h.getVal("key-a", "v1,m1")
+	h.getVal("key-b", "m1,m2")
+	h.getVal("key-c", "m1")
+
+	// Operands spread over memdb and tables.
+	h.compactMem()
+	h.merge("key-a", "m2")
+	h.getVal("key-a", "v1,m1,m2")
+
+	snap := h.getSnapshot()
+	h.merge("key-a", "m3")
+	h.getValr(snap, "key-a", "v1,m1,m2")
+	snap.Release()
+	h.getVal("key-a", "v1,m1,m2,m3")
+
+	h.compactMem()
+	h.compactRange("", "")
+	h.getVal("key-a", "v1,m1,m2,m3")
+	h.getVal("key-b", "m1,m2")
+	h.getVal("key-c", "m1")
+
+	h.reopenDB()
+	h.getVal("key-a", "v1,m1,m2,m3")
+}
+
+func TestDB_MergeIterator(t *testing.T) {
+	h := newMergeHarness(t)
+	defer h.close()
+
+	h.put("key-a", "v1")
+	h.merge("key-a", "m1")
+	h.compactMem()
+	h.merge("key-a", "m2")
+	h.merge("key-b", "m1")
+	h.put("key-c", "v1")
+
+	want := []string{"key-a=v1,m1,m2", "key-b=m1", "key-c=v1"}
+	iter := h.db.NewIterator(nil, nil)
+	defer iter.Release()
+	var got []string
+	for iter.Next() {
+		got = append(got, string(iter.Key())+"="+string(iter.Value()))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("forward: got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("forward: got %q, want %q", got, want)
+		}
+	}
+	got = got[:0]
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		got = append(got, string(iter.Key())+"="+string(iter.Value()))
+	}
+	for i := range want {
+		if got[len(got)-1-i] != want[i] {
+			t.Fatalf("backward: got %q, want %q", got, want)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal("iterator error: ", err)
+	}
+}
+
+func TestDB_MergePartial(t *testing.T) {
+	h := newMergeHarness(t)
+	defer h.close()
+
+	// Keep an older value in a deeper level, so operands above it can only
+	// be partially merged.
+	h.put("key-a", "v1")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.merge("key-a", "m1")
+	h.merge("key-a", "m2")
+	h.merge("key-a", "!m3")
+	h.compactMem()
+	h.getVal("key-a", "v1,m1,m2,!m3")
+	h.merge("key-a", "m4")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.getVal("key-a", "v1,m1,m2,!m3,m4")
+	h.compactRange("", "")
+	h.getVal("key-a", "v1,m1,m2,!m3,m4")
+}
+
+func TestDB_MergeBatchState(t *testing.T) {
+	h := newMergeHarness(t)
+	defer h.close()
+
+	b := new(Batch)
+	b.Put([]byte("state-a"), []byte("v1"))
+	b.Merge([]byte("state-a"), []byte("m1"))
+	if err := h.db.Write_s(b, nil); err != nil {
+		t.Fatal("Write_s: got error: ", err)
+	}
+	if err := h.db.Merge_s([]byte("state-a"), []byte("m2"), nil); err != nil {
+		t.Fatal("Merge_s: got error: ", err)
+	}
+	h.reopenDB()
+	v, err := h.db.Get_s([]byte("state-a"), nil)
+	if err != nil {
+		t.Fatal("Get_s: got error: ", err)
+	}
+	if string(v) != "v1,m1,m2" {
+		t.Fatalf("Get_s: got %q, want %q", v, "v1,m1,m2")
+	}
+}
+
+func TestDB_MergeNoOperator(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	if err := h.db.Merge([]byte("key-a"), []byte("m1"), nil); err != ErrNoMergeOperator {
+		t.Fatalf("Merge: got %v, want %v", err, ErrNoMergeOperator)
+	}
+	b := new(Batch)
+	b.Merge([]byte("key-a"), []byte("m1"))
+	if err := h.db.Write(b, nil); err != ErrNoMergeOperator {
+		t.Fatalf("Write: got %v, want %v", err, ErrNoMergeOperator)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"fmt"
+	"time"
+)
+
+// Keyspace identifies which of the two LSM-trees of the DB something
+// concerns.
+type Keyspace int
+
+const (
+	// KeyspaceMain is the tree written by Put, Delete and Write.
+	KeyspaceMain Keyspace = iota
+	// KeyspaceState is the tree written by Put_s and Write_s.
+	KeyspaceState
+)
+
+func (ks Keyspace) String() string {
+	switch ks {
+	case KeyspaceMain:
+		return "main"
+	case KeyspaceState:
+		return "state"
+	}
+	return fmt.Sprintf("<unknown:%d>", int(ks))
+}
+
+// TableInfo describes a 'sorted table' file.
+type TableInfo struct {
+	// Keyspace is KeyspaceMain for the leftover tables removed on open,
+	// which belong to no version.
+	Keyspace Keyspace
+	// Level is the level the table belongs to. It is -1 for TableCreated
+	// and TableDeleted, since a file is not bound to a level at that point.
+	Level int
+	Num   int64
+	// Size is the file size in bytes. It is zero for TableDeleted.
+	Size int64
+}
+
+// FlushInfo describes a memdb flush.
+type FlushInfo struct {
+	Keyspace Keyspace
+	// Entries and Size are the number of entries and bytes of the memdb.
+	Entries int
+	Size    int
+	// Output and Duration are only set for FlushEnd.
+	Output   []TableInfo
+	Duration time.Duration
+	// Err is set for FlushEnd if the flush was abandoned, e.g. because the
+	// DB is closing; the memdb is flushed again from its journal later.
+	Err error
+}
+
+// CompactionInfo describes a table compaction.
+type CompactionInfo struct {
+	Keyspace    Keyspace
+	SourceLevel int
+	// Trivial is true if the table is moved to the next level without
+	// being rewritten.
+	Trivial bool
+	// IntraL0 is true if level-0 tables were merged back into level-0,
+	// see Options.IntraL0Ratio.
+	IntraL0    bool
+	Input      []TableInfo
+	InputBytes int64
+	// Output, OutputBytes and Duration are only set for CompactionEnd.
+	Output      []TableInfo
+	OutputBytes int64
+	Duration    time.Duration
+	// Err is set for CompactionEnd if the compaction was abandoned, e.g.
+	// because the DB is closing; the input tables are left as they were.
+	Err error
+}
+
+// WriteStallInfo describes a write pause caused by too many level-0 tables.
+type WriteStallInfo struct {
+	Keyspace Keyspace
+	// Duration is only set for WriteStallEnd.
+	Duration time.Duration
+}
+
+// ManifestInfo describes a manifest rotation. A manifest holds both
+// keyspaces, so it has no Keyspace.
+type ManifestInfo struct {
+	Num int64
+	// PrevNum is the number of the replaced manifest, zero if none.
+	PrevNum int64
+}
+
+// EventListener holds callbacks invoked on background events of the DB.
+// Any callback may be nil. The callbacks are called synchronously from the
+// goroutine doing the work, so they should return quickly.
+type EventListener struct {
+	// FlushBegin and FlushEnd are called around a memdb flush.
+	FlushBegin func(FlushInfo)
+	FlushEnd   func(FlushInfo)
+
+	// CompactionBegin and CompactionEnd are called around a table compaction.
+	CompactionBegin func(CompactionInfo)
+	CompactionEnd   func(CompactionInfo)
+
+	// WriteStallBegin and WriteStallEnd are called when writes are paused
+	// and resumed.
+	WriteStallBegin func(WriteStallInfo)
+	WriteStallEnd   func(WriteStallInfo)
+
+	// TableCreated is called after a table file is written and synced.
+	// TableDeleted is called after an obsolete table file is removed,
+	// including the output of an abandoned flush or compaction and the
+	// leftover tables removed on open.
+	TableCreated func(TableInfo)
+	TableDeleted func(TableInfo)
+
+	// ManifestCreated is called after a new manifest has been installed.
+	ManifestCreated func(ManifestInfo)
+}
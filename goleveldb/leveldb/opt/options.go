@@ -9,10 +9,12 @@ package opt
 
 import (
 	"math"
+	"time"
 
 	"awesomeProject1/goleveldb/leveldb/cache"
 	"awesomeProject1/goleveldb/leveldb/comparer"
 	"awesomeProject1/goleveldb/leveldb/filter"
+	"awesomeProject1/goleveldb/leveldb/util"
 )
 
 const (
@@ -37,7 +39,13 @@ var (
 	DefaultCompactionTotalSize           = 10 * MiB //表示 LevelDB 中每个层级（除了 Level 0）所有 SST 文件的总大小
 	DefaultCompactionTotalSizeMultiplier = 10.0     //用来计算Level 2以上的大小
 	DefaultCompressionType               = SnappyCompression
+	DefaultDynamicLevels                 = 7
 	DefaultIteratorSamplingRate          = 1 * MiB
+	DefaultJournalSyncBytes              = 1 * MiB
+	DefaultJournalSyncInterval           = 100 * time.Millisecond
+	DefaultMaxBackgroundCompactions      = 2
+	DefaultMaxSubcompactions             = 1
+	DefaultSubscriptionBuffer            = 4 * MiB
 	DefaultOpenFilesCacher               = LRUCacher
 	DefaultOpenFilesCacheCapacity        = 500     //最大缓存/打开500个sst文件
 	DefaultWriteBuffer                   = 4 * MiB //mem的大小
@@ -75,6 +83,33 @@ var (
 	NoCacher = &CacherFunc{}
 )
 
+// MergeOperator defines how 'merge operand' records written by Merge are
+// combined with the value they apply to. Operands are resolved lazily, i.e.
+// during Get, iteration and table compaction, never at write time.
+//
+// The same merge operator must be used for reads and writes over the
+// lifetime of the DB.
+type MergeOperator interface {
+	// Name returns name of the merge operator.
+	Name() string
+
+	// FullMerge applies the operands, ordered from oldest to newest, on top
+	// of the existing value. The existing value is nil if the key does not
+	// exist or has been deleted.
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, error)
+
+	// PartialMerge combines two adjacent operands into a single operand
+	// without knowing the existing value. It returns false if the operands
+	// cannot be combined, in which case both operands are kept as is.
+	PartialMerge(key, older, newer []byte) ([]byte, bool)
+}
+
+// RateLimiter limits the throughput of background writes.
+type RateLimiter interface {
+	// Request blocks until n bytes may be written with the given priority.
+	Request(n int, pri util.IOPriority)
+}
+
 // Compression is the 'sorted table' block compression algorithm to use.
 type Compression uint
 
@@ -97,6 +132,63 @@ const (
 	nCompression                          // 3
 )
 
+// MemTable is the 'memdb' implementation to use.
+type MemTable uint
+
+func (m MemTable) String() string {
+	switch m {
+	case DefaultMemTable:
+		return "default"
+	case SkipListMemTable:
+		return "skiplist"
+	case ConcurrentMemTable:
+		return "concurrent"
+	case HashMemTable:
+		return "hash"
+	}
+	return "invalid"
+}
+
+const (
+	DefaultMemTable    MemTable = iota // 0
+	SkipListMemTable                   // 1
+	ConcurrentMemTable                 // 2
+	HashMemTable                       // 3
+	nMemTable                          // 4
+)
+
+// JournalSync is the journal sync policy of a keyspace.
+type JournalSync uint
+
+func (j JournalSync) String() string {
+	switch j {
+	case DefaultJournalSync:
+		return "default"
+	case SyncOnRequest:
+		return "on-request"
+	case SyncGroup:
+		return "group"
+	case SyncBackground:
+		return "background"
+	}
+	return "invalid"
+}
+
+const (
+	DefaultJournalSync JournalSync = iota // 0
+	// SyncOnRequest syncs the journal for writes with WriteOptions.Sync.
+	SyncOnRequest // 1
+	// SyncGroup syncs the journal for every write. Concurrent writers are
+	// merged into one journal write and one fsync, see
+	// Options.JournalGroupCommitDelay.
+	SyncGroup // 2
+	// SyncBackground syncs the journal in the background every
+	// Options.JournalSyncInterval, or once Options.JournalSyncBytes were
+	// written since the last sync. WriteOptions.Sync is still honored.
+	SyncBackground // 3
+	nJournalSync   // 4
+)
+
 // Strict is the DB 'strict level'.
 type Strict uint
 
@@ -182,6 +274,39 @@ type Options struct {
 	// The default value is 4KiB.
 	BlockSize int
 
+	// ColdDir is the directory of the tables of the cold levels of both
+	// keyspaces, e.g. on a larger and slower disk. Used by OpenFile and
+	// RecoverFile, see storage.FileDirs.
+	//
+	// The default value is empty, see TableDir.
+	ColdDir string
+
+	// ColdLevel is the first level of the main keyspace whose tables go to
+	// the cold directory: compactions write their output there and a
+	// background mover relocates the tables that reached such a level by a
+	// trivial move, or after ColdLevel changed. Requires ColdDir and a
+	// storage implementing storage.Tierer; the mover doesn't run without
+	// them, nor once both ColdLevel and ColdLevel2 are unset.
+	//
+	// The default value is 0, which keeps all levels out of the cold
+	// directory.
+	ColdLevel int
+
+	// ColdLevel2 is ColdLevel for the state keyspace.
+	//
+	// The default value is 0, which keeps all levels out of the cold
+	// directory.
+	ColdLevel2 int
+
+	// CompactionDeletionRatio enables compaction of tables whose ratio of
+	// deletion markers to entries, as recorded in their properties block,
+	// reaches this value. Such a table is only picked when no level needs a
+	// size or seek compaction, and only if it overlaps the next level so
+	// the compaction can actually drop the markers.
+	//
+	// The default value is 0, which disables it.
+	CompactionDeletionRatio float64
+
 	// CompactionExpandLimitFactor limits compaction size after expanded.
 	// This will be multiplied by table size limit at compaction target level.
 	//
@@ -292,6 +417,25 @@ type Options struct {
 	// The default is false.
 	DisableSeeksCompaction bool
 
+	// DynamicLevelBytes sizes the levels backward from the actual size of
+	// the last level instead of from CompactionTotalSize: each level above
+	// it is CompactionTotalSizeMultiplier times smaller, down to the first
+	// one that would be smaller than CompactionTotalSize(1), the base level.
+	// Level-0 compactions go straight to the base level, so the levels
+	// above it stay empty while the DB is small, and the non-last levels
+	// never hold much more than a 1/(multiplier-1) share of the data.
+	// Applies to both keyspaces.
+	//
+	// The default value is false.
+	DynamicLevelBytes bool
+
+	// DynamicLevels is the number of levels of a DynamicLevelBytes DB,
+	// including level-0. Data of a new DB lands in the last of them; a DB
+	// already having deeper levels keeps using its deepest level.
+	//
+	// The default value is 7.
+	DynamicLevels int
+
 	// ErrorIfExist defines whether an error should returned if the DB already
 	// exist.
 	//
@@ -305,6 +449,12 @@ type Options struct {
 	// The default value is false.
 	ErrorIfMissing bool
 
+	// EventListener defines callbacks for flushes, compactions, write stalls
+	// and file lifecycle events.
+	//
+	// The default value is nil.
+	EventListener *EventListener
+
 	// Filter defines an 'effective filter' to use. An 'effective filter'
 	// if defined will be used to generate per-table filter block.
 	// The filter name will be stored on disk.
@@ -320,6 +470,30 @@ type Options struct {
 	// The default value is nil.
 	Filter filter.Filter
 
+	// FlushGuards are user keys at which the 'sorted table' written by a
+	// 'memdb' flush of the main keyspace is split, so that each level-0
+	// table covers a narrower key range. Intra-L0 compactions split their
+	// output the same way. The keys need not be sorted.
+	//
+	// The default value is nil.
+	FlushGuards [][]byte
+
+	// FlushGuards2 is FlushGuards for the state keyspace.
+	FlushGuards2 [][]byte
+
+	// FlushSplitL1 splits the flush output at the boundaries of the level-1
+	// tables as well, so a level-0 table only overlaps the level-1 tables of
+	// its own key range and the level-0 compaction picking it rewrites few
+	// of them.
+	//
+	// When flushes are split, or IntraL0Ratio is set, level-0 is measured
+	// in sorted runs: the largest number of level-0 tables overlapping at
+	// any key. That is what CompactionL0Trigger and the WriteL0 triggers
+	// are compared with.
+	//
+	// The default value is false.
+	FlushSplitL1 bool
+
 	// IteratorSamplingRate defines approximate gap (in bytes) between read
 	// sampling of an iterator. The samples will be used to determine when
 	// compaction should be triggered.
@@ -329,6 +503,124 @@ type Options struct {
 	// The default is 1MiB.
 	IteratorSamplingRate int
 
+	// IntraL0Ratio enables intra-L0 compaction. When a level-0 compaction is
+	// due but the level-1 tables it would rewrite are more than IntraL0Ratio
+	// times the size of its level-0 tables, the level-0 tables are merged
+	// into new level-0 tables instead, split like a flush. This is skipped
+	// once level-0 holds as many bytes as CompactionTotalSize(1).
+	//
+	// The default value is zero, which disables intra-L0 compaction.
+	IntraL0Ratio int
+
+	// JournalCompression is the compression of journal records. Records
+	// are compressed one by one and only stored compressed if that makes
+	// them smaller; a flag in the chunk header tells the reader.
+	//
+	// The default value (DefaultCompression) uses NoCompression.
+	JournalCompression Compression
+
+	// JournalDir is the directory of the journals of both keyspaces, e.g.
+	// on a disk with fast fsync. Used by OpenFile and RecoverFile, see
+	// storage.FileDirs.
+	//
+	// The default value is empty, see TableDir.
+	JournalDir string
+
+	// JournalGroupCommitDelay is how long a writer about to sync the
+	// journal waits for more writers to merge into the same fsync. It only
+	// waits when other writers were already merged, so a lone writer is
+	// never delayed. Write merge must be enabled.
+	//
+	// The default value is zero, which only merges the writers already
+	// waiting.
+	JournalGroupCommitDelay time.Duration
+
+	// JournalRecycle is the number of flushed journal files kept as
+	// '.log.free' and overwritten by the next journals of either keyspace
+	// instead of creating new files, which saves the filesystem metadata
+	// updates at high write rates. Journals are then written in a format
+	// carrying the file number in each chunk header, so that the leftovers
+	// of a recycled file aren't replayed. JournalRetention takes precedence.
+	// Requires a storage implementing storage.Reuser.
+	//
+	// The default value is 0, which removes journals once flushed.
+	JournalRecycle int
+
+	// JournalRetention is the number of journal files of each keyspace kept
+	// after their content has been flushed, so DB.Subscribe can replay them,
+	// also after a restart. Kept journals are renamed to '.log.old' and
+	// '.logs.old' and never replayed on open.
+	//
+	// The default value is 0, which removes journals once flushed.
+	JournalRetention int
+
+	// JournalSync is the journal sync policy of the main keyspace, see
+	// JournalSync. Options.NoSync overrides it.
+	//
+	// The default value is SyncOnRequest.
+	JournalSync JournalSync
+
+	// JournalSync2 is JournalSync for the state keyspace.
+	JournalSync2 JournalSync
+
+	// JournalSyncBytes is how many journal bytes SyncBackground lets
+	// accumulate before syncing ahead of JournalSyncInterval.
+	//
+	// The default value is 1MiB.
+	JournalSyncBytes int
+
+	// JournalSyncInterval is the period of SyncBackground.
+	//
+	// The default value is 100ms.
+	JournalSyncInterval time.Duration
+
+	// MaxBackgroundCompactions is the number of table compactions that may
+	// run at the same time, shared by both keyspaces. Each keyspace runs at
+	// most one table compaction at a time (use MaxSubcompactions to split a
+	// single compaction), so the only meaningful values are 1, where the
+	// keyspaces take turns, and 2; larger values are treated as 2. When the
+	// keyspaces compete for the slot, the one stalling writes is preferred,
+	// then the one with the higher compaction score.
+	//
+	// The default value is 2.
+	MaxBackgroundCompactions int
+
+	// MaxSubcompactions limits the number of key-range partitions a table
+	// compaction is split into. The partitions are built concurrently and
+	// committed together. A compaction is split at the boundaries of its
+	// input tables, so it never has more partitions than input tables.
+	//
+	// The default value is 1, which disables subcompactions.
+	MaxSubcompactions int
+
+	// MemTable defines the 'memdb' implementation of the main keyspace.
+	// ConcurrentMemTable lets merged writes insert into the 'memdb' in
+	// parallel and serves reads without locking.
+	//
+	// The default value is SkipListMemTable.
+	MemTable MemTable
+
+	// MemTable2 is MemTable for the state keyspace. HashMemTable, which is
+	// only supported here, indexes the 'memdb' by user key for exact lookups
+	// and sorts it only when it is iterated or flushed.
+	MemTable2 MemTable
+
+	// MemoryLimit caps the memory held by the 'memdb' of both keyspaces,
+	// frozen ones included, together with the block cache. The block cache
+	// capacity is set to what the 'memdb' leave, each time one is rotated or
+	// a ConcurrentMemTable outgrows its buffer, but never below an eighth of
+	// the limit. Setting it implies WriteBufferBudget, see
+	// GetWriteBufferBudget.
+	//
+	// The default value is zero, which means no limit.
+	MemoryLimit int
+
+	// MergeOperator defines the merge operator used to resolve 'merge operand'
+	// records. Merge returns an error if no merge operator is defined.
+	//
+	// The default value is nil.
+	MergeOperator MergeOperator
+
 	// NoSync allows completely disable fsync.
 	//
 	// The default is false.
@@ -351,14 +643,47 @@ type Options struct {
 	// The default value is 500.
 	OpenFilesCacheCapacity int
 
-	// If true then opens DB in read-only mode.
+	// RateLimiter limits the write throughput of memdb flushes and table
+	// compactions of both keyspaces. Flushes are given priority over
+	// compactions. util.RateLimiter implements this interface.
+	//
+	// The default value is nil, which means no limit.
+	RateLimiter RateLimiter
+
+	// If true then opens DB in read-only mode. The journals of both
+	// keyspaces are replayed into memory, nothing is written.
 	//
 	// The default value is false.
 	ReadOnly bool
 
+	// StateTableDir is the directory of the tables of the state keyspace,
+	// e.g. on NVMe for the trie nodes. Used by OpenFile and RecoverFile,
+	// see storage.FileDirs.
+	//
+	// The default value is empty, see TableDir.
+	StateTableDir string
+
 	// Strict defines the DB strict level.
 	Strict Strict
 
+	// SubscriptionBuffer is the amount of committed changes, in bytes, a
+	// subscription may queue before its subscriber is considered too slow
+	// and the subscription fails with ErrSubscriberLagged.
+	//
+	// The default value is 4MiB.
+	SubscriptionBuffer int
+
+	// TableDir is the directory of the tables of the main keyspace, e.g. on
+	// a larger disk for the block bodies. Used by OpenFile and RecoverFile,
+	// see storage.FileDirs. Files placed in other directories before are
+	// moved on open; to move them back into the DB directory set the
+	// directories to the DB path.
+	//
+	// The default value is empty: if none of TableDir, StateTableDir,
+	// JournalDir and ColdDir is set, the directories last used by the DB
+	// are kept, or everything goes in the DB directory.
+	TableDir string
+
 	//WriteBuffer defines maximum size of a 'memdb' before flushed to
 	//'sorted table'. 'memdb' is an in-memory DB backed by an on-disk
 	//unsorted journal.
@@ -371,6 +696,17 @@ type Options struct {
 	//WriteBuuffer2 defines maximum size of a memdb in another LSM-tree
 	WriteBuffer2 int
 
+	// WriteBufferBudget is the total size of the active 'memdb' of both
+	// keyspaces. When it is set WriteBuffer and WriteBuffer2 only give the
+	// initial split: each time a 'memdb' is rotated the budget is split
+	// again by the recent write rate of each keyspace, so a burst of writes
+	// to one keyspace gets a larger 'memdb'. Neither keyspace gets less than
+	// an eighth of the budget.
+	//
+	// The default value is zero, which keeps WriteBuffer and WriteBuffer2
+	// static unless MemoryLimit is set.
+	WriteBufferBudget int
+
 	// WriteL0StopTrigger defines number of 'sorted table' at level-0 that will
 	// pause write.
 	//
@@ -430,6 +766,34 @@ func (o *Options) GetBlockSize() int {
 	return o.BlockSize
 }
 
+func (o *Options) GetColdDir() string {
+	if o == nil {
+		return ""
+	}
+	return o.ColdDir
+}
+
+func (o *Options) GetColdLevel() int {
+	if o == nil || o.ColdLevel <= 0 {
+		return 0
+	}
+	return o.ColdLevel
+}
+
+func (o *Options) GetColdLevel2() int {
+	if o == nil || o.ColdLevel2 <= 0 {
+		return 0
+	}
+	return o.ColdLevel2
+}
+
+func (o *Options) GetCompactionDeletionRatio() float64 {
+	if o == nil || o.CompactionDeletionRatio <= 0 {
+		return 0
+	}
+	return o.CompactionDeletionRatio
+}
+
 func (o *Options) GetCompactionExpandLimit(level int) int {
 	factor := DefaultCompactionExpandLimitFactor
 	if o != nil && o.CompactionExpandLimitFactor > 0 {
@@ -510,6 +874,13 @@ func (o *Options) GetCompactionTotalSize(level int) int64 {
 	return int64(float64(base) * mult) //base=10m
 }
 
+func (o *Options) GetCompactionTotalSizeMultiplier() float64 {
+	if o == nil || o.CompactionTotalSizeMultiplier <= 0 {
+		return DefaultCompactionTotalSizeMultiplier
+	}
+	return o.CompactionTotalSizeMultiplier
+}
+
 func (o *Options) GetComparer() comparer.Comparer {
 	if o == nil || o.Comparer == nil {
 		return comparer.DefaultComparer
@@ -559,6 +930,20 @@ func (o *Options) GetDisableSeeksCompaction() bool {
 	return o.DisableSeeksCompaction
 }
 
+func (o *Options) GetDynamicLevelBytes() bool {
+	if o == nil {
+		return false
+	}
+	return o.DynamicLevelBytes
+}
+
+func (o *Options) GetDynamicLevels() int {
+	if o == nil || o.DynamicLevels < 2 {
+		return DefaultDynamicLevels
+	}
+	return o.DynamicLevels
+}
+
 func (o *Options) GetErrorIfExist() bool {
 	if o == nil {
 		return false
@@ -573,6 +958,13 @@ func (o *Options) GetErrorIfMissing() bool {
 	return o.ErrorIfMissing
 }
 
+func (o *Options) GetEventListener() *EventListener {
+	if o == nil {
+		return nil
+	}
+	return o.EventListener
+}
+
 func (o *Options) GetFilter() filter.Filter {
 	if o == nil {
 		return nil
@@ -580,6 +972,34 @@ func (o *Options) GetFilter() filter.Filter {
 	return o.Filter
 }
 
+func (o *Options) GetFlushGuards() [][]byte {
+	if o == nil {
+		return nil
+	}
+	return o.FlushGuards
+}
+
+func (o *Options) GetFlushGuards2() [][]byte {
+	if o == nil {
+		return nil
+	}
+	return o.FlushGuards2
+}
+
+func (o *Options) GetFlushSplitL1() bool {
+	if o == nil {
+		return false
+	}
+	return o.FlushSplitL1
+}
+
+func (o *Options) GetIntraL0Ratio() int {
+	if o == nil || o.IntraL0Ratio < 0 {
+		return 0
+	}
+	return o.IntraL0Ratio
+}
+
 func (o *Options) GetIteratorSamplingRate() int {
 	if o == nil || o.IteratorSamplingRate == 0 {
 		return DefaultIteratorSamplingRate
@@ -589,6 +1009,114 @@ func (o *Options) GetIteratorSamplingRate() int {
 	return o.IteratorSamplingRate
 }
 
+func (o *Options) GetJournalCompression() Compression {
+	if o == nil || o.JournalCompression <= DefaultCompression || o.JournalCompression >= nCompression {
+		return NoCompression
+	}
+	return o.JournalCompression
+}
+
+func (o *Options) GetJournalDir() string {
+	if o == nil {
+		return ""
+	}
+	return o.JournalDir
+}
+
+func (o *Options) GetJournalGroupCommitDelay() time.Duration {
+	if o == nil || o.JournalGroupCommitDelay <= 0 {
+		return 0
+	}
+	return o.JournalGroupCommitDelay
+}
+
+func (o *Options) GetJournalRecycle() int {
+	if o == nil || o.JournalRecycle <= 0 {
+		return 0
+	}
+	return o.JournalRecycle
+}
+
+func (o *Options) GetJournalRetention() int {
+	if o == nil || o.JournalRetention <= 0 {
+		return 0
+	}
+	return o.JournalRetention
+}
+
+func (o *Options) GetJournalSync() JournalSync {
+	if o == nil || o.JournalSync == DefaultJournalSync || o.JournalSync >= nJournalSync {
+		return SyncOnRequest
+	}
+	return o.JournalSync
+}
+
+func (o *Options) GetJournalSync2() JournalSync {
+	if o == nil || o.JournalSync2 == DefaultJournalSync || o.JournalSync2 >= nJournalSync {
+		return SyncOnRequest
+	}
+	return o.JournalSync2
+}
+
+func (o *Options) GetJournalSyncBytes() int {
+	if o == nil || o.JournalSyncBytes <= 0 {
+		return DefaultJournalSyncBytes
+	}
+	return o.JournalSyncBytes
+}
+
+func (o *Options) GetJournalSyncInterval() time.Duration {
+	if o == nil || o.JournalSyncInterval <= 0 {
+		return DefaultJournalSyncInterval
+	}
+	return o.JournalSyncInterval
+}
+
+func (o *Options) GetMaxBackgroundCompactions() int {
+	if o == nil || o.MaxBackgroundCompactions <= 0 {
+		return DefaultMaxBackgroundCompactions
+	} else if o.MaxBackgroundCompactions > 2 {
+		// 每个 keyspace 只有一个 compaction 循环。
+		return 2
+	}
+	return o.MaxBackgroundCompactions
+}
+
+func (o *Options) GetMaxSubcompactions() int {
+	if o == nil || o.MaxSubcompactions <= 0 {
+		return DefaultMaxSubcompactions
+	}
+	return o.MaxSubcompactions
+}
+
+func (o *Options) GetMemTable() MemTable {
+	if o == nil || o.MemTable == DefaultMemTable || o.MemTable == HashMemTable || o.MemTable >= nMemTable {
+		return SkipListMemTable
+	}
+	return o.MemTable
+}
+
+func (o *Options) GetMemTable2() MemTable {
+	if o == nil || o.MemTable2 == DefaultMemTable || o.MemTable2 >= nMemTable {
+		return SkipListMemTable
+	}
+	return o.MemTable2
+}
+
+func (o *Options) GetMemoryLimit() int {
+	if o == nil || o.MemoryLimit <= 0 {
+		return 0
+	}
+	return o.MemoryLimit
+}
+
+func (o *Options) GetMergeOperator() MergeOperator {
+	if o == nil {
+		return nil
+	}
+	return o.MergeOperator
+}
+
 func (o *Options) GetNoSync() bool {
 	if o == nil {
 		return false
@@ -622,6 +1150,13 @@ func (o *Options) GetOpenFilesCacheCapacity() int {
 	return o.OpenFilesCacheCapacity
 }
 
+func (o *Options) GetRateLimiter() RateLimiter {
+	if o == nil {
+		return nil
+	}
+	return o.RateLimiter
+}
+
 func (o *Options) GetReadOnly() bool {
 	if o == nil {
 		return false
@@ -629,6 +1164,13 @@ func (o *Options) GetReadOnly() bool {
 	return o.ReadOnly
 }
 
+func (o *Options) GetStateTableDir() string {
+	if o == nil {
+		return ""
+	}
+	return o.StateTableDir
+}
+
 func (o *Options) GetStrict(strict Strict) bool {
 	if o == nil || o.Strict == 0 {
 		return DefaultStrict&strict != 0
@@ -636,6 +1178,20 @@ func (o *Options) GetStrict(strict Strict) bool {
 	return o.Strict&strict != 0
 }
 
+func (o *Options) GetSubscriptionBuffer() int {
+	if o == nil || o.SubscriptionBuffer <= 0 {
+		return DefaultSubscriptionBuffer
+	}
+	return o.SubscriptionBuffer
+}
+
+func (o *Options) GetTableDir() string {
+	if o == nil {
+		return ""
+	}
+	return o.TableDir
+}
+
 func (o *Options) GetWriteBuffer() int {
 	if o == nil || o.WriteBuffer <= 0 {
 		return DefaultWriteBuffer
@@ -650,6 +1206,20 @@ func (o *Options) GetWriteBuffer2() int {
 	return o.WriteBuffer2
 }
 
+// GetWriteBufferBudget returns WriteBufferBudget, limited to half of
+// MemoryLimit to leave room for the frozen 'memdb'. If only MemoryLimit is
+// set the budget is a quarter of it.
+func (o *Options) GetWriteBufferBudget() int {
+	limit := o.GetMemoryLimit()
+	switch {
+	case o == nil || o.WriteBufferBudget <= 0:
+		return limit / 4
+	case limit > 0 && o.WriteBufferBudget > limit/2:
+		return limit / 2
+	}
+	return o.WriteBufferBudget
+}
+
 func (o *Options) GetWriteL0PauseTrigger() int {
 	if o == nil || o.WriteL0PauseTrigger == 0 {
 		return DefaultWriteL0PauseTrigger
@@ -728,6 +1298,21 @@ type WriteOptions struct {
 	//
 	// The default value is false.
 	Sync bool
+
+	// DisableWAL skips the journal for this write, for bulk loads that can
+	// be redone. The write only lives in the memdb until it is flushed to a
+	// table, Sync has no effect and the write is never merged with others.
+	//
+	// Close flushes such memdbs. If the process crashes before that, the
+	// next Open returns a corruption error instead of silently dropping the
+	// writes; use Recover to open the DB without them. A read-only open
+	// succeeds and reports them by DBStats.WALLessLost.
+	//
+	// Subscriptions and replication read the journals, so the write fails
+	// while one is open.
+	//
+	// The default value is false.
+	DisableWAL bool
 }
 
 func (wo *WriteOptions) GetNoWriteMerge() bool {
@@ -744,6 +1329,13 @@ func (wo *WriteOptions) GetSync() bool {
 	return wo.Sync
 }
 
+func (wo *WriteOptions) GetDisableWAL() bool {
+	if wo == nil {
+		return false
+	}
+	return wo.DisableWAL
+}
+
 func GetStrict(o *Options, ro *ReadOptions, strict Strict) bool {
 	if ro.GetStrict(StrictOverride) {
 		return ro.GetStrict(strict)
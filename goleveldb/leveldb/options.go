@@ -39,6 +39,9 @@ func (s *session) setOptions(o *opt.Options) {
 		no.Filter = &iFilter{filter}
 	}
 
+	// Event listener.
+	no.EventListener = newEventListener(o.GetEventListener())
+
 	s.o = &cachedOptions{Options: no}
 	s.o.cache()
 }
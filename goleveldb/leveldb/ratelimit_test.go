@@ -0,0 +1,49 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/util"
+)
+
+func TestDB_RateLimiter(t *testing.T) {
+	l := util.NewRateLimiter(64<<20, false)
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		RateLimiter:                  l,
+	})
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.put("bar", "v1")
+	h.compactMem()
+	h.put("foo", "v2")
+	h.compactMem()
+	h.compactRange("", "")
+
+	if l.TotalBytes(util.IOPriorityHigh) == 0 {
+		t.Fatal("flush writes were not rate limited")
+	}
+	if l.TotalBytes(util.IOPriorityLow) == 0 {
+		t.Fatal("compaction writes were not rate limited")
+	}
+
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: got error: ", err)
+	}
+	total := l.TotalBytes(util.IOPriorityHigh) + l.TotalBytes(util.IOPriorityLow)
+	if s.RateLimitedWrite[opt.KeyspaceMain] != total {
+		t.Fatalf("RateLimitedWrite: got %d, want %d", s.RateLimitedWrite[opt.KeyspaceMain], total)
+	}
+	if s.RateLimitedWrite[opt.KeyspaceState] != 0 {
+		t.Fatalf("RateLimitedWrite: got %d state bytes, want 0", s.RateLimitedWrite[opt.KeyspaceState])
+	}
+}
@@ -53,6 +53,9 @@ type session struct {
 	manifestWriter storage.Writer
 	manifestFd     storage.FileDesc
 
+	stWALLess  bool // unflushed WAL-less writes; need external synchronization
+	stWALLess2 bool
+
 	stCompPtrs  []internalKey // compaction pointers; need external synchronization
 	stCompPtrs2 []internalKey // compaction pointers; need external synchronization
 	stVersion   *version      // current version
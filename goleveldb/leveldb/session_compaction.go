@@ -7,10 +7,13 @@
 package leveldb
 
 import (
+	"sort"
+	"sync/atomic"
+
 	"awesomeProject1/goleveldb/leveldb/iterator"
 	"awesomeProject1/goleveldb/leveldb/memdb"
 	"awesomeProject1/goleveldb/leveldb/opt"
-	"sync/atomic"
+	"awesomeProject1/goleveldb/leveldb/util"
 )
 
 const (
@@ -18,8 +21,75 @@ const (
 	level0Compaction
 	nonLevel0Compaction
 	seekCompaction
+	deletionCompaction
+	intraL0Compaction
 )
 
+// keyBounds splits an ascending stream of user keys at the given bounds.
+type keyBounds struct {
+	ukeys [][]byte
+	i     int
+}
+
+// newKeyBounds sorts ukeys; it returns nil if there are none.
+func newKeyBounds(icmp *iComparer, ukeys [][]byte) *keyBounds {
+	if len(ukeys) == 0 {
+		return nil
+	}
+	sort.Slice(ukeys, func(i, j int) bool {
+		return icmp.uCompare(ukeys[i], ukeys[j]) < 0
+	})
+	return &keyBounds{ukeys: ukeys}
+}
+
+// cross reports whether ukey reached a bound the previous key had not.
+func (b *keyBounds) cross(icmp *iComparer, ukey []byte) (crossed bool) {
+	if b == nil {
+		return false
+	}
+	for b.i < len(b.ukeys) && icmp.uCompare(ukey, b.ukeys[b.i]) >= 0 {
+		b.i++
+		crossed = true
+	}
+	return
+}
+
+// l0Partitioned reports whether level-0 tables of ks are split by key
+// range, see opt.Options.FlushSplitL1.
+func (s *session) l0Partitioned(ks opt.Keyspace) bool {
+	guards := s.o.GetFlushGuards()
+	if ks == opt.KeyspaceState {
+		guards = s.o.GetFlushGuards2()
+	}
+	return len(guards) > 0 || s.o.GetFlushSplitL1() || s.o.GetIntraL0Ratio() > 0
+}
+
+// flushBounds returns the user keys new level-0 tables of the main keyspace
+// are split at: the flush guards and, with FlushSplitL1, the first key of
+// each level-1 table but the first. It returns nil if there are none.
+func (v *version) flushBounds() *keyBounds {
+	ukeys := append([][]byte{}, v.s.o.GetFlushGuards()...)
+	if v.s.o.GetFlushSplitL1() && len(v.levels) > 1 {
+		for i, t := range v.levels[1] {
+			if i > 0 {
+				ukeys = append(ukeys, t.imin.ukey())
+			}
+		}
+	}
+	return newKeyBounds(v.s.icmp, ukeys)
+}
+func (v *version) flushBounds_s() *keyBounds {
+	ukeys := append([][]byte{}, v.s.o.GetFlushGuards2()...)
+	if v.s.o.GetFlushSplitL1() && len(v.level_s) > 1 {
+		for i, t := range v.level_s[1] {
+			if i > 0 {
+				ukeys = append(ukeys, t.imin.ukey())
+			}
+		}
+	}
+	return newKeyBounds(v.s.icmp, ukeys)
+}
+
 func (s *session) pickMemdbLevel(umin, umax []byte, maxLevel int) int {
 	v := s.version()
 	defer v.release()
@@ -30,10 +100,16 @@ func (s *session) pickMemdbLevel_s(umin, umax []byte, maxLevel int) int {
 	defer v.release()
 	return v.pickMemdbLevel_s(umin, umax, maxLevel)
 }
-func (s *session) flushMemdb_s(rec *sessionRecord, mdb *memdb.DBs, maxLevel int) (int, error) {
+func (s *session) flushMemdb_s(rec *sessionRecord, mdb memdb.Table_s, maxLevel int) (int, error) {
 	// Create sorted table.
 	iter := mdb.NewIterator_s(nil)
 	defer iter.Release()
+	v := s.version()
+	bounds := v.flushBounds_s()
+	v.release()
+	if bounds != nil {
+		return s.flushMemdbSplit_s(rec, iter, bounds, maxLevel)
+	}
 	t, n, err := s.tops.createFrom_s(iter) //这里t是一个sfile
 	if err != nil {
 		return 0, err
@@ -60,6 +136,12 @@ func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (i
 	// Create sorted table.
 	iter := mdb.NewIterator(nil) //immutable的迭代器
 	defer iter.Release()
+	v := s.version()
+	bounds := v.flushBounds()
+	v.release()
+	if bounds != nil {
+		return s.flushMemdbSplit(rec, iter, bounds, maxLevel)
+	}
 	t, n, err := s.tops.createFrom(iter) //n为 number of entries added so far.
 	if err != nil {
 		return 0, err
@@ -79,6 +161,39 @@ func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (i
 	return flushLevel, nil
 }
 
+// flushMemdbSplit writes the flush output as one table per key range between
+// bounds. Each table picks its own level; the lowest one is returned.
+func (s *session) flushMemdbSplit(rec *sessionRecord, iter iterator.Iterator, bounds *keyBounds, maxLevel int) (int, error) {
+	tables, n, err := s.tops.createFromSplit(iter, bounds)
+	if err != nil {
+		return 0, err
+	}
+	flushLevel := maxLevel
+	for _, t := range tables {
+		level := s.pickMemdbLevel(t.imin.ukey(), t.imax.ukey(), maxLevel)
+		rec.addTableFile(level, t)
+		flushLevel = minInt(flushLevel, level)
+		s.logf("memdb@flush created L%d@%d S·%s %q:%q", level, t.fd.Num, shortenb(int(t.size)), t.imin, t.imax)
+	}
+	s.logf("memdb@flush split F·%d N·%d", len(tables), n)
+	return flushLevel, nil
+}
+func (s *session) flushMemdbSplit_s(rec *sessionRecord, iter iterator.Iterator, bounds *keyBounds, maxLevel int) (int, error) {
+	tables, n, err := s.tops.createFromSplit_s(iter, bounds)
+	if err != nil {
+		return 0, err
+	}
+	flushLevel := maxLevel
+	for _, t := range tables {
+		level := s.pickMemdbLevel_s(t.imin.ukey(), t.imax.ukey(), maxLevel)
+		rec.addTableFile_s(level, t)
+		flushLevel = minInt(flushLevel, level)
+		s.logf("memdb@flush created L%d@%d S·%s %q:%q", level, t.fd.Num, shortenb(int(t.size)), t.imin, t.imax)
+	}
+	s.logf("memdb@flush split F·%d N·%d", len(tables), n)
+	return flushLevel, nil
+}
+
 // Pick a compaction based on current state; need external synchronization.
 // 得到触发compaction的类型，并得到初步要参与compaction的数据t0，调用new compaction
 func (s *session) pickCompaction() *compaction {
@@ -111,13 +226,21 @@ func (s *session) pickCompaction() *compaction {
 			sourceLevel = ts.level
 			t0 = append(t0, ts.table)
 			typ = seekCompaction
+		} else if v.cDel != nil { //由删除标记比例触发的
+			sourceLevel = v.cDel.level
+			t0 = append(t0, v.cDel.table)
+			typ = deletionCompaction
 		} else {
 			v.release()
 			return nil
 		}
 	}
 
-	return newCompaction(s, v, sourceLevel, t0, typ)
+	c := newCompaction(s, v, sourceLevel, t0, typ)
+	if typ == level0Compaction {
+		c.tryIntraL0()
+	}
+	return c
 }
 func (s *session) pickCompaction_s() *compaction {
 	v := s.version() //获取当前的版本
@@ -150,13 +273,21 @@ func (s *session) pickCompaction_s() *compaction {
 			sourceLevel = ts.level
 			t0 = append(t0, ts.table)
 			typ = seekCompaction
+		} else if v.cDels != nil { //由删除标记比例触发的
+			sourceLevel = v.cDels.level
+			t0 = append(t0, v.cDels.table)
+			typ = deletionCompaction
 		} else {
 			v.release()
 			return nil
 		}
 	}
 
-	return newCompaction_s(s, v, sourceLevel, t0, typ) //return c *compare
+	c := newCompaction_s(s, v, sourceLevel, t0, typ) //return c *compare
+	if typ == level0Compaction {
+		c.tryIntraL0_s()
+	}
+	return c
 }
 
 // Create compaction from given level and range; need external synchronization.
@@ -245,6 +376,7 @@ func newCompaction(s *session, v *version, sourceLevel int, t0 tFiles, typ int)
 		sourceLevel:   sourceLevel,        //此为参与合并的是哪一层
 		levels:        [2]tFiles{t0, nil}, //得到了参与compaction的第一层数据
 		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(sourceLevel)),
+		dstLevel:      v.nextLevel(sourceLevel),
 		tPtrs:         make([]int, len(v.levels)), //一块空间
 	}
 	c.expand()
@@ -259,6 +391,7 @@ func newCompaction_s(s *session, v *version, sourceLevel int, t0 sFiles, typ int
 		sourceLevel:   sourceLevel,        //此为参与合并的是哪一层
 		level_s:       [2]sFiles{t0, nil}, //得到了参与compaction的第一层数据
 		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(sourceLevel)),
+		dstLevel:      v.nextLevel_s(sourceLevel),
 		tPtrs:         make([]int, len(v.level_s)), //一块空间
 	}
 	c.expand_s()
@@ -273,6 +406,7 @@ type compaction struct {
 
 	typ           int
 	sourceLevel   int
+	dstLevel      int       // levels[1] 所在的层，通常是 sourceLevel+1
 	levels        [2]tFiles //两层的sst？，所以用[2]吗，也就是说所有的sst的meta data有tfiles存
 	level_s       [2]sFiles
 	maxGPOverlaps int64
@@ -285,11 +419,14 @@ type compaction struct {
 	imin, imax        internalKey
 	tPtrs             []int
 	released          bool
+	slice             *util.Range // 子合并的key范围，nil表示整个合并
+	bounds            *keyBounds  // intra-L0 合并输出的切分点
 	//快照？
 	snapGPI               int
 	snapSeenKey           bool
 	snapGPOverlappedBytes int64
 	snapTPtrs             []int
+	snapBoundsI           int
 }
 
 func (c *compaction) save() {
@@ -297,6 +434,9 @@ func (c *compaction) save() {
 	c.snapSeenKey = c.seenKey
 	c.snapGPOverlappedBytes = c.gpOverlappedBytes
 	c.snapTPtrs = append(c.snapTPtrs[:0], c.tPtrs...)
+	if c.bounds != nil {
+		c.snapBoundsI = c.bounds.i
+	}
 }
 
 func (c *compaction) restore() {
@@ -304,6 +444,113 @@ func (c *compaction) restore() {
 	c.seenKey = c.snapSeenKey
 	c.gpOverlappedBytes = c.snapGPOverlappedBytes
 	c.tPtrs = append(c.tPtrs[:0], c.snapTPtrs...)
+	if c.bounds != nil {
+		c.bounds.i = c.snapBoundsI
+	}
+}
+
+// outputLevel returns the level the tables built by c go to.
+func (c *compaction) outputLevel() int {
+	if c.typ == intraL0Compaction {
+		return 0
+	}
+	return c.dstLevel
+}
+
+// inputLevel returns the level of c.levels[i].
+func (c *compaction) inputLevel(i int) int {
+	if i == 0 {
+		return c.sourceLevel
+	}
+	return c.dstLevel
+}
+
+// tryIntraL0 turns a level-0 compaction into an intra-L0 one when it would
+// rewrite too much of level-1, see opt.Options.IntraL0Ratio.
+func (c *compaction) tryIntraL0() {
+	ratio := int64(c.s.o.GetIntraL0Ratio())
+	t0, t1 := c.levels[0], c.levels[1]
+	if ratio <= 0 || len(t0) < 2 || t1.size() <= ratio*t0.size() || c.v.levels[0].size() >= c.s.o.GetCompactionTotalSize(1) {
+		return
+	}
+	c.s.logf("table@compaction intra-L0 F·%d S·%s instead of L1 F·%d S·%s",
+		len(t0), shortenb(int(t0.size())), len(t1), shortenb(int(t1.size())))
+	c.typ = intraL0Compaction
+	c.levels[1] = nil
+	c.gp = nil
+	c.bounds = c.v.flushBounds()
+	c.save()
+}
+func (c *compaction) tryIntraL0_s() {
+	ratio := int64(c.s.o.GetIntraL0Ratio())
+	t0, t1 := c.level_s[0], c.level_s[1]
+	if ratio <= 0 || len(t0) < 2 || t1.size() <= ratio*t0.size() || c.v.level_s[0].size() >= c.s.o.GetCompactionTotalSize(1) {
+		return
+	}
+	c.s.logf("table@compaction intra-L0 F·%d S·%s instead of L1 F·%d S·%s",
+		len(t0), shortenb(int(t0.size())), len(t1), shortenb(int(t1.size())))
+	c.typ = intraL0Compaction
+	c.level_s[1] = nil
+	c.gps = nil
+	c.bounds = c.v.flushBounds_s()
+	c.save()
+}
+
+// split partitions c by user key into at most n subcompactions, using the
+// largest keys of the input tables as split points. Each subcompaction only
+// iterates its own key range; they share the version of c, so only c must be
+// released.
+func (c *compaction) split(n int) []*compaction {
+	var bounds [][]byte
+	for _, tables := range c.levels {
+		for _, t := range tables {
+			bounds = append(bounds, t.imax.ukey())
+		}
+	}
+	for _, tables := range c.level_s {
+		for _, t := range tables {
+			bounds = append(bounds, t.imax.ukey())
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool {
+		return c.s.icmp.uCompare(bounds[i], bounds[j]) < 0
+	})
+	uniq := bounds[:0]
+	for _, b := range bounds {
+		if len(uniq) == 0 || c.s.icmp.uCompare(uniq[len(uniq)-1], b) != 0 {
+			uniq = append(uniq, b)
+		}
+	}
+	// Nothing comes after the largest key, so it can't split anything.
+	if len(uniq) > 0 {
+		uniq = uniq[:len(uniq)-1]
+	}
+	if n > len(uniq)+1 {
+		n = len(uniq) + 1
+	}
+	if n <= 1 {
+		return []*compaction{c}
+	}
+
+	subs := make([]*compaction, n)
+	var start internalKey
+	for i := range subs {
+		sub := *c
+		sub.tPtrs = make([]int, len(c.tPtrs))
+		sub.snapTPtrs = nil
+		sub.gpi, sub.seenKey, sub.gpOverlappedBytes = 0, false, 0
+		sub.slice = &util.Range{Start: start}
+		if c.bounds != nil {
+			sub.bounds = &keyBounds{ukeys: c.bounds.ukeys}
+		}
+		if i < n-1 {
+			start = makeInternalKey(nil, uniq[(i+1)*len(uniq)/n], keyMaxSeq, keyTypeSeek)
+			sub.slice.Limit = start
+		}
+		sub.save()
+		subs[i] = &sub
+	}
+	return subs
 }
 
 func (c *compaction) release() {
@@ -317,8 +564,8 @@ func (c *compaction) release() {
 func (c *compaction) expand() {
 	limit := int64(c.s.o.GetCompactionExpandLimit(c.sourceLevel)) //参与compaction的大小限制？
 	vt0 := c.v.levels[c.sourceLevel]
-	vt1 := tFiles{}                                          //暂且为空
-	if level := c.sourceLevel + 1; level < len(c.v.levels) { //下一层
+	vt1 := tFiles{}                                   //暂且为空
+	if level := c.dstLevel; level < len(c.v.levels) { //下一层
 		vt1 = c.v.levels[level]
 	}
 
@@ -338,7 +585,7 @@ func (c *compaction) expand() {
 	amin, amax := append(t0, t1...).getRange(c.s.icmp) //返回两层tables的最大key和最小key？
 
 	// See if we can grow the number of inputs in "sourceLevel" without
-	// changing the number of "sourceLevel+1" files we pick up.
+	// changing the number of "dstLevel" files we pick up.
 	if len(t1) > 0 {
 		exp0 := vt0.getOverlaps(nil, c.s.icmp, amin.ukey(), amax.ukey(), c.sourceLevel == 0)
 		if len(exp0) > len(t0) && t1.size()+exp0.size() < limit {
@@ -346,7 +593,7 @@ func (c *compaction) expand() {
 			exp1 := vt1.getOverlaps(nil, c.s.icmp, xmin.ukey(), xmax.ukey(), false)
 			if len(exp1) == len(t1) {
 				c.s.logf("table@compaction expanding L%d+L%d (F·%d S·%s)+(F·%d S·%s) -> (F·%d S·%s)+(F·%d S·%s)",
-					c.sourceLevel, c.sourceLevel+1, len(t0), shortenb(int(t0.size())), len(t1), shortenb(int(t1.size())),
+					c.sourceLevel, c.dstLevel, len(t0), shortenb(int(t0.size())), len(t1), shortenb(int(t1.size())),
 					len(exp0), shortenb(int(exp0.size())), len(exp1), shortenb(int(exp1.size())))
 				imin, imax = xmin, xmax
 				t0, t1 = exp0, exp1
@@ -356,8 +603,8 @@ func (c *compaction) expand() {
 	}
 
 	// Compute the set of grandparent files that overlap this compaction
-	// (parent == sourceLevel+1; grandparent == sourceLevel+2)
-	if level := c.sourceLevel + 2; level < len(c.v.levels) {
+	// (parent == dstLevel; grandparent == dstLevel+1)
+	if level := c.dstLevel + 1; level < len(c.v.levels) {
 		c.gp = c.v.levels[level].getOverlaps(c.gp, c.s.icmp, amin.ukey(), amax.ukey(), false)
 	}
 
@@ -367,8 +614,8 @@ func (c *compaction) expand() {
 func (c *compaction) expand_s() {
 	limit := int64(c.s.o.GetCompactionExpandLimit(c.sourceLevel)) //参与compaction的大小限制？
 	vt0 := c.v.level_s[c.sourceLevel]
-	vt1 := sFiles{}                                           //暂且为空
-	if level := c.sourceLevel + 1; level < len(c.v.level_s) { //下一层
+	vt1 := sFiles{}                                    //暂且为空
+	if level := c.dstLevel; level < len(c.v.level_s) { //下一层
 		vt1 = c.v.level_s[level]
 	}
 
@@ -388,7 +635,7 @@ func (c *compaction) expand_s() {
 	amin, amax := append(t0, t1...).getRange(c.s.icmp) //返回两层tables的最大key和最小key？
 
 	// See if we can grow the number of inputs in "sourceLevel" without
-	// changing the number of "sourceLevel+1" files we pick up.
+	// changing the number of "dstLevel" files we pick up.
 	if len(t1) > 0 {
 		exp0 := vt0.getOverlaps(nil, c.s.icmp, amin.ukey(), amax.ukey(), c.sourceLevel == 0)
 		if len(exp0) > len(t0) && t1.size()+exp0.size() < limit {
@@ -396,7 +643,7 @@ func (c *compaction) expand_s() {
 			exp1 := vt1.getOverlaps(nil, c.s.icmp, xmin.ukey(), xmax.ukey(), false)
 			if len(exp1) == len(t1) {
 				c.s.logf("table@compaction expanding L%d+L%d (F·%d S·%s)+(F·%d S·%s) -> (F·%d S·%s)+(F·%d S·%s)",
-					c.sourceLevel, c.sourceLevel+1, len(t0), shortenb(int(t0.size())), len(t1), shortenb(int(t1.size())),
+					c.sourceLevel, c.dstLevel, len(t0), shortenb(int(t0.size())), len(t1), shortenb(int(t1.size())),
 					len(exp0), shortenb(int(exp0.size())), len(exp1), shortenb(int(exp1.size())))
 				imin, imax = xmin, xmax
 				t0, t1 = exp0, exp1
@@ -406,8 +653,8 @@ func (c *compaction) expand_s() {
 	}
 
 	// Compute the set of grandparent files that overlap this compaction
-	// (parent == sourceLevel+1; grandparent == sourceLevel+2)
-	if level := c.sourceLevel + 2; level < len(c.v.level_s) {
+	// (parent == dstLevel; grandparent == dstLevel+1)
+	if level := c.dstLevel + 1; level < len(c.v.level_s) {
 		c.gps = c.v.level_s[level].getOverlaps(c.gps, c.s.icmp, amin.ukey(), amax.ukey(), false)
 	}
 
@@ -417,13 +664,17 @@ func (c *compaction) expand_s() {
 
 // Check whether compaction is trivial.
 func (c *compaction) trivial() bool {
-	return len(c.levels[0]) == 1 && len(c.levels[1]) == 0 && c.gp.size() <= c.maxGPOverlaps
+	return c.typ != intraL0Compaction && len(c.levels[0]) == 1 && len(c.levels[1]) == 0 && c.gp.size() <= c.maxGPOverlaps
 }
 func (c *compaction) trivial_s() bool {
-	return len(c.level_s[0]) == 1 && len(c.level_s[1]) == 0 && c.gps.size() <= c.maxGPOverlaps
+	return c.typ != intraL0Compaction && len(c.level_s[0]) == 1 && len(c.level_s[1]) == 0 && c.gps.size() <= c.maxGPOverlaps
 }
 func (c *compaction) baseLevelForKey(ukey []byte) bool {
-	for level := c.sourceLevel + 2; level < len(c.v.levels); level++ {
+	if c.typ == intraL0Compaction {
+		// 输出还在 level-0，level-1 及以下可能还有这个 key。
+		return false
+	}
+	for level := c.dstLevel + 1; level < len(c.v.levels); level++ {
 		tables := c.v.levels[level]
 		for c.tPtrs[level] < len(tables) {
 			t := tables[c.tPtrs[level]]
@@ -441,7 +692,10 @@ func (c *compaction) baseLevelForKey(ukey []byte) bool {
 	return true
 }
 func (c *compaction) baseLevelForKey_s(ukey []byte) bool {
-	for level := c.sourceLevel + 2; level < len(c.v.level_s); level++ {
+	if c.typ == intraL0Compaction {
+		return false
+	}
+	for level := c.dstLevel + 1; level < len(c.v.level_s); level++ {
 		tables := c.v.level_s[level]
 		for c.tPtrs[level] < len(tables) {
 			t := tables[c.tPtrs[level]]
@@ -459,6 +713,9 @@ func (c *compaction) baseLevelForKey_s(ukey []byte) bool {
 	return true
 }
 func (c *compaction) shouldStopBefore(ikey internalKey) bool {
+	if c.typ == intraL0Compaction {
+		return c.bounds.cross(c.s.icmp, ikey.ukey())
+	}
 	for ; c.gpi < len(c.gp); c.gpi++ {
 		gp := c.gp[c.gpi]
 		if c.s.icmp.Compare(ikey, gp.imax) <= 0 {
@@ -478,6 +735,9 @@ func (c *compaction) shouldStopBefore(ikey internalKey) bool {
 	return false
 }
 func (c *compaction) shouldStopBefore_s(ikey internalKey) bool {
+	if c.typ == intraL0Compaction {
+		return c.bounds.cross(c.s.icmp, ikey.ukey())
+	}
 	for ; c.gpi < len(c.gps); c.gpi++ {
 		gps := c.gps[c.gpi]
 		if c.s.icmp.Compare(ikey, gps.imax) <= 0 {
@@ -523,12 +783,12 @@ func (c *compaction) newIterator() iterator.Iterator {
 		}
 
 		// Level-0 is not sorted and may overlaps each other.
-		if c.sourceLevel+i == 0 {
+		if c.inputLevel(i) == 0 {
 			for _, t := range tables {
-				its = append(its, c.s.tops.newIterator(t, nil, ro))
+				its = append(its, c.s.tops.newIterator(t, c.slice, ro))
 			}
 		} else {
-			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro), strict)
+			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, c.slice, ro), strict)
 			its = append(its, it)
 		}
 	}
@@ -560,12 +820,12 @@ func (c *compaction) newIterator_s() iterator.Iterator {
 		}
 
 		// Level-0 is not sorted and may overlaps each other.
-		if c.sourceLevel+i == 0 {
+		if c.inputLevel(i) == 0 {
 			for _, t := range tables {
-				its = append(its, c.s.tops.newIterator_s(t, nil, ro))
+				its = append(its, c.s.tops.newIterator_s(t, c.slice, ro))
 			}
 		} else {
-			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro), strict)
+			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, c.slice, ro), strict)
 			its = append(its, it)
 		}
 	}
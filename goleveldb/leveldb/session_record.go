@@ -35,6 +35,8 @@ const (
 	recAddTables   = 11
 	// 8 was used for large value refs
 	recPrevJournalNum = 9
+	recWALLess        = 13
+	recWALLess2       = 14
 )
 
 type cpRecord struct {
@@ -68,6 +70,8 @@ type sessionRecord struct {
 	addedTabless   []atRecord //使用同名方法添加数据
 	deletedTables  []dtRecord
 	deletedTabless []dtRecord
+	walLess        bool // 内存里有没写日志的数据，见 opt.WriteOptions.DisableWAL
+	walLess2       bool
 	scratch        [binary.MaxVarintLen64]byte
 	err            error
 }
@@ -101,6 +105,15 @@ func (p *sessionRecord) setSeqNum(num uint64) {
 	p.seqNum = num
 }
 
+func (p *sessionRecord) setWALLess(walLess bool) {
+	p.hasRec |= 1 << recWALLess
+	p.walLess = walLess
+}
+func (p *sessionRecord) setWALLess_s(walLess bool) {
+	p.hasRec |= 1 << recWALLess2
+	p.walLess2 = walLess
+}
+
 func (p *sessionRecord) addCompPtr(level int, ikey internalKey) {
 	p.hasRec |= 1 << recCompPtr
 	p.compPtrs = append(p.compPtrs, cpRecord{level, ikey})
@@ -167,6 +180,13 @@ func (p *sessionRecord) putUvarint(w io.Writer, x uint64) {
 	_, p.err = w.Write(p.scratch[:n])
 }
 
+func boolUvarint(x bool) uint64 {
+	if x {
+		return 1
+	}
+	return 0
+}
+
 func (p *sessionRecord) putVarint(w io.Writer, x int64) {
 	if x < 0 {
 		panic("invalid negative value")
@@ -203,6 +223,14 @@ func (p *sessionRecord) encode(w io.Writer) error {
 		p.putUvarint(w, recSeqNum)
 		p.putUvarint(w, p.seqNum)
 	}
+	if p.has(recWALLess) {
+		p.putUvarint(w, recWALLess)
+		p.putUvarint(w, boolUvarint(p.walLess))
+	}
+	if p.has(recWALLess2) {
+		p.putUvarint(w, recWALLess2)
+		p.putUvarint(w, boolUvarint(p.walLess2))
+	}
 	for _, r := range p.compPtrs {
 		p.putUvarint(w, recCompPtr)
 		p.putUvarint(w, uint64(r.level))
@@ -342,6 +370,16 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.setSeqNum(x)
 			}
+		case recWALLess:
+			x := p.readUvarint("wal-less", br)
+			if p.err == nil {
+				p.setWALLess(x != 0)
+			}
+		case recWALLess2:
+			x := p.readUvarint("wal-less", br)
+			if p.err == nil {
+				p.setWALLess_s(x != 0)
+			}
 		case recCompPtr:
 			level := p.readLevel("comp-ptr.level", br)
 			ikey := p.readBytes("comp-ptr.ikey", br)
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"awesomeProject1/goleveldb/leveldb/journal"
+	"awesomeProject1/goleveldb/leveldb/opt"
 	"awesomeProject1/goleveldb/leveldb/storage"
 )
 
@@ -24,7 +25,7 @@ type dropper struct {
 
 func (d dropper) Drop(err error) {
 	if e, ok := err.(*journal.ErrCorrupted); ok {
-		d.s.logf("journal@drop %s-%d S·%s %q", d.fd.Type, d.fd.Num, shortenb(e.Size), e.Reason)
+		d.s.logf("journal@drop %s-%d S·%s @%d %q", d.fd.Type, d.fd.Num, shortenb(e.Size), e.Offset, e.Reason)
 	} else {
 		d.s.logf("journal@drop %s-%d %q", d.fd.Type, d.fd.Num, err)
 	}
@@ -55,9 +56,10 @@ const (
 // vDelta indicates the change information between the next version
 // and the currently specified version
 type vDelta struct {
-	vid     int64
-	added   []int64
-	deleted []int64
+	vid       int64
+	added     []int64
+	deleted   []int64
+	deleted_s []int64
 }
 
 // vTask defines a version task for either reference or release.
@@ -109,6 +111,11 @@ func (s *session) refLoop() {
 				s.tops.remove(storage.FileDesc{Type: storage.TypeTable, Num: t})
 			}
 		}
+		for _, t := range d.deleted_s {
+			if addFileRef(t, -1) == 0 {
+				s.tops.remove_s(storage.FileDesc{Type: storage.TypeTable, Num: t})
+			}
+		}
 	}
 
 	timer := time.NewTimer(0)
@@ -227,7 +234,7 @@ func (s *session) refLoop() {
 				for _, tt := range t.sfiles {
 					for _, t := range tt {
 						if addFileRef(t.fd.Num, -1) == 0 {
-							s.tops.remove(t.fd)
+							s.tops.remove_s(t.fd)
 							//s.tops2.remove(t.fd)
 						}
 					}
@@ -282,6 +289,16 @@ func (s *session) tLen_s(level int) int {
 	defer s.vmu.Unlock()
 	return s.stVersion.tLen_s(level)
 }
+func (s *session) l0Runs() int {
+	s.vmu.Lock()
+	defer s.vmu.Unlock()
+	return s.stVersion.l0Runs()
+}
+func (s *session) l0Runs_s() int {
+	s.vmu.Lock()
+	defer s.vmu.Unlock()
+	return s.stVersion.l0Runs_s()
+}
 
 // Set current version to v.
 func (s *session) setVersion(r *sessionRecord, v *version) {
@@ -293,8 +310,9 @@ func (s *session) setVersion(r *sessionRecord, v *version) {
 	if s.stVersion != nil {
 		if r != nil {
 			var (
-				added   = make([]int64, 0, len(r.addedTables)+len(r.addedTabless))     //增加的文件num
-				deleted = make([]int64, 0, len(r.deletedTables)+len(r.deletedTabless)) //删除的文件num
+				added     = make([]int64, 0, len(r.addedTables)+len(r.addedTabless)) //增加的文件num
+				deleted   = make([]int64, 0, len(r.deletedTables))                   //删除的文件num
+				deleted_s = make([]int64, 0, len(r.deletedTabless))
 			)
 			for _, t := range r.addedTables {
 				added = append(added, t.num)
@@ -306,10 +324,10 @@ func (s *session) setVersion(r *sessionRecord, v *version) {
 				deleted = append(deleted, t.num)
 			}
 			for _, t := range r.deletedTabless {
-				deleted = append(deleted, t.num)
+				deleted_s = append(deleted_s, t.num)
 			}
 			select {
-			case s.deltaCh <- &vDelta{vid: s.stVersion.id, added: added, deleted: deleted}: //增加的文件号和删除的文件号
+			case s.deltaCh <- &vDelta{vid: s.stVersion.id, added: added, deleted: deleted, deleted_s: deleted_s}: //增加的文件号和删除的文件号
 			case <-v.s.closeC:
 				s.log("reference loop already exist")
 			}
@@ -411,6 +429,14 @@ func (s *session) fillRecord(r *sessionRecord, snapshot bool) {
 			r.setSeqNum(s.stSeqNum)
 		}
 
+		// 新 manifest 也要带上 WAL-less 标记，否则崩溃后发现不了。
+		if s.stWALLess && !r.has(recWALLess) {
+			r.setWALLess(true)
+		}
+		if s.stWALLess2 && !r.has(recWALLess2) {
+			r.setWALLess_s(true)
+		}
+
 		for level, ik := range s.stCompPtrs { //compaction point
 			if ik != nil {
 				r.addCompPtr(level, ik)
@@ -442,6 +468,15 @@ func (s *session) fillRecord(r *sessionRecord, snapshot bool) {
 		r.setComparer(s.icmp.uName())
 	}
 }*/
+// walLess reports whether the manifest marks unflushed WAL-less writes of ks;
+// need external synchronization.
+func (s *session) walLess(ks opt.Keyspace) bool {
+	if ks == opt.KeyspaceState {
+		return s.stWALLess2
+	}
+	return s.stWALLess
+}
+
 // Mark if record has been committed, this will update session state;
 // need external synchronization.
 func (s *session) recordCommited(rec *sessionRecord) {
@@ -457,6 +492,13 @@ func (s *session) recordCommited(rec *sessionRecord) {
 		s.stSeqNum = rec.seqNum
 	}
 
+	if rec.has(recWALLess) {
+		s.stWALLess = rec.walLess
+	}
+	if rec.has(recWALLess2) {
+		s.stWALLess2 = rec.walLess2
+	}
+
 	for _, r := range rec.compPtrs {
 		s.setCompPtr(r.level, internalKey(r.ikey))
 	}
@@ -494,12 +536,14 @@ func (s *session) newManifest(rec *sessionRecord, v *version) (err error) {
 			if s.manifestWriter != nil {
 				s.manifestWriter.Close()
 			}
-			if !s.manifestFd.Zero() {
-				s.stor.Remove(s.manifestFd)
+			prev := s.manifestFd
+			if !prev.Zero() {
+				s.stor.Remove(prev)
 			}
 			s.manifestFd = fd
 			s.manifestWriter = writer
 			s.manifest = jw
+			s.o.EventListener.ManifestCreated(opt.ManifestInfo{Num: fd.Num, PrevNum: prev.Num})
 		} else {
 			writer.Close()
 			s.stor.Remove(fd)
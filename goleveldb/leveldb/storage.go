@@ -26,6 +26,36 @@ func (c *iStorage) Create_s(fd storage.FileDesc) (storage.Writer, error) {
 	return &iStorageWriter{w, c}, err
 }
 
+// Reuse reuses oldfd for newfd, see storage.Reuser. The caller must check
+// canReuse first.
+func (c *iStorage) Reuse(oldfd, newfd storage.FileDesc) (storage.Writer, error) {
+	w, err := c.Storage.(storage.Reuser).Reuse(oldfd, newfd)
+	return &iStorageWriter{w, c}, err
+}
+
+// CreateCold creates fd in the cold tier, see storage.Tierer. The caller
+// must check canTier first.
+func (c *iStorage) CreateCold(fd storage.FileDesc, state bool) (storage.Writer, error) {
+	w, err := c.Storage.(storage.Tierer).CreateCold(fd, state)
+	return &iStorageWriter{w, c}, err
+}
+
+// Tier moves fd between the tiers, see storage.Tierer. The caller must
+// check canTier first.
+func (c *iStorage) Tier(fd storage.FileDesc, state, cold bool) error {
+	return c.Storage.(storage.Tierer).Tier(fd, state, cold)
+}
+
+func (c *iStorage) canTier() bool {
+	_, ok := c.Storage.(storage.Tierer)
+	return ok
+}
+
+func (c *iStorage) canReuse() bool {
+	_, ok := c.Storage.(storage.Reuser)
+	return ok
+}
+
 func (c *iStorage) reads() uint64 {
 	return atomic.LoadUint64(&c.read)
 }
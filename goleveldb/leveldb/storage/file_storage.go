@@ -73,11 +73,13 @@ const logSizeThreshold = 1024 * 1024 // 1 MiB
 // fileStorage is a file-system backed storage.
 type fileStorage struct {
 	path     string
+	dirs     FileDirs
 	readOnly bool
 
 	mu sync.Mutex
 	//mu2     sync.Mutex
 	flock   fileLock
+	dlocks  []fileLock
 	slock   *fileStorageLock
 	logw    *os.File
 	logSize int64
@@ -88,7 +90,27 @@ type fileStorage struct {
 }
 
 // The storage must be closed after use, by calling Close method.
+//
+// The files are placed with the FileDirs last given to OpenFileDirs, or
+// all in path if there was none.
 func OpenFile(path string, readOnly bool) (Storage, error) {
+	return openFile(path, nil, readOnly)
+}
+
+// OpenFileDirs is like OpenFile but places the tables and journals in the
+// given directories, which are created if missing and locked along with
+// path. Files placed with another layout before are moved on open, so
+// OpenFileDirs may take a while when a directory is on another
+// filesystem; a read-only storage cannot move them and fails instead.
+//
+// The tables of the state keyspace that used to share a directory with
+// the main ones cannot be told apart here, they are moved by the DB
+// through Placer.
+func OpenFileDirs(path string, dirs FileDirs, readOnly bool) (Storage, error) {
+	return openFile(path, &dirs, readOnly)
+}
+
+func openFile(path string, dirs *FileDirs, readOnly bool) (Storage, error) {
 	if fi, err := os.Stat(path); err == nil {
 		if !fi.IsDir() {
 			return nil, fmt.Errorf("leveldb/storage: open %s: not a directory", path)
@@ -106,12 +128,38 @@ func OpenFile(path string, readOnly bool) (Storage, error) {
 		return nil, err
 	}
 
+	fs := &fileStorage{
+		path:     path,
+		readOnly: readOnly,
+		flock:    flock,
+	}
 	defer func() {
 		if err != nil {
+			for _, dlock := range fs.dlocks {
+				dlock.release()
+			}
 			flock.release()
 		}
 	}()
 
+	old, err := readDirs(path)
+	if err != nil {
+		return nil, err
+	}
+	fs.dirs = old
+	if dirs != nil {
+		if fs.dirs, err = resolveDirs(path, *dirs); err != nil {
+			return nil, err
+		}
+		if fs.dirs != old && readOnly {
+			err = fmt.Errorf("leveldb/storage: open %s: directories changed, cannot move the files read-only", path)
+			return nil, err
+		}
+	}
+	if err = fs.lockDirs(); err != nil {
+		return nil, err
+	}
+
 	var (
 		logw    *os.File
 		logSize int64
@@ -128,12 +176,14 @@ func OpenFile(path string, readOnly bool) (Storage, error) {
 		}
 	}
 
-	fs := &fileStorage{
-		path:     path,
-		readOnly: readOnly,
-		flock:    flock,
-		logw:     logw,
-		logSize:  logSize,
+	fs.logw, fs.logSize = logw, logSize
+	if fs.dirs != old {
+		if err = fs.migrate(old); err != nil {
+			if logw != nil {
+				logw.Close()
+			}
+			return nil, err
+		}
 	}
 	runtime.SetFinalizer(fs, (*fileStorage).Close)
 	return fs, nil
@@ -290,7 +340,6 @@ func (fs *fileStorage) SetMeta(fd FileDesc) error {
 }
 
 func (fs *fileStorage) GetMeta() (FileDesc, error) {
-	fmt.Printf("get meta\n")
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 	if fs.open < 0 {
@@ -442,25 +491,46 @@ func (fs *fileStorage) List(ft FileType) (fds []FileDesc, err error) {
 	if fs.open < 0 {
 		return nil, ErrClosed
 	}
-	dir, err := os.Open(fs.path)
-	if err != nil {
-		return
-	}
-	names, err := dir.Readdirnames(0)
-	// Close the dir first before checking for Readdirnames error.
-	if cerr := dir.Close(); cerr != nil {
-		fs.log(fmt.Sprintf("close dir: %v", cerr))
-	}
-	if err == nil {
+	seen := make(map[FileDesc]bool)
+	for _, d := range fs.dirs.list() {
+		path := fs.dirPath(d)
+		dir, err := os.Open(path)
+		if err != nil {
+			if d != "" && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		names, err := dir.Readdirnames(0)
+		// Close the dir first before checking for Readdirnames error.
+		if cerr := dir.Close(); cerr != nil {
+			fs.log(fmt.Sprintf("close dir: %v", cerr))
+		}
+		if err != nil {
+			return nil, err
+		}
 		for _, name := range names {
-			if fd, ok := fsParseName(name); ok && fd.Type&ft != 0 {
-				fds = append(fds, fd)
+			fd, ok := fsParseName(name)
+			if !ok || fd.Type&ft == 0 || seen[fd] || !fs.inDir(fd, path) {
+				continue
 			}
+			seen[fd] = true
+			fds = append(fds, fd)
 		}
 	}
 	return
 }
 
+// inDir reports whether files like fd belong in the directory path.
+func (fs *fileStorage) inDir(fd FileDesc, path string) bool {
+	for _, d := range fs.dirsOf(fd) {
+		if d == path {
+			return true
+		}
+	}
+	return false
+}
+
 func (fs *fileStorage) Open(fd FileDesc) (Reader, error) {
 	if !FileDescOk(fd) {
 		return nil, ErrInvalidFile
@@ -471,21 +541,34 @@ func (fs *fileStorage) Open(fd FileDesc) (Reader, error) {
 	if fs.open < 0 {
 		return nil, ErrClosed
 	}
-	of, err := os.OpenFile(filepath.Join(fs.path, fsGenName(fd)), os.O_RDONLY, 0)
+	of, err := fs.openFile(fd)
 	if err != nil {
-		if fsHasOldName(fd) && os.IsNotExist(err) {
-			of, err = os.OpenFile(filepath.Join(fs.path, fsGenOldName(fd)), os.O_RDONLY, 0)
-			if err == nil {
-				goto ok
-			}
-		}
 		return nil, err
 	}
-ok:
 	fs.open++
 	return &fileWrap{File: of, fs: fs, fd: fd}, nil
 }
 
+// openFile opens fd for reading, falling back to its old name.
+func (fs *fileStorage) openFile(fd FileDesc) (*os.File, error) {
+	var err error
+	for i, d := range fs.dirsOf(fd) {
+		of, err1 := os.OpenFile(filepath.Join(d, fsGenName(fd)), os.O_RDONLY, 0)
+		if err1 != nil && fsHasOldName(fd) && os.IsNotExist(err1) {
+			if of, err2 := os.OpenFile(filepath.Join(d, fsGenOldName(fd)), os.O_RDONLY, 0); err2 == nil {
+				return of, nil
+			}
+		}
+		if err1 == nil {
+			return of, nil
+		}
+		if i == 0 || !os.IsNotExist(err1) {
+			err = err1
+		}
+	}
+	return nil, err
+}
+
 func (fs *fileStorage) Create(fd FileDesc) (Writer, error) {
 	if !FileDescOk(fd) {
 		return nil, ErrInvalidFile
@@ -499,7 +582,7 @@ func (fs *fileStorage) Create(fd FileDesc) (Writer, error) {
 	if fs.open < 0 {
 		return nil, ErrClosed
 	}
-	of, err := os.OpenFile(filepath.Join(fs.path, fsGenName(fd)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	of, err := os.OpenFile(filepath.Join(fs.dir(fd, false), fsGenName(fd)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -519,7 +602,7 @@ func (fs *fileStorage) Create_s(fd FileDesc) (Writer, error) {
 	if fs.open < 0 {
 		return nil, ErrClosed
 	}
-	of, err := os.OpenFile(filepath.Join(fs.path, fsGenName(fd)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	of, err := os.OpenFile(filepath.Join(fs.dir(fd, true), fsGenName(fd)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -540,12 +623,18 @@ func (fs *fileStorage) Remove(fd FileDesc) error {
 	if fs.open < 0 {
 		return ErrClosed
 	}
-	err := os.Remove(filepath.Join(fs.path, fsGenName(fd)))
+	dir := fs.find(fd)
+	err := os.Remove(filepath.Join(dir, fsGenName(fd)))
 	if err != nil {
 		if fsHasOldName(fd) && os.IsNotExist(err) {
-			if e1 := os.Remove(filepath.Join(fs.path, fsGenOldName(fd))); !os.IsNotExist(e1) {
-				fs.log(fmt.Sprintf("remove %s: %v (old name)", fd, err))
-				err = e1
+			for _, d := range fs.dirsOf(fd) {
+				e1 := os.Remove(filepath.Join(d, fsGenOldName(fd)))
+				if e1 == nil {
+					return nil
+				} else if !os.IsNotExist(e1) {
+					fs.log(fmt.Sprintf("remove %s: %v (old name)", fd, err))
+					return e1
+				}
 			}
 		} else {
 			fs.log(fmt.Sprintf("remove %s: %v", fd, err))
@@ -570,7 +659,53 @@ func (fs *fileStorage) Rename(oldfd, newfd FileDesc) error {
 	if fs.open < 0 {
 		return ErrClosed
 	}
-	return rename(filepath.Join(fs.path, fsGenName(oldfd)), filepath.Join(fs.path, fsGenName(newfd)))
+	dir := fs.find(oldfd)
+	// 替换已有的文件时留在它原来的目录，比如状态表或冷层的表。
+	newdir := fs.find(newfd)
+	if newfd.Type == oldfd.Type {
+		newdir = dir
+	}
+	oldpath, newpath := filepath.Join(dir, fsGenName(oldfd)), filepath.Join(newdir, fsGenName(newfd))
+	if newdir == dir {
+		return rename(oldpath, newpath)
+	}
+	// 目录可能在别的文件系统上，rename 不了就拷贝过去。
+	if err := moveFile(oldpath, newpath); err != nil {
+		fs.log(fmt.Sprintf("rename %s: %v", oldfd, err))
+		return err
+	}
+	return syncDirs(newdir, dir)
+}
+
+func (fs *fileStorage) Reuse(oldfd, newfd FileDesc) (Writer, error) {
+	if !FileDescOk(oldfd) || !FileDescOk(newfd) {
+		return nil, ErrInvalidFile
+	}
+	if fs.readOnly {
+		return nil, errReadOnly
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return nil, ErrClosed
+	}
+	dir := fs.dir(newfd, false)
+	path := filepath.Join(dir, fsGenName(newfd))
+	if err := rename(filepath.Join(fs.find(oldfd), fsGenName(oldfd)), path); err != nil {
+		return nil, err
+	}
+	// 新名字要落盘，不然崩溃之后写进去的内容还挂在旧名字下。
+	if err := syncDir(dir); err != nil {
+		fs.log(fmt.Sprintf("syncDir: %v", err))
+		return nil, err
+	}
+	of, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fs.open++
+	return &fileWrap{File: of, fs: fs, fd: newfd}, nil
 }
 
 func (fs *fileStorage) Close() error {
@@ -589,6 +724,11 @@ func (fs *fileStorage) Close() error {
 	if fs.logw != nil {
 		fs.logw.Close()
 	}
+	for _, dlock := range fs.dlocks {
+		if err := dlock.release(); err != nil {
+			fs.log(fmt.Sprintf("unlock: %v", err))
+		}
+	}
 	return fs.flock.release()
 }
 
@@ -641,6 +781,12 @@ func fsGenName(fd FileDesc) string {
 		return fmt.Sprintf("%06d.ldb", fd.Num)
 	case TypeTemp:
 		return fmt.Sprintf("%06d.tmp", fd.Num)
+	case TypeJournalOld:
+		return fmt.Sprintf("%06d.log.old", fd.Num)
+	case TypeJournalsOld:
+		return fmt.Sprintf("%06d.logs.old", fd.Num)
+	case TypeJournalFree:
+		return fmt.Sprintf("%06d.log.free", fd.Num)
 	default:
 		panic("invalid file type")
 	}
@@ -671,6 +817,12 @@ func fsParseName(name string) (fd FileDesc, ok bool) {
 			fd.Type = TypeTable
 		case "tmp":
 			fd.Type = TypeTemp
+		case "log.old":
+			fd.Type = TypeJournalOld
+		case "logs.old":
+			fd.Type = TypeJournalsOld
+		case "log.free":
+			fd.Type = TypeJournalFree
 		default:
 			return
 		}
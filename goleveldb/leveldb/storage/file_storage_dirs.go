@@ -0,0 +1,455 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileDirs places the files of a file storage in other directories than
+// the DB directory, e.g. the state tables on a fast disk and the chain
+// tables on a larger one. An empty path means the DB directory, which
+// always keeps the manifest, CURRENT, LOCK and LOG.
+type FileDirs struct {
+	// Table holds the tables created by Create.
+	Table string
+	// StateTable holds the tables created by Create_s.
+	StateTable string
+	// Journal holds the journals of both keyspaces, old and free ones
+	// included.
+	Journal string
+	// Cold holds the tables of the cold levels of both keyspaces, see
+	// Tierer. Empty, or the DB directory, means there is no cold tier.
+	Cold string
+}
+
+// Placer is implemented by storages that keep the tables of the two
+// keyspaces in different places, see FileDirs.
+type Placer interface {
+	// Place moves the table fd to where the tables of the state keyspace
+	// are kept if state is true, or of the main keyspace otherwise. It is
+	// a no-op if fd is already there.
+	Place(fd FileDesc, state bool) error
+}
+
+// Tierer is implemented by storages with a cold tier for the tables of the
+// bottom levels, see FileDirs.Cold. Tables are read the same way in either
+// tier.
+type Tierer interface {
+	// CreateCold is like Create, or Create_s if state is true, but creates
+	// the table in the cold tier if there is one.
+	CreateCold(fd FileDesc, state bool) (Writer, error)
+
+	// Tier moves the table fd to the cold tier if cold is true, or to
+	// where the tables of its keyspace are kept otherwise. It is a no-op
+	// if fd is already there or there is no cold tier.
+	Tier(fd FileDesc, state, cold bool) error
+}
+
+// dirsName is the file in the DB directory recording the FileDirs the
+// files were last placed with.
+const dirsName = "DIRS"
+
+var errCorruptedDirs = errors.New("leveldb/storage: corrupted DIRS file")
+
+// resolveDirs makes the paths of dirs absolute; the DB directory itself
+// becomes empty so that the DB directory can still be moved.
+func resolveDirs(path string, dirs FileDirs) (FileDirs, error) {
+	root, err := filepath.Abs(path)
+	if err != nil {
+		return FileDirs{}, err
+	}
+	for _, d := range []*string{&dirs.Table, &dirs.StateTable, &dirs.Journal, &dirs.Cold} {
+		if *d == "" {
+			continue
+		}
+		if *d, err = filepath.Abs(*d); err != nil {
+			return FileDirs{}, err
+		}
+		if *d == root {
+			*d = ""
+		}
+	}
+	return dirs, nil
+}
+
+// readDirs returns the recorded FileDirs of the DB directory at path, or
+// the zero FileDirs if there is no record.
+func readDirs(path string) (dirs FileDirs, err error) {
+	b, err := os.ReadFile(filepath.Join(path, dirsName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), " ")
+		if !ok {
+			return FileDirs{}, &ErrCorrupted{Err: errCorruptedDirs}
+		}
+		switch key {
+		case "table":
+			dirs.Table = value
+		case "state":
+			dirs.StateTable = value
+		case "journal":
+			dirs.Journal = value
+		case "cold":
+			dirs.Cold = value
+		default:
+			return FileDirs{}, &ErrCorrupted{Err: errCorruptedDirs}
+		}
+	}
+	return
+}
+
+// writeDirs records dirs in the DB directory at path.
+func writeDirs(path string, dirs FileDirs) error {
+	content := fmt.Sprintf("table %s\nstate %s\njournal %s\ncold %s\n", dirs.Table, dirs.StateTable, dirs.Journal, dirs.Cold)
+	tmp := filepath.Join(path, dirsName+".tmp")
+	if err := writeFileSynced(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := rename(tmp, filepath.Join(path, dirsName)); err != nil {
+		return err
+	}
+	return syncDir(path)
+}
+
+// list returns the distinct directories of dirs, the DB directory first.
+func (dirs FileDirs) list() []string {
+	ds := []string{""}
+	for _, d := range []string{dirs.Table, dirs.StateTable, dirs.Journal, dirs.Cold} {
+		dup := false
+		for _, x := range ds {
+			dup = dup || x == d
+		}
+		if !dup {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// lockDirs creates and locks the directories of fs.dirs besides the DB
+// directory, no two DBs may share one.
+func (fs *fileStorage) lockDirs() error {
+	for _, d := range fs.dirs.list()[1:] {
+		if !fs.readOnly {
+			if err := os.MkdirAll(d, 0755); err != nil {
+				return err
+			}
+		}
+		flock, err := newFileLock(filepath.Join(d, "LOCK"), fs.readOnly)
+		if err != nil {
+			return err
+		}
+		fs.dlocks = append(fs.dlocks, flock)
+	}
+	return nil
+}
+
+// dirPath returns the path of the directory d of fs.dirs.
+func (fs *fileStorage) dirPath(d string) string {
+	if d == "" {
+		return fs.path
+	}
+	return d
+}
+
+// dir returns the directory a new file fd is created in.
+func (fs *fileStorage) dir(fd FileDesc, state bool) string {
+	switch fd.Type {
+	case TypeTable:
+		if state {
+			return fs.dirPath(fs.dirs.StateTable)
+		}
+		return fs.dirPath(fs.dirs.Table)
+	case TypeJournal, TypeJournals, TypeJournalOld, TypeJournalsOld, TypeJournalFree:
+		return fs.dirPath(fs.dirs.Journal)
+	}
+	return fs.path
+}
+
+// dirsOf returns the directories an existing file fd may be in; the
+// storage does not know the keyspace nor the tier of a table.
+func (fs *fileStorage) dirsOf(fd FileDesc) []string {
+	dirs := []string{fs.dir(fd, false)}
+	if fd.Type == TypeTable {
+		if fs.dirs.StateTable != fs.dirs.Table {
+			dirs = append(dirs, fs.dir(fd, true))
+		}
+		if cold := fs.dirs.Cold; cold != "" && cold != fs.dirs.Table && cold != fs.dirs.StateTable {
+			dirs = append(dirs, cold)
+		}
+	}
+	return dirs
+}
+
+// find returns the directory holding the file fd, or the first directory
+// it may be in if there is none.
+func (fs *fileStorage) find(fd FileDesc) string {
+	dirs := fs.dirsOf(fd)
+	for _, d := range dirs {
+		if _, err := os.Stat(filepath.Join(d, fsGenName(fd))); err == nil {
+			return d
+		}
+	}
+	return dirs[0]
+}
+
+// migrate moves the files placed with the layout old to where fs.dirs
+// keeps them, then records fs.dirs. A crash in the middle is finished by
+// the next open, as the old layout is still recorded.
+func (fs *fileStorage) migrate(old FileDirs) error {
+	touched := make(map[string]bool)
+	for _, d := range append(old.list(), fs.dirs.list()...) {
+		if touched[d] {
+			continue
+		}
+		touched[d] = true
+		names, err := readDirNames(fs.dirPath(d))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, name := range names {
+			fd, ok := fsParseName(name)
+			if !ok {
+				continue
+			}
+			var to string
+			switch fd.Type {
+			case TypeTable:
+				if d == fs.dirs.Table || d == fs.dirs.StateTable || (d != "" && d == fs.dirs.Cold) {
+					continue
+				}
+				// 原来和主库的表放在一起的状态表留给 Place 去挪。
+				to = fs.dirs.Table
+				if d == old.StateTable && d != old.Table {
+					to = fs.dirs.StateTable
+				} else if d != "" && d == old.Cold && fs.dirs.Cold != "" {
+					to = fs.dirs.Cold
+				}
+			case TypeJournal, TypeJournals, TypeJournalOld, TypeJournalsOld, TypeJournalFree:
+				if d == fs.dirs.Journal {
+					continue
+				}
+				to = fs.dirs.Journal
+			default:
+				continue
+			}
+			if err := moveFile(filepath.Join(fs.dirPath(d), name), filepath.Join(fs.dirPath(to), name)); err != nil {
+				fs.log(fmt.Sprintf("migrate %s: %v", name, err))
+				return err
+			}
+			fs.log(fmt.Sprintf("migrate %s: %s -> %s", name, fs.dirPath(d), fs.dirPath(to)))
+		}
+	}
+	for _, d := range fs.dirs.list() {
+		if err := syncDir(fs.dirPath(d)); err != nil {
+			return err
+		}
+	}
+	return writeDirs(fs.path, fs.dirs)
+}
+
+func (fs *fileStorage) Place(fd FileDesc, state bool) error {
+	if fd.Type != TypeTable || !FileDescOk(fd) {
+		return ErrInvalidFile
+	}
+	if fs.readOnly {
+		return errReadOnly
+	}
+	if fs.dirs.Table == fs.dirs.StateTable {
+		return nil
+	}
+	return fs.moveTable(fd, fs.dir(fd, !state), fs.dir(fd, state))
+}
+
+func (fs *fileStorage) CreateCold(fd FileDesc, state bool) (Writer, error) {
+	if fd.Type != TypeTable || !FileDescOk(fd) {
+		return nil, ErrInvalidFile
+	}
+	if fs.dirs.Cold == "" {
+		if state {
+			return fs.Create_s(fd)
+		}
+		return fs.Create(fd)
+	}
+	if fs.readOnly {
+		return nil, errReadOnly
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return nil, ErrClosed
+	}
+	of, err := os.OpenFile(filepath.Join(fs.dirs.Cold, fsGenName(fd)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fs.open++
+	return &fileWrap{File: of, fs: fs, fd: fd}, nil
+}
+
+func (fs *fileStorage) Tier(fd FileDesc, state, cold bool) error {
+	if fd.Type != TypeTable || !FileDescOk(fd) {
+		return ErrInvalidFile
+	}
+	if fs.readOnly {
+		return errReadOnly
+	}
+	hot := fs.dir(fd, state)
+	if fs.dirs.Cold == "" || fs.dirs.Cold == hot {
+		return nil
+	}
+	if cold {
+		return fs.moveTable(fd, hot, fs.dirs.Cold)
+	}
+	return fs.moveTable(fd, fs.dirs.Cold, hot)
+}
+
+// moveTable moves the table fd from the directory from to to, if it is in
+// from. Across filesystems the copy is made without holding mu, so that
+// the other calls don't wait for it; the table is found in from until the
+// copy is renamed in place, and mu is only held for that and the removal
+// of the source.
+func (fs *fileStorage) moveTable(fd FileDesc, from, to string) error {
+	fs.mu.Lock()
+	if fs.open < 0 {
+		fs.mu.Unlock()
+		return ErrClosed
+	}
+	src, err := findName(fd, from)
+	if err != nil || src == "" {
+		fs.mu.Unlock()
+		return err
+	}
+	dst := filepath.Join(to, fsGenName(fd))
+	err = renameTable(src, dst)
+	fs.mu.Unlock()
+	if err == nil {
+		return syncDirs(to, from)
+	}
+
+	// 不在同一个文件系统上，锁外拷贝到 .tmp。
+	tmp := dst + ".tmp"
+	if err := copyFileSynced(src, tmp); err != nil {
+		os.Remove(tmp)
+		fs.log(fmt.Sprintf("move %s: %v", fd, err))
+		return err
+	}
+	fs.mu.Lock()
+	if fs.open < 0 {
+		err = ErrClosed
+	} else if _, err = os.Stat(src); err == nil {
+		if err = rename(tmp, dst); err == nil {
+			tmp = ""
+			err = os.Remove(src)
+		}
+	} else if os.IsNotExist(err) {
+		// 拷贝期间表被删掉了。
+		err = nil
+	}
+	fs.mu.Unlock()
+	if tmp != "" {
+		os.Remove(tmp)
+	}
+	if err != nil {
+		fs.log(fmt.Sprintf("move %s: %v", fd, err))
+		return err
+	}
+	return syncDirs(to, from)
+}
+
+// renameTable is the rename tried by moveTable and moveFile before
+// copying; tests replace it to copy on a single filesystem.
+var renameTable = rename
+
+// findName returns the path of the table fd in dir, under its current or
+// old name, or an empty path if it is not there.
+func findName(fd FileDesc, dir string) (string, error) {
+	for _, name := range []string{fsGenName(fd), fsGenOldName(fd)} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func syncDirs(dirs ...string) error {
+	for _, dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFile renames src to dst, copying it if they are on different
+// filesystems.
+func moveFile(src, dst string) error {
+	if err := renameTable(src, dst); err == nil {
+		return nil
+	}
+	tmp := dst + ".tmp"
+	err := copyFileSynced(src, tmp)
+	if err == nil {
+		err = rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFileSynced copies src to dst and syncs dst.
+func copyFileSynced(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err1 := out.Sync(); err == nil {
+		err = err1
+	}
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func readDirNames(path string) ([]string, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdirnames(0)
+}
@@ -0,0 +1,334 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func createFile(t *testing.T, create func(FileDesc) (Writer, error), fd FileDesc) {
+	w, err := create(fd)
+	if err != nil {
+		t.Fatalf("Create %s: got error: %v", fd, err)
+	}
+	if _, err := w.Write([]byte(fd.String())); err != nil {
+		t.Fatalf("Write %s: got error: %v", fd, err)
+	}
+	w.Close()
+}
+
+func checkFile(t *testing.T, stor Storage, fd FileDesc) {
+	r, err := stor.Open(fd)
+	if err != nil {
+		t.Fatalf("Open %s: got error: %v", fd, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil || string(b) != fd.String() {
+		t.Fatalf("Read %s: got %q, %v", fd, b, err)
+	}
+}
+
+func checkExist(t *testing.T, dir string, names ...string) {
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("%s not in %s: %v", name, dir, err)
+		}
+	}
+}
+
+func TestFileStorage_Dirs(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	root := filepath.Join(temp, "db")
+	dirs := FileDirs{
+		Table:      filepath.Join(temp, "chain"),
+		StateTable: filepath.Join(temp, "state"),
+		Journal:    filepath.Join(temp, "wal"),
+	}
+
+	stor, err := OpenFileDirs(root, dirs, false)
+	if err != nil {
+		t.Fatal("OpenFileDirs: got error: ", err)
+	}
+	main := FileDesc{Type: TypeTable, Num: 3}
+	state := FileDesc{Type: TypeTable, Num: 4}
+	journal := FileDesc{Type: TypeJournal, Num: 5}
+	manifest := FileDesc{Type: TypeManifest, Num: 6}
+	createFile(t, stor.Create, main)
+	createFile(t, stor.Create_s, state)
+	createFile(t, stor.Create, journal)
+	createFile(t, stor.Create, manifest)
+	checkExist(t, dirs.Table, "000003.ldb")
+	checkExist(t, dirs.StateTable, "000004.ldb")
+	checkExist(t, dirs.Journal, "000005.log")
+	checkExist(t, root, "MANIFEST-000006")
+	for _, fd := range []FileDesc{main, state, journal, manifest} {
+		checkFile(t, stor, fd)
+	}
+
+	fds, err := stor.List(TypeAll)
+	if err != nil {
+		t.Fatal("List: got error: ", err)
+	}
+	sort.Slice(fds, func(i, j int) bool { return fds[i].Num < fds[j].Num })
+	if len(fds) != 4 || fds[0] != main || fds[1] != state || fds[2] != journal || fds[3] != manifest {
+		t.Fatalf("List: got %v", fds)
+	}
+
+	// 目录也上锁，别的库不能共用。
+	if other, err := OpenFileDirs(filepath.Join(temp, "other"), FileDirs{Journal: dirs.Journal}, false); err == nil {
+		other.Close()
+		t.Fatal("OpenFileDirs sharing a directory: expect error")
+	}
+
+	if err := stor.Remove(state); err != nil {
+		t.Fatal("Remove: got error: ", err)
+	}
+	if _, err := stor.Open(state); !os.IsNotExist(err) {
+		t.Fatalf("Open removed: got %v", err)
+	}
+	stor.Close()
+
+	// OpenFile 沿用记下来的目录。
+	stor, err = OpenFile(root, true)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	checkFile(t, stor, main)
+	checkFile(t, stor, journal)
+	stor.Close()
+}
+
+func TestFileStorage_DirsMigrate(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	root := filepath.Join(temp, "db")
+
+	stor, err := OpenFile(root, false)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	main := FileDesc{Type: TypeTable, Num: 3}
+	state := FileDesc{Type: TypeTable, Num: 4}
+	journal := FileDesc{Type: TypeJournals, Num: 5}
+	createFile(t, stor.Create, main)
+	createFile(t, stor.Create_s, state)
+	createFile(t, stor.Create, journal)
+	stor.Close()
+
+	dirs := FileDirs{
+		Table:      filepath.Join(temp, "chain"),
+		StateTable: filepath.Join(temp, "state"),
+		Journal:    filepath.Join(temp, "wal"),
+	}
+	if _, err := OpenFileDirs(root, dirs, true); err == nil {
+		t.Fatal("OpenFileDirs read-only with a new layout: expect error")
+	}
+	stor, err = OpenFileDirs(root, dirs, false)
+	if err != nil {
+		t.Fatal("OpenFileDirs: got error: ", err)
+	}
+	// 存储分不清状态表，都先放到主库的目录，再由 Place 挪过去。
+	checkExist(t, dirs.Table, "000003.ldb", "000004.ldb")
+	checkExist(t, dirs.Journal, "000005.logs")
+	checkFile(t, stor, state)
+	if err := stor.(Placer).Place(state, true); err != nil {
+		t.Fatal("Place: got error: ", err)
+	}
+	if err := stor.(Placer).Place(main, false); err != nil {
+		t.Fatal("Place: got error: ", err)
+	}
+	checkExist(t, dirs.StateTable, "000004.ldb")
+	checkExist(t, dirs.Table, "000003.ldb")
+	checkFile(t, stor, state)
+	stor.Close()
+
+	// 换回单目录，状态表从自己的目录搬回去。
+	stor, err = OpenFileDirs(root, FileDirs{}, false)
+	if err != nil {
+		t.Fatal("OpenFileDirs: got error: ", err)
+	}
+	defer stor.Close()
+	checkExist(t, root, "000003.ldb", "000004.ldb", "000005.logs")
+	for _, fd := range []FileDesc{main, state, journal} {
+		checkFile(t, stor, fd)
+	}
+	if err := stor.(Placer).Place(state, true); err != nil {
+		t.Fatal("Place: got error: ", err)
+	}
+}
+
+func TestFileStorage_Tier(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	root := filepath.Join(temp, "db")
+	dirs := FileDirs{
+		StateTable: filepath.Join(temp, "state"),
+		Cold:       filepath.Join(temp, "cold"),
+	}
+
+	stor, err := OpenFileDirs(root, dirs, false)
+	if err != nil {
+		t.Fatal("OpenFileDirs: got error: ", err)
+	}
+	tierer := stor.(Tierer)
+	main := FileDesc{Type: TypeTable, Num: 3}
+	state := FileDesc{Type: TypeTable, Num: 4}
+	createFile(t, func(fd FileDesc) (Writer, error) { return tierer.CreateCold(fd, false) }, main)
+	createFile(t, func(fd FileDesc) (Writer, error) { return tierer.CreateCold(fd, true) }, state)
+	checkExist(t, dirs.Cold, "000003.ldb", "000004.ldb")
+	checkFile(t, stor, main)
+	checkFile(t, stor, state)
+
+	// 移回热层时回到各自 keyspace 的目录。
+	if err := tierer.Tier(main, false, false); err != nil {
+		t.Fatal("Tier: got error: ", err)
+	}
+	if err := tierer.Tier(state, true, false); err != nil {
+		t.Fatal("Tier: got error: ", err)
+	}
+	checkExist(t, root, "000003.ldb")
+	checkExist(t, dirs.StateTable, "000004.ldb")
+	if err := tierer.Tier(state, true, false); err != nil {
+		t.Fatal("Tier again: got error: ", err)
+	}
+	if err := tierer.Tier(main, false, true); err != nil {
+		t.Fatal("Tier: got error: ", err)
+	}
+	checkExist(t, dirs.Cold, "000003.ldb")
+	checkFile(t, stor, main)
+	checkFile(t, stor, state)
+	stor.Close()
+
+	// 换一个冷目录，冷表跟着搬过去。
+	dirs.Cold = filepath.Join(temp, "cold2")
+	stor, err = OpenFileDirs(root, dirs, false)
+	if err != nil {
+		t.Fatal("OpenFileDirs: got error: ", err)
+	}
+	defer stor.Close()
+	checkExist(t, dirs.Cold, "000003.ldb")
+	checkFile(t, stor, main)
+}
+
+func TestFileStorage_TierCopy(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	root := filepath.Join(temp, "db")
+	cold := filepath.Join(temp, "cold")
+
+	// 模拟跨文件系统，rename 失败只能拷贝。
+	defer func(f func(string, string) error) { renameTable = f }(renameTable)
+	renameTable = func(string, string) error { return os.ErrInvalid }
+
+	stor, err := OpenFileDirs(root, FileDirs{Cold: cold}, false)
+	if err != nil {
+		t.Fatal("OpenFileDirs: got error: ", err)
+	}
+	defer stor.Close()
+	tierer := stor.(Tierer)
+	fd := FileDesc{Type: TypeTable, Num: 3}
+	createFile(t, stor.Create, fd)
+	if err := tierer.Tier(fd, false, true); err != nil {
+		t.Fatal("Tier: got error: ", err)
+	}
+	checkExist(t, cold, "000003.ldb")
+	if _, err := os.Stat(filepath.Join(root, "000003.ldb")); !os.IsNotExist(err) {
+		t.Fatalf("source left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cold, "000003.ldb.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("temporary copy left behind: %v", err)
+	}
+	checkFile(t, stor, fd)
+
+	// 源文件已经删掉的表不用搬。
+	gone := FileDesc{Type: TypeTable, Num: 4}
+	if err := tierer.Tier(gone, false, true); err != nil {
+		t.Fatal("Tier removed table: got error: ", err)
+	}
+}
+
+func TestFileStorage_DirsRenameReplace(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	dirs := FileDirs{StateTable: filepath.Join(temp, "state")}
+
+	stor, err := OpenFileDirs(filepath.Join(temp, "db"), dirs, false)
+	if err != nil {
+		t.Fatal("OpenFileDirs: got error: ", err)
+	}
+	defer stor.Close()
+	state := FileDesc{Type: TypeTable, Num: 3}
+	tmp := FileDesc{Type: TypeTemp, Num: 4}
+	createFile(t, stor.Create_s, state)
+	createFile(t, stor.Create_s, tmp)
+
+	// 重建的表替换原来的表，留在状态表的目录。
+	if err := stor.Rename(tmp, state); err != nil {
+		t.Fatal("Rename: got error: ", err)
+	}
+	checkExist(t, dirs.StateTable, "000003.ldb")
+	r, err := stor.Open(state)
+	if err != nil {
+		t.Fatal("Open: got error: ", err)
+	}
+	defer r.Close()
+	if b, err := io.ReadAll(r); err != nil || string(b) != tmp.String() {
+		t.Fatalf("Read: got %q, %v", b, err)
+	}
+}
+
+func TestFileStorage_DirsRenameCopy(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+	dirs := FileDirs{StateTable: filepath.Join(temp, "state")}
+
+	// 状态表的目录在别的文件系统上，重建的表只能拷贝过去。
+	defer func(f func(string, string) error) { renameTable = f }(renameTable)
+	var renamed int
+	renameTable = func(string, string) error {
+		renamed++
+		return os.ErrInvalid
+	}
+
+	root := filepath.Join(temp, "db")
+	stor, err := OpenFileDirs(root, dirs, false)
+	if err != nil {
+		t.Fatal("OpenFileDirs: got error: ", err)
+	}
+	defer stor.Close()
+	state := FileDesc{Type: TypeTable, Num: 3}
+	tmp := FileDesc{Type: TypeTemp, Num: 4}
+	createFile(t, stor.Create_s, state)
+	createFile(t, stor.Create_s, tmp)
+
+	if err := stor.Rename(tmp, state); err != nil {
+		t.Fatal("Rename: got error: ", err)
+	}
+	if renamed == 0 {
+		t.Fatal("Rename across directories didn't fall back to copying")
+	}
+	checkExist(t, dirs.StateTable, "000003.ldb")
+	for _, path := range []string{filepath.Join(root, "000004.tmp"), filepath.Join(dirs.StateTable, "000003.ldb.tmp")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s left behind: %v", path, err)
+		}
+	}
+	r, err := stor.Open(state)
+	if err != nil {
+		t.Fatal("Open: got error: ", err)
+	}
+	defer r.Close()
+	if b, err := io.ReadAll(r); err != nil || string(b) != tmp.String() {
+		t.Fatalf("Read: got %q, %v", b, err)
+	}
+}
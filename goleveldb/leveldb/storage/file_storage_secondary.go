@@ -0,0 +1,214 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package storage
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Pinner is implemented by storages that keep table files readable after
+// their owner removes them, see OpenFileSecondary. Remove releases a pin.
+type Pinner interface {
+	// Pinned returns the table files pinned and not removed yet, in
+	// ascending order.
+	Pinned() []FileDesc
+}
+
+type noFileLock struct{}
+
+func (noFileLock) release() error { return nil }
+
+// pinnedFile is a table file of the primary kept open by a secondary, so it
+// stays readable after the primary removes it.
+type pinnedFile struct {
+	f       *os.File
+	size    int64
+	readers int
+	removed bool
+}
+
+type pinnedReader struct {
+	*io.SectionReader
+	ss     *secondaryStorage
+	num    int64
+	p      *pinnedFile
+	closed bool
+}
+
+func (r *pinnedReader) Close() error {
+	r.ss.mu.Lock()
+	defer r.ss.mu.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	r.closed = true
+	r.p.readers--
+	r.ss.unpin(r.num, r.p)
+	return nil
+}
+
+// secondaryStorage reads the files of a primary DB directory without
+// locking it. The lock and the LOG belong to the secondary directory.
+type secondaryStorage struct {
+	primary *fileStorage
+	own     Storage
+
+	mu     sync.Mutex
+	pinned map[int64]*pinnedFile
+	closed bool
+}
+
+// OpenFileSecondary returns a storage reading the DB at primaryPath, which
+// may be open by another process, without writing to it. The secondary
+// directory is created if missing and holds the lock and the LOG.
+//
+// Table files are pinned on first open: they stay readable after the
+// primary removes them, until Remove is called for them. The storage
+// implements Pinner.
+//
+// The storage must be closed after use, by calling Close method.
+func OpenFileSecondary(primaryPath, secondaryPath string) (Storage, error) {
+	fi, err := os.Stat(primaryPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: primaryPath, Err: os.ErrInvalid}
+	}
+	dirs, err := readDirs(primaryPath)
+	if err != nil {
+		return nil, err
+	}
+	own, err := OpenFile(secondaryPath, false)
+	if err != nil {
+		return nil, err
+	}
+	return &secondaryStorage{
+		primary: &fileStorage{path: primaryPath, dirs: dirs, readOnly: true, flock: noFileLock{}},
+		own:     own,
+		pinned:  make(map[int64]*pinnedFile),
+	}, nil
+}
+
+func (ss *secondaryStorage) Lock() (Locker, error) {
+	return ss.own.Lock()
+}
+
+func (ss *secondaryStorage) Log(str string) {
+	ss.own.Log(str)
+}
+
+func (ss *secondaryStorage) SetMeta(fd FileDesc) error {
+	return errReadOnly
+}
+
+func (ss *secondaryStorage) GetMeta() (FileDesc, error) {
+	return ss.primary.GetMeta()
+}
+
+func (ss *secondaryStorage) List(ft FileType) ([]FileDesc, error) {
+	return ss.primary.List(ft)
+}
+
+func (ss *secondaryStorage) Open(fd FileDesc) (Reader, error) {
+	if fd.Type != TypeTable {
+		return ss.primary.Open(fd)
+	}
+	if !FileDescOk(fd) {
+		return nil, ErrInvalidFile
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.closed {
+		return nil, ErrClosed
+	}
+	p := ss.pinned[fd.Num]
+	if p == nil || p.removed {
+		f, err := ss.primary.openFile(fd)
+		if err != nil {
+			return nil, err
+		}
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		p = &pinnedFile{f: f, size: size}
+		ss.pinned[fd.Num] = p
+	}
+	p.readers++
+	return &pinnedReader{SectionReader: io.NewSectionReader(p.f, 0, p.size), ss: ss, num: fd.Num, p: p}, nil
+}
+
+func (ss *secondaryStorage) Create(fd FileDesc) (Writer, error) {
+	return nil, errReadOnly
+}
+
+func (ss *secondaryStorage) Create_s(fd FileDesc) (Writer, error) {
+	return nil, errReadOnly
+}
+
+// Remove never removes a file of the primary. For table files it releases
+// the pin once the last reader is closed.
+func (ss *secondaryStorage) Remove(fd FileDesc) error {
+	if fd.Type != TypeTable {
+		return errReadOnly
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if p := ss.pinned[fd.Num]; p != nil {
+		p.removed = true
+		ss.unpin(fd.Num, p)
+	}
+	return nil
+}
+
+func (ss *secondaryStorage) Pinned() []FileDesc {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var fds []FileDesc
+	for num, p := range ss.pinned {
+		if !p.removed {
+			fds = append(fds, FileDesc{Type: TypeTable, Num: num})
+		}
+	}
+	sort.Slice(fds, func(i, j int) bool { return fds[i].Num < fds[j].Num })
+	return fds
+}
+
+// unpin closes p if it is removed and unused; must be called with mu held.
+func (ss *secondaryStorage) unpin(num int64, p *pinnedFile) {
+	if p.removed && p.readers == 0 {
+		p.f.Close()
+		if ss.pinned[num] == p {
+			delete(ss.pinned, num)
+		}
+	}
+}
+
+func (ss *secondaryStorage) Rename(oldfd, newfd FileDesc) error {
+	return errReadOnly
+}
+
+func (ss *secondaryStorage) Close() error {
+	ss.mu.Lock()
+	if ss.closed {
+		ss.mu.Unlock()
+		return ErrClosed
+	}
+	ss.closed = true
+	for num, p := range ss.pinned {
+		p.f.Close()
+		delete(ss.pinned, num)
+	}
+	ss.mu.Unlock()
+	return ss.own.Close()
+}
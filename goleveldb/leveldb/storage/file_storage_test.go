@@ -29,6 +29,9 @@ var cases = []struct {
 	{nil, "MANIFEST-000007", TypeManifest, 7},
 	{nil, "9223372036854775807.log", TypeJournal, 9223372036854775807},
 	{nil, "000100.tmp", TypeTemp, 100},
+	{nil, "000101.log.old", TypeJournalOld, 101},
+	{nil, "000102.logs.old", TypeJournalsOld, 102},
+	{nil, "000103.log.free", TypeJournalFree, 103},
 }
 
 var invalidCases = []string{
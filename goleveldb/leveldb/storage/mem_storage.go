@@ -12,7 +12,7 @@ import (
 	"sync"
 )
 
-const typeShift = 5
+const typeShift = 8
 
 // Verify at compile-time that typeShift is large enough to cover all FileType
 // values by confirming that 0 == 0.
@@ -194,6 +194,24 @@ func (ms *memStorage) Rename(oldfd, newfd FileDesc) error {
 	return nil
 }
 
+func (ms *memStorage) Reuse(oldfd, newfd FileDesc) (Writer, error) {
+	if err := ms.Rename(oldfd, newfd); err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	m, exist := ms.files[packFile(newfd)]
+	if !exist {
+		return nil, os.ErrNotExist
+	}
+	if m.open {
+		return nil, errFileOpen
+	}
+	m.open = true
+	return &memWriter{memFile: m, ms: ms, reused: true}, nil
+}
+
 func (*memStorage) Close() error { return nil }
 
 type memFile struct {
@@ -222,6 +240,22 @@ type memWriter struct {
 	*memFile
 	ms     *memStorage
 	closed bool
+	// reused writers overwrite the file from off, see Reuse.
+	reused bool
+	off    int
+}
+
+func (mw *memWriter) Write(p []byte) (int, error) {
+	if !mw.reused {
+		return mw.memFile.Write(p)
+	}
+	n := 0
+	if b := mw.memFile.Bytes(); mw.off < len(b) {
+		n = copy(b[mw.off:], p)
+	}
+	m, err := mw.memFile.Write(p[n:])
+	mw.off += n + m
+	return n + m, err
 }
 
 func (*memWriter) Sync() error { return nil }
@@ -23,8 +23,14 @@ const (
 	TypeJournals
 	TypeTable
 	TypeTemp
-
-	TypeAll = TypeManifest | TypeJournal | TypeJournals | TypeTable | TypeTemp
+	// Journals of either keyspace kept after being flushed, see
+	// opt.Options.JournalRetention.
+	TypeJournalOld
+	TypeJournalsOld
+	// Flushed journals kept for reuse, see opt.Options.JournalRecycle.
+	TypeJournalFree
+
+	TypeAll = TypeManifest | TypeJournal | TypeJournals | TypeTable | TypeTemp | TypeJournalOld | TypeJournalsOld | TypeJournalFree
 )
 
 func (t FileType) String() string {
@@ -39,6 +45,10 @@ func (t FileType) String() string {
 		return "table"
 	case TypeTemp:
 		return "temp"
+	case TypeJournalOld, TypeJournalsOld:
+		return "old journal"
+	case TypeJournalFree:
+		return "free journal"
 	}
 	return fmt.Sprintf("<unknown:%d>", t)
 }
@@ -117,6 +127,12 @@ func (fd FileDesc) String() string {
 		return fmt.Sprintf("%06d.ldb", fd.Num)
 	case TypeTemp:
 		return fmt.Sprintf("%06d.tmp", fd.Num)
+	case TypeJournalOld:
+		return fmt.Sprintf("%06d.log.old", fd.Num)
+	case TypeJournalsOld:
+		return fmt.Sprintf("%06d.logs.old", fd.Num)
+	case TypeJournalFree:
+		return fmt.Sprintf("%06d.log.free", fd.Num)
 	default:
 		return fmt.Sprintf("%#x-%d", fd.Type, fd.Num)
 	}
@@ -135,12 +151,24 @@ func FileDescOk(fd FileDesc) bool {
 	case TypeJournals:
 	case TypeTable:
 	case TypeTemp:
+	case TypeJournalOld:
+	case TypeJournalsOld:
+	case TypeJournalFree:
 	default:
 		return false
 	}
 	return fd.Num >= 0
 }
 
+// Reuser is implemented by storages that can reuse a file in place of
+// creating a new one, see opt.Options.JournalRecycle.
+type Reuser interface {
+	// Reuse renames oldfd to newfd and opens it write-only without
+	// truncating, so that writes overwrite the old content.
+	// Returns ErrClosed if the underlying storage is closed.
+	Reuse(oldfd, newfd FileDesc) (Writer, error)
+}
+
 // Storage is the storage. A storage instance must be safe for concurrent use.
 type Storage interface {
 	// Lock locks the storage. Any subsequent attempt to call Lock will fail
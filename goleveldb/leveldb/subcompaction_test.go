@@ -0,0 +1,123 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/testutil"
+)
+
+func TestDB_Subcompaction(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MaxSubcompactions:            4,
+	})
+	defer h.close()
+
+	// Four overlapping level-0 tables with distinct largest keys.
+	for i := 0; i < 4; i++ {
+		for j := 0; j <= 25*(i+1); j++ {
+			h.put(fmt.Sprintf("key%03d", j), fmt.Sprintf("v%d-%d", i, j))
+		}
+		h.delete(fmt.Sprintf("key%03d", i))
+		h.compactMem()
+	}
+	h.tablesPerLevel("4")
+	h.compactRangeAt(0, "", "")
+
+	v := h.db.s.version()
+	tables := v.levels[1]
+	if len(tables) < 2 {
+		v.release()
+		t.Fatalf("got %d level-1 tables, want output of several subcompactions", len(tables))
+	}
+	for i := 1; i < len(tables); i++ {
+		if h.db.s.icmp.uCompare(tables[i-1].imax.ukey(), tables[i].imin.ukey()) >= 0 {
+			v.release()
+			t.Fatalf("level-1 tables overlap: %q >= %q", tables[i-1].imax, tables[i].imin)
+		}
+	}
+	v.release()
+
+	// Only the deletion of the last round isn't overwritten.
+	for j := 0; j <= 100; j++ {
+		key := fmt.Sprintf("key%03d", j)
+		if j == 3 {
+			h.get(key, false)
+		} else {
+			h.getVal(key, fmt.Sprintf("v3-%d", j))
+		}
+	}
+	h.reopenDB()
+	h.getVal("key100", "v3-100")
+	h.get("key003", false)
+}
+
+func TestDB_SubcompactionPause(t *testing.T) {
+	var created int64
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MaxSubcompactions:            4,
+		CompactionTableSize:          4 * 1024,
+		EventListener: &opt.EventListener{
+			TableCreated: func(opt.TableInfo) {
+				atomic.AddInt64(&created, 1)
+			},
+		},
+	})
+	defer h.close()
+
+	// 最大 key 各不相同，压缩才会拆开；值不可压缩，才写得出足够多的表。
+	rnd := testutil.NewRand()
+	var last string
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 1000*(i+1); j++ {
+			last = string(randomString(rnd, 200))
+			h.put(fmt.Sprintf("key%05d", j), last)
+		}
+		h.compactMem()
+	}
+	h.tablesPerLevel("4")
+
+	atomic.StoreInt64(&created, 0)
+	errC := make(chan error, 1)
+	go func() {
+		errC <- h.db.compTriggerRange(h.db.tcompCmdC, 0, nil, nil)
+	}()
+	for atomic.LoadInt64(&created) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 像 memCompaction 一样暂停 table compaction。
+	resumeC := make(chan struct{})
+	select {
+	case h.db.tcompPauseC <- (chan<- struct{})(resumeC):
+	case <-time.After(5 * time.Second):
+		t.Fatal("table compaction didn't take the pause request")
+	}
+	// 正在写的表写完之后，所有子压缩都应该停下来。
+	time.Sleep(50 * time.Millisecond)
+	paused := atomic.LoadInt64(&created)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&created); n != paused {
+		t.Errorf("paused compaction created %d tables", n-paused)
+	}
+	select {
+	case <-resumeC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("table compaction didn't wait for resume")
+	}
+	if err := <-errC; err != nil {
+		t.Fatal("CompactRangeAt: got error: ", err)
+	}
+	h.getVal("key03999", last)
+}
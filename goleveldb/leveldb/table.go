@@ -10,7 +10,9 @@ import (
 	"bytes"
 	"fmt"
 	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"awesomeProject1/goleveldb/leveldb/cache"
 	"awesomeProject1/goleveldb/leveldb/iterator"
@@ -640,43 +642,100 @@ type tOps struct {
 	cache        *cache.Cache
 	bcache       *cache.Cache
 	bpool        *util.BufferPool
+	limiter      opt.RateLimiter
+
+	// props caches the properties of the tables written or opened so far,
+	// keyed by file number, so compaction picking needn't do I/O.
+	props sync.Map
+	// Bytes written through the rate limiter and time spent waiting for
+	// it, indexed by opt.Keyspace.
+	limitedBytes [2]int64
+	limitedWait  [2]int64
+}
+
+// limitedWriter passes table writes through the rate limiter.
+type limitedWriter struct {
+	storage.Writer
+	t   *tOps
+	pri util.IOPriority
+	ks  opt.Keyspace
+}
+
+func (w *limitedWriter) Write(p []byte) (n int, err error) {
+	start := time.Now()
+	w.t.limiter.Request(len(p), w.pri)
+	atomic.AddInt64(&w.t.limitedWait[w.ks], int64(time.Since(start)))
+	atomic.AddInt64(&w.t.limitedBytes[w.ks], int64(len(p)))
+	return w.Writer.Write(p)
+}
+
+func (t *tOps) limitWriter(fw storage.Writer, pri util.IOPriority, ks opt.Keyspace) storage.Writer {
+	if t.limiter == nil {
+		return fw
+	}
+	return &limitedWriter{Writer: fw, t: t, pri: pri, ks: ks}
+}
+
+// createFile creates the table file fd of the state keyspace if state is
+// true, in the cold tier if cold is true and the storage has one.
+func (t *tOps) createFile(fd storage.FileDesc, state, cold bool) (storage.Writer, error) {
+	switch {
+	case cold && t.s.stor.canTier():
+		return t.s.stor.CreateCold(fd, state)
+	case state:
+		return t.s.stor.Create_s(fd)
+	}
+	return t.s.stor.Create(fd)
 }
 
 // Creates an empty table and returns table writer.
 // 莫非这里是新建一个real & empty 的sstable并返回twriter
-func (t *tOps) create() (*tWriter, error) {
+// pri是写入限速时使用的优先级，flush使用IOPriorityHigh，compaction使用IOPriorityLow
+// cold表示输出到冷层，见 opt.Options.ColdLevel
+func (t *tOps) create(pri util.IOPriority, cold bool) (*tWriter, error) {
 	fd := storage.FileDesc{Type: storage.TypeTable, Num: t.s.allocFileNum()} //得到文件类型和文件名
-	fw, err := t.s.stor.Create(fd)                                           //storage.writer
+	fw, err := t.createFile(fd, false, cold)                                 //storage.writer
 	if err != nil {
 		return nil, err
 	}
+	fw = t.limitWriter(fw, pri, opt.KeyspaceMain)
 	return &tWriter{
 		t:  t,                                  //tOps
 		fd: fd,                                 //文件描述符
 		w:  fw,                                 //storage.writer
 		tw: table.NewWriter(fw, t.s.o.Options), //*table.writer
+		props: table.Properties{
+			Keyspace:     opt.KeyspaceMain.String(),
+			CreationTime: time.Now().Unix(),
+		},
 	}, nil
 }
-func (t *tOps) create_s() (*tWriter, error) {
+func (t *tOps) create_s(pri util.IOPriority, cold bool) (*tWriter, error) {
 	fd := storage.FileDesc{Type: storage.TypeTable, Num: t.s.allocFileNum()} //得到文件类型和文件名
-	fw, err := t.s.stor.Create_s(fd)                                         //storage.writer
+	fw, err := t.createFile(fd, true, cold)                                  //storage.writer
 	if err != nil {
 		return nil, err
 	}
+	fw = t.limitWriter(fw, pri, opt.KeyspaceState)
 	return &tWriter{
 		t:  t,                                  //tOps
 		fd: fd,                                 //文件描述符
 		w:  fw,                                 //storage.writer
 		tw: table.NewWriter(fw, t.s.o.Options), //*table.writer
+		props: table.Properties{
+			Keyspace:     opt.KeyspaceState.String(),
+			CreationTime: time.Now().Unix(),
+		},
 	}, nil
 }
 
 // Builds table from src iterator.createfrom函数的主要功能是创建新的文件，将frozenmemdb中的数据取出，然后刷新到磁盘。
 func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
-	w, err := t.create() //w is type of *tWriter,封装了table writer
+	w, err := t.create(util.IOPriorityHigh, false) //w is type of *tWriter,封装了table writer
 	if err != nil {
 		return
 	}
+	w.props.Producer = "flush"
 
 	defer func() {
 		if err != nil {
@@ -700,10 +759,11 @@ func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
 	return
 }
 func (t *tOps) createFrom_s(src iterator.Iterator) (f *sFile, n int, err error) {
-	w, err := t.create_s() //w is type of *tWriter,封装了table writer
+	w, err := t.create_s(util.IOPriorityHigh, false) //w is type of *tWriter,封装了table writer
 	if err != nil {
 		return
 	}
+	w.props.Producer = "flush"
 
 	defer func() {
 		if err != nil {
@@ -726,6 +786,90 @@ func (t *tOps) createFrom_s(src iterator.Iterator) (f *sFile, n int, err error)
 	return
 }
 
+// createFromSplit is createFrom, starting a new table each time the user
+// key reaches one of bounds.
+func (t *tOps) createFromSplit(src iterator.Iterator, bounds *keyBounds) (tables tFiles, n int, err error) {
+	n, err = t.createSplit(src, bounds, func() (*tWriter, error) {
+		return t.create(util.IOPriorityHigh, false)
+	}, func(w *tWriter) error {
+		f, err := w.finish()
+		if err == nil {
+			tables = append(tables, f)
+		}
+		return err
+	})
+	if err != nil {
+		for _, f := range tables {
+			t.s.stor.Remove(f.fd)
+		}
+	}
+	return
+}
+
+func (t *tOps) createFromSplit_s(src iterator.Iterator, bounds *keyBounds) (tables sFiles, n int, err error) {
+	n, err = t.createSplit(src, bounds, func() (*tWriter, error) {
+		return t.create_s(util.IOPriorityHigh, false)
+	}, func(w *tWriter) error {
+		f, err := w.finish_s()
+		if err == nil {
+			tables = append(tables, f)
+		}
+		return err
+	})
+	if err != nil {
+		for _, f := range tables {
+			t.s.stor.Remove(f.fd)
+		}
+	}
+	return
+}
+
+// createSplit writes src to tables made by create, handing each one to
+// finish when the user key reaches one of bounds and at the end. It returns
+// the number of entries written. On error the table being written is
+// dropped; the finished ones are left to the caller.
+func (t *tOps) createSplit(src iterator.Iterator, bounds *keyBounds, create func() (*tWriter, error), finish func(w *tWriter) error) (n int, err error) {
+	var w *tWriter
+	defer func() {
+		if err != nil && w != nil {
+			w.drop()
+		}
+	}()
+	// 两个 keyspace 的表只在创建和收尾上不同。
+	next := func() error {
+		n += w.tw.EntriesLen()
+		if err := finish(w); err != nil {
+			return err
+		}
+		w = nil
+		return nil
+	}
+
+	for src.Next() {
+		if bounds.cross(t.s.icmp, internalKey(src.Key()).ukey()) && w != nil {
+			if err = next(); err != nil {
+				return
+			}
+		}
+		if w == nil {
+			if w, err = create(); err != nil {
+				return
+			}
+			w.props.Producer = "flush"
+		}
+		if err = w.append(src.Key(), src.Value()); err != nil {
+			return
+		}
+	}
+	if err = src.Error(); err != nil {
+		return
+	}
+	if w != nil {
+		err = next()
+	}
+	return
+}
+
 // Opens table. It returns a cache handle, which should
 // be released after use. 打开一个sst文件
 func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
@@ -750,6 +894,7 @@ func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
 			r.Close()
 			return 0, nil
 		}
+		t.cacheProperties(f.fd, tr)
 
 		return 1, tr
 
@@ -778,6 +923,7 @@ func (t *tOps) open_s(f *sFile) (ch *cache.Handle, err error) {
 			r.Close()
 			return 0, nil
 		}
+		t.cacheProperties(f.fd, tr)
 		return 1, tr
 
 	})
@@ -864,18 +1010,79 @@ func (t *tOps) newIterator_s(f *sFile, slice *util.Range, ro *opt.ReadOptions) i
 	return iter
 }
 
+// cacheProperties remembers the properties of a freshly opened table. A
+// missing or unreadable properties block only disables the features built
+// on it, so errors are just logged.
+func (t *tOps) cacheProperties(fd storage.FileDesc, tr *table.Reader) {
+	if _, ok := t.props.Load(fd.Num); ok {
+		return
+	}
+	p, err := tr.Properties()
+	if err != nil {
+		t.s.logf("table@properties @%d %q", fd.Num, err)
+		return
+	}
+	if p != nil {
+		t.props.Store(fd.Num, p)
+	}
+}
+
+// cachedProperties returns the cached properties of the table, or nil if
+// the table wasn't opened yet or has no properties.
+func (t *tOps) cachedProperties(fd storage.FileDesc) *table.Properties {
+	if p, ok := t.props.Load(fd.Num); ok {
+		return p.(*table.Properties)
+	}
+	return nil
+}
+
+// Returns the properties of the given table, opening it if needed.
+func (t *tOps) properties(f *tFile) (*table.Properties, error) {
+	if p := t.cachedProperties(f.fd); p != nil {
+		return p, nil
+	}
+	ch, err := t.open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Release()
+	return ch.Value().(*table.Reader).Properties()
+}
+func (t *tOps) properties_s(f *sFile) (*table.Properties, error) {
+	if p := t.cachedProperties(f.fd); p != nil {
+		return p, nil
+	}
+	ch, err := t.open_s(f)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Release()
+	return ch.Value().(*table.Reader).Properties()
+}
+
 // Removes table from persistent storage. It waits until
 // no one use the the table.
 func (t *tOps) remove(fd storage.FileDesc) {
+	t.removeKs(fd, opt.KeyspaceMain)
+}
+
+// remove_s是remove在状态数据上的版本，只影响删除事件中的Keyspace
+func (t *tOps) remove_s(fd storage.FileDesc) {
+	t.removeKs(fd, opt.KeyspaceState)
+}
+
+func (t *tOps) removeKs(fd storage.FileDesc, ks opt.Keyspace) {
 	t.cache.Delete(0, uint64(fd.Num), func() {
 		if err := t.s.stor.Remove(fd); err != nil {
 			t.s.logf("table@remove removing @%d %q", fd.Num, err)
 		} else {
 			t.s.logf("table@remove removed @%d", fd.Num)
+			t.s.o.EventListener.TableDeleted(opt.TableInfo{Keyspace: ks, Level: -1, Num: fd.Num})
 		}
 		if t.evictRemoved && t.bcache != nil {
 			t.bcache.EvictNS(uint64(fd.Num))
 		}
+		t.props.Delete(fd.Num)
 		// Try to reuse file num, useful for discarded transaction.
 		t.s.reuseFileNum(fd.Num)
 	})
@@ -923,6 +1130,7 @@ func newTableOps(s *session) *tOps {
 		cache:        cache.NewCache(cacher),
 		bcache:       bcache,
 		bpool:        bpool,
+		limiter:      s.o.GetRateLimiter(),
 	}
 }
 func (s *session) SetC() {
@@ -940,6 +1148,8 @@ type tWriter struct {
 	tw *table.Writer    //内嵌的table writer
 
 	first, last []byte //sst中的最小和最大key
+
+	props table.Properties // 写入properties块的统计信息
 }
 
 // Append key/value pair to the table.内存或者sst文件的迭代器
@@ -949,6 +1159,20 @@ func (w *tWriter) append(key, value []byte) error {
 		w.first = append([]byte{}, key...)
 	}
 	w.last = append(w.last[:0], key...)
+	if _, seq, kt, err := parseInternalKey(key); err == nil {
+		switch kt {
+		case keyTypeDel:
+			w.props.NumDeletions++
+		case keyTypeMerge:
+			w.props.NumMerges++
+		}
+		if w.tw.EntriesLen() == 0 || seq < w.props.SmallestSeq {
+			w.props.SmallestSeq = seq
+		}
+		if seq > w.props.LargestSeq {
+			w.props.LargestSeq = seq
+		}
+	}
 	return w.tw.Append(key, value)
 	//不断利用迭代器读取需要写入的数据，并不断调用Append函数，直至所有的有效数据读取完毕，为sst附上元数据
 	//sst的元数据为文件编码、大小、最大Key值、最小Key值
@@ -971,10 +1195,13 @@ func (w *tWriter) close() {
 // Finalizes the table and returns table file.
 func (w *tWriter) finish() (f *tFile, err error) {
 	defer w.close()
+	w.tw.SetProperties(&w.props)
 	err = w.tw.Close()
 	if err != nil {
 		return
 	}
+	props := w.tw.Properties()
+	w.t.props.Store(w.fd.Num, &props)
 	if !w.t.noSync {
 		err = w.w.Sync()
 		if err != nil {
@@ -983,14 +1210,18 @@ func (w *tWriter) finish() (f *tFile, err error) {
 	}
 	//返回table的basic information
 	f = newTableFile(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), internalKey(w.last))
+	w.t.s.o.EventListener.TableCreated(opt.TableInfo{Keyspace: opt.KeyspaceMain, Level: -1, Num: f.fd.Num, Size: f.size})
 	return
 }
 func (w *tWriter) finish_s() (f *sFile, err error) {
 	defer w.close()
+	w.tw.SetProperties(&w.props)
 	err = w.tw.Close()
 	if err != nil {
 		return
 	}
+	props := w.tw.Properties()
+	w.t.props.Store(w.fd.Num, &props)
 	if !w.t.noSync {
 		err = w.w.Sync()
 		if err != nil {
@@ -999,6 +1230,7 @@ func (w *tWriter) finish_s() (f *sFile, err error) {
 	}
 	//返回table的basic information
 	f = newTableFile_s(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), internalKey(w.last))
+	w.t.s.o.EventListener.TableCreated(opt.TableInfo{Keyspace: opt.KeyspaceState, Level: -1, Num: f.fd.Num, Size: f.size})
 	return
 }
 
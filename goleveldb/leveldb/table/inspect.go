@@ -0,0 +1,220 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package table
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BlockInfo describes a single block of a table.
+type BlockInfo struct {
+	Kind        string // data-block, index-block, meta-block, filter-block or properties-block
+	Offset      uint64
+	Length      uint64 // on-disk length, without the trailer
+	RawLength   int    // length after decompression
+	Compression string
+
+	Entries  int
+	Restarts int
+	// RestartInterval is the largest number of entries between two
+	// restart points.
+	RestartInterval int
+
+	// IndexKey is the separator key of the index entry pointing to the
+	// block, only set for data blocks.
+	IndexKey []byte
+
+	// Err is set if the checksum or the block structure is invalid.
+	Err error
+}
+
+// Layout describes the block layout of a table, as returned by
+// Reader.Inspect.
+type Layout struct {
+	Size       int64
+	Meta       BlockInfo
+	Index      BlockInfo
+	Filter     *BlockInfo // nil if the table has no filter block
+	FilterName string
+
+	// PropertiesBlock and Properties are nil if the table has no
+	// properties block.
+	PropertiesBlock *BlockInfo
+	Properties      *Properties
+	Data            []BlockInfo
+
+	Entries    int
+	KeyBytes   int64
+	ValueBytes int64
+}
+
+// Corrupted returns the number of blocks that failed verification.
+func (l *Layout) Corrupted() (n int) {
+	for _, b := range append([]BlockInfo{l.Meta, l.Index}, l.Data...) {
+		if b.Err != nil {
+			n++
+		}
+	}
+	if l.Filter != nil && l.Filter.Err != nil {
+		n++
+	}
+	if l.PropertiesBlock != nil && l.PropertiesBlock.Err != nil {
+		n++
+	}
+	return
+}
+
+func compressionName(t byte) string {
+	switch t {
+	case blockTypeNoCompression:
+		return "none"
+	case blockTypeSnappyCompression:
+		return "snappy"
+	}
+	return fmt.Sprintf("unknown(%#x)", t)
+}
+
+// inspectBlock reads bh with checksum verification regardless of the
+// reader options and walks its entries and restart points. The returned
+// block is nil if it could not be read.
+func (r *Reader) inspectBlock(bh blockHandle, kind string) (*block, BlockInfo) {
+	info := BlockInfo{Kind: kind, Offset: bh.offset, Length: bh.length}
+	var t [1]byte
+	if _, err := r.reader.ReadAt(t[:], int64(bh.offset+bh.length)); err != nil && err != io.EOF {
+		info.Err = err
+		return nil, info
+	}
+	info.Compression = compressionName(t[0])
+
+	b, err := r.readBlock(bh, true)
+	if err != nil {
+		info.Err = err
+		return nil, info
+	}
+	info.RawLength = len(b.data)
+	info.Restarts = b.restartsLen
+	if b.restartsLen < 1 || b.restartsOffset < 0 {
+		info.Err = r.newErrCorruptedBH(bh, "invalid restart points")
+		b.Release()
+		return nil, info
+	}
+
+	var ri, run int
+	for offset := 0; offset < b.restartsOffset; {
+		if ri+1 < b.restartsLen && offset >= b.restartOffset(ri+1) {
+			ri++
+			run = 0
+		}
+		_, _, nShared, n, err := b.entry(offset)
+		if err == nil && offset == b.restartOffset(ri) && nShared != 0 {
+			err = &ErrCorrupted{Reason: "restart point with shared prefix"}
+		}
+		if err != nil {
+			info.Err = r.fixErrCorruptedBH(bh, err)
+			break
+		}
+		info.Entries++
+		if run++; run > info.RestartInterval {
+			info.RestartInterval = run
+		}
+		offset += n
+	}
+	return b, info
+}
+
+// Inspect verifies the checksum of every block of the table and returns its
+// layout. If fn is not nil it is called with each entry of the data blocks
+// in order; the key and value must not be retained.
+//
+// Corrupted data and filter blocks are reported in the layout and don't stop
+// the walk, a corrupted index block does.
+func (r *Reader) Inspect(fn func(key, value []byte)) (*Layout, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	l := &Layout{Size: int64(r.indexBH.offset+r.indexBH.length) + blockTrailerLen + footerLen}
+
+	metaBlock, meta := r.inspectBlock(r.metaBH, "meta-block")
+	l.Meta = meta
+	if metaBlock != nil {
+		// 不依赖 Options 中的 filter，元索引里登记的 filter 都要检查。
+		metaIter := r.newBlockIter(metaBlock, nil, nil, true)
+		for metaIter.Next() {
+			key := string(metaIter.Key())
+			if key == propertiesKey {
+				continue
+			}
+			if !strings.HasPrefix(key, "filter.") || l.Filter != nil {
+				continue
+			}
+			if filterBH, n := decodeBlockHandle(metaIter.Value()); n > 0 {
+				l.FilterName = key[7:]
+				l.Filter = &BlockInfo{Kind: "filter-block", Offset: filterBH.offset, Length: filterBH.length}
+				fb, err := r.readFilterBlock(filterBH)
+				if err != nil {
+					l.Filter.Err = err
+				} else {
+					l.Filter.RawLength = len(fb.data)
+					l.Filter.Entries = fb.filtersNum
+					fb.Release()
+				}
+			}
+		}
+		metaIter.Release()
+		metaBlock.Release()
+	}
+
+	if r.propsBH.length > 0 {
+		propsBlock, info := r.inspectBlock(r.propsBH, "properties-block")
+		if propsBlock != nil {
+			propsBlock.Release()
+			l.Properties, info.Err = r.readProperties()
+		}
+		l.PropertiesBlock = &info
+	}
+
+	indexBlock, index := r.inspectBlock(r.indexBH, "index-block")
+	l.Index = index
+	if indexBlock == nil {
+		return l, index.Err
+	}
+	defer indexBlock.Release()
+
+	indexIter := r.newBlockIter(indexBlock, nil, nil, true)
+	defer indexIter.Release()
+	for indexIter.Next() {
+		dataBH, n := decodeBlockHandle(indexIter.Value())
+		if n == 0 {
+			return l, r.newErrCorruptedBH(r.indexBH, "bad data block handle")
+		}
+		dataBlock, info := r.inspectBlock(dataBH, "data-block")
+		info.IndexKey = append([]byte(nil), indexIter.Key()...)
+		if dataBlock != nil {
+			dataIter := r.newBlockIter(dataBlock, nil, nil, true)
+			for dataIter.Next() {
+				l.Entries++
+				l.KeyBytes += int64(len(dataIter.Key()))
+				l.ValueBytes += int64(len(dataIter.Value()))
+				if fn != nil {
+					fn(dataIter.Key(), dataIter.Value())
+				}
+			}
+			if err := dataIter.Error(); err != nil && info.Err == nil {
+				info.Err = err
+			}
+			dataIter.Release()
+			dataBlock.Release()
+		}
+		l.Data = append(l.Data, info)
+	}
+	return l, indexIter.Error()
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package table
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// propertiesKey is the metaindex key of the properties block. Readers
+// not knowing it simply ignore the block.
+const propertiesKey = "leveldb.properties"
+
+// Properties holds the metadata stored in the properties block of a table.
+//
+// The fields up to FilterSize are filled by Writer itself; the rest is
+// passed through Writer.SetProperties by the caller, since the table
+// package doesn't interpret keys.
+type Properties struct {
+	NumEntries    uint64
+	RawKeySize    uint64
+	RawValueSize  uint64
+	NumDataBlocks uint64
+	DataSize      uint64
+	IndexSize     uint64
+	FilterSize    uint64
+	Compression   string
+	FilterPolicy  string
+
+	NumDeletions uint64
+	NumMerges    uint64
+	SmallestSeq  uint64
+	LargestSeq   uint64
+	Keyspace     string // "main" or "state", see opt.Keyspace
+	CreationTime int64  // unix seconds
+	Producer     string // e.g. "flush" or "compaction L1->L2"
+
+	// Unknown holds properties not known to this version, so they survive
+	// a round trip.
+	Unknown map[string][]byte
+}
+
+// DeletionRatio returns NumDeletions / NumEntries.
+func (p *Properties) DeletionRatio() float64 {
+	if p.NumEntries == 0 {
+		return 0
+	}
+	return float64(p.NumDeletions) / float64(p.NumEntries)
+}
+
+type propertyField struct {
+	u *uint64
+	i *int64
+	s *string
+}
+
+func (p *Properties) fields() map[string]propertyField {
+	return map[string]propertyField{
+		"leveldb.num.entries":     {u: &p.NumEntries},
+		"leveldb.raw.key.size":    {u: &p.RawKeySize},
+		"leveldb.raw.value.size":  {u: &p.RawValueSize},
+		"leveldb.num.data.blocks": {u: &p.NumDataBlocks},
+		"leveldb.data.size":       {u: &p.DataSize},
+		"leveldb.index.size":      {u: &p.IndexSize},
+		"leveldb.filter.size":     {u: &p.FilterSize},
+		"leveldb.compression":     {s: &p.Compression},
+		"leveldb.filter.policy":   {s: &p.FilterPolicy},
+		"leveldb.num.deletions":   {u: &p.NumDeletions},
+		"leveldb.num.merges":      {u: &p.NumMerges},
+		"leveldb.smallest.seq":    {u: &p.SmallestSeq},
+		"leveldb.largest.seq":     {u: &p.LargestSeq},
+		"leveldb.keyspace":        {s: &p.Keyspace},
+		"leveldb.creation.time":   {i: &p.CreationTime},
+		"leveldb.producer":        {s: &p.Producer},
+	}
+}
+
+// encode appends the properties to w, sorted by name.
+func (p *Properties) encode(w *blockWriter) {
+	fields := p.fields()
+	names := make([]string, 0, len(fields)+len(p.Unknown))
+	for name := range fields {
+		names = append(names, name)
+	}
+	for name := range p.Unknown {
+		if _, ok := fields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf [binary.MaxVarintLen64]byte
+	for _, name := range names {
+		f, ok := fields[name]
+		switch {
+		case !ok:
+			w.append([]byte(name), p.Unknown[name])
+		case f.u != nil:
+			w.append([]byte(name), buf[:binary.PutUvarint(buf[:], *f.u)])
+		case f.i != nil:
+			w.append([]byte(name), buf[:binary.PutVarint(buf[:], *f.i)])
+		default:
+			w.append([]byte(name), []byte(*f.s))
+		}
+	}
+}
+
+func (p *Properties) decode(name string, value []byte) error {
+	f, ok := p.fields()[name]
+	switch {
+	case !ok:
+		if p.Unknown == nil {
+			p.Unknown = make(map[string][]byte)
+		}
+		p.Unknown[name] = append([]byte(nil), value...)
+	case f.u != nil:
+		x, n := binary.Uvarint(value)
+		if n <= 0 {
+			return &ErrCorrupted{Reason: "invalid property " + name}
+		}
+		*f.u = x
+	case f.i != nil:
+		x, n := binary.Varint(value)
+		if n <= 0 {
+			return &ErrCorrupted{Reason: "invalid property " + name}
+		}
+		*f.i = x
+	default:
+		*f.s = string(value)
+	}
+	return nil
+}
+
+// readProperties reads and decodes the properties block.
+func (r *Reader) readProperties() (*Properties, error) {
+	b, err := r.readBlock(r.propsBH, true)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Release()
+	p := &Properties{}
+	iter := r.newBlockIter(b, nil, nil, true)
+	defer iter.Release()
+	for iter.Next() {
+		if err := p.decode(string(iter.Key()), iter.Value()); err != nil {
+			return nil, r.fixErrCorruptedBH(r.propsBH, err)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Properties returns the properties of the table, or nil if the table was
+// written without a properties block. The result is cached and must not be
+// modified.
+func (r *Reader) Properties() (*Properties, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.props == nil && r.propsBH.length > 0 {
+		p, err := r.readProperties()
+		if err != nil {
+			return nil, err
+		}
+		r.props = p
+	}
+	return r.props, nil
+}
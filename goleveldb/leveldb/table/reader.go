@@ -523,12 +523,14 @@ type Reader struct {
 
 	dataEnd                   int64
 	metaBH, indexBH, filterBH blockHandle
+	propsBH                   blockHandle
 	//metaBH表示meta index block在table中的位置和长度
 	//indexBH表示index block在table中的位置和长度
 	//filterBH表示filter block在table中的位置和长度
 
 	indexBlock  *block       //指向索引块的数据
 	filterBlock *filterBlock //指向filter块的数据
+	props       *Properties  //properties块，首次读取后缓存
 }
 
 func (r *Reader) blockKind(bh blockHandle) string {
@@ -541,6 +543,10 @@ func (r *Reader) blockKind(bh blockHandle) string {
 		if r.filterBH.length > 0 {
 			return "filter-block"
 		}
+	case r.propsBH.offset:
+		if r.propsBH.length > 0 {
+			return "properties-block"
+		}
 	}
 	return "data-block"
 }
@@ -1088,7 +1094,16 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 	metaIter := r.newBlockIter(metaBlock, nil, nil, true)
 	for metaIter.Next() {
 		key := string(metaIter.Key())
-		if !strings.HasPrefix(key, "filter.") {
+		if key == propertiesKey {
+			if propsBH, n := decodeBlockHandle(metaIter.Value()); n > 0 {
+				r.propsBH = propsBH
+				if int64(propsBH.offset) < r.dataEnd {
+					r.dataEnd = int64(propsBH.offset)
+				}
+			}
+			continue
+		}
+		if !strings.HasPrefix(key, "filter.") || r.filter != nil {
 			continue
 		}
 		fn := key[7:]
@@ -1105,12 +1120,14 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 		if r.filter != nil {
 			filterBH, n := decodeBlockHandle(metaIter.Value())
 			if n == 0 {
+				r.filter = nil
 				continue
 			}
 			r.filterBH = filterBH
 			// Update data end.
-			r.dataEnd = int64(filterBH.offset)
-			break
+			if int64(filterBH.offset) < r.dataEnd {
+				r.dataEnd = int64(filterBH.offset)
+			}
 		}
 	}
 	metaIter.Release()
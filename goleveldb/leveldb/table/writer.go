@@ -151,6 +151,7 @@ type Writer struct { //这貌似就是一个table的结构
 	pendingBH   blockHandle
 	offset      uint64
 	nEntries    int
+	props       Properties
 	// Scratch allocated enough for 5 uvarint. Block writer should not use
 	// first 20-bytes since it will be used to encode block handle, which
 	// then passed to the block writer itself.
@@ -246,6 +247,8 @@ func (w *Writer) Append(key, value []byte) error {
 	w.flushPendingBH(key)
 	// Append key/value pair to the data block.
 	w.dataBlock.append(key, value)
+	w.props.RawKeySize += uint64(len(key))
+	w.props.RawValueSize += uint64(len(value))
 	// Add key to the filter block.
 	w.filterBlock.add(key)
 
@@ -280,6 +283,24 @@ func (w *Writer) BytesLen() int {
 	return int(w.offset)
 }
 
+// SetProperties sets the properties that the writer can't collect itself,
+// see Properties. It must be called before Close.
+func (w *Writer) SetProperties(p *Properties) {
+	w.props.NumDeletions = p.NumDeletions
+	w.props.NumMerges = p.NumMerges
+	w.props.SmallestSeq = p.SmallestSeq
+	w.props.LargestSeq = p.LargestSeq
+	w.props.Keyspace = p.Keyspace
+	w.props.CreationTime = p.CreationTime
+	w.props.Producer = p.Producer
+	w.props.Unknown = p.Unknown
+}
+
+// Properties returns the properties written by Close.
+func (w *Writer) Properties() Properties {
+	return w.props
+}
+
 // Close will finalize the table. Calling Append is not possible
 // after Close, but calling BlocksLen, EntriesLen and BytesLen
 // is still possible.
@@ -297,6 +318,7 @@ func (w *Writer) Close() error {
 		}
 	}
 	w.flushPendingBH(nil)
+	dataEnd := w.offset
 
 	// Write the filter block.
 	var filterBH blockHandle
@@ -308,12 +330,35 @@ func (w *Writer) Close() error {
 		}
 	}
 
+	// Write the properties block. The index block is written last, so
+	// its uncompressed length is recorded.
+	w.props.NumEntries = uint64(w.nEntries)
+	w.props.NumDataBlocks = uint64(w.indexBlock.nEntries)
+	w.props.DataSize = dataEnd
+	w.props.FilterSize = filterBH.length
+	w.props.IndexSize = uint64(w.indexBlock.buf.Len())
+	w.props.Compression = w.compression.String()
+	if w.filter != nil {
+		w.props.FilterPolicy = w.filter.Name()
+	}
+	w.props.encode(&w.dataBlock)
+	w.dataBlock.finish()
+	propsBH, err := w.writeBlock(&w.dataBlock.buf, w.compression)
+	if err != nil {
+		w.err = err
+		return w.err
+	}
+	w.dataBlock.reset()
+	w.dataBlock.prevKey = w.dataBlock.prevKey[:0]
+
 	// Write the metaindex block.
 	if filterBH.length > 0 {
 		key := []byte("filter." + w.filter.Name())
 		n := encodeBlockHandle(w.scratch[:20], filterBH)
 		w.dataBlock.append(key, w.scratch[:n])
 	}
+	n := encodeBlockHandle(w.scratch[:20], propsBH)
+	w.dataBlock.append([]byte(propertiesKey), w.scratch[:n])
 	w.dataBlock.finish()
 	metaindexBH, err := w.writeBlock(&w.dataBlock.buf, w.compression)
 	if err != nil {
@@ -334,7 +379,7 @@ func (w *Writer) Close() error {
 	for i := range footer {
 		footer[i] = 0
 	}
-	n := encodeBlockHandle(footer, metaindexBH)
+	n = encodeBlockHandle(footer, metaindexBH)
 	encodeBlockHandle(footer[n:], indexBH)
 	copy(footer[footerLen-len(magic):], magic)
 	if _, err := w.writer.Write(footer); err != nil {
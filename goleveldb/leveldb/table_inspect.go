@@ -0,0 +1,319 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/table"
+)
+
+// TableEntry is an entry of a table file, as passed to the callback of
+// InspectTable. Key and Value must not be retained.
+type TableEntry struct {
+	Key   []byte // user key
+	Seq   uint64
+	Type  string // "v", "d" or "m"
+	Value []byte
+
+	// Err is set if the internal key is corrupted, Key then holds the raw
+	// internal key.
+	Err error
+}
+
+// TableBlock describes a block of a table file.
+type TableBlock struct {
+	Kind            string `json:"kind"`
+	Offset          uint64 `json:"offset"`
+	Length          uint64 `json:"length"`
+	RawLength       int    `json:"rawLength"`
+	Compression     string `json:"compression,omitempty"`
+	Entries         int    `json:"entries"`
+	Restarts        int    `json:"restarts"`
+	RestartInterval int    `json:"restartInterval"`
+	IndexKey        string `json:"indexKey,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// TableReport is the result of InspectTable.
+type TableReport struct {
+	File       string       `json:"file"`
+	Size       int64        `json:"size"`
+	Meta       TableBlock   `json:"meta"`
+	Index      TableBlock   `json:"index"`
+	Filter     *TableBlock  `json:"filter,omitempty"`
+	FilterName string       `json:"filterName,omitempty"`
+	Data       []TableBlock `json:"data"`
+
+	PropertiesBlock *TableBlock       `json:"propertiesBlock,omitempty"`
+	Properties      *table.Properties `json:"properties,omitempty"`
+
+	Entries    int    `json:"entries"`
+	Deletions  int    `json:"deletions"`
+	Merges     int    `json:"merges"`
+	KeyBytes   int64  `json:"keyBytes"`
+	ValueBytes int64  `json:"valueBytes"`
+	MinSeq     uint64 `json:"minSeq"`
+	MaxSeq     uint64 `json:"maxSeq"`
+	Smallest   string `json:"smallest,omitempty"`
+	Largest    string `json:"largest,omitempty"`
+
+	// Corrupted is the number of blocks failing verification.
+	Corrupted int `json:"corrupted"`
+}
+
+func newTableBlock(b *table.BlockInfo) TableBlock {
+	tb := TableBlock{
+		Kind:            b.Kind,
+		Offset:          b.Offset,
+		Length:          b.Length,
+		RawLength:       b.RawLength,
+		Compression:     b.Compression,
+		Entries:         b.Entries,
+		Restarts:        b.Restarts,
+		RestartInterval: b.RestartInterval,
+	}
+	if b.IndexKey != nil {
+		tb.IndexKey = formatInternalKey(b.IndexKey)
+	}
+	if b.Err != nil {
+		tb.Error = b.Err.Error()
+	}
+	return tb
+}
+
+// InspectTable walks a table file of either keyspace, verifying the
+// checksum of every block. The file is read through table.Reader; only the
+// comparer of o is used. If fn is not nil it is called with each entry in
+// order. Like table.Reader, r is closed if it is an io.Closer.
+//
+// Block corruptions are reported in the returned report, the error is only
+// set if the table can't be walked at all.
+func InspectTable(r io.ReaderAt, size int64, fd storage.FileDesc, o *opt.Options, fn func(TableEntry)) (*TableReport, error) {
+	tr, err := table.NewReader(r, size, fd, nil, nil, o)
+	if err != nil {
+		if closer, ok := r.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, err
+	}
+	defer tr.Release()
+
+	report := &TableReport{File: fd.String(), Size: size, Data: []TableBlock{}}
+	var first, last internalKey
+	layout, err := tr.Inspect(func(key, value []byte) {
+		ukey, seq, kt, kerr := parseInternalKey(key)
+		if kerr == nil {
+			switch kt {
+			case keyTypeDel:
+				report.Deletions++
+			case keyTypeMerge:
+				report.Merges++
+			}
+			if report.Entries == 0 || seq < report.MinSeq {
+				report.MinSeq = seq
+			}
+			if seq > report.MaxSeq {
+				report.MaxSeq = seq
+			}
+		}
+		if report.Entries == 0 {
+			first = append(first, key...)
+		}
+		last = append(last[:0], key...)
+		report.Entries++
+		if fn != nil {
+			if kerr != nil {
+				fn(TableEntry{Key: key, Value: value, Err: kerr})
+			} else {
+				fn(TableEntry{Key: ukey, Seq: seq, Type: kt.String(), Value: value})
+			}
+		}
+	})
+	if layout == nil {
+		return nil, err
+	}
+	report.Meta = newTableBlock(&layout.Meta)
+	report.Index = newTableBlock(&layout.Index)
+	if layout.Filter != nil {
+		fb := newTableBlock(layout.Filter)
+		report.Filter = &fb
+		report.FilterName = layout.FilterName
+	}
+	if layout.PropertiesBlock != nil {
+		pb := newTableBlock(layout.PropertiesBlock)
+		report.PropertiesBlock = &pb
+		report.Properties = layout.Properties
+	}
+	for i := range layout.Data {
+		report.Data = append(report.Data, newTableBlock(&layout.Data[i]))
+	}
+	report.KeyBytes, report.ValueBytes = layout.KeyBytes, layout.ValueBytes
+	report.Corrupted = layout.Corrupted()
+	if report.Entries > 0 {
+		report.Smallest, report.Largest = formatInternalKey(first), formatInternalKey(last)
+	}
+	return report, err
+}
+
+func writeTableBlock(w io.Writer, b *TableBlock) {
+	fmt.Fprintf(w, "  %s @%d L·%d raw·%d", b.Kind, b.Offset, b.Length, b.RawLength)
+	if b.Compression != "" {
+		fmt.Fprintf(w, " %s", b.Compression)
+	}
+	if b.Kind == "filter-block" {
+		fmt.Fprintf(w, " filters·%d", b.Entries)
+	} else {
+		fmt.Fprintf(w, " N·%d R·%d RI·%d", b.Entries, b.Restarts, b.RestartInterval)
+	}
+	if b.IndexKey != "" {
+		fmt.Fprintf(w, " index-key %s", b.IndexKey)
+	}
+	if b.Error != "" {
+		fmt.Fprintf(w, " CORRUPTED: %s", b.Error)
+	}
+	fmt.Fprintln(w)
+}
+
+// WriteText writes the layout and statistics of r in a human readable
+// form.
+func (r *TableReport) WriteText(w io.Writer) error {
+	ew := &errWriter{w: w}
+	fmt.Fprintf(ew, "table %s S·%s\n", r.File, shortenb(int(r.Size)))
+	for i := range r.Data {
+		writeTableBlock(ew, &r.Data[i])
+	}
+	if r.Filter != nil {
+		fmt.Fprintf(ew, "  filter %s\n", r.FilterName)
+		writeTableBlock(ew, r.Filter)
+	}
+	if r.PropertiesBlock != nil {
+		writeTableBlock(ew, r.PropertiesBlock)
+	}
+	writeTableBlock(ew, &r.Meta)
+	writeTableBlock(ew, &r.Index)
+	if p := r.Properties; p != nil {
+		fmt.Fprintf(ew, "properties keyspace %s producer %q created %s\n",
+			p.Keyspace, p.Producer, time.Unix(p.CreationTime, 0).Format(time.RFC3339))
+		fmt.Fprintf(ew, "  entries %d (del %d, merge %d) raw keys %s values %s seq %d..%d\n",
+			p.NumEntries, p.NumDeletions, p.NumMerges, shortenb(int(p.RawKeySize)), shortenb(int(p.RawValueSize)),
+			p.SmallestSeq, p.LargestSeq)
+		fmt.Fprintf(ew, "  data blocks %d data %s index %s filter %s compression %s filter-policy %q\n",
+			p.NumDataBlocks, shortenb(int(p.DataSize)), shortenb(int(p.IndexSize)), shortenb(int(p.FilterSize)),
+			p.Compression, p.FilterPolicy)
+	}
+	fmt.Fprintf(ew, "entries %d (del %d, merge %d) keys %s values %s seq %d..%d\n",
+		r.Entries, r.Deletions, r.Merges, shortenb(int(r.KeyBytes)), shortenb(int(r.ValueBytes)), r.MinSeq, r.MaxSeq)
+	if r.Entries > 0 {
+		fmt.Fprintf(ew, "range %s:%s\n", r.Smallest, r.Largest)
+	}
+	if r.Corrupted > 0 {
+		fmt.Fprintf(ew, "verify: %d corrupted blocks\n", r.Corrupted)
+	} else {
+		fmt.Fprintf(ew, "verify: ok\n")
+	}
+	return ew.err
+}
+
+// TableLevelStats aggregates the table statistics of a level.
+type TableLevelStats struct {
+	Level      int   `json:"level"`
+	Tables     int   `json:"tables"`
+	Size       int64 `json:"size"`
+	DataBlocks int   `json:"dataBlocks"`
+	Entries    int   `json:"entries"`
+	Deletions  int   `json:"deletions"`
+	Merges     int   `json:"merges"`
+	KeyBytes   int64 `json:"keyBytes"`
+	ValueBytes int64 `json:"valueBytes"`
+	FilterSize int64 `json:"filterSize"`
+	Corrupted  int   `json:"corrupted"`
+}
+
+func (s *TableLevelStats) add(r *TableReport) {
+	s.Tables++
+	s.Size += r.Size
+	s.DataBlocks += len(r.Data)
+	s.Entries += r.Entries
+	s.Deletions += r.Deletions
+	s.Merges += r.Merges
+	s.KeyBytes += r.KeyBytes
+	s.ValueBytes += r.ValueBytes
+	if r.Filter != nil {
+		s.FilterSize += int64(r.Filter.Length)
+	}
+	s.Corrupted += r.Corrupted
+}
+
+// TableStatsReport holds per level table statistics of both keyspaces, see
+// InspectTables.
+type TableStatsReport struct {
+	Manifest    string            `json:"manifest"`
+	Levels      []TableLevelStats `json:"levels"`
+	LevelsState []TableLevelStats `json:"levelsState"`
+}
+
+func inspectLevelTables(stor storage.Storage, o *opt.Options, levels []ManifestLevel) ([]TableLevelStats, error) {
+	stats := []TableLevelStats{}
+	for _, l := range levels {
+		ls := TableLevelStats{Level: l.Level}
+		for _, t := range l.Tables {
+			fd := storage.FileDesc{Type: storage.TypeTable, Num: t.Num}
+			reader, err := stor.Open(fd)
+			if err != nil {
+				return stats, err
+			}
+			r, err := InspectTable(reader, t.Size, fd, o, nil)
+			if r == nil {
+				return stats, err
+			}
+			ls.add(r)
+		}
+		stats = append(stats, ls)
+	}
+	return stats, nil
+}
+
+// InspectTables walks every live table of the current manifest and returns
+// statistics per level, for the leveled tree and the state tree separately.
+func InspectTables(stor storage.Storage, o *opt.Options) (*TableStatsReport, error) {
+	m, err := InspectManifest(stor, storage.FileDesc{})
+	if err != nil {
+		return nil, err
+	}
+	report := &TableStatsReport{Manifest: m.Manifest}
+	if report.Levels, err = inspectLevelTables(stor, o, m.Levels); err != nil {
+		return report, err
+	}
+	report.LevelsState, err = inspectLevelTables(stor, o, m.LevelsState)
+	return report, err
+}
+
+func writeTableLevelStats(w io.Writer, name string, stats []TableLevelStats) {
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s %d: F·%d S·%s blocks·%d entries·%d (del %d, merge %d) keys·%s values·%s filter·%s",
+			name, s.Level, s.Tables, shortenb(int(s.Size)), s.DataBlocks, s.Entries, s.Deletions, s.Merges,
+			shortenb(int(s.KeyBytes)), shortenb(int(s.ValueBytes)), shortenb(int(s.FilterSize)))
+		if s.Corrupted > 0 {
+			fmt.Fprintf(w, " corrupted·%d", s.Corrupted)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// WriteText writes r in a human readable form, one line per level.
+func (r *TableStatsReport) WriteText(w io.Writer) error {
+	ew := &errWriter{w: w}
+	fmt.Fprintf(ew, "manifest %s\n", r.Manifest)
+	writeTableLevelStats(ew, "level", r.Levels)
+	writeTableLevelStats(ew, "level_s", r.LevelsState)
+	return ew.err
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/table"
+)
+
+func TestInspectTable(t *testing.T) {
+	o := &opt.Options{BlockSize: 512, BlockRestartInterval: 4, Compression: opt.NoCompression}
+	buf := &bytes.Buffer{}
+	tw := table.NewWriter(buf, o)
+	for i := 0; i < 100; i++ {
+		kt := keyTypeVal
+		if i%10 == 0 {
+			kt = keyTypeDel
+		}
+		ik := makeInternalKey(nil, []byte(fmt.Sprintf("key%03d", i)), uint64(i+1), kt)
+		if err := tw.Append(ik, bytes.Repeat([]byte{'v'}, 20)); err != nil {
+			t.Fatal("Append: got error: ", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal("Close: got error: ", err)
+	}
+	data := buf.Bytes()
+	fd := storage.FileDesc{Type: storage.TypeTable, Num: 1}
+
+	var entries int
+	report, err := InspectTable(bytes.NewReader(data), int64(len(data)), fd, o, func(e TableEntry) {
+		if e.Err != nil {
+			t.Fatalf("entry %d: got error %v", entries, e.Err)
+		}
+		if want := fmt.Sprintf("key%03d", entries); string(e.Key) != want || e.Seq != uint64(entries+1) {
+			t.Fatalf("entry %d: got %q seq %d", entries, e.Key, e.Seq)
+		}
+		entries++
+	})
+	if err != nil {
+		t.Fatal("InspectTable: got error: ", err)
+	}
+	if entries != 100 || report.Entries != 100 || report.Deletions != 10 {
+		t.Fatalf("got %d entries (report %d, %d deletions)", entries, report.Entries, report.Deletions)
+	}
+	if report.MinSeq != 1 || report.MaxSeq != 100 {
+		t.Fatalf("seq range: got %d..%d", report.MinSeq, report.MaxSeq)
+	}
+	if len(report.Data) < 2 || report.Corrupted != 0 {
+		t.Fatalf("got %d data blocks, %d corrupted", len(report.Data), report.Corrupted)
+	}
+	var n int
+	for _, b := range report.Data {
+		if b.RestartInterval != 4 || b.IndexKey == "" {
+			t.Fatalf("data block %+v", b)
+		}
+		n += b.Entries
+	}
+	if n != 100 {
+		t.Fatalf("data blocks hold %d entries", n)
+	}
+
+	// Corrupt the second data block, the walk must go on.
+	want := 100 - report.Data[1].Entries
+	data[report.Data[1].Offset+1] ^= 0xff
+	report, err = InspectTable(bytes.NewReader(data), int64(len(data)), fd, o, nil)
+	if err != nil {
+		t.Fatal("InspectTable: got error: ", err)
+	}
+	if report.Corrupted != 1 || report.Data[1].Error == "" {
+		t.Fatalf("got %d corrupted blocks", report.Corrupted)
+	}
+	if report.Entries != want {
+		t.Fatalf("got %d entries, want %d", report.Entries, want)
+	}
+}
+
+func TestInspectTables(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.delete("bar")
+	h.compactMem()
+	for _, k := range []string{"a", "b", "c"} {
+		if err := h.db.Put_s([]byte(k), []byte("v1"), nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem_s(0, true)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+	h.closeDB()
+
+	report, err := InspectTables(h.stor, nil)
+	if err != nil {
+		t.Fatal("InspectTables: got error: ", err)
+	}
+	sum := func(stats []TableLevelStats) (s TableLevelStats) {
+		for _, l := range stats {
+			s.Tables += l.Tables
+			s.Entries += l.Entries
+			s.Deletions += l.Deletions
+			s.Corrupted += l.Corrupted
+		}
+		return
+	}
+	if s := sum(report.Levels); s.Tables != 1 || s.Entries != 2 || s.Deletions != 1 || s.Corrupted != 0 {
+		t.Fatalf("Levels: got %+v", s)
+	}
+	if s := sum(report.LevelsState); s.Tables != 1 || s.Entries != 3 || s.Corrupted != 0 {
+		t.Fatalf("LevelsState: got %+v", s)
+	}
+	if err := report.WriteText(&bytes.Buffer{}); err != nil {
+		t.Fatal("WriteText: got error: ", err)
+	}
+}
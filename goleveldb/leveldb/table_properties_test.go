@@ -0,0 +1,108 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+)
+
+func TestDB_TableProperties(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.put("bar", "v1")
+	h.delete("baz")
+	h.compactMem()
+	if err := h.db.Put_s([]byte("state"), []byte("v1"), nil); err != nil {
+		t.Fatal("Put_s: got error: ", err)
+	}
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem_s(0, true)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+
+	check := func() {
+		props, err := h.db.GetTableProperties(opt.KeyspaceMain)
+		if err != nil {
+			t.Fatal("GetTableProperties: got error: ", err)
+		}
+		if len(props) != 1 || props[0].Properties == nil {
+			t.Fatalf("GetTableProperties: got %+v", props)
+		}
+		p := props[0].Properties
+		if p.NumEntries != 3 || p.NumDeletions != 1 || p.Keyspace != "main" || p.Producer != "flush" {
+			t.Fatalf("main properties: got %+v", p)
+		}
+		if p.SmallestSeq != 1 || p.LargestSeq != 3 || p.CreationTime == 0 || p.NumDataBlocks != 1 {
+			t.Fatalf("main properties: got %+v", p)
+		}
+
+		props, err = h.db.GetTableProperties(opt.KeyspaceState)
+		if err != nil {
+			t.Fatal("GetTableProperties: got error: ", err)
+		}
+		if len(props) != 1 || props[0].Properties == nil {
+			t.Fatalf("GetTableProperties_s: got %+v", props)
+		}
+		if p := props[0].Properties; p.NumEntries != 1 || p.Keyspace != "state" {
+			t.Fatalf("state properties: got %+v", p)
+		}
+	}
+	check()
+	// Read back from disk.
+	h.reopenDB()
+	check()
+}
+
+func TestDB_DeletionRatioCompaction(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionDeletionRatio:      0.5,
+	})
+	defer h.close()
+
+	for i := 0; i < 20; i++ {
+		h.put(fmt.Sprintf("k%02d", i), "v")
+	}
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1")
+
+	for i := 0; i < 20; i++ {
+		h.delete(fmt.Sprintf("k%02d", i))
+	}
+	// Not h.compactMem, the tables may be gone before it counts them.
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem(0, true)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("memdb compaction: got error: ", err)
+	}
+
+	// The table full of deletions overlaps level-1, so it is compacted even
+	// though level-0 is far below its trigger, dropping all entries.
+	var s DBStats
+	for i := 0; ; i++ {
+		if err := h.db.Stats(&s); err != nil {
+			t.Fatal("Stats: got error: ", err)
+		}
+		if s.DeletionComp > 0 && h.totalTables() == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("DeletionComp %d, tables left: %s", s.DeletionComp, h.getTablesPerLevel())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -61,6 +61,9 @@ const (
 	typeJournals
 	typeTable
 	typeTemp
+	typeJournalOld
+	typeJournalsOld
+	typeJournalFree
 
 	typeCount
 )
@@ -101,6 +104,12 @@ func flattenType(m StorageMode, t storage.FileType) int {
 		return x + typeTable
 	case storage.TypeTemp:
 		return x + typeTemp
+	case storage.TypeJournalOld:
+		return x + typeJournalOld
+	case storage.TypeJournalsOld:
+		return x + typeJournalsOld
+	case storage.TypeJournalFree:
+		return x + typeJournalFree
 	default:
 		panic("invalid file type")
 	}
@@ -121,6 +130,12 @@ func listFlattenType(m StorageMode, t storage.FileType) []int {
 			ret = append(ret, x+typeTable)
 		case t&storage.TypeTemp != 0:
 			ret = append(ret, x+typeTemp)
+		case t&storage.TypeJournalOld != 0:
+			ret = append(ret, x+typeJournalOld)
+		case t&storage.TypeJournalsOld != 0:
+			ret = append(ret, x+typeJournalsOld)
+		case t&storage.TypeJournalFree != 0:
+			ret = append(ret, x+typeJournalFree)
 		}
 	}
 	switch {
@@ -506,6 +521,99 @@ func (s *Storage) Rename(oldfd, newfd storage.FileDesc) (err error) {
 	return
 }
 
+// Reuse is a rename followed by a create, it reuses the file if the
+// underlying storage implements storage.Reuser.
+func (s *Storage) Reuse(oldfd, newfd storage.FileDesc) (w storage.Writer, err error) {
+	reuser, ok := s.Storage.(storage.Reuser)
+	if !ok {
+		if err = s.Rename(oldfd, newfd); err != nil {
+			return
+		}
+		return s.Create(newfd)
+	}
+	err = s.emulateError(ModeCreate, newfd.Type)
+	if err == nil {
+		s.stall(ModeCreate, newfd.Type)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.assertOpen(oldfd)
+		s.assertOpen(newfd)
+		s.countNB(ModeCreate, newfd.Type, 0)
+		w, err = reuser.Reuse(oldfd, newfd)
+	}
+	if err != nil {
+		s.logI("file reuse failed, oldfd=%s newfd=%s err=%v", oldfd, newfd, err)
+	} else {
+		s.logI("file reused, oldfd=%s newfd=%s", oldfd, newfd)
+		s.opens[packFile(newfd)] = true
+		w = &writer{s, newfd, w}
+	}
+	return
+}
+
+// Place moves the table if the underlying storage implements
+// storage.Placer.
+func (s *Storage) Place(fd storage.FileDesc, state bool) (err error) {
+	placer, ok := s.Storage.(storage.Placer)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = placer.Place(fd, state); err != nil {
+		s.logI("file place failed, fd=%s state=%v err=%v", fd, state, err)
+	}
+	return
+}
+
+// CreateCold creates the table in the cold tier if the underlying storage
+// implements storage.Tierer.
+func (s *Storage) CreateCold(fd storage.FileDesc, state bool) (w storage.Writer, err error) {
+	tierer, ok := s.Storage.(storage.Tierer)
+	if !ok {
+		if state {
+			return s.Create_s(fd)
+		}
+		return s.Create(fd)
+	}
+	err = s.emulateError(ModeCreate, fd.Type)
+	if err == nil {
+		s.stall(ModeCreate, fd.Type)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.assertOpen(fd)
+		s.countNB(ModeCreate, fd.Type, 0)
+		w, err = tierer.CreateCold(fd, state)
+	}
+	if err != nil {
+		s.logI("file create failed (cold), fd=%s err=%v", fd, err)
+	} else {
+		s.logI("file created (cold), fd=%s", fd)
+		s.opens[packFile(fd)] = true
+		w = &writer{s, fd, w}
+	}
+	return
+}
+
+// Tier moves the table if the underlying storage implements
+// storage.Tierer. The table may be open for reading.
+func (s *Storage) Tier(fd storage.FileDesc, state, cold bool) (err error) {
+	tierer, ok := s.Storage.(storage.Tierer)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = tierer.Tier(fd, state, cold); err != nil {
+		s.logI("file tier failed, fd=%s cold=%v err=%v", fd, cold, err)
+	}
+	return
+}
+
 func (s *Storage) ForceRename(oldfd, newfd storage.FileDesc) (err error) {
 	s.countNB(ModeRename, oldfd.Type, 0)
 	if err = s.Storage.Rename(oldfd, newfd); err != nil {
@@ -0,0 +1,172 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// IOPriority is the priority of a rate limited write.
+type IOPriority int
+
+const (
+	// IOPriorityLow is used by table compactions.
+	IOPriorityLow IOPriority = iota
+	// IOPriorityHigh is used by memdb flushes.
+	IOPriorityHigh
+)
+
+const (
+	rateLimiterRefillPeriod = 100 * time.Millisecond
+	rateLimiterTunePeriod   = 100 * rateLimiterRefillPeriod
+	rateLimiterMinDivisor   = 20
+)
+
+// RateLimiter is a token-bucket limiter for background writes. The bucket
+// is refilled continuously and holds at most one refill period worth of
+// bytes. Low priority requests only take tokens while no high priority
+// request is waiting.
+//
+// If auto-tuning is enabled the rate moves between 1/20 of the configured
+// rate and the configured rate, depending on how often the bucket was found
+// drained during the last tuning period.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // bytes per second
+	maxRate   float64
+	autoTune  bool
+	available float64
+	last      time.Time
+
+	waiting [2]int
+	total   [2]int64
+
+	tuneStart   time.Time
+	drained     int
+	lastDrained int64
+}
+
+// NewRateLimiter creates a new initialized rate limiter allowing
+// bytesPerSecond bytes to be written per second.
+func NewRateLimiter(bytesPerSecond int64, autoTune bool) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		panic("leveldb/util: rate limiter requires a positive rate")
+	}
+	now := time.Now()
+	return &RateLimiter{
+		rate:        float64(bytesPerSecond),
+		maxRate:     float64(bytesPerSecond),
+		autoTune:    autoTune,
+		last:        now,
+		tuneStart:   now,
+		lastDrained: -1,
+	}
+}
+
+func (l *RateLimiter) burst() float64 {
+	return l.rate * rateLimiterRefillPeriod.Seconds()
+}
+
+// refill adds tokens for the time passed since the last refill and, if
+// needed, retunes the rate. Must be called with mu held.
+func (l *RateLimiter) refill(now time.Time) {
+	l.available += now.Sub(l.last).Seconds() * l.rate
+	if burst := l.burst(); l.available > burst {
+		l.available = burst
+	}
+	l.last = now
+
+	if !l.autoTune || now.Sub(l.tuneStart) < rateLimiterTunePeriod {
+		return
+	}
+	periods := int(now.Sub(l.tuneStart) / rateLimiterRefillPeriod)
+	ratio := float64(l.drained) / float64(periods)
+	switch {
+	case ratio > 0.9:
+		l.rate *= 1.05
+	case ratio < 0.5:
+		l.rate *= 0.95
+	}
+	if l.rate > l.maxRate {
+		l.rate = l.maxRate
+	} else if min := l.maxRate / rateLimiterMinDivisor; l.rate < min {
+		l.rate = min
+	}
+	l.tuneStart = now
+	l.drained = 0
+}
+
+// markDrained records that a request had to wait during the current refill
+// period. Must be called with mu held.
+func (l *RateLimiter) markDrained(now time.Time) {
+	if p := int64(now.Sub(l.tuneStart) / rateLimiterRefillPeriod); p != l.lastDrained {
+		l.lastDrained = p
+		l.drained++
+	}
+}
+
+// Request blocks until n bytes may be written with the given priority.
+// Requests larger than the bucket are split.
+func (l *RateLimiter) Request(n int, pri IOPriority) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total[pri] += int64(n)
+	for remain := float64(n); remain > 0; {
+		chunk := remain
+		if burst := l.burst(); chunk > burst {
+			chunk = burst
+		}
+		for {
+			now := time.Now()
+			l.refill(now)
+			if l.available >= chunk && (pri == IOPriorityHigh || l.waiting[IOPriorityHigh] == 0) {
+				l.available -= chunk
+				break
+			}
+			l.markDrained(now)
+			wait := rateLimiterRefillPeriod
+			if need := time.Duration((chunk - l.available) / l.rate * float64(time.Second)); need > 0 && need < wait {
+				wait = need
+			}
+			l.waiting[pri]++
+			l.mu.Unlock()
+			time.Sleep(wait)
+			l.mu.Lock()
+			l.waiting[pri]--
+		}
+		remain -= chunk
+	}
+}
+
+// SetBytesPerSecond changes the configured rate. With auto-tuning enabled
+// this is the upper bound of the tuned rate.
+func (l *RateLimiter) SetBytesPerSecond(bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		panic("leveldb/util: rate limiter requires a positive rate")
+	}
+	l.mu.Lock()
+	l.refill(time.Now())
+	l.rate = float64(bytesPerSecond)
+	l.maxRate = float64(bytesPerSecond)
+	l.mu.Unlock()
+}
+
+// BytesPerSecond returns the current rate, which may be lower than the
+// configured one if auto-tuning is enabled.
+func (l *RateLimiter) BytesPerSecond() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// TotalBytes returns the number of bytes requested with the given priority.
+func (l *RateLimiter) TotalBytes(pri IOPriority) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total[pri]
+}
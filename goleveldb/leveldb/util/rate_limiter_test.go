@@ -0,0 +1,61 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package util
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Rate(t *testing.T) {
+	l := NewRateLimiter(1<<20, false)
+	start := time.Now()
+	for i := 0; i < 64; i++ {
+		l.Request(4<<10, IOPriorityLow)
+	}
+	// 256KiB at 1MiB/s, starting from an empty bucket.
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("256KiB written in %v, want at least 200ms", d)
+	}
+	if n := l.TotalBytes(IOPriorityLow); n != 256<<10 {
+		t.Fatalf("TotalBytes: got %d, want %d", n, 256<<10)
+	}
+}
+
+func TestRateLimiter_Priority(t *testing.T) {
+	l := NewRateLimiter(1<<20, false)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		finished []IOPriority
+	)
+	request := func(pri IOPriority) {
+		defer wg.Done()
+		for i := 0; i < 16; i++ {
+			l.Request(4<<10, pri)
+		}
+		mu.Lock()
+		finished = append(finished, pri)
+		mu.Unlock()
+	}
+	wg.Add(2)
+	go request(IOPriorityLow)
+	go request(IOPriorityHigh)
+	wg.Wait()
+	if finished[0] != IOPriorityHigh {
+		t.Fatalf("low priority requests finished first")
+	}
+}
+
+func TestRateLimiter_SetBytesPerSecond(t *testing.T) {
+	l := NewRateLimiter(1<<20, true)
+	l.SetBytesPerSecond(4 << 20)
+	if r := l.BytesPerSecond(); r != 4<<20 {
+		t.Fatalf("BytesPerSecond: got %d, want %d", r, 4<<20)
+	}
+}
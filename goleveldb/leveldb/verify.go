@@ -0,0 +1,316 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+	"awesomeProject1/goleveldb/leveldb/table"
+)
+
+// Kinds of VerifyProblem.
+const (
+	VerifyCorrupted = "corrupted" // block checksum or structure
+	VerifyOrder     = "order"     // keys out of order within a table
+	VerifyBounds    = "bounds"    // first/last key differs from the manifest
+	VerifySize      = "size"      // file size differs from the manifest
+	VerifyOverlap   = "overlap"   // tables of a level > 0 overlap
+	VerifyShared    = "shared"    // file listed twice, possibly in both trees
+	VerifyMissing   = "missing"   // live file not found
+	VerifyOrphan    = "orphan"    // file not referenced by the DB
+)
+
+// VerifyProblem is an inconsistency found by DB.Verify.
+type VerifyProblem struct {
+	Kind     string `json:"kind"`
+	Keyspace string `json:"keyspace,omitempty"` // "main" or "state"
+	Level    int    `json:"level"`              // -1 for orphaned files
+	File     string `json:"file"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func (p VerifyProblem) String() string {
+	where := p.File
+	if p.Keyspace != "" {
+		where = fmt.Sprintf("%s L%d %s", p.Keyspace, p.Level, p.File)
+	}
+	if p.Detail == "" {
+		return fmt.Sprintf("%s: %s", p.Kind, where)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.Kind, where, p.Detail)
+}
+
+// VerifyReport is the result of DB.Verify.
+type VerifyReport struct {
+	Tables      int   `json:"tables"`
+	TablesState int   `json:"tablesState"`
+	Entries     int   `json:"entries"`
+	Bytes       int64 `json:"bytes"`
+
+	Problems []VerifyProblem `json:"problems"`
+}
+
+// OK returns whether no problem was found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// WriteText writes r in a human readable form, one line per problem.
+func (r *VerifyReport) WriteText(w io.Writer) error {
+	ew := &errWriter{w: w}
+	fmt.Fprintf(ew, "tables %d (state %d) entries %d S·%s\n",
+		r.Tables, r.TablesState, r.Entries, shortenb(int(r.Bytes)))
+	for _, p := range r.Problems {
+		fmt.Fprintln(ew, p)
+	}
+	if r.OK() {
+		fmt.Fprintf(ew, "verify: ok\n")
+	} else {
+		fmt.Fprintf(ew, "verify: %d problems\n", len(r.Problems))
+	}
+	return ew.err
+}
+
+// verifyTable is a live table of either tree.
+type verifyTable struct {
+	ks         opt.Keyspace
+	level      int
+	fd         storage.FileDesc
+	size       int64
+	imin, imax internalKey
+}
+
+type verifier struct {
+	stor   storage.Storage
+	icmp   *iComparer
+	o      *opt.Options
+	report *VerifyReport
+}
+
+func (vr *verifier) problem(kind string, t *verifyTable, format string, args ...interface{}) {
+	vr.report.Problems = append(vr.report.Problems, VerifyProblem{
+		Kind:     kind,
+		Keyspace: t.ks.String(),
+		Level:    t.level,
+		File:     t.fd.String(),
+		Detail:   fmt.Sprintf(format, args...),
+	})
+}
+
+// checkTable reads every block of t, verifying checksums, key order and
+// the key range recorded in the manifest.
+func (vr *verifier) checkTable(t *verifyTable) {
+	reader, err := vr.stor.Open(t.fd)
+	if err != nil {
+		vr.problem(VerifyMissing, t, "%v", err)
+		return
+	}
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		reader.Close()
+		vr.problem(VerifyCorrupted, t, "%v", err)
+		return
+	}
+	if size != t.size {
+		vr.problem(VerifySize, t, "file has %d bytes, manifest %d", size, t.size)
+	}
+	tr, err := table.NewReader(reader, size, t.fd, nil, nil, vr.o)
+	if err != nil {
+		reader.Close()
+		vr.problem(VerifyCorrupted, t, "%v", err)
+		return
+	}
+	defer tr.Release()
+
+	var (
+		first, last internalKey
+		n           int
+		badKeys     int
+		disordered  int
+	)
+	layout, err := tr.Inspect(func(key, value []byte) {
+		if _, _, _, kerr := parseInternalKey(key); kerr != nil {
+			badKeys++
+		} else if n > 0 && vr.icmp.Compare(last, key) >= 0 {
+			if disordered == 0 {
+				vr.problem(VerifyOrder, t, "%s after %s", formatInternalKey(key), formatInternalKey(last))
+			}
+			disordered++
+		}
+		if n == 0 {
+			first = append(first, key...)
+		}
+		last = append(last[:0], key...)
+		n++
+	})
+	if err != nil {
+		vr.problem(VerifyCorrupted, t, "%v", err)
+	}
+	if layout == nil {
+		return
+	}
+	vr.report.Entries += n
+	vr.report.Bytes += size
+	for _, b := range append([]table.BlockInfo{layout.Meta, layout.Index}, layout.Data...) {
+		if b.Err != nil {
+			vr.problem(VerifyCorrupted, t, "%s @%d: %v", b.Kind, b.Offset, b.Err)
+		}
+	}
+	if layout.Filter != nil && layout.Filter.Err != nil {
+		vr.problem(VerifyCorrupted, t, "filter-block @%d: %v", layout.Filter.Offset, layout.Filter.Err)
+	}
+	if layout.PropertiesBlock != nil && layout.PropertiesBlock.Err != nil {
+		vr.problem(VerifyCorrupted, t, "properties-block @%d: %v", layout.PropertiesBlock.Offset, layout.PropertiesBlock.Err)
+	}
+	if badKeys > 0 {
+		vr.problem(VerifyCorrupted, t, "%d invalid internal keys", badKeys)
+	}
+	if disordered > 1 {
+		vr.problem(VerifyOrder, t, "%d keys out of order in total", disordered)
+	}
+	// 损坏的 block 会少读 key，这时候范围对不上是意料之中的。
+	if layout.Corrupted() == 0 && err == nil {
+		if n == 0 {
+			vr.problem(VerifyBounds, t, "table is empty")
+		} else if vr.icmp.Compare(first, t.imin) != 0 || vr.icmp.Compare(last, t.imax) != 0 {
+			vr.problem(VerifyBounds, t, "table has %s:%s, manifest %s:%s",
+				formatInternalKey(first), formatInternalKey(last), formatInternalKey(t.imin), formatInternalKey(t.imax))
+		}
+	}
+}
+
+// checkOverlap checks that the tables of each level > 0 of a tree are
+// disjoint in user keys; compactions never split a user key over two
+// tables.
+func (vr *verifier) checkOverlap(tables []verifyTable) {
+	byLevel := make(map[int][]*verifyTable)
+	for i := range tables {
+		if t := &tables[i]; t.level > 0 {
+			byLevel[t.level] = append(byLevel[t.level], t)
+		}
+	}
+	for _, lt := range byLevel {
+		sort.Slice(lt, func(i, j int) bool { return vr.icmp.Compare(lt[i].imin, lt[j].imin) < 0 })
+		for i := 1; i < len(lt); i++ {
+			if vr.icmp.uCompare(lt[i-1].imax.ukey(), lt[i].imin.ukey()) >= 0 {
+				vr.problem(VerifyOverlap, lt[i], "overlaps %s (%s >= %s)", lt[i-1].fd,
+					formatInternalKey(lt[i-1].imax), formatInternalKey(lt[i].imin))
+			}
+		}
+	}
+}
+
+// verify checks the given live tables of both trees. Files of fds that are
+// not live tables are reported as orphans, unless accepted by live.
+func (vr *verifier) verify(tables, tablesState []verifyTable, fds []storage.FileDesc, live func(storage.FileDesc) bool) {
+	vr.report.Tables, vr.report.TablesState = len(tables), len(tablesState)
+
+	seen := make(map[int64]*verifyTable)
+	for _, ts := range [][]verifyTable{tables, tablesState} {
+		for i := range ts {
+			t := &ts[i]
+			if prev, ok := seen[t.fd.Num]; ok {
+				vr.problem(VerifyShared, t, "also listed as %s L%d", prev.ks, prev.level)
+				continue
+			}
+			seen[t.fd.Num] = t
+			vr.checkTable(t)
+		}
+	}
+	vr.checkOverlap(tables)
+	vr.checkOverlap(tablesState)
+
+	for _, fd := range fds {
+		if _, ok := seen[fd.Num]; ok && fd.Type == storage.TypeTable {
+			continue
+		}
+		if !live(fd) {
+			vr.report.Problems = append(vr.report.Problems, VerifyProblem{Kind: VerifyOrphan, Level: -1, File: fd.String()})
+		}
+	}
+}
+
+// Verify walks every live table of both trees and checks block checksums,
+// key order, that the key range of each table matches the manifest, that
+// the tables of levels > 0 don't overlap and that no file is part of both
+// trees. Table and journal files not referenced by the DB are reported as
+// orphans.
+//
+// Verify reads every table and may take a while. Tables written by
+// compactions still running when Verify finishes may be reported as
+// orphans.
+func (db *DB) Verify() (*VerifyReport, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+
+	// 先记下当前的 journal，之后轮转出来的 journal 编号只会更大。
+	db.memMu.RLock()
+	journalFd, journalFd2 := db.journalFd, db.journalFd2
+	if !db.frozenJournalFd.Zero() {
+		journalFd = db.frozenJournalFd
+	}
+	if !db.frozenJournalFd2.Zero() {
+		journalFd2 = db.frozenJournalFd2
+	}
+	db.memMu.RUnlock()
+
+	// 持有 version 直到检查结束，期间 compaction 不会删掉这些表。
+	v := db.s.version()
+	defer v.release()
+	var tables, tablesState []verifyTable
+	for level, lt := range v.levels {
+		for _, t := range lt {
+			tables = append(tables, verifyTable{opt.KeyspaceMain, level, t.fd, t.size, t.imin, t.imax})
+		}
+	}
+	for level, lt := range v.level_s {
+		for _, t := range lt {
+			tablesState = append(tablesState, verifyTable{opt.KeyspaceState, level, t.fd, t.size, t.imin, t.imax})
+		}
+	}
+
+	fds, err := db.s.stor.List(storage.TypeTable | storage.TypeJournal | storage.TypeJournals)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{Problems: []VerifyProblem{}}
+	vr := &verifier{stor: db.s.stor, icmp: db.s.icmp, o: db.s.o.Options, report: report}
+	vr.verify(tables, tablesState, fds, func(fd storage.FileDesc) bool {
+		switch fd.Type {
+		case storage.TypeJournal:
+			// 只读打开时没有新建 journal，磁盘上的都是要回放的。
+			return journalFd.Zero() || fd.Num >= journalFd.Num
+		case storage.TypeJournals:
+			return journalFd2.Zero() || fd.Num >= journalFd2.Num
+		}
+		// 检查期间提交的表不算孤立文件。
+		v := db.s.version()
+		defer v.release()
+		for _, lt := range v.levels {
+			for _, t := range lt {
+				if t.fd.Num == fd.Num {
+					return true
+				}
+			}
+		}
+		for _, lt := range v.level_s {
+			for _, t := range lt {
+				if t.fd.Num == fd.Num {
+					return true
+				}
+			}
+		}
+		return false
+	})
+	return report, nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+
+	"awesomeProject1/goleveldb/leveldb/opt"
+	"awesomeProject1/goleveldb/leveldb/storage"
+)
+
+func verifyProblems(r *VerifyReport, kind string) (ps []VerifyProblem) {
+	for _, p := range r.Problems {
+		if p.Kind == kind {
+			ps = append(ps, p)
+		}
+	}
+	return
+}
+
+func TestDB_Verify(t *testing.T) {
+	h := newDbCorruptHarness(t)
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	for i := 0; i < 10; i++ {
+		if err := h.db.Put_s([]byte(fmt.Sprintf("s%03d", i)), []byte("v"), nil); err != nil {
+			t.Fatal("Put_s: got error: ", err)
+		}
+	}
+	h.db.writeLockC <- struct{}{}
+	_, err := h.db.rotateMem_s(0, true)
+	<-h.db.writeLockC
+	if err != nil {
+		t.Fatal("state memdb compaction: got error: ", err)
+	}
+
+	r, err := h.db.Verify()
+	if err != nil {
+		t.Fatal("Verify: got error: ", err)
+	}
+	if !r.OK() || r.Tables == 0 || r.TablesState == 0 || r.Entries < 110 {
+		t.Fatalf("Verify: got %+v", r)
+	}
+
+	// 把同一个表同时放进两棵树，并制造 L1 上的重叠。
+	v := h.db.s.version()
+	var main *tFile
+	for _, tables := range v.levels {
+		if len(tables) > 0 {
+			main = tables[0]
+			break
+		}
+	}
+	v.release()
+	vt := verifyTable{opt.KeyspaceMain, 1, main.fd, main.size, main.imin, main.imax}
+	vts := vt
+	vts.ks = opt.KeyspaceState
+	r = &VerifyReport{}
+	vr := &verifier{stor: h.stor, icmp: h.db.s.icmp, o: h.db.s.o.Options, report: r}
+	vr.verify([]verifyTable{vt, vt}, []verifyTable{vts}, nil, nil)
+	if len(verifyProblems(r, VerifyShared)) != 2 {
+		t.Fatalf("verify: got problems %v", r.Problems)
+	}
+	vt2 := vt
+	vt2.fd.Num += 1000
+	r.Problems = nil
+	vr.verify([]verifyTable{vt, vt2}, nil, nil, nil)
+	if len(verifyProblems(r, VerifyOverlap)) != 1 || len(verifyProblems(r, VerifyMissing)) != 1 {
+		t.Fatalf("verify: got problems %v", r.Problems)
+	}
+
+	// Corrupt the main table.
+	h.closeDB()
+	fds, _ := h.stor.List(storage.TypeTable)
+	sortFds(fds)
+	for i, fd := range fds {
+		if fd == main.fd {
+			h.corrupt(storage.TypeTable, i, 100, 1)
+		}
+	}
+	h.openDB()
+
+	orphan := storage.FileDesc{Type: storage.TypeTable, Num: 9999}
+	w, err := h.stor.Create(orphan)
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	w.Write([]byte("junk"))
+	w.Close()
+
+	r, err = h.db.Verify()
+	if err != nil {
+		t.Fatal("Verify: got error: ", err)
+	}
+	if ps := verifyProblems(r, VerifyCorrupted); len(ps) == 0 || ps[0].File != main.fd.String() || ps[0].Keyspace != "main" {
+		t.Fatalf("Verify: got problems %v, want %s corrupted", r.Problems, main.fd)
+	}
+	if ps := verifyProblems(r, VerifyOrphan); len(ps) != 1 || ps[0].File != orphan.String() {
+		t.Fatalf("Verify: got problems %v, want %s orphaned", r.Problems, orphan)
+	}
+}
@@ -8,6 +8,7 @@ package leveldb
 
 import (
 	"fmt"
+	"sort"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -44,8 +45,14 @@ type version struct {
 	cLevels int //记录另外一个LSM
 	cScores float64
 
-	cSeek    unsafe.Pointer
-	nSeek    unsafe.Pointer
+	cSeek unsafe.Pointer
+	nSeek unsafe.Pointer
+
+	// Tables picked for compaction because of their deletion ratio, see
+	// opt.Options.CompactionDeletionRatio. Initialized by computeCompaction().
+	cDel  *tSet
+	cDels *tSet_s
+
 	closing  bool
 	ref      int //记录sst的引用？？？？？
 	released bool
@@ -276,6 +283,8 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 						value = fval
 						err = nil
 					case keyTypeDel:
+					case keyTypeMerge:
+						err = errMergeOperand
 					default:
 						panic("leveldb: invalid internalKey type")
 					}
@@ -295,6 +304,8 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 				value = zval
 				err = nil
 			case keyTypeDel:
+			case keyTypeMerge:
+				err = errMergeOperand
 			default:
 				panic("leveldb: invalid internalKey type")
 			}
@@ -376,6 +387,8 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 						value = fval
 						err = nil
 					case keyTypeDel:
+					case keyTypeMerge:
+						err = errMergeOperand
 					default:
 						panic("leveldb: invalid internalKey type")
 					}
@@ -395,6 +408,8 @@ func (v *version) get_s(aux sFiles, ikey internalKey, ro *opt.ReadOptions, noVal
 				value = zval
 				err = nil
 			case keyTypeDel:
+			case keyTypeMerge:
+				err = errMergeOperand
 			default:
 				panic("leveldb: invalid internalKey type")
 			}
@@ -524,6 +539,62 @@ func (v *version) tLen_s(level int) int {
 	return 0
 }
 
+// l0Runs returns the number of level-0 tables a lookup may have to search.
+// Unless level-0 is partitioned, see opt.Options.FlushSplitL1, that is
+// simply the number of level-0 tables; otherwise it is the largest number of
+// them overlapping at any key.
+func (v *version) l0Runs() int {
+	if !v.s.l0Partitioned(opt.KeyspaceMain) || len(v.levels) == 0 {
+		return v.tLen(0)
+	}
+	tables := v.levels[0]
+	ranges := make([][2][]byte, len(tables))
+	for i, t := range tables {
+		ranges[i] = [2][]byte{t.imin.ukey(), t.imax.ukey()}
+	}
+	return maxOverlap(v.s.icmp, ranges)
+}
+func (v *version) l0Runs_s() int {
+	if !v.s.l0Partitioned(opt.KeyspaceState) || len(v.level_s) == 0 {
+		return v.tLen_s(0)
+	}
+	tables := v.level_s[0]
+	ranges := make([][2][]byte, len(tables))
+	for i, t := range tables {
+		ranges[i] = [2][]byte{t.imin.ukey(), t.imax.ukey()}
+	}
+	return maxOverlap(v.s.icmp, ranges)
+}
+
+// maxOverlap returns the largest number of the given closed user key ranges
+// containing a single key.
+func maxOverlap(icmp *iComparer, ranges [][2][]byte) (max int) {
+	type edge struct {
+		ukey []byte
+		end  bool
+	}
+	edges := make([]edge, 0, 2*len(ranges))
+	for _, r := range ranges {
+		edges = append(edges, edge{r[0], false}, edge{r[1], true})
+	}
+	// 相同的 key 先算开始再算结束，两端都是闭区间。
+	sort.Slice(edges, func(i, j int) bool {
+		if c := icmp.uCompare(edges[i].ukey, edges[j].ukey); c != 0 {
+			return c < 0
+		}
+		return !edges[i].end && edges[j].end
+	})
+	n := 0
+	for _, e := range edges {
+		if e.end {
+			n--
+		} else if n++; n > max {
+			max = n
+		}
+	}
+	return
+}
+
 // 用于test
 func (v *version) offsetOf(ikey internalKey) (n int64, err error) {
 	for level, tables := range v.levels {
@@ -611,10 +682,12 @@ func (v *version) computeCompaction() {
 	statSizes := make([]string, len(v.levels))
 	statScore := make([]string, len(v.levels))
 	statTotSize := int64(0)
+	sizes := v.levelSizes()
+	targets, base := v.s.levelTargets(sizes)
 	//遍历[]tfiles
 	for level, tables := range v.levels {
 		var score float64
-		size := tables.size() //所有sst.size的和
+		size := sizes[level] //所有sst.size的和
 		if level == 0 {
 			// We treat level-0 specially by bounding the number of files
 			// instead of number of bytes for two reasons:
@@ -627,7 +700,9 @@ func (v *version) computeCompaction() {
 			// file size is small (perhaps because of a small write-buffer
 			// setting, or very high compression ratios, or lots of
 			// overwrites/deletions).
-			score = float64(len(tables)) / float64(v.s.o.GetCompactionL0Trigger()) // 文件个数/4
+			score = float64(v.l0Runs()) / float64(v.s.o.GetCompactionL0Trigger()) // 文件个数/4
+		} else if targets != nil {
+			score = dynamicScore(size, level, base, targets)
 		} else {
 			score = float64(size) / float64(v.s.o.GetCompactionTotalSize(level)) //文件的总大小/预设的每个level的文件大小总量
 		}
@@ -645,6 +720,9 @@ func (v *version) computeCompaction() {
 	//最后找出算出的值最大的一个赋值到v.cScore，level赋值到v.cLevel，其实选出当前最满的那一层
 	v.cLevel = bestLevel
 	v.cScore = bestScore
+	if v.cScore < 1 {
+		v.cDel = v.pickDeletionTable()
+	}
 
 	v.s.logf("version@stat F·%v S·%s%v Sc·%v", statFiles, shortenb(int(statTotSize)), statSizes, statScore)
 }
@@ -657,10 +735,12 @@ func (v *version) computeCompaction_s() {
 	statSizes := make([]string, len(v.level_s))
 	statScore := make([]string, len(v.level_s))
 	statTotSize := int64(0)
+	sizes := v.levelSizes_s()
+	targets, base := v.s.levelTargets(sizes)
 	//遍历[]sfiles
 	for level, tables := range v.level_s {
 		var score float64
-		size := tables.size() //所有sst.size的和
+		size := sizes[level] //所有sst.size的和
 		if level == 0 {
 			// We treat level-0 specially by bounding the number of files
 			// instead of number of bytes for two reasons:
@@ -673,7 +753,9 @@ func (v *version) computeCompaction_s() {
 			// file size is small (perhaps because of a small write-buffer
 			// setting, or very high compression ratios, or lots of
 			// overwrites/deletions).
-			score = float64(len(tables)) / float64(v.s.o.GetCompactionL0Trigger2()) // 文件个数/4
+			score = float64(v.l0Runs_s()) / float64(v.s.o.GetCompactionL0Trigger2()) // 文件个数/4
+		} else if targets != nil {
+			score = dynamicScore(size, level, base, targets)
 		} else {
 			score = float64(size) / float64(v.s.o.GetCompactionTotalSize(level)) //文件的总大小/预设的每个level的文件大小总量
 		}
@@ -691,16 +773,158 @@ func (v *version) computeCompaction_s() {
 	//最后找出算出的值最大的一个赋值到v.cScore，level赋值到v.cLevel，其实选出当前最满的那一层
 	v.cLevels = bestLevel
 	v.cScores = bestScore
+	if v.cScores < 1 {
+		v.cDels = v.pickDeletionTable_s()
+	}
 
 	v.s.logf("version@stat F·%v S·%s%v Sc·%v", statFiles, shortenb(int(statTotSize)), statSizes, statScore)
 }
 
+// levelTargets returns the target size of each level computed backward from
+// the size of the last level, and the base level, if
+// opt.Options.DynamicLevelBytes is set. Otherwise targets is nil and base is
+// 1. The last level is the deepest non-empty one, or the last of
+// opt.Options.DynamicLevels if all of them below level-0 are empty.
+func (s *session) levelTargets(sizes []int64) (targets []int64, base int) {
+	if !s.o.GetDynamicLevelBytes() {
+		return nil, 1
+	}
+	last := 0
+	for level := len(sizes) - 1; level > 0; level-- {
+		if sizes[level] > 0 {
+			last = level
+			break
+		}
+	}
+	if last == 0 {
+		last = s.o.GetDynamicLevels() - 1
+	}
+	targets = make([]int64, last+1)
+	min := s.o.GetCompactionTotalSize(1)
+	mult := s.o.GetCompactionTotalSizeMultiplier()
+	targets[last] = min
+	if last < len(sizes) && sizes[last] > min {
+		targets[last] = sizes[last]
+	}
+	base = last
+	for level := last - 1; level > 0; level-- {
+		t := int64(float64(targets[level+1]) / mult)
+		if t < min {
+			break
+		}
+		targets[level] = t
+		base = level
+	}
+	return
+}
+
+// dynamicScore returns the compaction score of a level below level-0 with
+// the given dynamic targets. The last level never needs compacting; the
+// levels above the base level should be empty and are drained.
+func dynamicScore(size int64, level, base int, targets []int64) float64 {
+	switch {
+	case level >= len(targets)-1 || size == 0:
+		return 0
+	case level < base:
+		return 1
+	}
+	return float64(size) / float64(targets[level])
+}
+
+// nextLevel returns the level a compaction from sourceLevel writes to: the
+// next level, or with opt.Options.DynamicLevelBytes the first level below
+// that is not empty or not above the base level.
+func (v *version) nextLevel(sourceLevel int) int {
+	_, base := v.s.levelTargets(v.levelSizes())
+	for level := sourceLevel + 1; level < base; level++ {
+		if v.tLen(level) > 0 {
+			return level
+		}
+	}
+	return maxInt(sourceLevel+1, base)
+}
+func (v *version) nextLevel_s(sourceLevel int) int {
+	_, base := v.s.levelTargets(v.levelSizes_s())
+	for level := sourceLevel + 1; level < base; level++ {
+		if v.tLen_s(level) > 0 {
+			return level
+		}
+	}
+	return maxInt(sourceLevel+1, base)
+}
+
+func (v *version) levelSizes() []int64 {
+	sizes := make([]int64, len(v.levels))
+	for level, tables := range v.levels {
+		sizes[level] = tables.size()
+	}
+	return sizes
+}
+func (v *version) levelSizes_s() []int64 {
+	sizes := make([]int64, len(v.level_s))
+	for level, tables := range v.level_s {
+		sizes[level] = tables.size()
+	}
+	return sizes
+}
+
+// pickDeletionTable returns the table with the highest deletion ratio of at
+// least CompactionDeletionRatio, among the tables overlapping the next level.
+// A table without overlap would only be moved down, keeping its deletion
+// markers. Only cached properties are consulted, so no I/O is done.
+func (v *version) pickDeletionTable() *tSet {
+	minRatio := v.s.o.GetCompactionDeletionRatio()
+	if minRatio <= 0 {
+		return nil
+	}
+	var (
+		best      *tSet
+		bestRatio float64
+	)
+	for level := 0; level+1 < len(v.levels); level++ {
+		for _, t := range v.levels[level] {
+			p := v.s.tops.cachedProperties(t.fd)
+			if p == nil {
+				continue
+			}
+			if r := p.DeletionRatio(); r >= minRatio && r > bestRatio &&
+				v.levels[level+1].overlaps(v.s.icmp, t.imin.ukey(), t.imax.ukey(), false) {
+				best, bestRatio = &tSet{level: level, table: t}, r
+			}
+		}
+	}
+	return best
+}
+func (v *version) pickDeletionTable_s() *tSet_s {
+	minRatio := v.s.o.GetCompactionDeletionRatio()
+	if minRatio <= 0 {
+		return nil
+	}
+	var (
+		best      *tSet_s
+		bestRatio float64
+	)
+	for level := 0; level+1 < len(v.level_s); level++ {
+		for _, t := range v.level_s[level] {
+			p := v.s.tops.cachedProperties(t.fd)
+			if p == nil {
+				continue
+			}
+			if r := p.DeletionRatio(); r >= minRatio && r > bestRatio &&
+				v.level_s[level+1].overlaps(v.s.icmp, t.imin.ukey(), t.imax.ukey(), false) {
+				best, bestRatio = &tSet_s{level: level, table: t}, r
+			}
+		}
+	}
+	return best
+}
+
 // 查看是否需要合并
 func (v *version) needCompaction() bool {
-	return v.cScore >= 1 || atomic.LoadPointer(&v.cSeek) != nil
+	return v.cScore >= 1 || atomic.LoadPointer(&v.cSeek) != nil || v.cDel != nil
 }
 func (v *version) needCompaction_s() bool {
-	return v.cScores >= 1 || atomic.LoadPointer(&v.nSeek) != nil
+	return v.cScores >= 1 || atomic.LoadPointer(&v.nSeek) != nil || v.cDels != nil
 }
 
 type tablesScratch struct {